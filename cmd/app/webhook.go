@@ -30,15 +30,17 @@ import (
 	"github.com/juicedata/juicefs-csi-driver/pkg/config"
 	mountctrl "github.com/juicedata/juicefs-csi-driver/pkg/controller"
 	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/webhook/certs"
 	"github.com/juicedata/juicefs-csi-driver/pkg/webhook/handler"
 )
 
 type WebhookManager struct {
-	mgr    ctrl.Manager
-	client *k8sclient.K8sClient
+	mgr         ctrl.Manager
+	client      *k8sclient.K8sClient
+	certManager *certs.CertManager
 }
 
-func NewWebhookManager(certDir string, webhookPort int, leaderElection bool,
+func NewWebhookManager(certDir string, webhookPort int, selfManagedCert bool, certSecret, webhookService string, leaderElection bool,
 	leaderElectionNamespace string,
 	leaderElectionLeaseDuration time.Duration) (*WebhookManager, error) {
 	_ = clientgoscheme.AddToScheme(scheme)
@@ -83,13 +85,25 @@ func NewWebhookManager(certDir string, webhookPort int, leaderElection bool,
 			return nil, err
 		}
 	}
-	return &WebhookManager{
+	w := &WebhookManager{
 		mgr:    mgr,
 		client: k8sClient,
-	}, nil
+	}
+	if selfManagedCert {
+		w.certManager = certs.NewCertManager(k8sClient, certDir, config.Namespace, certSecret, webhookService)
+	}
+	return w, nil
 }
 
 func (w *WebhookManager) Start(ctx context.Context) error {
+	if w.certManager != nil {
+		// webhook server loads certs on start, which must be ready before
+		if err := w.certManager.Ensure(ctx); err != nil {
+			klog.Errorf("Ensure webhook certs error: %v", err)
+			return err
+		}
+		go w.certManager.Run(ctx)
+	}
 	if err := w.registerWebhook(); err != nil {
 		klog.Errorf("Register webhook error: %v", err)
 		return err
@@ -51,6 +51,8 @@ func parseControllerConfig() {
 	config.CacheClientConf = cacheConf
 	config.FormatInPod = formatInPod
 	config.ValidatingWebhook = validationWebhook
+	config.WebhookCertDir = certDir
+	config.WebhookService = webhookService
 	if os.Getenv("DRIVER_NAME") != "" {
 		config.DriverName = os.Getenv("DRIVER_NAME")
 	}
@@ -173,7 +175,7 @@ func controllerRun() {
 	if config.Webhook {
 		go func() {
 			ctx := ctrl.SetupSignalHandler()
-			mgr, err := app.NewWebhookManager(certDir, webhookPort, leaderElection, leaderElectionNamespace, leaderElectionLeaseDuration)
+			mgr, err := app.NewWebhookManager(certDir, webhookPort, selfManagedCert, certSecret, webhookService, leaderElection, leaderElectionNamespace, leaderElectionLeaseDuration)
 			if err != nil {
 				klog.Fatalln(err)
 			}
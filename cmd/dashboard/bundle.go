@@ -0,0 +1,94 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/dashboard"
+)
+
+var (
+	bundleOutput       string
+	bundleSysNamespace string
+	bundleTailLines    int64
+)
+
+func newBundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle (pod|pvc) <namespace>/<name> | bundle node <name>",
+		Short: "collect diagnostic bundle of an app pod, pvc or node as tar.gz",
+		Example: `  juicefs-csi-dashboard bundle pod default/my-app
+  juicefs-csi-dashboard bundle pvc default/my-pvc -o my-pvc.tar.gz
+  juicefs-csi-dashboard bundle node node-1 --kubeconfig ~/.kube/config`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBundle(args[0], args[1])
+		},
+	}
+	sysNamespace := os.Getenv(SysNamespaceKey)
+	if sysNamespace == "" {
+		sysNamespace = "kube-system"
+	}
+	cmd.Flags().StringVarP(&bundleOutput, "output", "o", "", "File to write bundle to, defaults to juicefs-bundle-<kind>-<name>-<time>.tar.gz in current directory.")
+	cmd.Flags().StringVar(&bundleSysNamespace, "sys-namespace", sysNamespace, "Namespace of JuiceFS CSI driver and mount pods.")
+	cmd.Flags().Int64Var(&bundleTailLines, "tail", 2000, "Lines of logs of each container, 0 means all.")
+	return cmd
+}
+
+func runBundle(kind, name string) error {
+	target, err := dashboard.ParseBundleTarget(kind, name)
+	if err != nil {
+		return err
+	}
+	config, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("can't get k8s config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("can't create k8s client: %v", err)
+	}
+	collector := dashboard.NewBundleCollector(client, bundleSysNamespace, config)
+	collector.TailLines = bundleTailLines
+
+	output := bundleOutput
+	if output == "" {
+		output = dashboard.BundleName(target, time.Now()) + ".tar.gz"
+	}
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := collector.Collect(context.Background(), target, f); err != nil {
+		f.Close()
+		os.Remove(output)
+		return fmt.Errorf("collect bundle of %s %s error: %v", kind, name, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("bundle is saved to %s\n", output)
+	return nil
+}
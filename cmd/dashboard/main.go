@@ -32,6 +32,7 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -44,6 +45,7 @@ import (
 	"k8s.io/klog"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	"github.com/juicedata/juicefs-csi-driver/pkg/dashboard"
 )
@@ -67,6 +69,13 @@ var (
 	leaderElection              bool
 	leaderElectionNamespace     string
 	leaderElectionLeaseDuration time.Duration
+
+	authTokenFile      string
+	authTokenReview    bool
+	authBasicSecret    string
+	authNamespaceAuthz bool
+	auditLogFile       string
+	enableDiagnostics  bool
 )
 
 func main() {
@@ -85,6 +94,15 @@ func main() {
 	cmd.PersistentFlags().StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace where the leader election resource lives. Defaults to the pod namespace if not set.")
 	cmd.PersistentFlags().DurationVar(&leaderElectionLeaseDuration, "leader-election-lease-duration", 15*time.Second, "Duration, in seconds, that non-leader candidates will wait to force acquire leadership. Defaults to 15 seconds.")
 
+	cmd.PersistentFlags().StringVar(&authTokenFile, "auth-token-file", "", "Authenticate bearer tokens in the csv file, each line is: token,user,uid,\"group1,group2\".")
+	cmd.PersistentFlags().BoolVar(&authTokenReview, "auth-token-review", false, "Authenticate bearer tokens of kubernetes users with TokenReview.")
+	cmd.PersistentFlags().StringVar(&authBasicSecret, "auth-basic-secret", "", "Authenticate basic auth with users in the secret (<namespace>/<name> or <name> in namespace of dashboard), whose keys are usernames and values are passwords.")
+	cmd.PersistentFlags().BoolVar(&authNamespaceAuthz, "auth-namespace-authz", false, "Restrict authenticated users to namespaces where they can get pods, checked with SubjectAccessReview. Mount pods are accessible with their PVs, csi pods require access to namespace of dashboard.")
+	cmd.PersistentFlags().StringVar(&auditLogFile, "audit-log-file", "", "File to append audit entries of actions (delete, recreate, abort-fuse, upgrade and clean-cache) as json lines, defaults to log of dashboard. Actions are only enabled with --auth-namespace-authz.")
+	cmd.PersistentFlags().BoolVar(&enableDiagnostics, "enable-diagnostics", false, "Enable diagnostic commands (stats, info, summary, accesslog and config) executed in mount pods, requires authentication.")
+
+	cmd.AddCommand(newBundleCmd())
+
 	goFlag := goflag.CommandLine
 	klog.InitFlags(goFlag)
 	cmd.PersistentFlags().AddGoFlagSet(goFlag)
@@ -118,6 +136,37 @@ func run() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	podApi := dashboard.NewAPI(ctx, sysNamespace, mgr.GetClient(), client)
+	go func() {
+		if mgr.GetCache().WaitForCacheSync(ctx) {
+			podApi.RunMountPodVersionMetrics(ctx, prometheus.WrapRegistererWithPrefix("juicefs_", metrics.Registry))
+		}
+	}()
+	authenticator, err := newAuthenticator(client, sysNamespace)
+	if err != nil {
+		log.Fatalf("can't create authenticator: %v", err)
+	}
+	if authenticator != nil {
+		var authorizer *dashboard.NamespaceAuthorizer
+		if authNamespaceAuthz {
+			authorizer = dashboard.NewNamespaceAuthorizer(client)
+		}
+		podApi.SetAuth(authenticator, authorizer)
+		if auditLogFile != "" {
+			f, err := os.OpenFile(auditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				log.Fatalf("can't open audit log file: %v", err)
+			}
+			defer f.Close()
+			podApi.SetAuditLog(f)
+		}
+		if enableDiagnostics {
+			podApi.SetRestConfig(config)
+		}
+	} else if authNamespaceAuthz {
+		log.Fatalf("--auth-namespace-authz requires at least one of --auth-token-file, --auth-token-review and --auth-basic-secret")
+	} else if enableDiagnostics {
+		log.Fatalf("--enable-diagnostics requires at least one of --auth-token-file, --auth-token-review and --auth-basic-secret")
+	}
 	router := gin.Default()
 	if devMode {
 		router.Use(cors.New(cors.Config{
@@ -183,6 +232,31 @@ func run() {
 	}
 }
 
+func newAuthenticator(client kubernetes.Interface, sysNamespace string) (dashboard.Authenticator, error) {
+	var authenticators dashboard.UnionAuthenticator
+	if authTokenFile != "" {
+		a, err := dashboard.NewTokenFileAuthenticator(authTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+	if authTokenReview {
+		authenticators = append(authenticators, dashboard.NewTokenReviewAuthenticator(client))
+	}
+	if authBasicSecret != "" {
+		namespace, name := sysNamespace, authBasicSecret
+		if i := strings.Index(authBasicSecret, "/"); i >= 0 {
+			namespace, name = authBasicSecret[:i], authBasicSecret[i+1:]
+		}
+		authenticators = append(authenticators, dashboard.NewBasicAuthenticator(client, namespace, name))
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return authenticators, nil
+}
+
 func getLocalConfig() (*rest.Config, error) {
 	home := homedir.HomeDir()
 	if home == "" {
@@ -202,6 +276,9 @@ func newManager(conf *rest.Config) (ctrl.Manager, error) {
 		LeaseDuration:           &leaderElectionLeaseDuration,
 		NewCache: cache.BuilderWithOptions(cache.Options{
 			Scheme: scheme,
+			SelectorsByObject: cache.SelectorsByObject{
+				&corev1.Event{}: {Field: dashboard.FailedMountEventSelector},
+			},
 		}),
 	})
 }
@@ -0,0 +1,141 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/webhook/handler"
+)
+
+const inClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func newDryRunInjectCmd() *cobra.Command {
+	var (
+		file, server, token, caFile, namespace, output string
+		serverless, insecure                           bool
+	)
+	cmd := &cobra.Command{
+		Use:   "dry-run-inject",
+		Short: "Show how a pod is mutated by sidecar webhook without creating it",
+		Example: `  # port-forward webhook service first when running out of cluster
+  kubectl -n kube-system port-forward svc/juicefs-admission-webhook 9444:443
+  juicefs-csi dry-run-inject -f pod.yaml --server https://localhost:9444 --insecure --token $(kubectl create token default)`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" {
+				return fmt.Errorf("pod manifest is required")
+			}
+			var body []byte
+			var err error
+			if file == "-" {
+				body, err = io.ReadAll(os.Stdin)
+			} else {
+				body, err = os.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+			if token == "" {
+				if data, err := os.ReadFile(inClusterTokenFile); err == nil {
+					token = strings.TrimSpace(string(data))
+				}
+			}
+			tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+			if caFile != "" {
+				ca, err := os.ReadFile(caFile)
+				if err != nil {
+					return err
+				}
+				tlsConfig.RootCAs = x509.NewCertPool()
+				tlsConfig.RootCAs.AppendCertsFromPEM(ca)
+			}
+			client := &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			}
+
+			query := url.Values{}
+			if namespace != "" {
+				query.Set("namespace", namespace)
+			}
+			if serverless {
+				query.Set("serverless", "true")
+			}
+			req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(server, "/")+handler.DryRunPath+"?"+query.Encode(), bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			data, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("dry run failed: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+			}
+
+			var result handler.DryRunResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				return err
+			}
+			switch output {
+			case "diff":
+				if result.Skipped != "" {
+					fmt.Println(result.Skipped)
+					return nil
+				}
+				fmt.Print(result.Diff)
+			case "json":
+				fmt.Println(string(data))
+			default:
+				out, err := yaml.JSONToYAML(data)
+				if err != nil {
+					return err
+				}
+				fmt.Print(string(out))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Pod manifest in yaml or json, - for stdin.")
+	cmd.Flags().StringVar(&server, "server", "https://juicefs-admission-webhook.kube-system.svc", "Address of admission webhook.")
+	cmd.Flags().StringVar(&token, "token", "", "Bearer token allowed to create pods in namespace of the pod, defaults to service account token in cluster.")
+	cmd.Flags().StringVar(&caFile, "cacert", "", "CA of admission webhook.")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Skip verifying cert of admission webhook.")
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace of the pod, defaults to namespace in manifest or default.")
+	cmd.Flags().BoolVar(&serverless, "serverless", false, "Inject as in serverless environment.")
+	cmd.Flags().StringVarP(&output, "output", "o", "yaml", "Output format: yaml, json or diff.")
+	return cmd
+}
@@ -37,6 +37,7 @@ var (
 	formatInPod bool
 	process     bool
 	configPath  string
+	stateFile   string
 
 	provisioner       bool
 	cacheConf         bool
@@ -44,6 +45,9 @@ var (
 	certDir           string
 	webhookPort       int
 	validationWebhook bool
+	selfManagedCert   bool
+	certSecret        string
+	webhookService    string
 
 	podManager         bool
 	reconcilerInterval int
@@ -88,11 +92,17 @@ func main() {
 	cmd.Flags().StringVar(&certDir, "webhook-cert-dir", "/etc/webhook/certs", "Admission webhook cert/key dir.")
 	cmd.Flags().IntVar(&webhookPort, "webhook-port", 9444, "Admission webhook port.")
 	cmd.Flags().BoolVar(&validationWebhook, "validating-webhook", false, "Enable validation webhook in controller. default false.")
+	cmd.Flags().BoolVar(&selfManagedCert, "webhook-self-managed-cert", false, "Generate and rotate admission webhook certs in controller, stored in webhook-cert-secret and written to webhook-cert-dir. default false.")
+	cmd.Flags().StringVar(&certSecret, "webhook-cert-secret", "juicefs-webhook-certs", "Secret storing self-managed admission webhook certs.")
+	cmd.Flags().StringVar(&webhookService, "webhook-service", "juicefs-admission-webhook", "Service of admission webhook, used in self-managed certs and caBundle of webhook configurations.")
 
 	// node flags
+	cmd.Flags().StringVar(&stateFile, "process-state-file", config.ProcessStatePath, "File of mount bookkeeping in by-process mode, should be on a hostPath to survive restarts of csi node.")
 	cmd.Flags().BoolVar(&podManager, "enable-manager", false, "Enable pod manager in csi node. default false.")
 	cmd.Flags().IntVar(&reconcilerInterval, "reconciler-interval", 5, "interval (default 5s) for reconciler")
 
+	cmd.AddCommand(newDryRunInjectCmd())
+
 	goFlag := goflag.CommandLine
 	klog.InitFlags(goFlag)
 	cmd.PersistentFlags().AddGoFlagSet(goFlag)
@@ -34,12 +34,14 @@ import (
 	"github.com/juicedata/juicefs-csi-driver/pkg/driver"
 	k8s "github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
 	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func parseNodeConfig() {
 	config.ByProcess = process
 	if process {
+		config.ProcessStatePath = stateFile
 		// if run in process, does not need pod info
 		config.FormatInPod = false
 		return
@@ -77,6 +79,20 @@ func parseNodeConfig() {
 			config.SecretReconcilerInterval = duration
 		}
 	}
+	if mountMetrics := os.Getenv("JUICEFS_AGGREGATE_MOUNT_METRICS"); mountMetrics != "" {
+		if aggregate, err := strconv.ParseBool(mountMetrics); err == nil {
+			config.MountMetrics = aggregate
+		} else {
+			klog.Errorf("cannot parse JUICEFS_AGGREGATE_MOUNT_METRICS %s: %v", mountMetrics, err)
+		}
+	}
+	if interval := os.Getenv("JUICEFS_CACHE_USAGE_INTERVAL"); interval != "" {
+		if duration, err := time.ParseDuration(interval); err == nil && duration > 0 {
+			config.CacheUsageInterval = duration
+		} else {
+			klog.Errorf("cannot parse JUICEFS_CACHE_USAGE_INTERVAL %s: %v", interval, err)
+		}
+	}
 
 	if jfsMountPriorityName != "" {
 		config.JFSMountPriorityName = jfsMountPriorityName
@@ -140,12 +156,33 @@ func nodeRun() {
 		}
 	}()
 
+	var cacheClient *k8s.K8sClient
+	if !process {
+		var err error
+		if cacheClient, err = k8s.NewClient(); err != nil {
+			klog.Fatalf("Can't get k8s client: %v", err)
+		}
+	}
+	cacheGC := controller.NewCacheGC(cacheClient)
+	go cacheGC.Run(context.Background())
+
 	registerer, registry := util.NewPrometheus(config.NodeName)
+	cacheUsage := controller.NewCacheUsageCollector(cacheClient, registerer)
+	go cacheUsage.Run(context.Background())
+
+	var gatherer prometheus.Gatherer = registry
+	if config.MountMetrics {
+		gatherer = prometheus.Gatherers{registry, controller.NewMountMetricsAggregator(cacheClient)}
+	}
+
 	// http server for metrics
 	go func() {
 		mux := http.NewServeMux()
+		mux.Handle("/cache-gc", cacheGC)
+		mux.Handle("/cache-usage", cacheUsage)
+		mux.Handle("/metrics-targets", controller.NewMetricsTargets(cacheClient))
 		mux.Handle("/metrics", promhttp.HandlerFor(
-			registry,
+			gatherer,
 			promhttp.HandlerOpts{
 				// Opt into OpenMetrics to support exemplars.
 				EnableOpenMetrics: true,
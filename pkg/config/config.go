@@ -21,14 +21,17 @@ import (
 	"fmt"
 	"hash/fnv"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/klog"
 
@@ -45,6 +48,7 @@ var (
 	Webhook           = false            // inject juicefs client as sidecar in pod (only in k8s)
 	ValidatingWebhook = false            // start validating webhook, applicable to ee only
 	Immutable         = false            // csi driver is running in an immutable environment
+	MountMetrics      = false            // aggregate metrics of mount pods in csi node (only in node)
 
 	DriverName               = "csi.juicefs.com"
 	NodeName                 = ""
@@ -55,6 +59,7 @@ var (
 	ReconcileTimeout         = 5 * time.Minute
 	ReconcilerInterval       = 5
 	SecretReconcilerInterval = 1 * time.Hour
+	CacheUsageInterval       = 5 * time.Minute
 
 	CSIPod = corev1.Pod{}
 
@@ -66,6 +71,7 @@ var (
 	TmpPodMountBase       = "/tmp"
 	PodMountBase          = "/jfs"
 	MountBase             = "/var/lib/jfs"
+	ProcessStatePath      = "/var/lib/juicefs/state/csi-state.json" // bookkeeping of process mounts, should be on a hostPath
 	FsType                = "juicefs"
 	CliPath               = "/usr/bin/juicefs"
 	CeCliPath             = "/usr/local/bin/juicefs"
@@ -74,6 +80,9 @@ var (
 	DefaultClientConfPath = "/root/.juicefs"
 	ROConfPath            = "/etc/juicefs"
 
+	WebhookCertDir = "/etc/webhook/certs"        // cert dir of admission webhook, override by flag
+	WebhookService = "juicefs-admission-webhook" // service of admission webhook, override by flag
+
 	DefaultCEMountImage = "juicedata/mount:ce-nightly" // mount pod ce image, override by ENV
 	DefaultEEMountImage = "juicedata/mount:ee-nightly" // mount pod ee image, override by ENV
 )
@@ -110,6 +119,22 @@ const (
 	injectSidecar        = ".sidecar" + inject
 	InjectSidecarDone    = "done" + injectSidecar
 	InjectSidecarDisable = "disable" + injectSidecar
+	// InjectSidecarEnable pod annotation, opt in sidecar injection for rules requiring it
+	InjectSidecarEnable = "enable" + injectSidecar
+	// InjectSidecarDecision pod annotation, records how juicefs volumes of the pod are mounted and the rule matched
+	InjectSidecarDecision = "decision" + injectSidecar
+	// InjectSidecarServerless pod annotation, "true" or "false" explicitly decides whether serverless sidecar is injected
+	InjectSidecarServerless = "serverless" + injectSidecar
+	// InjectSidecarClaims pod annotation, records PVCs whose volumes are replaced by sidecar, comma separated
+	InjectSidecarClaims = "claims" + injectSidecar
+
+	// sidecar credentials
+	SidecarCredentialsSecret = "secret"
+	SidecarCredentialsFetch  = "fetch"
+	// CredentialAudience audience of service account token used by sidecar to fetch credentials
+	CredentialAudience = "csi.juicefs.com"
+	// CredentialPath path of webhook server serving credentials to sidecar
+	CredentialPath = "/juicefs/credentials"
 
 	// config in pv
 	MountPodCpuLimitKey    = "juicefs/mount-cpu-limit"
@@ -130,6 +155,8 @@ const (
 	// DeleteDelayTimeKey mount pod annotation
 	DeleteDelayTimeKey = "juicefs-delete-delay"
 	DeleteDelayAtKey   = "juicefs-delete-at"
+	// MetricsAddrKey mount pod annotation, address of metrics served by juicefs client
+	MetricsAddrKey = "juicefs-metrics-addr"
 
 	// default value
 	DefaultMountPodCpuLimit   = "2000m"
@@ -222,17 +249,548 @@ func (mpp *MountPodPatch) merge(mp MountPodPatch) {
 	}
 }
 
+// Matches checks if the patch applies to mount pods of the PVC, patch without pvcSelector applies to all
+func (mpp *MountPodPatch) Matches(pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if mpp.PVCSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(mpp.PVCSelector)
+	if err != nil {
+		return false, err
+	}
+	if pvc == nil {
+		return false, nil
+	}
+	return selector.Matches(labels.Set(pvc.Labels)), nil
+}
+
+// CacheGCPolicy defines how csi node collects garbage in cache directories of its node.
+// Only cached blocks (<cache-dir>/<uuid>/raw/chunks) are collected, staging blocks are never touched.
+type CacheGCPolicy struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// only report what would be removed, do not remove anything
+	DryRun bool `json:"dryRun,omitempty"`
+	// interval between two rounds, default 1h
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// cache dirs to collect besides the hostPath cache dirs of mount pods on the node
+	CacheDirs []string `json:"cacheDirs,omitempty"`
+	// max size of cached blocks in each cache dir, the oldest blocks are removed first
+	MaxSize *resource.Quantity `json:"maxSize,omitempty"`
+	// cached blocks not modified for longer than maxAge are removed
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+	// remove cache of volumes which are not mounted on the node any more
+	CleanDeletedVolumes bool `json:"cleanDeletedVolumes,omitempty"`
+}
+
+const defaultCacheGCInterval = time.Hour
+
+// GetInterval returns interval of cache gc
+func (p *CacheGCPolicy) GetInterval() time.Duration {
+	if p == nil || p.Interval == nil || p.Interval.Duration <= 0 {
+		return defaultCacheGCInterval
+	}
+	return p.Interval.Duration
+}
+
+// SecretValidation defines how validating webhook checks secrets of juicefs volumes.
+// Metadata engine (community edition) or auth (enterprise edition) is always checked.
+type SecretValidation struct {
+	// check access to object storage with a small read/write test
+	ProbeStorage bool `json:"probeStorage,omitempty"`
+	// timeout of checks, default 25s, it should be shorter than timeoutSeconds of the secret webhook (30s),
+	// otherwise apiserver stops waiting and admits the secret without checks
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// results of checks are cached by content of secret, default 10m, 0 disables caching
+	CacheTTL *metav1.Duration `json:"cacheTTL,omitempty"`
+	// return failed checks as admission warnings instead of rejecting the secret,
+	// invalid configs or envs in secret are always rejected
+	WarnOnly bool `json:"warnOnly,omitempty"`
+}
+
+const (
+	defaultSecretValidationTimeout  = 25 * time.Second
+	defaultSecretValidationCacheTTL = 10 * time.Minute
+)
+
+// GetTimeout returns timeout of secret checks
+func (v *SecretValidation) GetTimeout() time.Duration {
+	if v == nil || v.Timeout == nil || v.Timeout.Duration <= 0 {
+		return defaultSecretValidationTimeout
+	}
+	return v.Timeout.Duration
+}
+
+// GetCacheTTL returns how long results of secret checks are cached
+func (v *SecretValidation) GetCacheTTL() time.Duration {
+	if v == nil || v.CacheTTL == nil || v.CacheTTL.Duration < 0 {
+		return defaultSecretValidationCacheTTL
+	}
+	return v.CacheTTL.Duration
+}
+
+const (
+	InjectModeSidecar  = "sidecar"
+	InjectModeMountPod = "mountpod"
+)
+
+// SidecarInjection defines how juicefs volumes of pods are mounted when sidecar webhook is enabled.
+// Rules are evaluated in order for each juicefs volume of pod, the first matched rule decides.
+type SidecarInjection struct {
+	Rules []SidecarInjectionRule `json:"rules,omitempty"`
+	// mode of volumes matching no rule, sidecar by default
+	DefaultMode string `json:"defaultMode,omitempty"`
+}
+
+// SidecarInjectionRule matches juicefs volumes of pods, all conditions set in the rule must be satisfied.
+type SidecarInjectionRule struct {
+	// name of the rule, recorded in pod annotation
+	Name              string                `json:"name"`
+	Namespaces        []string              `json:"namespaces,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// storageClass of pv
+	StorageClasses []string `json:"storageClasses,omitempty"`
+	// pod must have annotation enable.sidecar.juicefs.com/inject: "true"
+	RequireOptIn bool `json:"requireOptIn,omitempty"`
+	// sidecar or mountpod
+	Mode string `json:"mode"`
+}
+
+// SidecarInjectionTarget is a juicefs volume of pod which injection rules are evaluated against
+type SidecarInjectionTarget struct {
+	Namespace       string
+	NamespaceLabels map[string]string
+	PodLabels       map[string]string
+	PodAnnotations  map[string]string
+	StorageClass    string
+}
+
+// NeedNamespaceLabels returns whether labels of namespace are needed to evaluate rules
+func (s *SidecarInjection) NeedNamespaceLabels() bool {
+	for _, r := range s.Rules {
+		if r.NamespaceSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide returns mode of the target and name of the matched rule, rule is empty if no rule matched
+func (s *SidecarInjection) Decide(target SidecarInjectionTarget) (mode, rule string) {
+	for _, r := range s.Rules {
+		matched, err := r.Match(target)
+		if err != nil {
+			klog.Errorf("invalid sidecar injection rule %s: %v", r.Name, err)
+			continue
+		}
+		if matched {
+			return r.Mode, r.Name
+		}
+	}
+	if s.DefaultMode == "" {
+		return InjectModeSidecar, ""
+	}
+	return s.DefaultMode, ""
+}
+
+// Match checks if the target satisfies all conditions of the rule
+func (r *SidecarInjectionRule) Match(target SidecarInjectionTarget) (bool, error) {
+	if r.Mode != InjectModeSidecar && r.Mode != InjectModeMountPod {
+		return false, fmt.Errorf("unknown mode %q", r.Mode)
+	}
+	if r.RequireOptIn && target.PodAnnotations[InjectSidecarEnable] != True {
+		return false, nil
+	}
+	if len(r.Namespaces) != 0 && !containsString(r.Namespaces, target.Namespace) {
+		return false, nil
+	}
+	if len(r.StorageClasses) != 0 && !containsString(r.StorageClasses, target.StorageClass) {
+		return false, nil
+	}
+	for _, s := range []struct {
+		selector *metav1.LabelSelector
+		labels   map[string]string
+	}{
+		{r.NamespaceSelector, target.NamespaceLabels},
+		{r.PodSelector, target.PodLabels},
+	} {
+		if s.selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(s.selector)
+		if err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(s.labels)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+const (
+	// juicefs mount point is propagated to app containers through a hostPath with bidirectional propagation
+	ServerlessPropagationHostPath = "hostPath"
+	// juicefs mount point is propagated to app containers through an emptyDir shared with them
+	ServerlessPropagationEmptyDir = "emptyDir"
+	// juicefs volume of app containers is replaced with a csi volume provided by the platform
+	ServerlessPropagationCSI = "csi"
+
+	// taint key of virtual-kubelet nodes, whose value is name of the provider
+	VirtualKubeletProviderKey = "virtual-kubelet.io/provider"
+)
+
+// ServerlessProfile defines how juicefs client is injected as sidecar on a serverless (virtual-kubelet) platform.
+// Profiles are evaluated in order when pod is mutated by serverless sidecar webhook, the first matched profile is used,
+// and pods matching no profile are handled by the builtin builders.
+// All selectors set in the profile must be satisfied, a profile with no selector matches all pods.
+type ServerlessProfile struct {
+	Name string `json:"name"`
+	// virtual-kubelet providers, matched against values of virtual-kubelet.io/provider tolerations of pod
+	Providers []string `json:"providers,omitempty"`
+	// matched against nodeSelector of pod
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	PodSelector  *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// run juicefs client as privileged container, otherwise only capabilities below are added
+	Privileged   bool                `json:"privileged,omitempty"`
+	Capabilities []corev1.Capability `json:"capabilities,omitempty"`
+	// how mount point is propagated to app containers: hostPath (default), emptyDir or csi
+	MountPropagation string `json:"mountPropagation,omitempty"`
+	// csi volume juicefs volume of app is replaced with, required when mountPropagation is csi
+	CSI *ServerlessProfileCSI `json:"csi,omitempty"`
+	// mount propagation of juicefs volume in app containers, kept as is if empty
+	AppMountPropagation *corev1.MountPropagationMode `json:"appMountPropagation,omitempty"`
+	// annotations added to app pod, ${MOUNT_PATH} and ${MOUNT_CONTAINER} are replaced with
+	// mount point and name of juicefs client container
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// envs added to juicefs client container
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// ServerlessProfileCSI is the csi volume provided by serverless platform to share mount point of sidecar
+type ServerlessProfileCSI struct {
+	Driver string  `json:"driver"`
+	FSType *string `json:"fsType,omitempty"`
+	// attribute of volume which is set to mount point
+	MountPointAttribute string `json:"mountPointAttribute,omitempty"`
+	// other attributes of volume
+	VolumeAttributes map[string]string `json:"volumeAttributes,omitempty"`
+}
+
+// ServerlessProfileTarget is a pod which serverless profiles are evaluated against
+type ServerlessProfileTarget struct {
+	PodLabels    map[string]string
+	NodeSelector map[string]string
+	Providers    []string
+}
+
+// NewServerlessProfileTarget returns what serverless profiles are matched against for pod,
+// virtual-kubelet providers are those tolerated by pod
+func NewServerlessProfileTarget(pod *corev1.Pod) ServerlessProfileTarget {
+	target := ServerlessProfileTarget{
+		PodLabels:    pod.Labels,
+		NodeSelector: pod.Spec.NodeSelector,
+	}
+	for _, t := range pod.Spec.Tolerations {
+		if t.Key == VirtualKubeletProviderKey && t.Value != "" {
+			target.Providers = append(target.Providers, t.Value)
+		}
+	}
+	return target
+}
+
+// Validate checks if the profile is well-formed
+func (p *ServerlessProfile) Validate() error {
+	switch p.MountPropagation {
+	case "", ServerlessPropagationHostPath, ServerlessPropagationEmptyDir:
+	case ServerlessPropagationCSI:
+		if p.CSI == nil || p.CSI.Driver == "" {
+			return fmt.Errorf("csi driver is required when mountPropagation is csi")
+		}
+	default:
+		return fmt.Errorf("unknown mountPropagation %q", p.MountPropagation)
+	}
+	return nil
+}
+
+// GetMountPropagation returns how mount point is propagated to app containers
+func (p *ServerlessProfile) GetMountPropagation() string {
+	if p.MountPropagation == "" {
+		return ServerlessPropagationHostPath
+	}
+	return p.MountPropagation
+}
+
+// Match checks if the target satisfies all selectors of the profile
+func (p *ServerlessProfile) Match(target ServerlessProfileTarget) (bool, error) {
+	if err := p.Validate(); err != nil {
+		return false, err
+	}
+	return target.match(p.Providers, p.NodeSelector, p.PodSelector)
+}
+
+// match checks if the target tolerates one of providers and satisfies selectors, empty conditions are ignored
+func (t ServerlessProfileTarget) match(providers []string, nodeSelector, podSelector *metav1.LabelSelector) (bool, error) {
+	if len(providers) != 0 {
+		matched := false
+		for _, provider := range t.Providers {
+			if containsString(providers, provider) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	for _, s := range []struct {
+		selector *metav1.LabelSelector
+		labels   map[string]string
+	}{
+		{nodeSelector, t.NodeSelector},
+		{podSelector, t.PodLabels},
+	} {
+		if s.selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(s.selector)
+		if err != nil {
+			return false, err
+		}
+		if !selector.Matches(labels.Set(s.labels)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// FindServerlessProfile returns the first profile matching target, nil if none matched
+func (c *Config) FindServerlessProfile(target ServerlessProfileTarget) *ServerlessProfile {
+	for i := range c.ServerlessProfiles {
+		p := &c.ServerlessProfiles[i]
+		matched, err := p.Match(target)
+		if err != nil {
+			klog.Errorf("invalid serverless profile %s: %v", p.Name, err)
+			continue
+		}
+		if matched {
+			return p
+		}
+	}
+	return nil
+}
+
+// label of virtual-kubelet nodes
+const (
+	VirtualKubeletNodeLabelKey   = "type"
+	VirtualKubeletNodeLabelValue = "virtual-kubelet"
+)
+
+// ServerlessDetection decides per pod whether juicefs client is injected as serverless sidecar,
+// so that one webhook serves pods on both regular nodes and virtual nodes.
+// The pod annotation serverless.sidecar.juicefs.com/inject ("true" or "false") always decides first,
+// then rules are evaluated in order, then pods are detected automatically if auto is set.
+// Pods not decided by any of them are injected as the webhook path they are sent to.
+type ServerlessDetection struct {
+	Rules []ServerlessDetectionRule `json:"rules,omitempty"`
+	// pods targeting virtual-kubelet nodes (label type=virtual-kubelet) by nodeSelector or required node affinity,
+	// or bursting to VCI or CCI, are serverless
+	Auto bool `json:"auto,omitempty"`
+}
+
+// ServerlessDetectionRule matches pods, all conditions set in the rule must be satisfied
+type ServerlessDetectionRule struct {
+	Name string `json:"name"`
+	// virtual-kubelet providers, matched against values of virtual-kubelet.io/provider tolerations of pod
+	Providers []string `json:"providers,omitempty"`
+	// matched against nodeSelector of pod
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	PodSelector  *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// whether matched pods are serverless
+	Serverless bool `json:"serverless"`
+}
+
+// Detect returns whether pod is serverless and why, decided is false if pod is not decided by the detection
+func (d *ServerlessDetection) Detect(pod *corev1.Pod) (serverless bool, reason string, decided bool) {
+	switch pod.Annotations[InjectSidecarServerless] {
+	case True:
+		return true, "annotation " + InjectSidecarServerless, true
+	case False:
+		return false, "annotation " + InjectSidecarServerless, true
+	}
+	target := NewServerlessProfileTarget(pod)
+	for _, r := range d.Rules {
+		matched, err := target.match(r.Providers, r.NodeSelector, r.PodSelector)
+		if err != nil {
+			klog.Errorf("invalid serverless detection rule %s: %v", r.Name, err)
+			continue
+		}
+		if matched {
+			return r.Serverless, "rule " + r.Name, true
+		}
+	}
+	if d.Auto && targetsVirtualNode(pod) {
+		return true, "virtual node", true
+	}
+	return false, "", false
+}
+
+// targetsVirtualNode checks if pod can only be scheduled to virtual-kubelet nodes, or bursts to VCI or CCI
+func targetsVirtualNode(pod *corev1.Pod) bool {
+	// the same as builder.VCIANNOKey and builder.CCIANNOKey
+	if pod.Annotations["vke.volcengine.com/burst-to-vci"] == "enforce" || pod.Labels["virtual-kubelet.io/burst-to-cci"] == "enforce" {
+		return true
+	}
+	if pod.Spec.NodeSelector[VirtualKubeletNodeLabelKey] == VirtualKubeletNodeLabelValue {
+		return true
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	// terms are ORed, all of them must require virtual-kubelet nodes
+	terms := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		virtual := false
+		for _, e := range term.MatchExpressions {
+			if e.Key == VirtualKubeletNodeLabelKey && e.Operator == corev1.NodeSelectorOpIn &&
+				len(e.Values) == 1 && e.Values[0] == VirtualKubeletNodeLabelValue {
+				virtual = true
+			}
+		}
+		if !virtual {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 // TODO: migrate more config for here
 type Config struct {
 	// arrange mount pod to node with node selector instead nodeName
 	EnableNodeSelector bool            `json:"enableNodeSelector,omitempty"`
 	MountPodPatch      []MountPodPatch `json:"mountPodPatch"`
+	// inject juicefs client as native sidecar (init container with restartPolicy Always) in sidecar mode,
+	// only takes effect when apiserver supports it (v1.29+)
+	EnableNativeSidecar bool `json:"enableNativeSidecar,omitempty"`
+	// inject one juicefs client for PVCs of pod from the same filesystem with the same options in sidecar mode,
+	// which mounts root of the filesystem and serves subPath of each PVC
+	ShareSidecarClient bool `json:"shareSidecarClient,omitempty"`
+	// rules deciding how juicefs volumes are mounted when sidecar webhook is enabled
+	SidecarInjection *SidecarInjection `json:"sidecarInjection,omitempty"`
+	// how juicefs client in sidecar gets credentials of volume: "secret" (default) copies them into a secret
+	// in namespace of app pod, "fetch" fetches them from webhook at start with service account token of app pod
+	SidecarCredentials string `json:"sidecarCredentials,omitempty"`
+	// decide per pod whether juicefs client is injected as serverless sidecar
+	ServerlessDetection *ServerlessDetection `json:"serverlessDetection,omitempty"`
+	// how juicefs client is injected as sidecar on serverless platforms, selected per pod
+	ServerlessProfiles []ServerlessProfile `json:"serverlessProfiles,omitempty"`
+	// garbage collection of cache in csi node
+	CacheGC *CacheGCPolicy `json:"cacheGC,omitempty"`
+	// how validating webhook checks secrets of juicefs volumes
+	SecretValidation *SecretValidation `json:"secretValidation,omitempty"`
 }
 
 func (c *Config) Unmarshal(data []byte) error {
 	return yaml.Unmarshal(data, c)
 }
 
+// Validate checks if the config is well-formed, errors of all fields are returned together
+func (c *Config) Validate() error {
+	var errs []error
+	for i, mp := range c.MountPodPatch {
+		if _, err := mp.Matches(nil); err != nil {
+			errs = append(errs, fmt.Errorf("mountPodPatch[%d].pvcSelector: %v", i, err))
+		}
+	}
+	switch c.SidecarCredentials {
+	case "", SidecarCredentialsSecret, SidecarCredentialsFetch:
+	default:
+		errs = append(errs, fmt.Errorf("sidecarCredentials: unknown value %q", c.SidecarCredentials))
+	}
+	if c.SidecarInjection != nil {
+		switch c.SidecarInjection.DefaultMode {
+		case "", InjectModeSidecar, InjectModeMountPod:
+		default:
+			errs = append(errs, fmt.Errorf("sidecarInjection.defaultMode: unknown mode %q", c.SidecarInjection.DefaultMode))
+		}
+		for i, r := range c.SidecarInjection.Rules {
+			if r.Mode != InjectModeSidecar && r.Mode != InjectModeMountPod {
+				errs = append(errs, fmt.Errorf("sidecarInjection.rules[%d]: unknown mode %q", i, r.Mode))
+			}
+			if err := validateSelectors(r.NamespaceSelector, r.PodSelector); err != nil {
+				errs = append(errs, fmt.Errorf("sidecarInjection.rules[%d]: %v", i, err))
+			}
+		}
+	}
+	for i, p := range c.ServerlessProfiles {
+		if err := p.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("serverlessProfiles[%d]: %v", i, err))
+		}
+		if err := validateSelectors(p.NodeSelector, p.PodSelector); err != nil {
+			errs = append(errs, fmt.Errorf("serverlessProfiles[%d]: %v", i, err))
+		}
+	}
+	if c.ServerlessDetection != nil {
+		for i, r := range c.ServerlessDetection.Rules {
+			if r.Name == "" {
+				errs = append(errs, fmt.Errorf("serverlessDetection.rules[%d]: name is required", i))
+			}
+			if err := validateSelectors(r.NodeSelector, r.PodSelector); err != nil {
+				errs = append(errs, fmt.Errorf("serverlessDetection.rules[%d]: %v", i, err))
+			}
+		}
+	}
+	if gc := c.CacheGC; gc != nil {
+		for i, dir := range gc.CacheDirs {
+			if !filepath.IsAbs(dir) || filepath.Clean(dir) == "/" {
+				errs = append(errs, fmt.Errorf("cacheGC.cacheDirs[%d]: %q is not an absolute path of directory", i, dir))
+			}
+		}
+		if gc.MaxSize != nil && gc.MaxSize.Sign() < 0 {
+			errs = append(errs, fmt.Errorf("cacheGC.maxSize: negative size %s", gc.MaxSize.String()))
+		}
+		errs = appendDurationError(errs, "cacheGC.interval", gc.Interval)
+		errs = appendDurationError(errs, "cacheGC.maxAge", gc.MaxAge)
+	}
+	if v := c.SecretValidation; v != nil {
+		errs = appendDurationError(errs, "secretValidation.timeout", v.Timeout)
+		errs = appendDurationError(errs, "secretValidation.cacheTTL", v.CacheTTL)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func appendDurationError(errs []error, field string, d *metav1.Duration) []error {
+	if d != nil && d.Duration < 0 {
+		return append(errs, fmt.Errorf("%s: negative duration %s", field, d.Duration))
+	}
+	return errs
+}
+
+func validateSelectors(selectors ...*metav1.LabelSelector) error {
+	for _, s := range selectors {
+		if s == nil {
+			continue
+		}
+		if _, err := metav1.LabelSelectorAsSelector(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GenMountPodPatch generate mount pod patch from jfsSettting
 // 1. match pv selector
 // 2. parse template value
@@ -245,19 +803,8 @@ func (c *Config) GenMountPodPatch(setting JfsSetting) MountPodPatch {
 
 	// merge each patch
 	for _, mp := range c.MountPodPatch {
-		if mp.PVCSelector == nil {
+		if matched, _ := mp.Matches(setting.PVC); matched {
 			patch.merge(mp.deepCopy())
-		} else {
-			if setting.PVC == nil {
-				continue
-			}
-			selector, err := metav1.LabelSelectorAsSelector(mp.PVCSelector)
-			if err != nil {
-				continue
-			}
-			if selector.Matches(labels.Set(setting.PVC.Labels)) {
-				patch.merge(mp.deepCopy())
-			}
 		}
 	}
 	if setting.IsCe {
@@ -19,9 +19,11 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -267,3 +269,248 @@ func TestGenMountPodPatchParseTwice(t *testing.T) {
 	actualPatch = baseConfig.GenMountPodPatch(setting)
 	assert.Equal(t, expectedPatch2, actualPatch)
 }
+
+func TestSidecarInjectionDecide(t *testing.T) {
+	injection := &SidecarInjection{
+		DefaultMode: InjectModeMountPod,
+		Rules: []SidecarInjectionRule{
+			{
+				Name:         "opt-in",
+				RequireOptIn: true,
+				Mode:         InjectModeSidecar,
+			},
+			{
+				Name:              "team-a",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+				PodSelector:       &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpExists}}},
+				Mode:              InjectModeSidecar,
+			},
+			{
+				Name:           "shared-sc",
+				Namespaces:     []string{"default"},
+				StorageClasses: []string{"juicefs-shared"},
+				Mode:           InjectModeSidecar,
+			},
+			{
+				Name: "invalid",
+				Mode: "unknown",
+			},
+		},
+	}
+	tests := []struct {
+		name     string
+		target   SidecarInjectionTarget
+		wantMode string
+		wantRule string
+	}{
+		{
+			name:     "opt in",
+			target:   SidecarInjectionTarget{Namespace: "default", PodAnnotations: map[string]string{InjectSidecarEnable: True}},
+			wantMode: InjectModeSidecar,
+			wantRule: "opt-in",
+		},
+		{
+			name:     "namespace and pod selector",
+			target:   SidecarInjectionTarget{Namespace: "ns-a", NamespaceLabels: map[string]string{"team": "a"}, PodLabels: map[string]string{"app": "x"}},
+			wantMode: InjectModeSidecar,
+			wantRule: "team-a",
+		},
+		{
+			name:     "pod selector not matched",
+			target:   SidecarInjectionTarget{Namespace: "ns-a", NamespaceLabels: map[string]string{"team": "a"}},
+			wantMode: InjectModeMountPod,
+		},
+		{
+			name:     "storageClass",
+			target:   SidecarInjectionTarget{Namespace: "default", StorageClass: "juicefs-shared"},
+			wantMode: InjectModeSidecar,
+			wantRule: "shared-sc",
+		},
+		{
+			name:     "storageClass in other namespace",
+			target:   SidecarInjectionTarget{Namespace: "other", StorageClass: "juicefs-shared"},
+			wantMode: InjectModeMountPod,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, rule := injection.Decide(tt.target)
+			assert.Equal(t, tt.wantMode, mode)
+			assert.Equal(t, tt.wantRule, rule)
+		})
+	}
+
+	// sidecar by default, compatible with old versions
+	mode, rule := (&SidecarInjection{}).Decide(SidecarInjectionTarget{Namespace: "default"})
+	assert.Equal(t, InjectModeSidecar, mode)
+	assert.Equal(t, "", rule)
+}
+
+func TestFindServerlessProfile(t *testing.T) {
+	cfg := &Config{ServerlessProfiles: []ServerlessProfile{
+		{
+			Name:             "invalid",
+			MountPropagation: ServerlessPropagationCSI,
+		},
+		{
+			Name:      "provider",
+			Providers: []string{"acme"},
+		},
+		{
+			Name:         "node",
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"type": "virtual-kubelet"}},
+			PodSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"app": "x"}},
+		},
+	}}
+	tests := []struct {
+		name   string
+		target ServerlessProfileTarget
+		want   string
+	}{
+		{
+			name:   "provider",
+			target: ServerlessProfileTarget{Providers: []string{"other", "acme"}},
+			want:   "provider",
+		},
+		{
+			name:   "node and pod selector",
+			target: ServerlessProfileTarget{NodeSelector: map[string]string{"type": "virtual-kubelet"}, PodLabels: map[string]string{"app": "x"}},
+			want:   "node",
+		},
+		{
+			name:   "pod selector not matched",
+			target: ServerlessProfileTarget{NodeSelector: map[string]string{"type": "virtual-kubelet"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := cfg.FindServerlessProfile(tt.target)
+			if tt.want == "" {
+				assert.Nil(t, profile)
+				return
+			}
+			if assert.NotNil(t, profile) {
+				assert.Equal(t, tt.want, profile.Name)
+			}
+		})
+	}
+}
+
+func TestServerlessDetectionDetect(t *testing.T) {
+	detection := &ServerlessDetection{
+		Auto: true,
+		Rules: []ServerlessDetectionRule{
+			{
+				Name:        "gpu-pool",
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "gpu"}},
+				Serverless:  false,
+			},
+			{
+				Name:       "acme",
+				Providers:  []string{"acme"},
+				Serverless: true,
+			},
+		},
+	}
+	virtualAffinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{
+				Key:      VirtualKubeletNodeLabelKey,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   []string{VirtualKubeletNodeLabelValue},
+			}},
+		}}},
+	}}
+	tests := []struct {
+		name           string
+		pod            corev1.Pod
+		wantServerless bool
+		wantDecided    bool
+	}{
+		{
+			name: "annotation",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{InjectSidecarServerless: False}, Labels: map[string]string{"pool": "gpu"}},
+				Spec:       corev1.PodSpec{NodeSelector: map[string]string{VirtualKubeletNodeLabelKey: VirtualKubeletNodeLabelValue}},
+			},
+			wantDecided: true,
+		},
+		{
+			name: "rule",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"pool": "gpu"}},
+				Spec:       corev1.PodSpec{NodeSelector: map[string]string{VirtualKubeletNodeLabelKey: VirtualKubeletNodeLabelValue}},
+			},
+			wantDecided: true,
+		},
+		{
+			name:           "provider rule",
+			pod:            corev1.Pod{Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{{Key: VirtualKubeletProviderKey, Value: "acme"}}}},
+			wantServerless: true,
+			wantDecided:    true,
+		},
+		{
+			name:           "node selector",
+			pod:            corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{VirtualKubeletNodeLabelKey: VirtualKubeletNodeLabelValue}}},
+			wantServerless: true,
+			wantDecided:    true,
+		},
+		{
+			name:           "node affinity",
+			pod:            corev1.Pod{Spec: corev1.PodSpec{Affinity: virtualAffinity}},
+			wantServerless: true,
+			wantDecided:    true,
+		},
+		{
+			name: "regular node",
+			pod:  corev1.Pod{Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{{Key: VirtualKubeletProviderKey, Operator: corev1.TolerationOpExists}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverless, _, decided := detection.Detect(&tt.pod)
+			assert.Equal(t, tt.wantServerless, serverless)
+			assert.Equal(t, tt.wantDecided, decided)
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	invalidSelector := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "a", Operator: "bad"}}}
+	cfg := &Config{
+		MountPodPatch: []MountPodPatch{
+			{CEMountImage: "juicedata/mount:ce-test"},
+			{PVCSelector: invalidSelector},
+		},
+		SidecarCredentials: "unknown",
+		SidecarInjection:   &SidecarInjection{Rules: []SidecarInjectionRule{{Name: "r", Mode: "other"}}},
+		ServerlessProfiles: []ServerlessProfile{{Name: "p", Providers: []string{"acme"}, PodSelector: invalidSelector}},
+		ServerlessDetection: &ServerlessDetection{Rules: []ServerlessDetectionRule{
+			{Name: "vk", Providers: []string{"acme"}, Serverless: true},
+			{NodeSelector: invalidSelector},
+		}},
+		CacheGC: &CacheGCPolicy{
+			CacheDirs: []string{"/var/jfsCache", "cache"},
+			MaxSize:   resource.NewQuantity(-1, resource.BinarySI),
+			MaxAge:    &metav1.Duration{Duration: -time.Hour},
+		},
+		SecretValidation: &SecretValidation{Timeout: &metav1.Duration{Duration: -time.Second}},
+	}
+	err := cfg.Validate()
+	if assert.Error(t, err) {
+		for _, field := range []string{"mountPodPatch[1].pvcSelector", "sidecarCredentials", "sidecarInjection.rules[0]", "serverlessProfiles[0]",
+			"serverlessDetection.rules[1]: name is required", "cacheGC.cacheDirs[1]", "cacheGC.maxSize", "cacheGC.maxAge", "secretValidation.timeout"} {
+			assert.Contains(t, err.Error(), field)
+		}
+		for _, field := range []string{"mountPodPatch[0]", "serverlessDetection.rules[0]", "cacheGC.cacheDirs[0]", "cacheGC.interval"} {
+			assert.NotContains(t, err.Error(), field)
+		}
+	}
+	assert.NoError(t, (&Config{MountPodPatch: []MountPodPatch{{PVCSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"a": "b"}}}}}).Validate())
+
+	matched, err := cfg.MountPodPatch[0].Matches(nil)
+	assert.True(t, matched)
+	assert.NoError(t, err)
+	_, err = cfg.MountPodPatch[1].Matches(&corev1.PersistentVolumeClaim{})
+	assert.Error(t, err)
+}
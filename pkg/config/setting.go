@@ -344,6 +344,11 @@ func ParseSetting(secrets, volCtx map[string]string, options []string, usePod bo
 }
 
 func GenPodAttrWithCfg(setting *JfsSetting, volCtx map[string]string) error {
+	return GlobalConfig.GenPodAttr(setting, volCtx)
+}
+
+// GenPodAttr generates attributes of mount pod from volume context and mount pod patches of the config
+func (c *Config) GenPodAttr(setting *JfsSetting, volCtx map[string]string) error {
 	var err error
 	var attr *PodAttr
 	if setting.Attr != nil {
@@ -417,7 +422,7 @@ func GenPodAttrWithCfg(setting *JfsSetting, volCtx map[string]string) error {
 	}
 
 	// overwrite by mountpod patch
-	patch := GlobalConfig.GenMountPodPatch(*setting)
+	patch := c.GenMountPodPatch(*setting)
 	if patch.Image != "" {
 		attr.Image = patch.Image
 	}
@@ -605,3 +610,111 @@ func getDefaultResource() corev1.ResourceRequirements {
 		},
 	}
 }
+
+// volumeSettingKeys are keys of juicefs settings in volume attributes, storageClass parameters and pvc annotations
+var volumeSettingKeys = []string{
+	MountPodCpuLimitKey, MountPodMemLimitKey, MountPodCpuRequestKey, MountPodMemRequestKey,
+	mountPodLabelKey, mountPodAnnotationKey, mountPodServiceAccount, mountPodImageKey,
+	deleteDelay, cleanCache, cachePVC, cacheEmptyDir, cacheInlineVolume, mountPodHostPath,
+}
+
+// ValidateVolumeContext validates juicefs settings in volume context, which are parsed by ParseSetting when mounting
+func ValidateVolumeContext(volCtx map[string]string) error {
+	for k, v := range volCtx {
+		if err := ValidateVolumeContextKey(k, v); err != nil {
+			return err
+		}
+	}
+	return ValidateVolumeContextCombination(volCtx)
+}
+
+// ValidateVolumeContextKey validates a single juicefs setting in volume context, keys without juicefs/ prefix are skipped
+func ValidateVolumeContextKey(key, value string) error {
+	if !strings.HasPrefix(key, "juicefs/") {
+		return nil
+	}
+	if !containsString(volumeSettingKeys, key) {
+		return fmt.Errorf("unknown setting %s, supported settings: %s", key, strings.Join(volumeSettingKeys, ", "))
+	}
+	if err := validateVolumeSetting(key, value); err != nil {
+		return fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	return nil
+}
+
+// ValidateVolumeContextCombination validates juicefs settings in volume context depending on each other,
+// e.g. requests of mount pod resources must not exceed limits.
+func ValidateVolumeContextCombination(volCtx map[string]string) error {
+	resources, err := ParsePodResources(volCtx[MountPodCpuLimitKey], volCtx[MountPodMemLimitKey], volCtx[MountPodCpuRequestKey], volCtx[MountPodMemRequestKey])
+	if err != nil {
+		return err
+	}
+	// mount pod is rejected by apiserver if request exceeds limit
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return fmt.Errorf("%s request %s of mount pod exceeds limit %s", name, request.String(), limit.String())
+		}
+	}
+	// parse as mounting to catch anything else
+	if _, err := ParseSetting(map[string]string{"name": "validate"}, volCtx, nil, true, nil, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+func validateVolumeSetting(key, value string) error {
+	switch key {
+	case MountPodCpuLimitKey, MountPodMemLimitKey, MountPodCpuRequestKey, MountPodMemRequestKey:
+		if value == "" {
+			return nil
+		}
+		_, err := resource.ParseQuantity(value)
+		return err
+	case mountPodLabelKey, mountPodAnnotationKey:
+		if value == "" {
+			return nil
+		}
+		return parseYamlOrJson(value, &map[string]string{})
+	case deleteDelay:
+		if value == "" {
+			return nil
+		}
+		_, err := time.ParseDuration(value)
+		return err
+	case cleanCache:
+		if value != True && value != False {
+			return fmt.Errorf("must be %q or %q", True, False)
+		}
+	case cacheEmptyDir:
+		parts := strings.Split(strings.TrimSpace(value), ":")
+		if len(parts) > 2 {
+			return fmt.Errorf("must be in format <medium>[:<sizeLimit>]")
+		}
+		medium := corev1.StorageMedium(strings.TrimSpace(parts[0]))
+		if medium != corev1.StorageMediumDefault && medium != corev1.StorageMediumMemory && !strings.HasPrefix(string(medium), string(corev1.StorageMediumHugePages)) {
+			return fmt.Errorf("unsupported medium %s", medium)
+		}
+		if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+			if _, err := resource.ParseQuantity(strings.TrimSpace(parts[1])); err != nil {
+				return fmt.Errorf("invalid sizeLimit: %v", err)
+			}
+		}
+	case cacheInlineVolume:
+		inlineVolumes := []*corev1.CSIVolumeSource{}
+		if err := json.Unmarshal([]byte(value), &inlineVolumes); err != nil {
+			return err
+		}
+		for i, v := range inlineVolumes {
+			if v == nil || v.Driver == "" {
+				return fmt.Errorf("driver of inline volume %d is empty", i)
+			}
+		}
+	case mountPodHostPath:
+		for _, p := range strings.Split(value, ",") {
+			if p = strings.TrimSpace(p); p != "" && !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("host path %s is not absolute", p)
+			}
+		}
+	}
+	return nil
+}
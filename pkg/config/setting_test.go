@@ -836,3 +836,76 @@ func Test_ParseFormatOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateVolumeContext(t *testing.T) {
+	tests := []struct {
+		name    string
+		volCtx  map[string]string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			volCtx: map[string]string{
+				MountPodCpuLimitKey:    "1",
+				MountPodMemLimitKey:    "2Gi",
+				deleteDelay:            "10m",
+				cleanCache:             "true",
+				cacheEmptyDir:          "Memory:1Gi",
+				cacheInlineVolume:      `[{"driver":"local.csi.aliyun.com","volumeAttributes":{"vgName":"yoda-pool"}}]`,
+				mountPodLabelKey:       "a: b",
+				mountPodHostPath:       "/data, /log",
+				"subPath":              "sub",
+				"csi.storage.k8s.io/x": "y",
+			},
+		},
+		{
+			name:    "unknown key",
+			volCtx:  map[string]string{"juicefs/mount-cpu-limt": "1"},
+			wantErr: "unknown setting juicefs/mount-cpu-limt",
+		},
+		{
+			name:    "invalid cpu",
+			volCtx:  map[string]string{MountPodCpuLimitKey: "1 core"},
+			wantErr: "invalid juicefs/mount-cpu-limit",
+		},
+		{
+			name:    "invalid delay",
+			volCtx:  map[string]string{deleteDelay: "10 min"},
+			wantErr: "invalid juicefs/mount-delete-delay",
+		},
+		{
+			name:    "invalid inline volume",
+			volCtx:  map[string]string{cacheInlineVolume: `[{"driver":}]`},
+			wantErr: "invalid juicefs/mount-cache-inline-volume",
+		},
+		{
+			name:    "invalid emptyDir",
+			volCtx:  map[string]string{cacheEmptyDir: "Memory:1 G"},
+			wantErr: "invalid juicefs/mount-cache-emptydir",
+		},
+		{
+			name:    "invalid clean cache",
+			volCtx:  map[string]string{cleanCache: "yes"},
+			wantErr: "invalid juicefs/clean-cache",
+		},
+		{
+			name:    "relative host path",
+			volCtx:  map[string]string{mountPodHostPath: "data"},
+			wantErr: "invalid juicefs/host-path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateVolumeContext(tt.volCtx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateVolumeContext() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateVolumeContext() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
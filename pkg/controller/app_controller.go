@@ -181,6 +181,14 @@ func ShouldInQueue(pod *corev1.Pod) bool {
 		return false
 	}
 
+	// ignore if fuse container is native sidecar, which is stopped by kubelet after app containers exit
+	for _, cn := range pod.Spec.InitContainers {
+		if strings.Contains(cn.Name, config.MountContainerName) {
+			klog.V(6).Infof("juicefs sidecar in pod [%s] in [%s] is native sidecar, skip.", pod.Name, pod.Namespace)
+			return false
+		}
+	}
+
 	// ignore if no fuse container
 	exist := false
 	for _, cn := range pod.Spec.Containers {
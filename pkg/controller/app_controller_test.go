@@ -68,6 +68,26 @@ func Test_shouldRequeue(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "native-sidecar",
+			args: args{
+				pod: &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "test",
+						Labels: map[string]string{
+							config.InjectSidecarDone: config.True,
+						},
+					},
+					Spec: corev1.PodSpec{
+						RestartPolicy:  corev1.RestartPolicyNever,
+						InitContainers: []corev1.Container{{Name: config.MountContainerName}},
+						Containers:     []corev1.Container{{Name: "app"}},
+					},
+					Status: corev1.PodStatus{Phase: corev1.PodRunning},
+				},
+			},
+			want: false,
+		},
 		{
 			name: "no-fuse",
 			args: args{
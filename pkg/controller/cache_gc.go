@@ -0,0 +1,318 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+)
+
+const (
+	defaultCacheDir = "/var/jfsCache"
+
+	CacheGCReasonVolumeDeleted = "VolumeDeleted"
+	CacheGCReasonExpired       = "Expired"
+	CacheGCReasonOverSize      = "OverSize"
+)
+
+// CacheGC collects garbage in cache dirs of the node according to config.GlobalConfig.CacheGC
+type CacheGC struct {
+	*k8sclient.K8sClient
+
+	mu         sync.Mutex
+	lastReport *CacheGCReport
+}
+
+// CacheGCAction is a path removed (or would be removed in dry run) by cache gc
+type CacheGCAction struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// CacheGCReport is the result of one round of cache gc
+type CacheGCReport struct {
+	StartAt    time.Time       `json:"startAt"`
+	DryRun     bool            `json:"dryRun"`
+	CacheDirs  []string        `json:"cacheDirs"`
+	Actions    []CacheGCAction `json:"actions"`
+	FreedBytes int64           `json:"freedBytes"`
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+type cacheBlock struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// cacheDirUsage is the volumes using a cache dir on the node
+type cacheDirUsage struct {
+	uuids map[string]bool
+	// some mount pod using the cache dir has no uuid, the dir can not be collected by volume
+	unknown bool
+	// the cache dir is a hostPath of mount pods on the node
+	inUse bool
+}
+
+// NewCacheGC creates cache gc, client is nil in process mode
+func NewCacheGC(client *k8sclient.K8sClient) *CacheGC {
+	return &CacheGC{K8sClient: client}
+}
+
+// Run runs cache gc periodically until ctx is done
+func (g *CacheGC) Run(ctx context.Context) {
+	for {
+		policy := config.GlobalConfig.CacheGC
+		if policy != nil && policy.Enabled {
+			report := g.RunOnce(ctx, policy)
+			klog.Infof("[CacheGC] finished, dryRun: %v, actions: %d, freed bytes: %d, errors: %d",
+				report.DryRun, len(report.Actions), report.FreedBytes, len(report.Errors))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(policy.GetInterval()):
+		}
+	}
+}
+
+// RunOnce runs one round of cache gc with policy
+func (g *CacheGC) RunOnce(ctx context.Context, policy *config.CacheGCPolicy) *CacheGCReport {
+	report := &CacheGCReport{
+		StartAt: time.Now(),
+		DryRun:  policy.DryRun,
+		Actions: []CacheGCAction{},
+	}
+	defer func() {
+		g.mu.Lock()
+		g.lastReport = report
+		g.mu.Unlock()
+	}()
+
+	usages, err := g.getCacheDirUsages(ctx, policy)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	for dir := range usages {
+		report.CacheDirs = append(report.CacheDirs, dir)
+	}
+	sort.Strings(report.CacheDirs)
+
+	for _, dir := range report.CacheDirs {
+		if ctx.Err() != nil {
+			report.Errors = append(report.Errors, ctx.Err().Error())
+			return report
+		}
+		g.collectCacheDir(policy, dir, usages[dir], report)
+	}
+	return report
+}
+
+// LastReport returns report of the last round
+func (g *CacheGC) LastReport() *CacheGCReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lastReport
+}
+
+// ServeHTTP serves the last report of cache gc
+func (g *CacheGC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := g.LastReport()
+	if report == nil {
+		http.Error(w, "cache gc has not run yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		klog.Errorf("[CacheGC] encode report error: %v", err)
+	}
+}
+
+// getCacheDirUsages gets cache dirs to collect, and volumes using each of them on the node
+func (g *CacheGC) getCacheDirUsages(ctx context.Context, policy *config.CacheGCPolicy) (map[string]*cacheDirUsage, error) {
+	usages := make(map[string]*cacheDirUsage)
+	dirs := policy.CacheDirs
+	if len(dirs) == 0 {
+		dirs = []string{defaultCacheDir}
+	}
+	for _, dir := range dirs {
+		usages[filepath.Clean(dir)] = &cacheDirUsage{uuids: map[string]bool{}}
+	}
+	if g.K8sClient == nil {
+		// process mode, volumes using the cache dirs are unknown
+		for _, usage := range usages {
+			usage.unknown = true
+		}
+		return usages, nil
+	}
+
+	labelSelector := &metav1.LabelSelector{MatchLabels: map[string]string{
+		config.PodTypeKey: config.PodTypeValue,
+	}}
+	fieldSelector := &fields.Set{"spec.nodeName": config.NodeName}
+	pods, err := g.ListPod(ctx, config.Namespace, labelSelector, fieldSelector)
+	if err != nil {
+		return nil, fmt.Errorf("list mount pods error: %v", err)
+	}
+	for _, pod := range pods {
+		uuid := pod.Annotations[config.JuiceFSUUID]
+		for _, volume := range pod.Spec.Volumes {
+			if !strings.HasPrefix(volume.Name, "cachedir-") || volume.HostPath == nil {
+				continue
+			}
+			dir := filepath.Clean(volume.HostPath.Path)
+			usage, ok := usages[dir]
+			if !ok {
+				usage = &cacheDirUsage{uuids: map[string]bool{}}
+				usages[dir] = usage
+			}
+			usage.inUse = true
+			if uuid == "" {
+				usage.unknown = true
+				continue
+			}
+			usage.uuids[uuid] = true
+		}
+	}
+	return usages, nil
+}
+
+func (g *CacheGC) collectCacheDir(policy *config.CacheGCPolicy, dir string, usage *cacheDirUsage, report *CacheGCReport) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			report.Errors = append(report.Errors, fmt.Sprintf("read cache dir %s error: %v", dir, err))
+		} else if usage.inUse {
+			report.Errors = append(report.Errors, fmt.Sprintf("cache dir %s is not mounted into CSI node", dir))
+		}
+		return
+	}
+
+	var blocks []cacheBlock
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		uuid := entry.Name()
+		chunksPath := filepath.Join(dir, uuid, "raw", "chunks")
+		uuidBlocks, err := listCacheBlocks(chunksPath)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("list cache blocks in %s error: %v", chunksPath, err))
+			continue
+		}
+		if len(uuidBlocks) == 0 {
+			continue
+		}
+		if policy.CleanDeletedVolumes && !usage.unknown && !usage.uuids[uuid] {
+			var size int64
+			for _, b := range uuidBlocks {
+				size += b.size
+			}
+			g.remove(chunksPath, size, CacheGCReasonVolumeDeleted, policy.DryRun, report)
+			continue
+		}
+		blocks = append(blocks, uuidBlocks...)
+	}
+
+	// remove expired blocks
+	remained := blocks[:0]
+	for _, b := range blocks {
+		if policy.MaxAge != nil && policy.MaxAge.Duration > 0 && report.StartAt.Sub(b.modTime) > policy.MaxAge.Duration {
+			g.remove(b.path, b.size, CacheGCReasonExpired, policy.DryRun, report)
+			continue
+		}
+		remained = append(remained, b)
+	}
+
+	// remove the oldest blocks until the total size is under max size
+	if policy.MaxSize == nil || policy.MaxSize.Value() <= 0 {
+		return
+	}
+	var total int64
+	for _, b := range remained {
+		total += b.size
+	}
+	sort.Slice(remained, func(i, j int) bool {
+		return remained[i].modTime.Before(remained[j].modTime)
+	})
+	for _, b := range remained {
+		if total <= policy.MaxSize.Value() {
+			break
+		}
+		g.remove(b.path, b.size, CacheGCReasonOverSize, policy.DryRun, report)
+		total -= b.size
+	}
+}
+
+func (g *CacheGC) remove(path string, size int64, reason string, dryRun bool, report *CacheGCReport) {
+	if dryRun {
+		klog.Infof("[CacheGC] dry run: would remove %s (%d bytes), reason: %s", path, size, reason)
+	} else {
+		klog.V(5).Infof("[CacheGC] remove %s (%d bytes), reason: %s", path, size, reason)
+		if err := os.RemoveAll(path); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("remove %s error: %v", path, err))
+			return
+		}
+		report.FreedBytes += size
+	}
+	report.Actions = append(report.Actions, CacheGCAction{Path: path, Size: size, Reason: reason})
+}
+
+// listCacheBlocks lists all cached blocks under chunks path
+func listCacheBlocks(chunksPath string) ([]cacheBlock, error) {
+	var blocks []cacheBlock
+	err := filepath.WalkDir(chunksPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed by juicefs client
+				return nil
+			}
+			return err
+		}
+		blocks = append(blocks, cacheBlock{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	return blocks, err
+}
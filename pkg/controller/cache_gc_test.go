@@ -0,0 +1,153 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+)
+
+func writeCacheBlock(t *testing.T, dir, uuid, name string, size int, age time.Duration) string {
+	path := filepath.Join(dir, uuid, "raw", "chunks", "0", "0", name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCacheGC_RunOnce(t *testing.T) {
+	cacheDir := t.TempDir()
+	inUseOld := writeCacheBlock(t, cacheDir, "in-use", "1_0_4194304", 100, 3*time.Hour)
+	inUseMid := writeCacheBlock(t, cacheDir, "in-use", "2_0_4194304", 100, 2*time.Hour)
+	inUseNew := writeCacheBlock(t, cacheDir, "in-use", "3_0_4194304", 100, time.Minute)
+	expired := writeCacheBlock(t, cacheDir, "in-use", "4_0_4194304", 100, 48*time.Hour)
+	writeCacheBlock(t, cacheDir, "deleted", "1_0_4194304", 50, time.Minute)
+	staging := filepath.Join(cacheDir, "in-use", "rawstaging", "chunks", "5_0_4194304")
+	if err := os.MkdirAll(filepath.Dir(staging), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(staging, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mountPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "juicefs-test-node-pvc-xxx",
+			Namespace:   config.Namespace,
+			Labels:      map[string]string{config.PodTypeKey: config.PodTypeValue},
+			Annotations: map[string]string{config.JuiceFSUUID: "in-use"},
+		},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name:         "cachedir-0",
+				VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: cacheDir}},
+			}},
+		},
+	}
+	client := &k8sclient.K8sClient{Interface: fake.NewSimpleClientset(mountPod)}
+	maxSize := resource.MustParse("150")
+	policy := &config.CacheGCPolicy{
+		Enabled:             true,
+		DryRun:              true,
+		MaxSize:             &maxSize,
+		MaxAge:              &metav1.Duration{Duration: 24 * time.Hour},
+		CleanDeletedVolumes: true,
+	}
+
+	g := NewCacheGC(client)
+	report := g.RunOnce(context.TODO(), policy)
+	want := map[string]string{
+		filepath.Join(cacheDir, "deleted", "raw", "chunks"): CacheGCReasonVolumeDeleted,
+		expired:  CacheGCReasonExpired,
+		inUseOld: CacheGCReasonOverSize,
+		inUseMid: CacheGCReasonOverSize,
+	}
+	if len(report.Errors) != 0 {
+		t.Fatalf("RunOnce() errors = %v", report.Errors)
+	}
+	if len(report.Actions) != len(want) {
+		t.Fatalf("RunOnce() actions = %v, want %v", report.Actions, want)
+	}
+	for _, action := range report.Actions {
+		if want[action.Path] != action.Reason {
+			t.Errorf("RunOnce() unexpected action %v", action)
+		}
+	}
+	// nothing removed in dry run
+	if _, err := os.Stat(inUseOld); err != nil {
+		t.Errorf("RunOnce() removed %s in dry run", inUseOld)
+	}
+	if report.FreedBytes != 0 {
+		t.Errorf("RunOnce() freed %d bytes in dry run", report.FreedBytes)
+	}
+
+	policy.DryRun = false
+	report = g.RunOnce(context.TODO(), policy)
+	if report.FreedBytes != 350 {
+		t.Errorf("RunOnce() freed %d bytes, want 350", report.FreedBytes)
+	}
+	for path := range want {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("RunOnce() did not remove %s", path)
+		}
+	}
+	for _, path := range []string{inUseNew, staging} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("RunOnce() removed %s: %v", path, err)
+		}
+	}
+	if g.LastReport() != report {
+		t.Errorf("LastReport() is not the last report")
+	}
+}
+
+func TestCacheGC_RunOnceUnknownVolumes(t *testing.T) {
+	cacheDir := t.TempDir()
+	block := writeCacheBlock(t, cacheDir, "some-uuid", "1_0_4194304", 100, time.Minute)
+
+	// process mode, volumes using the cache dir are unknown
+	g := NewCacheGC(nil)
+	report := g.RunOnce(context.TODO(), &config.CacheGCPolicy{
+		Enabled:             true,
+		CacheDirs:           []string{cacheDir},
+		CleanDeletedVolumes: true,
+	})
+	if len(report.Actions) != 0 {
+		t.Errorf("RunOnce() actions = %v, want none", report.Actions)
+	}
+	if _, err := os.Stat(block); err != nil {
+		t.Errorf("RunOnce() removed %s: %v", block, err)
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+const (
+	CacheTypeHostPath = "hostPath"
+	CacheTypePVC      = "pvc"
+	CacheTypeEmptyDir = "emptyDir"
+)
+
+// CacheUsage is the local cache used by a volume in a cache dir of the node
+type CacheUsage struct {
+	VolumeUUID string `json:"volumeUUID"`
+	// path of cache dir on the host
+	CacheDir  string `json:"cacheDir"`
+	CacheType string `json:"cacheType"`
+	PV        string `json:"pv,omitempty"`
+	PVC       string `json:"pvc,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	MountPod  string `json:"mountPod,omitempty"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// CacheUsageReport is the cache usage of all volumes on the node
+type CacheUsageReport struct {
+	Node      string       `json:"node"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+	Usages    []CacheUsage `json:"usages"`
+}
+
+// cacheDirOwner is the mount pod and volume using a cache dir
+type cacheDirOwner struct {
+	cacheType string
+	uuid      string
+	pv        string
+	pvc       string
+	namespace string
+	mountPod  string
+}
+
+// CacheUsageCollector computes cache usage of volumes on the node periodically,
+// and exports it as prometheus metrics.
+type CacheUsageCollector struct {
+	*k8sclient.K8sClient
+
+	gauge  *prometheus.GaugeVec
+	mu     sync.Mutex
+	report *CacheUsageReport
+}
+
+// NewCacheUsageCollector creates cache usage collector, client is nil in process mode
+func NewCacheUsageCollector(client *k8sclient.K8sClient, reg prometheus.Registerer) *CacheUsageCollector {
+	c := &CacheUsageCollector{K8sClient: client}
+	c.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cache_usage_bytes",
+		Help: "local cache used by volume in bytes",
+	}, []string{"volume_uuid", "cache_dir", "cache_type", "pv", "pvc", "namespace"})
+	reg.MustRegister(c.gauge)
+	return c
+}
+
+// Run collects cache usage periodically until ctx is done
+func (c *CacheUsageCollector) Run(ctx context.Context) {
+	for {
+		if report, err := c.Collect(ctx); err != nil {
+			klog.Errorf("[CacheUsage] collect cache usage error: %v", err)
+		} else {
+			c.update(report)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.CacheUsageInterval):
+		}
+	}
+}
+
+func (c *CacheUsageCollector) update(report *CacheUsageReport) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report = report
+	c.gauge.Reset()
+	for _, u := range report.Usages {
+		c.gauge.WithLabelValues(u.VolumeUUID, u.CacheDir, u.CacheType, u.PV, u.PVC, u.Namespace).Set(float64(u.Bytes))
+	}
+}
+
+// Report returns the last collected cache usage
+func (c *CacheUsageCollector) Report() *CacheUsageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
+
+// ServeHTTP serves the last collected cache usage
+func (c *CacheUsageCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := c.Report()
+	if report == nil {
+		http.Error(w, "cache usage has not been collected yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		klog.Errorf("[CacheUsage] encode report error: %v", err)
+	}
+}
+
+// Collect computes cache usage of each volume in each cache dir on the node
+func (c *CacheUsageCollector) Collect(ctx context.Context) (*CacheUsageReport, error) {
+	owners, err := c.getCacheDirOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &CacheUsageReport{
+		Node:      config.NodeName,
+		UpdatedAt: time.Now(),
+		Usages:    []CacheUsage{},
+	}
+	dirs := make([]string, 0, len(owners))
+	for dir := range owners {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		report.Usages = append(report.Usages, collectCacheDirUsage(dir, owners[dir])...)
+	}
+	return report, nil
+}
+
+// getCacheDirOwners gets all cache dirs on the node, and the mount pods using them
+func (c *CacheUsageCollector) getCacheDirOwners(ctx context.Context) (map[string][]cacheDirOwner, error) {
+	owners := make(map[string][]cacheDirOwner)
+	if c.K8sClient == nil {
+		// process mode, only default cache dir is known
+		owners[defaultCacheDir] = []cacheDirOwner{{cacheType: CacheTypeHostPath}}
+		return owners, nil
+	}
+
+	labelSelector := &metav1.LabelSelector{MatchLabels: map[string]string{
+		config.PodTypeKey: config.PodTypeValue,
+	}}
+	fieldSelector := &fields.Set{"spec.nodeName": config.NodeName}
+	pods, err := c.ListPod(ctx, config.Namespace, labelSelector, fieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	kubeletDir := getKubeletDir()
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		owner := cacheDirOwner{
+			uuid:     pod.Annotations[config.JuiceFSUUID],
+			mountPod: pod.Name,
+		}
+		pv, err := getPVOfMountPod(ctx, c.K8sClient, pod)
+		if err != nil {
+			klog.Warningf("[CacheUsage] get pv of mount pod %s error: %v", pod.Name, err)
+		}
+		if pv != nil {
+			owner.pv = pv.Name
+			if pv.Spec.ClaimRef != nil {
+				owner.pvc = pv.Spec.ClaimRef.Name
+				owner.namespace = pv.Spec.ClaimRef.Namespace
+			}
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if !strings.HasPrefix(volume.Name, "cachedir-") {
+				continue
+			}
+			o := owner
+			var dir string
+			switch {
+			case volume.HostPath != nil:
+				o.cacheType = CacheTypeHostPath
+				dir = volume.HostPath.Path
+			case volume.EmptyDir != nil:
+				o.cacheType = CacheTypeEmptyDir
+				dir = filepath.Join(kubeletDir, "pods", string(pod.UID), "volumes", "kubernetes.io~empty-dir", volume.Name)
+			case volume.PersistentVolumeClaim != nil:
+				o.cacheType = CacheTypePVC
+				dir = c.getCachePVCDir(ctx, kubeletDir, pod, volume.PersistentVolumeClaim.ClaimName)
+			}
+			if dir == "" {
+				continue
+			}
+			dir = filepath.Clean(dir)
+			owners[dir] = append(owners[dir], o)
+		}
+	}
+	return owners, nil
+}
+
+// getPVOfMountPod gets the pv mounted by mount pod from its references, which are target paths of app pods:
+// /var/lib/kubelet/pods/<pod-uid>/volumes/kubernetes.io~csi/<pv>/mount. uniqueId of mount pod is volumeHandle
+// of the pv, or its storageClass name if mount pod is shared, nil is returned if mount pod is used by several pvs.
+func getPVOfMountPod(ctx context.Context, client *k8sclient.K8sClient, pod *corev1.Pod) (*corev1.PersistentVolume, error) {
+	uniqueId := pod.Annotations[config.UniqueId]
+	if uniqueId == "" {
+		uniqueId = pod.Labels[config.PodUniqueIdLabelKey]
+	}
+	var pvName string
+	for _, target := range util.GetAllRefKeys(*pod) {
+		pair := strings.Split(target, containerCsiDirectory+"/")
+		if len(pair) != 2 {
+			continue
+		}
+		name := strings.Split(pair[1], "/")[0]
+		if pvName != "" && pvName != name {
+			// volumes of a shared mount pod use the same cache and client, it can not be split by pv
+			return nil, nil
+		}
+		pvName = name
+	}
+	if pvName == "" {
+		return nil, nil
+	}
+	pv, err := client.GetPersistentVolume(ctx, pvName)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName ||
+		(pv.Spec.CSI.VolumeHandle != uniqueId && pv.Spec.StorageClassName != uniqueId) {
+		return nil, nil
+	}
+	return pv, nil
+}
+
+// getCachePVCDir gets path of cache pvc on the host from kubelet dir
+func (c *CacheUsageCollector) getCachePVCDir(ctx context.Context, kubeletDir string, pod *corev1.Pod, claimName string) string {
+	pvc, err := c.GetPersistentVolumeClaim(ctx, claimName, pod.Namespace)
+	if err != nil || pvc.Spec.VolumeName == "" {
+		klog.V(6).Infof("[CacheUsage] get cache pvc %s/%s error: %v", pod.Namespace, claimName, err)
+		return ""
+	}
+	matches, _ := filepath.Glob(filepath.Join(kubeletDir, "pods", string(pod.UID), "volumes", "*", pvc.Spec.VolumeName))
+	for _, match := range matches {
+		// csi volumes are mounted at <volume>/mount
+		if _, err := os.Stat(filepath.Join(match, "mount")); err == nil {
+			return filepath.Join(match, "mount")
+		}
+		return match
+	}
+	return ""
+}
+
+// collectCacheDirUsage computes usage of each volume in cache dir
+func collectCacheDirUsage(dir string, owners []cacheDirOwner) []CacheUsage {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Errorf("[CacheUsage] read cache dir %s error: %v", dir, err)
+		}
+		return nil
+	}
+	var usages []CacheUsage
+	var unclaimed []int
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		uuid := entry.Name()
+		usage := CacheUsage{
+			VolumeUUID: uuid,
+			CacheDir:   dir,
+			CacheType:  owners[0].cacheType,
+			Bytes:      dirSize(filepath.Join(dir, uuid)),
+		}
+		claimed := false
+		for _, o := range owners {
+			// pvc and emptyDir are used by only one mount pod, even if its uuid is unknown
+			if o.uuid == uuid || (o.uuid == "" && len(owners) == 1 && o.cacheType != CacheTypeHostPath) {
+				usage.PV, usage.PVC, usage.Namespace, usage.MountPod = o.pv, o.pvc, o.namespace, o.mountPod
+				claimed = true
+				break
+			}
+		}
+		if !claimed {
+			unclaimed = append(unclaimed, len(usages))
+		}
+		usages = append(usages, usage)
+	}
+
+	// mount pods created without clean cache have no uuid annotation,
+	// the cache is its own if it's the only one of them and only one volume in the dir is not claimed
+	var unknown []cacheDirOwner
+	for _, o := range owners {
+		if o.uuid == "" && o.mountPod != "" {
+			unknown = append(unknown, o)
+		}
+	}
+	if len(unknown) == 1 && len(unclaimed) == 1 {
+		o, usage := unknown[0], &usages[unclaimed[0]]
+		usage.PV, usage.PVC, usage.Namespace, usage.MountPod = o.pv, o.pvc, o.namespace, o.mountPod
+	}
+	return usages
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			// ignore files removed by juicefs client during walking
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// getKubeletDir gets kubelet root dir mounted in csi node
+func getKubeletDir() string {
+	kubeletDir := "/var/lib/kubelet"
+	for _, v := range config.CSIPod.Spec.Volumes {
+		if v.Name == "kubelet-dir" && v.HostPath != nil {
+			kubeletDir = v.HostPath.Path
+			break
+		}
+	}
+	return kubeletDir
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+func TestCacheUsageCollector_Collect(t *testing.T) {
+	hostPathDir := t.TempDir()
+	writeCacheBlock(t, hostPathDir, "uuid-a", "1_0_4194304", 100, time.Minute)
+	writeCacheBlock(t, hostPathDir, "uuid-a", "2_0_4194304", 100, time.Minute)
+	writeCacheBlock(t, hostPathDir, "uuid-unknown", "1_0_4194304", 30, time.Minute)
+
+	kubeletDir := t.TempDir()
+	oldCSIPod := config.CSIPod
+	defer func() { config.CSIPod = oldCSIPod }()
+	config.CSIPod = corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+		Name:         "kubelet-dir",
+		VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: kubeletDir}},
+	}}}}
+	emptyDir := filepath.Join(kubeletDir, "pods", "pod-b-uid", "volumes", "kubernetes.io~empty-dir", "cachedir-empty-dir")
+	writeCacheBlock(t, emptyDir, "uuid-b", "1_0_4194304", 20, time.Minute)
+
+	sharedDir := t.TempDir()
+	writeCacheBlock(t, sharedDir, "uuid-c", "1_0_4194304", 10, time.Minute)
+
+	newPV := func(name, volumeHandle, claim string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef: &corev1.ObjectReference{Name: claim, Namespace: "default"},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       config.DriverName,
+					VolumeHandle: volumeHandle,
+				}},
+			},
+		}
+	}
+	pvA := newPV("pv-a", "pv-a", "pvc-a")
+	// static pv whose name is not its volumeHandle
+	pvB := newPV("static-pv-b", "pv-b", "pvc-b")
+	pvC := newPV("pv-c", "pv-c", "pvc-c")
+	pvC.Spec.StorageClassName = "juicefs-sc"
+	// references of mount pod are target paths of app pods
+	refs := func(uniqueId string, pvs ...string) map[string]string {
+		annotations := map[string]string{config.UniqueId: uniqueId}
+		for _, pv := range pvs {
+			target := "/var/lib/kubelet/pods/app-uid/volumes/kubernetes.io~csi/" + pv + "/mount"
+			annotations[util.GetReferenceKey(target)] = target
+		}
+		return annotations
+	}
+	annotationsA := refs("pv-a", "pv-a")
+	annotationsA[config.JuiceFSUUID] = "uuid-a"
+	podA := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mount-pod-a",
+			Namespace:   config.Namespace,
+			Labels:      map[string]string{config.PodTypeKey: config.PodTypeValue},
+			Annotations: annotationsA,
+		},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "cachedir-0",
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: hostPathDir}},
+		}}},
+	}
+	podB := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mount-pod-b",
+			Namespace: config.Namespace,
+			UID:       "pod-b-uid",
+			Labels:    map[string]string{config.PodTypeKey: config.PodTypeValue},
+			// uuid is unknown when clean cache is not set
+			Annotations: refs("pv-b", "static-pv-b"),
+		},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "cachedir-empty-dir",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		}}},
+	}
+	// mount pod shared by storageClass, pv is got from its reference
+	podC := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mount-pod-c",
+			Namespace:   config.Namespace,
+			Labels:      map[string]string{config.PodTypeKey: config.PodTypeValue},
+			Annotations: refs("juicefs-sc", "pv-c"),
+		},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name:         "cachedir-0",
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: sharedDir}},
+		}}},
+	}
+	client := &k8sclient.K8sClient{Interface: fake.NewSimpleClientset(pvA, pvB, pvC, podA, podB, podC)}
+	c := NewCacheUsageCollector(client, prometheus.NewRegistry())
+
+	report, err := c.Collect(context.TODO())
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	got := map[string]CacheUsage{}
+	for _, u := range report.Usages {
+		got[u.VolumeUUID] = u
+	}
+	if len(got) != 4 {
+		t.Fatalf("Collect() usages = %+v, want 4 usages", report.Usages)
+	}
+	if u := got["uuid-a"]; u.Bytes != 200 || u.PV != "pv-a" || u.PVC != "pvc-a" || u.Namespace != "default" || u.CacheType != CacheTypeHostPath {
+		t.Errorf("Collect() usage of uuid-a = %+v", u)
+	}
+	if u := got["uuid-unknown"]; u.Bytes != 30 || u.PV != "" || u.MountPod != "" {
+		t.Errorf("Collect() usage of uuid-unknown = %+v", u)
+	}
+	if u := got["uuid-b"]; u.Bytes != 20 || u.MountPod != "mount-pod-b" || u.PV != "static-pv-b" || u.PVC != "pvc-b" || u.CacheType != CacheTypeEmptyDir {
+		t.Errorf("Collect() usage of uuid-b = %+v", u)
+	}
+	if u := got["uuid-c"]; u.Bytes != 10 || u.MountPod != "mount-pod-c" || u.PV != "pv-c" || u.PVC != "pvc-c" {
+		t.Errorf("Collect() usage of uuid-c = %+v", u)
+	}
+
+	c.update(report)
+	expected := `
+		# HELP cache_usage_bytes local cache used by volume in bytes
+		# TYPE cache_usage_bytes gauge
+		cache_usage_bytes{cache_dir="` + hostPathDir + `",cache_type="hostPath",namespace="default",pv="pv-a",pvc="pvc-a",volume_uuid="uuid-a"} 200
+	`
+	if err := testutil.CollectAndCompare(c.gauge, strings.NewReader(expected+`
+		cache_usage_bytes{cache_dir="`+hostPathDir+`",cache_type="hostPath",namespace="",pv="",pvc="",volume_uuid="uuid-unknown"} 30
+		cache_usage_bytes{cache_dir="`+emptyDir+`",cache_type="emptyDir",namespace="default",pv="static-pv-b",pvc="pvc-b",volume_uuid="uuid-b"} 20
+		cache_usage_bytes{cache_dir="`+sharedDir+`",cache_type="hostPath",namespace="default",pv="pv-c",pvc="pvc-c",volume_uuid="uuid-c"} 10
+	`)); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
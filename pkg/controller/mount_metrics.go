@@ -0,0 +1,198 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+// clientConfig is the part of .config in juicefs mount point, which contains the ports client listens on
+type clientConfig struct {
+	Port *struct {
+		PrometheusAgent string
+	}
+}
+
+// MetricsTarget is a group of metrics targets, compatible with prometheus http_sd_config
+type MetricsTarget struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// publishMetricsAddr discovers address of metrics served by juicefs client in mount pod,
+// and publishes it in annotation of mount pod.
+func (p *PodDriver) publishMetricsAddr(ctx context.Context, pod *corev1.Pod, mntPath string) {
+	addr, err := discoverMetricsAddr(ctx, pod, mntPath)
+	if err != nil {
+		klog.V(5).Infof("[publishMetricsAddr] discover metrics address of pod %s error: %v", pod.Name, err)
+		return
+	}
+	if addr == "" || pod.Annotations[config.MetricsAddrKey] == addr {
+		return
+	}
+	klog.V(5).Infof("[publishMetricsAddr] metrics address of pod %s is %s", pod.Name, addr)
+	if err := util.AddPodAnnotation(ctx, p.Client, pod, map[string]string{config.MetricsAddrKey: addr}); err != nil {
+		klog.Errorf("[publishMetricsAddr] add metrics address annotation to pod %s error: %v", pod.Name, err)
+	}
+}
+
+// metricsOptionRe matches metrics option in mount command of mount pod
+var metricsOptionRe = regexp.MustCompile(`metrics=([^,\s'"]+)`)
+
+// discoverMetricsAddr gets the address metrics actually bound to.
+// The client may listen on a random port (e.g. hostNetwork), which can be found in .config of mount point;
+// fall back to metrics option in mount command, then the metrics port declared in mount pod.
+// Mount pods of enterprise edition or hostNetwork declare no metrics port, they are skipped without the option.
+func discoverMetricsAddr(ctx context.Context, pod *corev1.Pod, mntPath string) (string, error) {
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("pod ip is empty")
+	}
+	listenAddr, err := readMetricsListenAddr(ctx, mntPath)
+	if listenAddr != "" {
+		return resolveMetricsAddr(pod.Status.PodIP, listenAddr)
+	}
+	var mountCmd string
+	if len(pod.Spec.Containers) > 0 {
+		mountCmd = strings.Join(pod.Spec.Containers[0].Command, " ")
+	}
+	if match := metricsOptionRe.FindStringSubmatch(mountCmd); match != nil {
+		// port 0 is a random port, which is only known from .config
+		if _, port, _ := net.SplitHostPort(match[1]); port != "0" {
+			return resolveMetricsAddr(pod.Status.PodIP, match[1])
+		}
+	}
+	if strings.Contains(mountCmd, config.JfsMountPath) {
+		return "", fmt.Errorf("client of enterprise edition has no fixed metrics port without metrics option, skip it: %v", err)
+	}
+	if pod.Spec.HostNetwork {
+		return "", fmt.Errorf("client of hostNetwork listens on a random metrics port, which is not found in .config, skip it: %v", err)
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == "metrics" {
+				return net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port.ContainerPort))), nil
+			}
+		}
+	}
+	return "", err
+}
+
+// readMetricsListenAddr reads the address metrics listens on from .config of mount point
+func readMetricsListenAddr(ctx context.Context, mntPath string) (string, error) {
+	var data []byte
+	err := util.DoWithTimeout(ctx, defaultCheckoutTimeout, func() (err error) {
+		data, err = os.ReadFile(filepath.Join(mntPath, ".config"))
+		return
+	})
+	if err != nil {
+		return "", fmt.Errorf("read .config error: %v", err)
+	}
+	var cfg clientConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", fmt.Errorf("parse .config error: %v", err)
+	}
+	if cfg.Port == nil {
+		return "", nil
+	}
+	return cfg.Port.PrometheusAgent, nil
+}
+
+// resolveMetricsAddr replaces unspecified host in listen address with the given host
+func resolveMetricsAddr(defaultHost, listenAddr string) (string, error) {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid metrics address %s: %v", listenAddr, err)
+	}
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = defaultHost
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// MetricsTargets serves metrics targets of mount pods on the node, compatible with prometheus http_sd_config
+type MetricsTargets struct {
+	*k8sclient.K8sClient
+}
+
+func NewMetricsTargets(client *k8sclient.K8sClient) *MetricsTargets {
+	return &MetricsTargets{K8sClient: client}
+}
+
+// List lists metrics targets of mount pods on the node
+func (m *MetricsTargets) List(ctx context.Context) ([]MetricsTarget, error) {
+	labelSelector := &metav1.LabelSelector{MatchLabels: map[string]string{
+		config.PodTypeKey: config.PodTypeValue,
+	}}
+	fieldSelector := &fields.Set{"spec.nodeName": config.NodeName}
+	pods, err := m.ListPod(ctx, config.Namespace, labelSelector, fieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	targets := []MetricsTarget{}
+	for _, pod := range pods {
+		addr := pod.Annotations[config.MetricsAddrKey]
+		if addr == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		targets = append(targets, MetricsTarget{
+			Targets: []string{addr},
+			Labels: map[string]string{
+				"namespace": pod.Namespace,
+				"pod":       pod.Name,
+				"node":      pod.Spec.NodeName,
+				"volume_id": pod.Labels[config.PodUniqueIdLabelKey],
+			},
+		})
+	}
+	return targets, nil
+}
+
+func (m *MetricsTargets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m.K8sClient == nil {
+		http.Error(w, "not supported in process mode", http.StatusNotFound)
+		return
+	}
+	targets, err := m.List(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("list metrics targets error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(targets); err != nil {
+		klog.Errorf("[MetricsTargets] encode targets error: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/klog"
+	"k8s.io/utils/mount"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+const mountMetricsScrapeTimeout = 5 * time.Second
+
+var mountInfoPath = "/proc/self/mountinfo"
+
+// mountMetricsSource is a juicefs client serving metrics on the node, and the labels added to its metrics
+type mountMetricsSource struct {
+	addr   string
+	labels map[string]string
+}
+
+// MountMetricsAggregator scrapes metrics of all mount pods (or mount processes) on the node,
+// and re-exposes them with labels of kubernetes resources using the volume.
+type MountMetricsAggregator struct {
+	*k8sclient.K8sClient
+	httpClient *http.Client
+}
+
+// NewMountMetricsAggregator creates mount metrics aggregator, client is nil in process mode
+func NewMountMetricsAggregator(client *k8sclient.K8sClient) *MountMetricsAggregator {
+	return &MountMetricsAggregator{
+		K8sClient:  client,
+		httpClient: &http.Client{Timeout: mountMetricsScrapeTimeout},
+	}
+}
+
+// Gather implements prometheus.Gatherer. Mount pods failed to scrape are skipped,
+// so that metrics of csi node itself are always served.
+func (a *MountMetricsAggregator) Gather() ([]*dto.MetricFamily, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), mountMetricsScrapeTimeout)
+	defer cancel()
+	sources, err := a.listSources(ctx)
+	if err != nil {
+		klog.Errorf("[MountMetrics] list mount metrics sources error: %v", err)
+		return nil, nil
+	}
+
+	results := make([]map[string]*dto.MetricFamily, len(sources))
+	var wg sync.WaitGroup
+	for i := range sources {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mfs, err := a.scrape(ctx, sources[i].addr)
+			if err != nil {
+				klog.V(5).Infof("[MountMetrics] scrape metrics from %s error: %v", sources[i].addr, err)
+				return
+			}
+			results[i] = mfs
+		}(i)
+	}
+	wg.Wait()
+
+	families := make(map[string]*dto.MetricFamily)
+	for i, mfs := range results {
+		for name, mf := range mfs {
+			family, ok := families[name]
+			if !ok {
+				family = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type}
+				families[name] = family
+			} else if family.GetType() != mf.GetType() {
+				klog.V(5).Infof("[MountMetrics] metric %s from %s has inconsistent type, skipped", name, sources[i].addr)
+				continue
+			}
+			for _, m := range mf.Metric {
+				m.Label = mergeLabelPairs(m.Label, sources[i].labels)
+				family.Metric = append(family.Metric, m)
+			}
+		}
+	}
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mfs := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		mfs = append(mfs, families[name])
+	}
+	return mfs, nil
+}
+
+func (a *MountMetricsAggregator) scrape(ctx context.Context, addr string) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/metrics", addr), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// listSources lists juicefs clients on the node
+func (a *MountMetricsAggregator) listSources(ctx context.Context) ([]mountMetricsSource, error) {
+	if a.K8sClient == nil {
+		return listProcessMetricsSources(ctx)
+	}
+
+	labelSelector := &metav1.LabelSelector{MatchLabels: map[string]string{
+		config.PodTypeKey: config.PodTypeValue,
+	}}
+	fieldSelector := &fields.Set{"spec.nodeName": config.NodeName}
+	mountPods, err := a.ListPod(ctx, config.Namespace, labelSelector, fieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	appPods, err := a.ListPod(ctx, "", nil, fieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	appPodNames := make(map[string]string, len(appPods))
+	for _, pod := range appPods {
+		appPodNames[string(pod.UID)] = pod.Namespace + "/" + pod.Name
+	}
+
+	var sources []mountMetricsSource
+	for _, pod := range mountPods {
+		addr := pod.Annotations[config.MetricsAddrKey]
+		if addr == "" || pod.DeletionTimestamp != nil {
+			continue
+		}
+		labels := map[string]string{
+			"mount_pod":    pod.Name,
+			"volume_id":    pod.Labels[config.PodUniqueIdLabelKey],
+			"pv":           "",
+			"pvc":          "",
+			"namespace":    "",
+			"storageclass": "",
+		}
+		// pv labels are left empty if mount pod is shared by several pvs
+		pv, err := getPVOfMountPod(ctx, a.K8sClient, &pod)
+		if err != nil {
+			klog.V(5).Infof("[MountMetrics] get pv of mount pod %s error: %v", pod.Name, err)
+		}
+		if pv != nil {
+			labels["pv"] = pv.Name
+			labels["storageclass"] = pv.Spec.StorageClassName
+			if pv.Spec.ClaimRef != nil {
+				labels["pvc"] = pv.Spec.ClaimRef.Name
+				labels["namespace"] = pv.Spec.ClaimRef.Namespace
+			}
+		}
+		var apps []string
+		for _, target := range util.GetAllRefKeys(pod) {
+			if name, ok := appPodNames[getPodUidFromTarget(target)]; ok {
+				apps = append(apps, name)
+			}
+		}
+		sort.Strings(apps)
+		labels["app_pods"] = strings.Join(apps, ",")
+		sources = append(sources, mountMetricsSource{addr: addr, labels: labels})
+	}
+	return sources, nil
+}
+
+// listProcessMetricsSources lists juicefs clients mounted by csi node itself in process mode
+func listProcessMetricsSources(ctx context.Context) ([]mountMetricsSource, error) {
+	mountInfos, err := mount.ParseMountInfo(mountInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	var sources []mountMetricsSource
+	for _, mi := range mountInfos {
+		if !strings.HasPrefix(mi.FsType, "fuse.juicefs") || filepath.Dir(mi.MountPoint) != filepath.Clean(config.MountBase) {
+			continue
+		}
+		listenAddr, err := readMetricsListenAddr(ctx, mi.MountPoint)
+		if err != nil || listenAddr == "" {
+			klog.V(6).Infof("[MountMetrics] get metrics address of %s error: %v", mi.MountPoint, err)
+			continue
+		}
+		addr, err := resolveMetricsAddr("127.0.0.1", listenAddr)
+		if err != nil {
+			continue
+		}
+		// mount point is named by volumeHandle, pv can not be got without kubernetes client in process mode
+		sources = append(sources, mountMetricsSource{addr: addr, labels: map[string]string{
+			"volume_id": filepath.Base(mi.MountPoint),
+		}})
+	}
+	return sources, nil
+}
+
+// getPodUidFromTarget gets uid of app pod from target path:
+// /var/lib/kubelet/pods/<pod-uid>/volumes/kubernetes.io~csi/<pv>/mount
+func getPodUidFromTarget(target string) string {
+	pair := strings.Split(target, containerCsiDirectory)
+	if len(pair) != 2 {
+		return ""
+	}
+	return filepath.Base(pair[0])
+}
+
+// mergeLabelPairs adds labels to label pairs of a metric, existing labels with the same name are overridden
+func mergeLabelPairs(pairs []*dto.LabelPair, labels map[string]string) []*dto.LabelPair {
+	merged := make([]*dto.LabelPair, 0, len(pairs)+len(labels))
+	for _, pair := range pairs {
+		if _, ok := labels[pair.GetName()]; !ok {
+			merged = append(merged, pair)
+		}
+	}
+	for name, value := range labels {
+		merged = append(merged, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].GetName() < merged[j].GetName() })
+	return merged
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+func Test_discoverMetricsAddr(t *testing.T) {
+	withConfig := func(content string) string {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ".config"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	metricsPort := []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9567}}
+	ceCmd := func(options string) []string {
+		return []string{"sh", "-c", config.CeMountPath + " ${metaurl} /jfs/pv -o " + options}
+	}
+	eeCmd := func(options string) []string {
+		return []string{"sh", "-c", config.JfsMountPath + " vol /jfs/pv -o " + options}
+	}
+	tests := []struct {
+		name        string
+		mntPath     string
+		command     []string
+		hostNetwork bool
+		ports       []corev1.ContainerPort
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:    "random port of hostNetwork",
+			mntPath: withConfig(`{"Port":{"PrometheusAgent":"0.0.0.0:41235"}}`),
+			want:    "10.0.0.1:41235",
+		},
+		{
+			name:    "specified host",
+			mntPath: withConfig(`{"Port":{"PrometheusAgent":"127.0.0.1:9567"}}`),
+			ports:   metricsPort,
+			want:    "127.0.0.1:9567",
+		},
+		{
+			name:    "no port in .config",
+			mntPath: withConfig(`{"Meta":"redis://"}`),
+			ports:   metricsPort,
+			want:    "10.0.0.1:9567",
+		},
+		{
+			name:    "no .config",
+			mntPath: t.TempDir(),
+			ports:   metricsPort,
+			want:    "10.0.0.1:9567",
+		},
+		{
+			name:    "no .config nor metrics port",
+			mntPath: t.TempDir(),
+			wantErr: true,
+		},
+		{
+			name:    "metrics option in mount command",
+			mntPath: t.TempDir(),
+			command: ceCmd("cache-size=100,metrics=0.0.0.0:9600"),
+			ports:   metricsPort,
+			want:    "10.0.0.1:9600",
+		},
+		{
+			name:    "enterprise edition with metrics option",
+			mntPath: t.TempDir(),
+			command: eeCmd("foreground,no-update,metrics=0.0.0.0:9567"),
+			want:    "10.0.0.1:9567",
+		},
+		{
+			name:    "enterprise edition without metrics option",
+			mntPath: t.TempDir(),
+			command: eeCmd("foreground,no-update"),
+			wantErr: true,
+		},
+		{
+			name:        "random port of hostNetwork not in .config",
+			mntPath:     withConfig(`{"Meta":"redis://"}`),
+			command:     ceCmd("metrics=0.0.0.0:0"),
+			hostNetwork: true,
+			wantErr:     true,
+		},
+		{
+			name:        "specified port of hostNetwork",
+			mntPath:     t.TempDir(),
+			command:     ceCmd("metrics=0.0.0.0:9600"),
+			hostNetwork: true,
+			want:        "10.0.0.1:9600",
+		},
+		{
+			name:    "invalid address",
+			mntPath: withConfig(`{"Port":{"PrometheusAgent":"invalid"}}`),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				Spec: corev1.PodSpec{
+					HostNetwork: tt.hostNetwork,
+					Containers:  []corev1.Container{{Command: tt.command, Ports: tt.ports}},
+				},
+				Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+			}
+			got, err := discoverMetricsAddr(context.TODO(), pod, tt.mntPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("discoverMetricsAddr() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("discoverMetricsAddr() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsTargets_ServeHTTP(t *testing.T) {
+	mountPod := func(name, addr string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: config.Namespace,
+				Labels: map[string]string{
+					config.PodTypeKey:          config.PodTypeValue,
+					config.PodUniqueIdLabelKey: "pv-" + name,
+				},
+				Annotations: map[string]string{config.MetricsAddrKey: addr},
+			},
+			Spec: corev1.PodSpec{NodeName: config.NodeName},
+		}
+	}
+	client := &k8sclient.K8sClient{Interface: fake.NewSimpleClientset(
+		mountPod("mount-b", "10.0.0.1:41235"),
+		mountPod("mount-a", "10.0.0.2:9567"),
+		mountPod("mount-c", ""),
+	)}
+
+	w := httptest.NewRecorder()
+	NewMetricsTargets(client).ServeHTTP(w, httptest.NewRequest("GET", "/metrics-targets", nil))
+	if w.Code != 200 {
+		t.Fatalf("ServeHTTP() code = %d, body = %s", w.Code, w.Body.String())
+	}
+	var got []MetricsTarget
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []MetricsTarget{
+		{
+			Targets: []string{"10.0.0.2:9567"},
+			Labels:  map[string]string{"namespace": config.Namespace, "pod": "mount-a", "node": config.NodeName, "volume_id": "pv-mount-a"},
+		},
+		{
+			Targets: []string{"10.0.0.1:41235"},
+			Labels:  map[string]string{"namespace": config.Namespace, "pod": "mount-b", "node": config.NodeName, "volume_id": "pv-mount-b"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ServeHTTP() got = %+v, want %+v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	NewMetricsTargets(nil).ServeHTTP(w, httptest.NewRequest("GET", "/metrics-targets", nil))
+	if w.Code != 404 {
+		t.Errorf("ServeHTTP() in process mode code = %d, want 404", w.Code)
+	}
+}
+
+func TestMountMetricsAggregator_Gather(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`# HELP juicefs_fuse_read_size_bytes size of read
+# TYPE juicefs_fuse_read_size_bytes counter
+juicefs_fuse_read_size_bytes{mp="/jfs/pv-a",vol_name="test"} 1024
+`))
+	}))
+	defer server.Close()
+
+	target := "/var/lib/kubelet/pods/app-uid/volumes/kubernetes.io~csi/pv-a/mount"
+	mountPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mount-a",
+			Namespace: config.Namespace,
+			Labels: map[string]string{
+				config.PodTypeKey:          config.PodTypeValue,
+				config.PodUniqueIdLabelKey: "pv-a",
+			},
+			Annotations: map[string]string{
+				config.MetricsAddrKey:        strings.TrimPrefix(server.URL, "http://"),
+				util.GetReferenceKey(target): target,
+			},
+		},
+		Spec: corev1.PodSpec{NodeName: config.NodeName},
+	}
+	appPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "app-uid"},
+		Spec:       corev1.PodSpec{NodeName: config.NodeName},
+	}
+	newPV := func(name, volumeHandle, claim string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				StorageClassName: "juicefs-sc",
+				ClaimRef:         &corev1.ObjectReference{Name: claim, Namespace: "default"},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       config.DriverName,
+					VolumeHandle: volumeHandle,
+				}},
+			},
+		}
+	}
+	// static pv whose name is not its volumeHandle
+	staticTarget := "/var/lib/kubelet/pods/app-uid/volumes/kubernetes.io~csi/static-pv-b/mount"
+	staticMountPod := mountPod.DeepCopy()
+	staticMountPod.Name = "mount-b"
+	staticMountPod.Labels[config.PodUniqueIdLabelKey] = "pv-b"
+	staticMountPod.Annotations = map[string]string{
+		config.MetricsAddrKey:              strings.TrimPrefix(server.URL, "http://"),
+		util.GetReferenceKey(staticTarget): staticTarget,
+	}
+	// mount pod shared by storageClass, whose uniqueId is storageClass name
+	sharedTarget := "/var/lib/kubelet/pods/app-uid/volumes/kubernetes.io~csi/pv-c/mount"
+	sharedMountPod := mountPod.DeepCopy()
+	sharedMountPod.Name = "mount-c"
+	sharedMountPod.Labels[config.PodUniqueIdLabelKey] = "juicefs-sc"
+	sharedMountPod.Annotations = map[string]string{
+		config.MetricsAddrKey:              strings.TrimPrefix(server.URL, "http://"),
+		util.GetReferenceKey(sharedTarget): sharedTarget,
+	}
+	client := &k8sclient.K8sClient{Interface: fake.NewSimpleClientset(mountPod, staticMountPod, sharedMountPod, appPod,
+		newPV("pv-a", "pv-a", "pvc-a"), newPV("static-pv-b", "pv-b", "pvc-b"), newPV("pv-c", "pv-c", "pvc-c"))}
+
+	expected := `
+		# HELP juicefs_fuse_read_size_bytes size of read
+		# TYPE juicefs_fuse_read_size_bytes counter
+		juicefs_fuse_read_size_bytes{app_pods="default/app",mount_pod="mount-a",mp="/jfs/pv-a",namespace="default",pv="pv-a",pvc="pvc-a",storageclass="juicefs-sc",vol_name="test",volume_id="pv-a"} 1024
+		juicefs_fuse_read_size_bytes{app_pods="default/app",mount_pod="mount-b",mp="/jfs/pv-a",namespace="default",pv="static-pv-b",pvc="pvc-b",storageclass="juicefs-sc",vol_name="test",volume_id="pv-b"} 1024
+		juicefs_fuse_read_size_bytes{app_pods="default/app",mount_pod="mount-c",mp="/jfs/pv-a",namespace="default",pv="pv-c",pvc="pvc-c",storageclass="juicefs-sc",vol_name="test",volume_id="juicefs-sc"} 1024
+	`
+	if err := testutil.GatherAndCompare(NewMountMetricsAggregator(client), strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
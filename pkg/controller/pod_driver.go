@@ -520,6 +520,7 @@ func (p *PodDriver) podReadyHandler(ctx context.Context, pod *corev1.Pod) error
 		return nil
 	}
 
+	p.publishMetricsAddr(ctx, pod, mntPath)
 	return p.recover(ctx, pod, mntPath)
 }
 
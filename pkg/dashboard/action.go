@@ -0,0 +1,469 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount/builder"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+const (
+	defaultCacheDir = "/var/jfsCache"
+
+	auditSuccess  = "success"
+	auditRejected = "rejected"
+	auditFailure  = "failure"
+)
+
+// AuditEntry records an operational action taken through dashboard API
+type AuditEntry struct {
+	Time    time.Time         `json:"time"`
+	User    string            `json:"user"`
+	Action  string            `json:"action"`
+	Target  string            `json:"target"`
+	Params  map[string]string `json:"params,omitempty"`
+	Result  string            `json:"result"`
+	Message string            `json:"message,omitempty"`
+}
+
+// ActionResult is the response of an operational action
+type ActionResult struct {
+	Action  string `json:"action"`
+	Target  string `json:"target"`
+	Message string `json:"message"`
+}
+
+// actionError is returned by actions with the status code for client
+type actionError struct {
+	code int
+	msg  string
+}
+
+func (e *actionError) Error() string {
+	return e.msg
+}
+
+func rejectf(code int, format string, args ...interface{}) error {
+	return &actionError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+type auditLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// SetAuditLog writes audit entries of actions as json lines to w, instead of the log of dashboard
+func (api *API) SetAuditLog(w io.Writer) {
+	api.audit = &auditLogger{w: w}
+}
+
+func (api *API) writeAudit(entry *AuditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		klog.Errorf("marshal audit entry error: %v", err)
+		return
+	}
+	if api.audit == nil {
+		klog.Infof("audit: %s", data)
+		return
+	}
+	api.audit.lock.Lock()
+	defer api.audit.lock.Unlock()
+	if _, err := api.audit.w.Write(append(data, '\n')); err != nil {
+		klog.Errorf("write audit entry %s error: %v", data, err)
+	}
+}
+
+// recordActionEvent creates an event of the action on the object, so that it is shown along with other events of the object
+func (api *API) recordActionEvent(c *gin.Context, obj client.Object, kind string, entry *AuditEntry) {
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	eventType := corev1.EventTypeNormal
+	if entry.Result != auditSuccess {
+		eventType = corev1.EventTypeWarning
+	}
+	now := metav1.NewTime(entry.Time)
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", obj.GetName(), entry.Time.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      kind,
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			UID:       obj.GetUID(),
+		},
+		Reason:         "DashboardAction",
+		Message:        fmt.Sprintf("%s by %s: %s", entry.Action, entry.User, entry.Message),
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: "juicefs-csi-dashboard"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := api.client.CoreV1().Events(namespace).Create(c, event, metav1.CreateOptions{}); err != nil {
+		klog.Errorf("create event of %s %s error: %v", kind, obj.GetName(), err)
+	}
+}
+
+// requireOperator aborts requests of users who can not delete pods in system namespace,
+// operational actions are only registered when authorization is enabled.
+func (api *API) requireOperator() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		obj, ok := c.Get(userKey)
+		if !ok {
+			c.String(403, "forbidden")
+			c.Abort()
+			return
+		}
+		allowed, err := api.authorizer.Can(c, obj.(*User), "delete", "pods", api.sysNamespace)
+		if err != nil {
+			klog.Errorf("authorize request error: %v", err)
+		}
+		if !allowed {
+			c.String(403, "forbidden")
+			c.Abort()
+		}
+	}
+}
+
+// doAction runs the action on the object in context and records it in audit log and events of the object
+func (api *API) doAction(action, key, kind string, do func(c *gin.Context, obj client.Object, params map[string]string) (string, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		o, ok := c.Get(key)
+		if !ok {
+			c.String(404, "not found")
+			return
+		}
+		obj := o.(client.Object)
+		entry := &AuditEntry{
+			Time:   time.Now(),
+			Action: action,
+			Target: fmt.Sprintf("%s/%s", strings.ToLower(kind), obj.GetName()),
+			Params: make(map[string]string),
+		}
+		if obj.GetNamespace() != "" {
+			entry.Target = fmt.Sprintf("%s/%s/%s", strings.ToLower(kind), obj.GetNamespace(), obj.GetName())
+		}
+		if user, ok := c.Get(userKey); ok {
+			entry.User = user.(*User).Name
+		}
+
+		msg, err := do(c, obj, entry.Params)
+		code := 200
+		if err != nil {
+			entry.Result, entry.Message, code = auditFailure, err.Error(), 500
+			if e, ok := err.(*actionError); ok {
+				entry.Result, code = auditRejected, e.code
+			}
+		} else {
+			entry.Result, entry.Message = auditSuccess, msg
+		}
+		api.writeAudit(entry)
+		if entry.Result != auditRejected {
+			api.recordActionEvent(c, obj, kind, entry)
+		}
+		if err != nil {
+			c.String(code, entry.Message)
+			return
+		}
+		c.IndentedJSON(code, &ActionResult{Action: action, Target: entry.Target, Message: msg})
+	}
+}
+
+func isMountPod(pod *corev1.Pod) bool {
+	return pod.Labels[config.PodTypeKey] == config.PodTypeValue
+}
+
+// referenceCount returns number of targets referencing the mount pod
+func referenceCount(pod *corev1.Pod) int {
+	count := 0
+	for k, v := range pod.Annotations {
+		if k == util.GetReferenceKey(v) {
+			count++
+		}
+	}
+	return count
+}
+
+func (api *API) checkMountPod(obj client.Object) (*corev1.Pod, error) {
+	pod := obj.(*corev1.Pod)
+	if !isMountPod(pod) || pod.Namespace != api.sysNamespace {
+		return nil, rejectf(400, "pod %s is not a mount pod", pod.Name)
+	}
+	if pod.DeletionTimestamp != nil {
+		return nil, rejectf(409, "mount pod %s is being deleted", pod.Name)
+	}
+	return pod, nil
+}
+
+func (api *API) deleteMountPod(c *gin.Context, pod *corev1.Pod) error {
+	return api.client.CoreV1().Pods(pod.Namespace).Delete(c, pod.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &pod.UID},
+	})
+}
+
+// deleteMountPodAction deletes mount pod not referenced by any target
+func (api *API) deleteMountPodAction() gin.HandlerFunc {
+	return api.doAction("delete", "pod", "Pod", func(c *gin.Context, obj client.Object, params map[string]string) (string, error) {
+		pod, err := api.checkMountPod(obj)
+		if err != nil {
+			return "", err
+		}
+		if refs := referenceCount(pod); refs != 0 {
+			return "", rejectf(409, "mount pod %s is referenced by %d targets, recreate it instead", pod.Name, refs)
+		}
+		if err := api.deleteMountPod(c, pod); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("mount pod %s deleted", pod.Name), nil
+	})
+}
+
+// recreateMountPodAction deletes mount pod referenced by targets, csi node recreates it with the same spec and recovers the targets
+func (api *API) recreateMountPodAction() gin.HandlerFunc {
+	return api.doAction("recreate", "pod", "Pod", func(c *gin.Context, obj client.Object, params map[string]string) (string, error) {
+		pod, err := api.checkMountPod(obj)
+		if err != nil {
+			return "", err
+		}
+		refs := referenceCount(pod)
+		if refs == 0 {
+			return "", rejectf(409, "mount pod %s is not referenced by any target, delete it instead", pod.Name)
+		}
+		if err := api.deleteMountPod(c, pod); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("mount pod %s deleted, csi node recreates it and recovers %d targets", pod.Name, refs), nil
+	})
+}
+
+// abortFuseAction aborts fuse connection of mount pod stuck in terminating, as csi node does after grace period
+func (api *API) abortFuseAction() gin.HandlerFunc {
+	return api.doAction("abort-fuse", "pod", "Pod", func(c *gin.Context, obj client.Object, params map[string]string) (string, error) {
+		pod := obj.(*corev1.Pod)
+		if !isMountPod(pod) || pod.Namespace != api.sysNamespace {
+			return "", rejectf(400, "pod %s is not a mount pod", pod.Name)
+		}
+		if pod.DeletionTimestamp == nil {
+			return "", rejectf(409, "mount pod %s is not terminating, only fuse connection of stuck mount pod can be aborted", pod.Name)
+		}
+		mountPoint, err := hostMountPointOf(pod)
+		if err != nil {
+			return "", rejectf(400, "get mount point of mount pod %s error: %v", pod.Name, err)
+		}
+		params["mountPoint"] = mountPoint
+		job := builder.NewFuseAbortJobOfMountPoint(pod, mountPoint)
+		if _, err := api.client.BatchV1().Jobs(job.Namespace).Create(c, job, metav1.CreateOptions{}); err != nil {
+			if k8serrors.IsAlreadyExists(err) {
+				return "", rejectf(409, "fuse abort job %s already exists", job.Name)
+			}
+			return "", err
+		}
+		return fmt.Sprintf("fuse abort job %s created on node %s", job.Name, pod.Spec.NodeName), nil
+	})
+}
+
+// hostMountPointOf returns mount point of mount pod on host
+func hostMountPointOf(pod *corev1.Pod) (string, error) {
+	sourcePath, _, err := util.GetMountPathOfPod(*pod)
+	if err != nil {
+		return "", err
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == builder.JfsDirName && volume.HostPath != nil {
+			return filepath.Join(volume.HostPath.Path, strings.TrimPrefix(sourcePath, config.PodMountBase)), nil
+		}
+	}
+	return "", fmt.Errorf("volume %s not found", builder.JfsDirName)
+}
+
+type upgradeRequest struct {
+	Image string `json:"image"`
+}
+
+// upgradeMountPodAction replaces image of mount container, kubelet restarts the container and csi node recovers
+// targets after the new client is ready, so mount point is unavailable during restart.
+func (api *API) upgradeMountPodAction() gin.HandlerFunc {
+	return api.doAction("upgrade", "pod", "Pod", func(c *gin.Context, obj client.Object, params map[string]string) (string, error) {
+		pod, err := api.checkMountPod(obj)
+		if err != nil {
+			return "", err
+		}
+		var req upgradeRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Image == "" {
+			return "", rejectf(400, "image is required")
+		}
+		params["image"] = req.Image
+		oldImage := pod.Spec.Containers[0].Image
+		if oldImage == req.Image {
+			return "", rejectf(400, "mount pod %s already runs image %s", pod.Name, req.Image)
+		}
+		patch, err := json.Marshal([]map[string]interface{}{
+			{"op": "test", "path": "/metadata/uid", "value": pod.UID},
+			{"op": "test", "path": "/spec/containers/0/image", "value": oldImage},
+			{"op": "replace", "path": "/spec/containers/0/image", "value": req.Image},
+		})
+		if err != nil {
+			return "", err
+		}
+		if _, err := api.client.CoreV1().Pods(pod.Namespace).Patch(c, pod.Name, types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("image of mount pod %s replaced from %s to %s", pod.Name, oldImage, req.Image), nil
+	})
+}
+
+type cleanCacheRequest struct {
+	Node      string   `json:"node"`
+	CacheDirs []string `json:"cacheDirs"`
+	// uuid of filesystem and image of job, defaults to those of mount pods of the volume
+	UUID  string `json:"uuid"`
+	Image string `json:"image"`
+}
+
+// cleanCacheAction cleans cache of volume on a node where it is not mounted
+func (api *API) cleanCacheAction() gin.HandlerFunc {
+	return api.doAction("clean-cache", "pv", "PersistentVolume", func(c *gin.Context, obj client.Object, params map[string]string) (string, error) {
+		pv := obj.(*corev1.PersistentVolume)
+		var req cleanCacheRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Node == "" {
+			return "", rejectf(400, "node is required")
+		}
+		params["node"] = req.Node
+
+		// mount pods of the volume are those with volumeHandle or storage class name (mount pod shared by sc) as unique id
+		var pods corev1.PodList
+		if err := api.cachedReader.List(c, &pods, &client.ListOptions{
+			LabelSelector: labels.SelectorFromSet(map[string]string{config.PodTypeKey: config.PodTypeValue}),
+		}); err != nil {
+			return "", err
+		}
+		isVolumePod := func(pod *corev1.Pod) bool {
+			uniqueId := pod.Labels[config.PodUniqueIdLabelKey]
+			return uniqueId != "" && (uniqueId == pv.Spec.CSI.VolumeHandle || uniqueId == pv.Spec.StorageClassName)
+		}
+		var cacheDirs []string
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !isVolumePod(pod) {
+				continue
+			}
+			if req.UUID == "" {
+				req.UUID = pod.Annotations[config.JuiceFSUUID]
+			}
+			if req.Image == "" {
+				req.Image = pod.Spec.Containers[0].Image
+			}
+			for _, volume := range pod.Spec.Volumes {
+				if strings.HasPrefix(volume.Name, "cachedir-") && volume.HostPath != nil {
+					cacheDirs = appendIfMissing(cacheDirs, filepath.Clean(volume.HostPath.Path))
+				}
+			}
+		}
+		if req.UUID == "" || req.Image == "" {
+			return "", rejectf(400, "no mount pod of volume found, uuid and image are required")
+		}
+		// cache of the filesystem may be used by mount pods of other volumes on the node
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Spec.NodeName == req.Node && (isVolumePod(pod) || pod.Annotations[config.JuiceFSUUID] == req.UUID) {
+				return "", rejectf(409, "filesystem is mounted by mount pod %s on node %s, clean cache after it is deleted", pod.Name, req.Node)
+			}
+		}
+
+		for _, dir := range cacheDirsInOptions(pv.Spec.MountOptions) {
+			cacheDirs = appendIfMissing(cacheDirs, dir)
+		}
+		if len(cacheDirs) == 0 {
+			cacheDirs = []string{defaultCacheDir}
+		}
+		if len(req.CacheDirs) == 0 {
+			req.CacheDirs = cacheDirs
+		}
+		for i, dir := range req.CacheDirs {
+			req.CacheDirs[i] = filepath.Clean(dir)
+			if !util.ContainsString(cacheDirs, req.CacheDirs[i]) {
+				return "", rejectf(400, "cache dir %s is not configured for volume, expected one of %s", dir, strings.Join(cacheDirs, ":"))
+			}
+		}
+		params["cacheDirs"] = strings.Join(req.CacheDirs, ":")
+
+		setting, err := config.ParseSetting(map[string]string{"name": req.UUID}, nil, []string{}, true, nil, nil)
+		if err != nil {
+			return "", err
+		}
+		setting.Attr.Image = req.Image
+		setting.Attr.Namespace = api.sysNamespace
+		setting.VolumeId = pv.Spec.CSI.VolumeHandle
+		setting.CacheDirs = req.CacheDirs
+		setting.UUID = req.UUID
+		job := builder.NewJobBuilder(setting, 0).NewJobForCleanCache()
+		job.Spec.Template.Spec.NodeName = req.Node
+		if _, err := api.client.BatchV1().Jobs(job.Namespace).Create(c, job, metav1.CreateOptions{}); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("clean cache job %s created on node %s", job.Name, req.Node), nil
+	})
+}
+
+// cacheDirsInOptions returns cache dirs on host in cache-dir of mount options
+func cacheDirsInOptions(options []string) []string {
+	var dirs []string
+	for _, option := range options {
+		for _, o := range strings.Split(option, ",") {
+			pair := strings.SplitN(strings.TrimSpace(o), "=", 2)
+			if len(pair) != 2 || pair[0] != "cache-dir" {
+				continue
+			}
+			for _, dir := range strings.Split(pair[1], ":") {
+				if dir = strings.TrimSpace(dir); dir != "" && dir != "memory" {
+					dirs = appendIfMissing(dirs, filepath.Clean(dir))
+				}
+			}
+		}
+	}
+	return dirs
+}
+
+func appendIfMissing(dirs []string, dir string) []string {
+	if util.ContainsString(dirs, dir) {
+		return dirs
+	}
+	return append(dirs, dir)
+}
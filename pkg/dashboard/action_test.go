@@ -0,0 +1,274 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+func TestMountPodActions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	target := "/var/lib/kubelet/pods/uid-1/volumes/kubernetes.io~csi/pvc-1/mount"
+	newMountPod := func(name string, targets ...string) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "kube-system",
+				UID:         types.UID("uid-" + name),
+				Labels:      map[string]string{config.PodTypeKey: config.PodTypeValue},
+				Annotations: map[string]string{},
+			},
+			Spec: corev1.PodSpec{
+				NodeName: "node-1",
+				Containers: []corev1.Container{{
+					Name:    "jfs-mount",
+					Image:   "juicedata/mount:ce-v1.1.0",
+					Command: []string{"sh", "-c", "/bin/mount.juicefs redis://127.0.0.1/1 /jfs/pvc-1-abcdef -o metrics=0.0.0.0:9567"},
+				}},
+				Volumes: []corev1.Volume{{
+					Name:         "jfs-dir",
+					VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/lib/juicefs/volume"}},
+				}},
+			},
+		}
+		for _, t := range targets {
+			pod.Annotations[util.GetReferenceKey(t)] = t
+		}
+		return pod
+	}
+	referenced := newMountPod("juicefs-node-1-pvc-1-abcdef", target)
+	idle := newMountPod("juicefs-node-1-pvc-2-abcdef")
+
+	if mp, err := hostMountPointOf(referenced); err != nil || mp != "/var/lib/juicefs/volume/pvc-1-abcdef" {
+		t.Errorf("hostMountPointOf() = %s, %v", mp, err)
+	}
+
+	client := fake.NewSimpleClientset(referenced, idle)
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithObjects(referenced, idle).Build(), client)
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	f, err := os.Create(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	api.SetAuditLog(f)
+	api.SetAuth(authenticatorFunc(func(req *http.Request) (*User, error) {
+		if user := req.Header.Get("X-Remote-User"); user != "" {
+			return &User{Name: user}, nil
+		}
+		return &User{Name: "alice"}, nil
+	}), newOperatorAuthorizer(client, "alice"))
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		action   string
+		body     string
+		wantCode int
+		deleted  bool
+	}{
+		{name: "delete referenced", pod: referenced, action: "delete", wantCode: 409},
+		{name: "recreate idle", pod: idle, action: "recreate", wantCode: 409},
+		{name: "abort running", pod: referenced, action: "abort-fuse", wantCode: 409},
+		{name: "upgrade without image", pod: referenced, action: "upgrade", body: "{}", wantCode: 400},
+		{name: "upgrade", pod: referenced, action: "upgrade", body: `{"image":"juicedata/mount:ce-v1.2.0"}`, wantCode: 200},
+		{name: "recreate referenced", pod: referenced, action: "recreate", wantCode: 200, deleted: true},
+		{name: "delete idle", pod: idle, action: "delete", wantCode: 200, deleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/api/v1/pod/kube-system/"+tt.pod.Name+"/actions/"+tt.action, bytes.NewBufferString(tt.body))
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected code %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			_, err := client.CoreV1().Pods("kube-system").Get(context.TODO(), tt.pod.Name, metav1.GetOptions{})
+			if deleted := err != nil; deleted != tt.deleted {
+				t.Errorf("expected pod deleted %v, got %v", tt.deleted, deleted)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []AuditEntry
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("unmarshal audit entry %s error: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != len(tests) {
+		t.Fatalf("expected %d audit entries, got %d", len(tests), len(entries))
+	}
+	for i, tt := range tests {
+		wantResult := auditSuccess
+		if tt.wantCode != 200 {
+			wantResult = auditRejected
+		}
+		if entries[i].User != "alice" || entries[i].Action != tt.action || entries[i].Result != wantResult {
+			t.Errorf("unexpected audit entry of %s: %+v", tt.name, entries[i])
+		}
+	}
+	events, _ := client.CoreV1().Events("kube-system").List(context.TODO(), metav1.ListOptions{})
+	if len(events.Items) != 3 {
+		t.Errorf("expected 3 events of successful actions, got %d", len(events.Items))
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/pod/kube-system/"+referenced.Name+"/actions/abort-fuse", nil)
+	req.Header.Set("X-Remote-User", "bob")
+	router.ServeHTTP(w, req)
+	if w.Code != 403 {
+		t.Errorf("expected actions of non-operator forbidden, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// actions are not registered without authorization
+	api.SetAuth(api.authenticator, nil)
+	router = gin.New()
+	api.Handle(router.Group("/api/v1"))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "/api/v1/pod/kube-system/"+referenced.Name+"/actions/recreate", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected actions disabled without authorization, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// newOperatorAuthorizer returns authorizer allowing everything to operator and nothing to others
+func newOperatorAuthorizer(client *fake.Clientset, operator string) *NamespaceAuthorizer {
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		sar.Status.Allowed = sar.Spec.User == operator
+		return true, sar, nil
+	})
+	return NewNamespaceAuthorizer(client)
+}
+
+type authenticatorFunc func(req *http.Request) (*User, error)
+
+func (f authenticatorFunc) Authenticate(req *http.Request) (*User, error) {
+	return f(req)
+}
+
+func TestCleanCacheAction(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pv := &corev1.PersistentVolume{
+		// fake client does not ignore namespace of cluster scoped objects as cache does
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: "kube-system"},
+		Spec: corev1.PersistentVolumeSpec{
+			MountOptions:           []string{"cache-dir=/data/cache1:/data/cache2/"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: config.DriverName, VolumeHandle: "pvc-1"}},
+		},
+	}
+	newMountPod := func(name, node, uniqueId, uuid string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "kube-system",
+				Labels:      map[string]string{config.PodTypeKey: config.PodTypeValue, config.PodUniqueIdLabelKey: uniqueId},
+				Annotations: map[string]string{config.JuiceFSUUID: uuid},
+			},
+			Spec: corev1.PodSpec{
+				NodeName:   node,
+				Containers: []corev1.Container{{Name: "jfs-mount", Image: "juicedata/mount:ce-v1.1.0"}},
+				Volumes: []corev1.Volume{{
+					Name:         "cachedir-0",
+					VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/data/cache1"}},
+				}},
+			},
+		}
+	}
+	// pvc-2 is another volume of the same filesystem
+	objs := []runtime.Object{pv, newMountPod("mount-1", "node-1", "pvc-1", "uuid-1"), newMountPod("mount-2", "node-2", "pvc-2", "uuid-1")}
+	client := fake.NewSimpleClientset()
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithRuntimeObjects(objs...).Build(), client)
+	api.SetAuth(authenticatorFunc(func(req *http.Request) (*User, error) {
+		return &User{Name: "alice"}, nil
+	}), newOperatorAuthorizer(client, "alice"))
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	tests := []struct {
+		name          string
+		body          string
+		wantCode      int
+		wantCacheDirs string
+	}{
+		{name: "mounted by mount pod of volume", body: `{"node":"node-1"}`, wantCode: 409},
+		{name: "mounted by mount pod of same filesystem", body: `{"node":"node-2"}`, wantCode: 409},
+		{name: "cache dir not configured", body: `{"node":"node-3","cacheDirs":["/etc"]}`, wantCode: 400},
+		{name: "cache dir escaping configured", body: `{"node":"node-3","cacheDirs":["/data/cache1/../../etc"]}`, wantCode: 400},
+		{name: "configured cache dir", body: `{"node":"node-3","cacheDirs":["/data/cache2"]}`, wantCode: 200, wantCacheDirs: "/data/cache2"},
+		{name: "cache dirs of volume", body: `{"node":"node-4"}`, wantCode: 200, wantCacheDirs: "/data/cache1:/data/cache2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/api/v1/pv/pvc-1/actions/clean-cache", bytes.NewBufferString(tt.body))
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected code %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			if tt.wantCode != 200 {
+				return
+			}
+			jobs, _ := client.BatchV1().Jobs("kube-system").List(context.TODO(), metav1.ListOptions{})
+			if len(jobs.Items) != 1 {
+				t.Fatalf("expected 1 clean cache job, got %d", len(jobs.Items))
+			}
+			job := jobs.Items[0]
+			defer client.BatchV1().Jobs("kube-system").Delete(context.TODO(), job.Name, metav1.DeleteOptions{})
+			var dirs []string
+			for _, volume := range job.Spec.Template.Spec.Volumes {
+				if volume.HostPath != nil && strings.HasPrefix(volume.Name, "cachedir-") {
+					dirs = append(dirs, strings.TrimSuffix(volume.HostPath.Path, "/uuid-1/raw"))
+				}
+			}
+			if got := strings.Join(dirs, ":"); got != tt.wantCacheDirs {
+				t.Errorf("expected cache dirs %s of job, got %s", tt.wantCacheDirs, got)
+			}
+		})
+	}
+}
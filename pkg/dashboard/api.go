@@ -42,6 +42,23 @@ type API struct {
 	pvcIndexes   *timeOrderedIndexes[corev1.PersistentVolumeClaim]
 	pairLock     sync.RWMutex
 	pairs        map[types.NamespacedName]types.NamespacedName
+	// field indexes of resources in time ordered indexes, for filters of list APIs
+	appFields *fieldIndexes
+	sysFields *fieldIndexes
+	pvFields  *fieldIndexes
+	pvcFields *fieldIndexes
+
+	// authentication and authorization, disabled if nil
+	authenticator Authenticator
+	authorizer    *NamespaceAuthorizer
+	// audit log of actions, log of dashboard if nil
+	audit *auditLogger
+	// executes diagnostics in mount pods, disabled if nil or authentication is disabled
+	exec executor
+
+	// versions of mount pods, updated with metrics of them
+	versionLock sync.RWMutex
+	versions    *mountPodVersionSnapshot
 }
 
 func NewAPI(ctx context.Context, sysNamespace string, cachedReader client.Reader, client kubernetes.Interface) *API {
@@ -55,38 +72,74 @@ func NewAPI(ctx context.Context, sysNamespace string, cachedReader client.Reader
 		pvIndexes:    newTimeIndexes[corev1.PersistentVolume](),
 		pvcIndexes:   newTimeIndexes[corev1.PersistentVolumeClaim](),
 		pairs:        make(map[types.NamespacedName]types.NamespacedName),
+		appFields:    newFieldIndexes(),
+		sysFields:    newFieldIndexes(),
+		pvFields:     newFieldIndexes(),
+		pvcFields:    newFieldIndexes(),
 	}
 	return api
 }
 
 func (api *API) Handle(group *gin.RouterGroup) {
-	group.GET("/debug/status", api.debugAPIStatus())
+	if api.authenticator != nil {
+		group.Use(api.authMiddleware())
+	}
+	group.GET("/debug/status", api.requireNamespace(""), api.debugAPIStatus())
 	group.GET("/pods", api.listAppPod())
 	group.GET("/syspods", api.listSysPod())
 	group.GET("/mountpods", api.listMountPod())
+	group.GET("/mountpods/versions", api.listMountPodVersions())
 	group.GET("/csi-nodes", api.listCSINodePod())
 	group.GET("/controllers", api.listCSIControllerPod())
 	group.GET("/pvs", api.listPVsHandler())
 	group.GET("/pvcs", api.listPVCsHandler())
 	group.GET("/storageclasses", api.listSCsHandler())
-	group.GET("/csi-node/:nodeName", api.getCSINodeByName())
+	group.GET("/problems", api.listProblems())
+	group.GET("/logs/stream", api.streamLogsOfSources())
+	group.GET("/csi-node/:nodeName", api.requireNamespace(api.sysNamespace), api.getCSINodeByName())
+	group.GET("/csi-node/:nodeName/cache-usage", api.requireNamespace(api.sysNamespace), api.getCacheUsageOfNode())
+	group.GET("/node/:nodeName/bundle", api.requireNamespace(api.sysNamespace), api.downloadBundle("node"))
 	podGroup := group.Group("/pod/:namespace/:name", api.getPodMiddileware())
 	podGroup.GET("/", api.getPodHandler())
 	podGroup.GET("/events", api.getPodEvents())
 	podGroup.GET("/logs/:container", api.getPodLogs())
+	podGroup.GET("/logs/:container/stream", api.streamPodLogs())
 	podGroup.GET("/pvs", api.listPodPVsHandler())
 	podGroup.GET("/pvcs", api.listPodPVCsHandler())
 	podGroup.GET("/mountpods", api.listMountPodsOfAppPod())
 	podGroup.GET("/apppods", api.listAppPodsOfMountPod())
 	podGroup.GET("/node", api.getPodNode())
+	if api.exec != nil && api.authenticator != nil {
+		podGroup.GET("/diagnostics/:command", api.runDiagnostic())
+	}
+	podGroup.GET("/bundle", api.downloadBundle("pod"))
+	if api.authorizer != nil {
+		podGroup.POST("/actions/delete", api.requireOperator(), api.deleteMountPodAction())
+		podGroup.POST("/actions/recreate", api.requireOperator(), api.recreateMountPodAction())
+		podGroup.POST("/actions/abort-fuse", api.requireOperator(), api.abortFuseAction())
+		podGroup.POST("/actions/upgrade", api.requireOperator(), api.upgradeMountPodAction())
+	}
 	pvGroup := group.Group("/pv/:name", api.getPVMiddileware())
 	pvGroup.GET("/", api.getPVHandler())
 	pvGroup.GET("/mountpods", api.getMountPodsOfPV())
 	pvGroup.GET("/events", api.getPVEvents())
+	pvGroup.GET("/timeline", api.getVolumeTimeline())
+	pvGroup.GET("/mountpods/logs/stream", api.streamMountPodLogsOfPV())
+	if api.authorizer != nil {
+		pvGroup.POST("/actions/clean-cache", api.requireOperator(), api.cleanCacheAction())
+	}
 	pvcGroup := group.Group("/pvc/:namespace/:name", api.getPVCMiddileware())
 	pvcGroup.GET("/", api.getPVCHandler())
 	pvcGroup.GET("/mountpods", api.getMountPodsOfPVC())
 	pvcGroup.GET("/events", api.getPVCEvents())
+	pvcGroup.GET("/timeline", api.getVolumeTimeline())
+	pvcGroup.GET("/bundle", api.downloadBundle("pvc"))
+	configGroup := group.Group("/config", api.requireNamespace(api.sysNamespace), api.getConfigMapMiddleware())
+	configGroup.GET("", api.getConfigHandler())
+	configGroup.POST("/preview", api.previewConfig())
+	if api.authorizer != nil {
+		configGroup.PUT("", api.requireOperator(), api.updateConfigAction())
+	}
 	scGroup := group.Group("/storageclass/:name", api.getSCMiddileware())
 	scGroup.GET("/", api.getSCHandler())
 	scGroup.GET("/pvs", api.getPVOfSC())
@@ -0,0 +1,469 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+const (
+	userKey         = "user"
+	pvNamespacesKey = "pvNamespaces"
+
+	authCacheTTL = time.Minute
+)
+
+// User is the user authenticated for a request of dashboard API
+type User struct {
+	Name   string   `json:"name"`
+	UID    string   `json:"uid,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+func (u *User) key() string {
+	return u.Name + "\x00" + u.UID + "\x00" + strings.Join(u.Groups, ",")
+}
+
+// Authenticator authenticates requests of dashboard API.
+// It returns nil user without error if the request carries no credential it recognizes.
+type Authenticator interface {
+	Authenticate(req *http.Request) (*User, error)
+}
+
+// UnionAuthenticator tries authenticators in order, the first one recognizing the credential wins
+type UnionAuthenticator []Authenticator
+
+func (u UnionAuthenticator) Authenticate(req *http.Request) (*User, error) {
+	for _, a := range u {
+		user, err := a.Authenticate(req)
+		if err != nil || user != nil {
+			return user, err
+		}
+	}
+	return nil, nil
+}
+
+func bearerToken(req *http.Request) string {
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// TokenFileAuthenticator authenticates bearer tokens listed in a csv file,
+// in the same format as static token file of kube-apiserver: token,user,uid,"group1,group2"
+type TokenFileAuthenticator struct {
+	tokens map[string]*User
+}
+
+func NewTokenFileAuthenticator(path string) (*TokenFileAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	tokens := make(map[string]*User)
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("token file %s line %d: token and user are required", path, line)
+		}
+		user := &User{Name: record[1]}
+		if len(record) > 2 {
+			user.UID = record[2]
+		}
+		if len(record) > 3 && record[3] != "" {
+			user.Groups = strings.Split(record[3], ",")
+		}
+		if _, ok := tokens[record[0]]; ok {
+			return nil, fmt.Errorf("token file %s line %d: duplicate token", path, line)
+		}
+		tokens[record[0]] = user
+	}
+	return &TokenFileAuthenticator{tokens: tokens}, nil
+}
+
+func (a *TokenFileAuthenticator) Authenticate(req *http.Request) (*User, error) {
+	token := bearerToken(req)
+	if token == "" {
+		return nil, nil
+	}
+	for t, user := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+type cachedUser struct {
+	user    *User
+	expires time.Time
+}
+
+// TokenReviewAuthenticator authenticates bearer tokens of kubernetes users with TokenReview
+type TokenReviewAuthenticator struct {
+	client kubernetes.Interface
+
+	lock  sync.Mutex
+	cache map[string]cachedUser
+}
+
+func NewTokenReviewAuthenticator(client kubernetes.Interface) *TokenReviewAuthenticator {
+	return &TokenReviewAuthenticator{
+		client: client,
+		cache:  make(map[string]cachedUser),
+	}
+}
+
+func (a *TokenReviewAuthenticator) Authenticate(req *http.Request) (*User, error) {
+	token := bearerToken(req)
+	if token == "" {
+		return nil, nil
+	}
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+	a.lock.Lock()
+	if c, ok := a.cache[key]; ok && now.Before(c.expires) {
+		a.lock.Unlock()
+		return c.user, nil
+	}
+	a.lock.Unlock()
+
+	review, err := a.client.AuthenticationV1().TokenReviews().Create(req.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("review token error: %v", err)
+	}
+	var user *User
+	if review.Status.Authenticated {
+		user = &User{
+			Name:   review.Status.User.Username,
+			UID:    review.Status.User.UID,
+			Groups: review.Status.User.Groups,
+		}
+	} else {
+		klog.V(5).Infof("token not authenticated: %s", review.Status.Error)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for k, c := range a.cache {
+		if now.After(c.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = cachedUser{user: user, expires: now.Add(authCacheTTL)}
+	return user, nil
+}
+
+// BasicAuthenticator authenticates basic auth with users in a secret, whose keys are usernames and values are passwords
+type BasicAuthenticator struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+
+	lock    sync.Mutex
+	users   map[string][]byte
+	expires time.Time
+}
+
+func NewBasicAuthenticator(client kubernetes.Interface, namespace, name string) *BasicAuthenticator {
+	return &BasicAuthenticator{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+func (a *BasicAuthenticator) Authenticate(req *http.Request) (*User, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	users, err := a.getUsers(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	expected, ok := users[username]
+	if !ok || subtle.ConstantTimeCompare(expected, []byte(password)) != 1 {
+		return nil, nil
+	}
+	return &User{Name: username}, nil
+}
+
+// getUsers returns users in the secret, which is reloaded every minute so that users can be changed without restart
+func (a *BasicAuthenticator) getUsers(ctx context.Context) (map[string][]byte, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.users != nil && time.Now().Before(a.expires) {
+		return a.users, nil
+	}
+	secret, err := a.client.CoreV1().Secrets(a.namespace).Get(ctx, a.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s/%s error: %v", a.namespace, a.name, err)
+	}
+	a.users = secret.Data
+	if a.users == nil {
+		a.users = make(map[string][]byte)
+	}
+	a.expires = time.Now().Add(authCacheTTL)
+	return a.users, nil
+}
+
+type cachedDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// NamespaceAuthorizer restricts users to namespaces where they can get pods, checked with SubjectAccessReview
+type NamespaceAuthorizer struct {
+	client kubernetes.Interface
+
+	lock  sync.Mutex
+	cache map[string]cachedDecision
+}
+
+func NewNamespaceAuthorizer(client kubernetes.Interface) *NamespaceAuthorizer {
+	return &NamespaceAuthorizer{
+		client: client,
+		cache:  make(map[string]cachedDecision),
+	}
+}
+
+// CanAccess checks if user can access namespace, empty namespace means all namespaces (cluster-wide access)
+func (a *NamespaceAuthorizer) CanAccess(ctx context.Context, user *User, namespace string) (bool, error) {
+	return a.Can(ctx, user, "get", "pods", namespace)
+}
+
+// Can checks if user can do verb on resource of core group in namespace
+func (a *NamespaceAuthorizer) Can(ctx context.Context, user *User, verb, resource, namespace string) (bool, error) {
+	key := strings.Join([]string{user.key(), verb, resource, namespace}, "\x00")
+	now := time.Now()
+	a.lock.Lock()
+	if d, ok := a.cache[key]; ok && now.Before(d.expires) {
+		a.lock.Unlock()
+		return d.allowed, nil
+	}
+	a.lock.Unlock()
+
+	review, err := a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Name,
+			UID:    user.UID,
+			Groups: user.Groups,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Resource:  resource,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("review access of user %s error: %v", user.Name, err)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for k, d := range a.cache {
+		if now.After(d.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = cachedDecision{allowed: review.Status.Allowed, expires: now.Add(authCacheTTL)}
+	return review.Status.Allowed, nil
+}
+
+// SetAuth enables authentication of dashboard API, and authorization by namespace if authorizer is not nil.
+// It must be called before Handle.
+func (api *API) SetAuth(authenticator Authenticator, authorizer *NamespaceAuthorizer) {
+	api.authenticator = authenticator
+	api.authorizer = authorizer
+}
+
+func (api *API) authMiddleware() gin.HandlerFunc {
+	_, basic := api.authenticator.(*BasicAuthenticator)
+	if union, ok := api.authenticator.(UnionAuthenticator); ok {
+		for _, a := range union {
+			if _, ok := a.(*BasicAuthenticator); ok {
+				basic = true
+			}
+		}
+	}
+	return func(c *gin.Context) {
+		user, err := api.authenticator.Authenticate(c.Request)
+		if err != nil {
+			klog.Errorf("authenticate request error: %v", err)
+			c.String(500, "authenticate error")
+			c.Abort()
+			return
+		}
+		if user == nil {
+			if basic {
+				c.Header("WWW-Authenticate", `Basic realm="juicefs-csi-dashboard"`)
+			}
+			c.String(401, "unauthorized")
+			c.Abort()
+			return
+		}
+		c.Set(userKey, user)
+	}
+}
+
+// canAccessNamespace checks if user of the request can access namespace, empty namespace means all namespaces
+func (api *API) canAccessNamespace(c *gin.Context, namespace string) bool {
+	if api.authorizer == nil {
+		return true
+	}
+	obj, ok := c.Get(userKey)
+	if !ok {
+		return false
+	}
+	allowed, err := api.authorizer.CanAccess(c, obj.(*User), namespace)
+	if err != nil {
+		klog.Errorf("authorize request error: %v", err)
+		return false
+	}
+	return allowed
+}
+
+// canAccessPV checks if user can access the namespace of PVC bound to pv, or all namespaces
+func (api *API) canAccessPV(c *gin.Context, pv *corev1.PersistentVolume) bool {
+	if pv.Spec.ClaimRef != nil && api.canAccessNamespace(c, pv.Spec.ClaimRef.Namespace) {
+		return true
+	}
+	return api.canAccessNamespace(c, "")
+}
+
+// canAccessPod checks if user can access the pod:
+// app pods are checked with their namespace, mount pods are also accessible if any of their PVs is,
+// other system pods require access to the system namespace
+func (api *API) canAccessPod(c *gin.Context, pod *corev1.Pod) bool {
+	if api.authorizer == nil {
+		return true
+	}
+	if api.canAccessNamespace(c, pod.Namespace) {
+		return true
+	}
+	if pod.Labels["app.kubernetes.io/name"] != "juicefs-mount" || pod.Labels[config.PodUniqueIdLabelKey] == "" {
+		return false
+	}
+	for _, namespace := range api.pvNamespaces(c)[pod.Labels[config.PodUniqueIdLabelKey]] {
+		if api.canAccessNamespace(c, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// pvNamespaces returns namespaces of PVCs bound to juicefs PVs by unique id of their mount pods,
+// PVs are listed once per request, and shared by all pods checked in it
+func (api *API) pvNamespaces(c *gin.Context) map[string][]string {
+	if obj, ok := c.Get(pvNamespacesKey); ok {
+		return obj.(map[string][]string)
+	}
+	namespaces := make(map[string][]string)
+	var pvs corev1.PersistentVolumeList
+	if err := api.cachedReader.List(c, &pvs); err != nil {
+		// not cached, so that the next check of the request retries
+		klog.Errorf("list pvs error: %v", err)
+		return namespaces
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName || pv.Spec.ClaimRef == nil {
+			continue
+		}
+		namespaces[pv.Spec.CSI.VolumeHandle] = append(namespaces[pv.Spec.CSI.VolumeHandle], pv.Spec.ClaimRef.Namespace)
+		if pv.Spec.StorageClassName != "" && pv.Spec.StorageClassName != pv.Spec.CSI.VolumeHandle {
+			namespaces[pv.Spec.StorageClassName] = append(namespaces[pv.Spec.StorageClassName], pv.Spec.ClaimRef.Namespace)
+		}
+	}
+	c.Set(pvNamespacesKey, namespaces)
+	return namespaces
+}
+
+// requireNamespace aborts requests of users who can not access namespace, empty namespace means all namespaces
+func (api *API) requireNamespace(namespace string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !api.canAccessNamespace(c, namespace) {
+			c.String(403, "forbidden")
+			c.Abort()
+		}
+	}
+}
+
+// filterPods keeps pods the user of request can access
+func (api *API) filterPods(c *gin.Context, pods []corev1.Pod) []corev1.Pod {
+	if api.authorizer == nil {
+		return pods
+	}
+	result := make([]corev1.Pod, 0, len(pods))
+	for i := range pods {
+		if api.canAccessPod(c, &pods[i]) {
+			result = append(result, pods[i])
+		}
+	}
+	return result
+}
+
+// filterPodPointers is filterPods for slices of pod pointers
+func (api *API) filterPodPointers(c *gin.Context, pods []*corev1.Pod) []*corev1.Pod {
+	if api.authorizer == nil {
+		return pods
+	}
+	result := make([]*corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if api.canAccessPod(c, pod) {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
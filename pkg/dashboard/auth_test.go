@@ -0,0 +1,186 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+func TestUnionAuthenticator(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(tokenFile, []byte("# static tokens\ntoken1,alice,1,\"dev,ops\"\ntoken2,bob\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewTokenFileAuthenticator(tokenFile)
+	if err != nil {
+		t.Fatalf("load token file error: %v", err)
+	}
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "dashboard-users", Namespace: "kube-system"},
+		Data:       map[string][]byte{"carol": []byte("secret")},
+	})
+	authenticator := UnionAuthenticator{tokens, NewBasicAuthenticator(client, "kube-system", "dashboard-users")}
+
+	tests := []struct {
+		name  string
+		setup func(req *http.Request)
+		want  *User
+	}{
+		{
+			name:  "static token with groups",
+			setup: func(req *http.Request) { req.Header.Set("Authorization", "Bearer token1") },
+			want:  &User{Name: "alice", UID: "1", Groups: []string{"dev", "ops"}},
+		},
+		{
+			name:  "static token without groups",
+			setup: func(req *http.Request) { req.Header.Set("Authorization", "bearer token2") },
+			want:  &User{Name: "bob"},
+		},
+		{
+			name:  "unknown token",
+			setup: func(req *http.Request) { req.Header.Set("Authorization", "Bearer token3") },
+		},
+		{
+			name:  "basic auth",
+			setup: func(req *http.Request) { req.SetBasicAuth("carol", "secret") },
+			want:  &User{Name: "carol"},
+		},
+		{
+			name:  "basic auth with wrong password",
+			setup: func(req *http.Request) { req.SetBasicAuth("carol", "wrong") },
+		},
+		{
+			name:  "no credential",
+			setup: func(req *http.Request) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+			tt.setup(req)
+			got, err := authenticator.Authenticate(req)
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespaceAuthorizer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	reviews := 0
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		sar.Status.Allowed = sar.Spec.User == "alice" && sar.Spec.ResourceAttributes.Namespace == "dev"
+		return true, sar, nil
+	})
+	authorizer := NewNamespaceAuthorizer(client)
+	alice := &User{Name: "alice"}
+	for i := 0; i < 2; i++ {
+		if ok, err := authorizer.CanAccess(context.TODO(), alice, "dev"); err != nil || !ok {
+			t.Errorf("expected alice to access dev, got %v, %v", ok, err)
+		}
+		if ok, err := authorizer.CanAccess(context.TODO(), alice, ""); err != nil || ok {
+			t.Errorf("expected alice not to access all namespaces, got %v, %v", ok, err)
+		}
+	}
+	if ok, err := authorizer.CanAccess(context.TODO(), &User{Name: "bob"}, "dev"); err != nil || ok {
+		t.Errorf("expected bob not to access dev, got %v, %v", ok, err)
+	}
+	if reviews != 3 {
+		t.Errorf("expected 3 reviews with cache, got %d", reviews)
+	}
+}
+
+type countingReader struct {
+	client.Reader
+	lists int
+}
+
+func (r *countingReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	r.lists++
+	return r.Reader.List(ctx, list, opts...)
+}
+
+func TestCanAccessPod(t *testing.T) {
+	pv := func(name, sc, namespace string) *corev1.PersistentVolume {
+		return &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				StorageClassName:       sc,
+				ClaimRef:               &corev1.ObjectReference{Namespace: namespace, Name: name},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: config.DriverName, VolumeHandle: name}},
+			},
+		}
+	}
+	mountPod := func(name, uniqueId string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system", Labels: map[string]string{
+			"app.kubernetes.io/name": "juicefs-mount", config.PodUniqueIdLabelKey: uniqueId,
+		}}}
+	}
+	reader := &countingReader{Reader: crfake.NewClientBuilder().WithObjects(pv("pv-a", "", "dev"), pv("pv-b", "juicefs-sc", "dev"), pv("pv-c", "", "prod")).Build()}
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		sar.Status.Allowed = sar.Spec.ResourceAttributes.Namespace == "dev"
+		return true, sar, nil
+	})
+	api := NewAPI(context.TODO(), "kube-system", reader, client)
+	api.SetAuth(nil, NewNamespaceAuthorizer(client))
+	c, _ := gin.CreateTestContext(nil)
+	c.Set(userKey, &User{Name: "alice"})
+
+	tests := []struct {
+		pod  *corev1.Pod
+		want bool
+	}{
+		{pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "dev"}}, want: true},
+		{pod: mountPod("mount-a", "pv-a"), want: true},
+		{pod: mountPod("mount-sc", "juicefs-sc"), want: true},
+		{pod: mountPod("mount-c", "pv-c"), want: false},
+		{pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "csi-node", Namespace: "kube-system", Labels: map[string]string{"app.kubernetes.io/name": "juicefs-csi-driver"}}}, want: false},
+	}
+	for _, tt := range tests {
+		if got := api.canAccessPod(c, tt.pod); got != tt.want {
+			t.Errorf("canAccessPod(%s) = %v, want %v", tt.pod.Name, got, tt.want)
+		}
+	}
+	if reader.lists != 1 {
+		t.Errorf("expected pvs listed once per request, got %d", reader.lists)
+	}
+}
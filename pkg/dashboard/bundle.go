@@ -0,0 +1,755 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+const (
+	csiConfigMapName       = "juicefs-csi-driver-config"
+	csiPluginContainerName = "juicefs-plugin"
+	defaultBundleTailLines = 2000
+	maxBundleLogBytes      = 10 << 20
+)
+
+// BundleTarget is the app pod, PVC or node which a diagnostic bundle is collected for
+type BundleTarget struct {
+	// Kind is one of "pod", "pvc" and "node"
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// ParseBundleTarget parses kind and name (<namespace>/<name> of pod and pvc, <name> of node)
+func ParseBundleTarget(kind, name string) (BundleTarget, error) {
+	switch kind {
+	case "pod", "pvc":
+		parts := strings.Split(name, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return BundleTarget{}, fmt.Errorf("%s should be <namespace>/<name>", kind)
+		}
+		return BundleTarget{Kind: kind, Namespace: parts[0], Name: parts[1]}, nil
+	case "node":
+		if name == "" || strings.Contains(name, "/") {
+			return BundleTarget{}, fmt.Errorf("invalid node name %s", name)
+		}
+		return BundleTarget{Kind: kind, Name: name}, nil
+	default:
+		return BundleTarget{}, fmt.Errorf("kind should be pod, pvc or node, got %s", kind)
+	}
+}
+
+// BundleName is the name of directory in bundle, and the bundle file without ".tar.gz"
+func BundleName(target BundleTarget, now time.Time) string {
+	parts := []string{"juicefs-bundle", target.Kind}
+	if target.Namespace != "" {
+		parts = append(parts, target.Namespace)
+	}
+	return strings.Join(append(parts, target.Name, now.Format("20060102150405")), "-")
+}
+
+// BundleCollector collects objects, logs and events related to an app pod, PVC or node
+// with secrets redacted, and writes them as a tar.gz
+type BundleCollector struct {
+	client       kubernetes.Interface
+	sysNamespace string
+	// executes command in CSI node to read mountinfo, skipped if nil
+	exec executor
+
+	// TailLines is the number of lines of logs of each container
+	TailLines int64
+	// IncludeSystem includes CSI node and controller pods, driver config and mountinfo of CSI nodes
+	IncludeSystem bool
+	// access filters related objects written into bundle, all of them are written if nil
+	access *bundleAccess
+}
+
+// bundleAccess checks if user can access objects in bundle
+type bundleAccess struct {
+	pod       func(pod *corev1.Pod) bool
+	pv        func(pv *corev1.PersistentVolume) bool
+	namespace func(namespace string) bool
+}
+
+// filter removes objects not accessible and returns number of them
+func (a *bundleAccess) filter(o *bundleObjects) int {
+	omitted := 0
+	appPods := o.appPods[:0]
+	for _, pod := range o.appPods {
+		if a.pod(pod) {
+			appPods = append(appPods, pod)
+		} else {
+			omitted++
+		}
+	}
+	o.appPods = appPods
+	mountPods := o.mountPods[:0]
+	for _, pod := range o.mountPods {
+		if a.pod(pod) {
+			mountPods = append(mountPods, pod)
+		} else {
+			omitted++
+		}
+	}
+	o.mountPods = mountPods
+	pvcs := o.pvcs[:0]
+	for _, pvc := range o.pvcs {
+		if a.namespace(pvc.Namespace) {
+			pvcs = append(pvcs, pvc)
+		} else {
+			omitted++
+		}
+	}
+	o.pvcs = pvcs
+	pvs := o.pvs[:0]
+	scNames := make([]string, 0, len(o.scNames))
+	seen := make(map[string]bool)
+	for _, pv := range o.pvs {
+		if !a.pv(pv) {
+			omitted++
+			continue
+		}
+		pvs = append(pvs, pv)
+		if name := pv.Spec.StorageClassName; name != "" && !seen[name] {
+			seen[name] = true
+			scNames = append(scNames, name)
+		}
+	}
+	o.pvs = pvs
+	o.scNames = scNames
+	return omitted
+}
+
+// NewBundleCollector creates a collector, mountinfo of CSI nodes is skipped if config is nil
+func NewBundleCollector(client kubernetes.Interface, sysNamespace string, config *rest.Config) *BundleCollector {
+	b := &BundleCollector{
+		client:        client,
+		sysNamespace:  sysNamespace,
+		TailLines:     defaultBundleTailLines,
+		IncludeSystem: true,
+	}
+	if config != nil {
+		b.exec = newExecutor(client, config)
+	}
+	return b
+}
+
+// bundleObjects are objects related to target, in order of discovery
+type bundleObjects struct {
+	appPods   []*corev1.Pod
+	mountPods []*corev1.Pod
+	pvcs      []*corev1.PersistentVolumeClaim
+	pvs       []*corev1.PersistentVolume
+	scNames   []string
+	nodeNames []string
+	seen      map[string]bool
+	// errors of collection, collection goes on
+	errors []string
+}
+
+func (o *bundleObjects) firstSeen(kind, namespace, name string) bool {
+	key := kind + "/" + namespace + "/" + name
+	if name == "" || o.seen[key] {
+		return false
+	}
+	o.seen[key] = true
+	return true
+}
+
+func (o *bundleObjects) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	klog.V(5).Infof("collect bundle: %s", msg)
+	o.errors = append(o.errors, msg)
+}
+
+func (o *bundleObjects) addAppPod(pod *corev1.Pod) {
+	if o.firstSeen("pod", pod.Namespace, pod.Name) {
+		o.appPods = append(o.appPods, pod)
+		o.addNode(pod.Spec.NodeName)
+	}
+}
+
+func (o *bundleObjects) addMountPod(pod *corev1.Pod) {
+	if o.firstSeen("pod", pod.Namespace, pod.Name) {
+		o.mountPods = append(o.mountPods, pod)
+		o.addNode(pod.Spec.NodeName)
+	}
+}
+
+func (o *bundleObjects) addPVC(pvc *corev1.PersistentVolumeClaim) {
+	if o.firstSeen("pvc", pvc.Namespace, pvc.Name) {
+		o.pvcs = append(o.pvcs, pvc)
+	}
+}
+
+func (o *bundleObjects) addPV(pv *corev1.PersistentVolume) {
+	if o.firstSeen("pv", "", pv.Name) {
+		o.pvs = append(o.pvs, pv)
+		if o.firstSeen("sc", "", pv.Spec.StorageClassName) {
+			o.scNames = append(o.scNames, pv.Spec.StorageClassName)
+		}
+	}
+}
+
+func (o *bundleObjects) addNode(name string) {
+	if o.firstSeen("node", "", name) {
+		o.nodeNames = append(o.nodeNames, name)
+	}
+}
+
+// uniqueIds are values of label volume-id of mount pods of the PVs
+func (o *bundleObjects) uniqueIds() map[string]bool {
+	ids := make(map[string]bool)
+	for _, pv := range o.pvs {
+		ids[pv.Spec.CSI.VolumeHandle] = true
+		if pv.Spec.StorageClassName != "" {
+			ids[pv.Spec.StorageClassName] = true
+		}
+	}
+	return ids
+}
+
+// Collect collects bundle of target and writes tar.gz to w, nothing is written if target is not found
+func (b *BundleCollector) Collect(ctx context.Context, target BundleTarget, w io.Writer) error {
+	objs := &bundleObjects{seen: make(map[string]bool)}
+	var err error
+	switch target.Kind {
+	case "pod":
+		err = b.resolvePod(ctx, target, objs)
+	case "pvc":
+		err = b.resolvePVC(ctx, target, objs)
+	case "node":
+		err = b.resolveNode(ctx, target, objs)
+	default:
+		err = fmt.Errorf("unknown kind %s", target.Kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	if b.access != nil {
+		if omitted := b.access.filter(objs); omitted != 0 {
+			objs.errorf("objects omitted without access: %d", omitted)
+		}
+	}
+
+	now := time.Now()
+	gz := gzip.NewWriter(w)
+	bw := &bundleWriter{tw: tar.NewWriter(gz), dir: BundleName(target, now), now: now, objs: objs}
+	b.write(ctx, target, bw)
+	if err := bw.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (b *BundleCollector) resolvePod(ctx context.Context, target BundleTarget, objs *bundleObjects) error {
+	pod, err := b.client.CoreV1().Pods(target.Namespace).Get(ctx, target.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if isMountPod(pod) {
+		objs.addMountPod(pod)
+		b.resolvePVsOfMountPods(ctx, objs)
+		b.resolveAppPodsOfMountPods(ctx, objs)
+		return nil
+	}
+	objs.addAppPod(pod)
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			b.resolveClaim(ctx, pod.Namespace, volume.PersistentVolumeClaim.ClaimName, objs)
+		}
+	}
+	if pod.Spec.NodeName == "" {
+		return nil
+	}
+	// mount pods of the app pod, referenced by its target path or of its PVs
+	mountPods, err := b.listMountPods(ctx, pod.Spec.NodeName)
+	if err != nil {
+		objs.errorf("list mount pods on node %s error: %v", pod.Spec.NodeName, err)
+		return nil
+	}
+	uniqueIds := objs.uniqueIds()
+	for _, mountPod := range mountPods {
+		if referencesPod(mountPod, pod.UID) || uniqueIds[mountPod.Labels[config.PodUniqueIdLabelKey]] {
+			objs.addMountPod(mountPod)
+		}
+	}
+	return nil
+}
+
+func (b *BundleCollector) resolvePVC(ctx context.Context, target BundleTarget, objs *bundleObjects) error {
+	if _, err := b.client.CoreV1().PersistentVolumeClaims(target.Namespace).Get(ctx, target.Name, metav1.GetOptions{}); err != nil {
+		return err
+	}
+	b.resolveClaim(ctx, target.Namespace, target.Name, objs)
+
+	// app pods using the PVC
+	pods, err := b.client.CoreV1().Pods(target.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		objs.errorf("list pods in namespace %s error: %v", target.Namespace, err)
+	} else {
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			for _, volume := range pod.Spec.Volumes {
+				if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == target.Name {
+					objs.addAppPod(pod)
+					break
+				}
+			}
+		}
+	}
+
+	// mount pods of the PV on every node
+	mountPods, err := b.listMountPods(ctx, "")
+	if err != nil {
+		objs.errorf("list mount pods error: %v", err)
+		return nil
+	}
+	uniqueIds := objs.uniqueIds()
+	for _, mountPod := range mountPods {
+		if uniqueIds[mountPod.Labels[config.PodUniqueIdLabelKey]] {
+			objs.addMountPod(mountPod)
+		}
+	}
+	return nil
+}
+
+func (b *BundleCollector) resolveNode(ctx context.Context, target BundleTarget, objs *bundleObjects) error {
+	if _, err := b.client.CoreV1().Nodes().Get(ctx, target.Name, metav1.GetOptions{}); err != nil {
+		return err
+	}
+	objs.addNode(target.Name)
+	mountPods, err := b.listMountPods(ctx, target.Name)
+	if err != nil {
+		objs.errorf("list mount pods on node %s error: %v", target.Name, err)
+		return nil
+	}
+	for _, mountPod := range mountPods {
+		objs.addMountPod(mountPod)
+	}
+	b.resolvePVsOfMountPods(ctx, objs)
+	b.resolveAppPodsOfMountPods(ctx, objs)
+	return nil
+}
+
+// resolveClaim adds the PVC and its JuiceFS PV
+func (b *BundleCollector) resolveClaim(ctx context.Context, namespace, name string, objs *bundleObjects) {
+	pvc, err := b.client.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		objs.errorf("get pvc %s/%s error: %v", namespace, name, err)
+		return
+	}
+	if pvc.Spec.VolumeName == "" {
+		objs.addPVC(pvc)
+		return
+	}
+	pv, err := b.client.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		objs.addPVC(pvc)
+		objs.errorf("get pv %s error: %v", pvc.Spec.VolumeName, err)
+		return
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName {
+		return
+	}
+	objs.addPVC(pvc)
+	objs.addPV(pv)
+}
+
+// resolvePVsOfMountPods adds JuiceFS PVs (and their PVCs) whose volume handle or storage class is unique id of mount pods
+func (b *BundleCollector) resolvePVsOfMountPods(ctx context.Context, objs *bundleObjects) {
+	uniqueIds := make(map[string]bool)
+	for _, mountPod := range objs.mountPods {
+		uniqueIds[mountPod.Labels[config.PodUniqueIdLabelKey]] = true
+	}
+	pvs, err := b.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		objs.errorf("list pvs error: %v", err)
+		return
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName {
+			continue
+		}
+		if !uniqueIds[pv.Spec.CSI.VolumeHandle] && !uniqueIds[pv.Spec.StorageClassName] {
+			continue
+		}
+		objs.addPV(pv)
+		if ref := pv.Spec.ClaimRef; ref != nil {
+			pvc, err := b.client.CoreV1().PersistentVolumeClaims(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				objs.errorf("get pvc %s/%s error: %v", ref.Namespace, ref.Name, err)
+				continue
+			}
+			objs.addPVC(pvc)
+		}
+	}
+}
+
+// resolveAppPodsOfMountPods adds app pods whose target paths are referenced by mount pods
+func (b *BundleCollector) resolveAppPodsOfMountPods(ctx context.Context, objs *bundleObjects) {
+	for _, nodeName := range objs.nodeNames {
+		pods, err := b.client.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+		})
+		if err != nil {
+			objs.errorf("list pods on node %s error: %v", nodeName, err)
+			continue
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Spec.NodeName != nodeName || isMountPod(pod) {
+				continue
+			}
+			for _, mountPod := range objs.mountPods {
+				if mountPod.Spec.NodeName == nodeName && referencesPod(mountPod, pod.UID) {
+					objs.addAppPod(pod)
+					break
+				}
+			}
+		}
+	}
+}
+
+// listMountPods lists mount pods on node, or on all nodes if node is empty
+func (b *BundleCollector) listMountPods(ctx context.Context, nodeName string) ([]*corev1.Pod, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{config.PodTypeKey: config.PodTypeValue}).String(),
+	}
+	if nodeName != "" {
+		opts.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+	}
+	pods, err := b.client.CoreV1().Pods(b.sysNamespace).List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*corev1.Pod, 0, len(pods.Items))
+	for i := range pods.Items {
+		if nodeName == "" || pods.Items[i].Spec.NodeName == nodeName {
+			result = append(result, &pods.Items[i])
+		}
+	}
+	return result, nil
+}
+
+// referencesPod checks if mount pod references a target path of the pod (/var/lib/kubelet/pods/<uid>/volumes/...)
+func referencesPod(mountPod *corev1.Pod, uid types.UID) bool {
+	if uid == "" {
+		return false
+	}
+	for k, v := range mountPod.Annotations {
+		if strings.HasPrefix(k, "juicefs-") && strings.Contains(v, "/pods/"+string(uid)+"/volumes/") {
+			return true
+		}
+	}
+	return false
+}
+
+// bundleWriter writes files into directory of bundle
+type bundleWriter struct {
+	tw   *tar.Writer
+	dir  string
+	now  time.Time
+	objs *bundleObjects
+}
+
+func (w *bundleWriter) add(name string, data []byte) {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:    path.Join(w.dir, name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: w.now,
+	}); err != nil {
+		w.objs.errorf("write %s error: %v", name, err)
+		return
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		w.objs.errorf("write %s error: %v", name, err)
+	}
+}
+
+// addObject writes object as yaml with secrets redacted
+func (w *bundleWriter) addObject(name string, obj interface{}) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		w.objs.errorf("marshal %s error: %v", name, err)
+		return
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		w.objs.errorf("unmarshal %s error: %v", name, err)
+		return
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		if meta, ok := m["metadata"].(map[string]interface{}); ok {
+			delete(meta, "managedFields")
+		}
+	}
+	data, err = yaml.Marshal(redactSecrets("", v))
+	if err != nil {
+		w.objs.errorf("marshal %s error: %v", name, err)
+		return
+	}
+	w.add(name, data)
+}
+
+func (b *BundleCollector) write(ctx context.Context, target BundleTarget, w *bundleWriter) {
+	objs := w.objs
+	for _, pod := range objs.appPods {
+		b.writePod(ctx, w, path.Join("app", pod.Namespace, pod.Name), pod)
+	}
+	for _, pod := range objs.mountPods {
+		b.writePod(ctx, w, path.Join("mount", pod.Name), pod)
+	}
+	for _, pvc := range objs.pvcs {
+		pvc.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"}
+		w.addObject(path.Join("pvc", pvc.Namespace, pvc.Name+".yaml"), pvc)
+		b.writeEvents(ctx, w, path.Join("pvc", pvc.Namespace, pvc.Name+".events.yaml"), pvc.Namespace, pvc.UID)
+	}
+	for _, pv := range objs.pvs {
+		pv.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolume"}
+		w.addObject(path.Join("pv", pv.Name+".yaml"), pv)
+		b.writeEvents(ctx, w, path.Join("pv", pv.Name+".events.yaml"), "", pv.UID)
+	}
+	for _, name := range objs.scNames {
+		sc, err := b.client.StorageV1().StorageClasses().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if !k8serrors.IsNotFound(err) {
+				objs.errorf("get storageclass %s error: %v", name, err)
+			}
+			continue
+		}
+		sc.TypeMeta = metav1.TypeMeta{APIVersion: "storage.k8s.io/v1", Kind: "StorageClass"}
+		w.addObject(path.Join("storageclass", name+".yaml"), sc)
+	}
+	for _, name := range objs.nodeNames {
+		node, err := b.client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			objs.errorf("get node %s error: %v", name, err)
+			continue
+		}
+		node.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Node"}
+		w.addObject(path.Join("node", name+".yaml"), node)
+	}
+	if b.IncludeSystem {
+		b.writeSystem(ctx, w)
+	}
+
+	summary := map[string]interface{}{
+		"target":        target,
+		"time":          w.now.Format(time.RFC3339),
+		"appPods":       len(objs.appPods),
+		"mountPods":     len(objs.mountPods),
+		"pvcs":          len(objs.pvcs),
+		"pvs":           len(objs.pvs),
+		"nodes":         objs.nodeNames,
+		"includeSystem": b.IncludeSystem,
+	}
+	w.addObject("bundle.yaml", summary)
+	if len(objs.errors) != 0 {
+		w.add("errors.txt", []byte(strings.Join(objs.errors, "\n")+"\n"))
+	}
+}
+
+// writeSystem writes CSI node pods on nodes of bundle with their mountinfo, CSI controller pods and driver config
+func (b *BundleCollector) writeSystem(ctx context.Context, w *bundleWriter) {
+	objs := w.objs
+	nodes := make(map[string]bool)
+	for _, name := range objs.nodeNames {
+		nodes[name] = true
+	}
+	for _, app := range []string{config.CSINodeLabelValue, "juicefs-csi-controller"} {
+		pods, err := b.client.CoreV1().Pods(b.sysNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(map[string]string{
+				"app.kubernetes.io/name": "juicefs-csi-driver",
+				"app":                    app,
+			}).String(),
+		})
+		if err != nil {
+			objs.errorf("list %s pods error: %v", app, err)
+			continue
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if app == config.CSINodeLabelValue && !nodes[pod.Spec.NodeName] {
+				continue
+			}
+			dir := path.Join("csi", pod.Name)
+			b.writePod(ctx, w, dir, pod)
+			if app == config.CSINodeLabelValue {
+				b.writeMountInfo(w, dir, pod)
+			}
+		}
+	}
+	cm, err := b.client.CoreV1().ConfigMaps(b.sysNamespace).Get(ctx, csiConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			objs.errorf("get configmap %s error: %v", csiConfigMapName, err)
+		}
+		return
+	}
+	cm.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+	w.addObject(path.Join("csi", csiConfigMapName+".yaml"), cm)
+}
+
+// writePod writes pod, its events and logs of every container into dir
+func (b *BundleCollector) writePod(ctx context.Context, w *bundleWriter, dir string, pod *corev1.Pod) {
+	pod.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	w.addObject(path.Join(dir, "pod.yaml"), pod)
+	b.writeEvents(ctx, w, path.Join(dir, "events.yaml"), pod.Namespace, pod.UID)
+	restarts := make(map[string]int32)
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		restarts[status.Name] = status.RestartCount
+	}
+	for _, container := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		b.writeLogs(ctx, w, path.Join(dir, container.Name+".log"), pod, container.Name, false)
+		if restarts[container.Name] > 0 {
+			b.writeLogs(ctx, w, path.Join(dir, container.Name+".previous.log"), pod, container.Name, true)
+		}
+	}
+}
+
+func (b *BundleCollector) writeLogs(ctx context.Context, w *bundleWriter, name string, pod *corev1.Pod, container string, previous bool) {
+	limitBytes := int64(maxBundleLogBytes)
+	opts := &corev1.PodLogOptions{Container: container, Previous: previous, LimitBytes: &limitBytes}
+	if b.TailLines > 0 {
+		opts.TailLines = &b.TailLines
+	}
+	data, err := b.client.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, opts).DoRaw(ctx)
+	if err != nil {
+		w.objs.errorf("get logs of %s/%s/%s error: %v", pod.Namespace, pod.Name, container, err)
+		return
+	}
+	w.add(name, []byte(redactURLPassword(string(data))))
+}
+
+func (b *BundleCollector) writeEvents(ctx context.Context, w *bundleWriter, name, namespace string, uid types.UID) {
+	list, err := b.client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("involvedObject.uid", string(uid)).String(),
+	})
+	if err != nil {
+		w.objs.errorf("list events of %s error: %v", name, err)
+		return
+	}
+	events := make([]corev1.Event, 0, len(list.Items))
+	for _, event := range list.Items {
+		if event.InvolvedObject.UID == uid {
+			events = append(events, event)
+		}
+	}
+	if len(events) != 0 {
+		w.addObject(name, events)
+	}
+}
+
+func (b *BundleCollector) writeMountInfo(w *bundleWriter, dir string, pod *corev1.Pod) {
+	if b.exec == nil || pod.Status.Phase != corev1.PodRunning || len(pod.Spec.Containers) == 0 {
+		return
+	}
+	container := pod.Spec.Containers[0].Name
+	if hasContainer(pod, csiPluginContainerName) {
+		container = csiPluginContainerName
+	}
+	stdout, stderr, err := b.exec(pod.Namespace, pod.Name, container, []string{"cat", "/proc/self/mountinfo"}, 30*time.Second)
+	if err != nil {
+		w.objs.errorf("read mountinfo in %s error: %v, stderr: %s", pod.Name, err, stderr)
+		return
+	}
+	w.add(path.Join(dir, "mountinfo"), []byte(stdout))
+}
+
+// downloadBundle serves bundle of the pod or pvc in context, or node in param,
+// system objects are included only if user can access system namespace
+func (api *API) downloadBundle(kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var target BundleTarget
+		switch kind {
+		case "pod", "pvc":
+			obj, ok := c.Get(kind)
+			if !ok {
+				c.String(404, "not found")
+				return
+			}
+			meta := obj.(metav1.Object)
+			target = BundleTarget{Kind: kind, Namespace: meta.GetNamespace(), Name: meta.GetName()}
+		case "node":
+			target = BundleTarget{Kind: kind, Name: c.Param("nodeName")}
+		}
+		collector := &BundleCollector{
+			client:        api.client,
+			sysNamespace:  api.sysNamespace,
+			exec:          api.exec,
+			TailLines:     defaultBundleTailLines,
+			IncludeSystem: api.canAccessNamespace(c, api.sysNamespace),
+		}
+		if api.authorizer != nil {
+			collector.access = &bundleAccess{
+				pod:       func(pod *corev1.Pod) bool { return api.canAccessPod(c, pod) },
+				pv:        func(pv *corev1.PersistentVolume) bool { return api.canAccessPV(c, pv) },
+				namespace: func(namespace string) bool { return api.canAccessNamespace(c, namespace) },
+			}
+		}
+		if tail := c.Query("tail"); tail != "" {
+			lines, err := strconv.ParseInt(tail, 10, 64)
+			if err != nil || lines < 0 {
+				c.String(400, "invalid tail %s", tail)
+				return
+			}
+			collector.TailLines = lines
+		}
+		c.Header("Content-Type", "application/gzip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", BundleName(target, time.Now())+".tar.gz"))
+		if err := collector.Collect(c, target, c.Writer); err != nil {
+			if c.Writer.Written() {
+				klog.Errorf("write bundle of %s %s error: %v", target.Kind, target.Name, err)
+				return
+			}
+			c.Header("Content-Disposition", "")
+			if k8serrors.IsNotFound(err) {
+				c.String(404, "not found")
+			} else {
+				c.String(500, "collect bundle error %v", err)
+			}
+		}
+	}
+}
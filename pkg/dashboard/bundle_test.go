@@ -0,0 +1,230 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+func readBundle(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("read gzip error: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatalf("read tar error: %v", err)
+		}
+		content, _ := io.ReadAll(tr)
+		// strip directory of bundle
+		files[strings.SplitN(header.Name, "/", 2)[1]] = string(content)
+	}
+}
+
+func TestBundleCollector(t *testing.T) {
+	target := "/var/lib/kubelet/pods/uid-app/volumes/kubernetes.io~csi/pvc-1/mount"
+	app := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: types.UID("uid-app")},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-1",
+			Containers: []corev1.Container{{Name: "app"}},
+			Volumes: []corev1.Volume{{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+			}},
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pvc-1"},
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			StorageClassName: "juicefs-sc",
+			ClaimRef:         &corev1.ObjectReference{Namespace: "default", Name: "data"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:       config.DriverName,
+				VolumeHandle: "pvc-1",
+			}},
+		},
+	}
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "juicefs-sc"}, Provisioner: config.DriverName}
+	mountPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "juicefs-node-1-pvc-1-abcdef",
+			Namespace:   "kube-system",
+			Labels:      map[string]string{config.PodTypeKey: config.PodTypeValue, config.PodUniqueIdLabelKey: "pvc-1"},
+			Annotations: map[string]string{util.GetReferenceKey(target): target},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1.Container{{
+				Name: "jfs-mount",
+				Env: []corev1.EnvVar{
+					{Name: "metaurl", Value: "redis://:secret-password@127.0.0.1/1"},
+					{Name: "SECRET_KEY", Value: "secret-key"},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "jfs-mount", RestartCount: 1}}},
+	}
+	otherMountPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "juicefs-node-1-pvc-2-abcdef",
+			Namespace: "kube-system",
+			Labels:    map[string]string{config.PodTypeKey: config.PodTypeValue, config.PodUniqueIdLabelKey: "pvc-2"},
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1", Containers: []corev1.Container{{Name: "jfs-mount"}}},
+	}
+	csiNode := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "juicefs-csi-node-abc",
+			Namespace: "kube-system",
+			Labels:    map[string]string{"app.kubernetes.io/name": "juicefs-csi-driver", "app": config.CSINodeLabelValue},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node-1", Containers: []corev1.Container{{Name: "juicefs-plugin"}}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: csiConfigMapName, Namespace: "kube-system"},
+		Data:       map[string]string{"config.yaml": "enableNodeSelector: false"},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	client := fake.NewSimpleClientset(app, pvc, pv, sc, mountPod, otherMountPod, csiNode, cm, node)
+
+	collector := NewBundleCollector(client, "kube-system", nil)
+	collector.exec = func(namespace, pod, container string, cmd []string, timeout time.Duration) (string, string, error) {
+		if pod != csiNode.Name || container != "juicefs-plugin" {
+			t.Errorf("unexpected exec in %s/%s", pod, container)
+		}
+		return "1 2 0:50 / /var/lib/juicefs/volume/pvc-1-abcdef rw - fuse.juicefs JuiceFS:vol rw\n", "", nil
+	}
+	var buf bytes.Buffer
+	if err := collector.Collect(context.TODO(), BundleTarget{Kind: "pod", Namespace: "default", Name: "app"}, &buf); err != nil {
+		t.Fatalf("collect error: %v", err)
+	}
+	files := readBundle(t, buf.Bytes())
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, want := range []string{
+		"app/default/app/pod.yaml",
+		"app/default/app/app.log",
+		"mount/juicefs-node-1-pvc-1-abcdef/pod.yaml",
+		"mount/juicefs-node-1-pvc-1-abcdef/jfs-mount.log",
+		"mount/juicefs-node-1-pvc-1-abcdef/jfs-mount.previous.log",
+		"pvc/default/data.yaml",
+		"pv/pvc-1.yaml",
+		"storageclass/juicefs-sc.yaml",
+		"node/node-1.yaml",
+		"csi/juicefs-csi-node-abc/pod.yaml",
+		"csi/juicefs-csi-node-abc/mountinfo",
+		"csi/" + csiConfigMapName + ".yaml",
+		"bundle.yaml",
+	} {
+		if _, ok := files[want]; !ok {
+			t.Errorf("expected %s in bundle, got %v", want, names)
+		}
+	}
+	if _, ok := files[path.Join("mount", otherMountPod.Name, "pod.yaml")]; ok {
+		t.Errorf("unrelated mount pod %s in bundle", otherMountPod.Name)
+	}
+	mountPodYaml := files["mount/juicefs-node-1-pvc-1-abcdef/pod.yaml"]
+	if strings.Contains(mountPodYaml, "secret-password") || strings.Contains(mountPodYaml, "secret-key") {
+		t.Errorf("secrets are not redacted: %s", mountPodYaml)
+	}
+	if !strings.Contains(mountPodYaml, "redis://:******@127.0.0.1/1") {
+		t.Errorf("expected redacted meta url in %s", mountPodYaml)
+	}
+
+	collector.IncludeSystem = false
+	buf.Reset()
+	if err := collector.Collect(context.TODO(), BundleTarget{Kind: "node", Name: "node-1"}, &buf); err != nil {
+		t.Fatalf("collect error: %v", err)
+	}
+	files = readBundle(t, buf.Bytes())
+	for _, want := range []string{"app/default/app/pod.yaml", "mount/juicefs-node-1-pvc-2-abcdef/pod.yaml", "pv/pvc-1.yaml"} {
+		if _, ok := files[want]; !ok {
+			t.Errorf("expected %s in bundle of node", want)
+		}
+	}
+	if _, ok := files["csi/juicefs-csi-node-abc/pod.yaml"]; ok {
+		t.Errorf("unexpected csi node in bundle without system objects")
+	}
+
+	// objects not accessible are omitted
+	collector.access = &bundleAccess{
+		pod: func(pod *corev1.Pod) bool {
+			return pod.Namespace == "default" || pod.Labels[config.PodUniqueIdLabelKey] == "pvc-1"
+		},
+		pv: func(pv *corev1.PersistentVolume) bool {
+			return pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.Namespace == "default"
+		},
+		namespace: func(namespace string) bool { return namespace == "default" },
+	}
+	buf.Reset()
+	if err := collector.Collect(context.TODO(), BundleTarget{Kind: "node", Name: "node-1"}, &buf); err != nil {
+		t.Fatalf("collect error: %v", err)
+	}
+	files = readBundle(t, buf.Bytes())
+	for _, want := range []string{"app/default/app/pod.yaml", "mount/juicefs-node-1-pvc-1-abcdef/pod.yaml", "pv/pvc-1.yaml", "pvc/default/data.yaml"} {
+		if _, ok := files[want]; !ok {
+			t.Errorf("expected %s in bundle of node with access", want)
+		}
+	}
+	if _, ok := files["mount/juicefs-node-1-pvc-2-abcdef/pod.yaml"]; ok {
+		t.Errorf("unexpected mount pod not accessible in bundle")
+	}
+	if !strings.Contains(files["errors.txt"], "objects omitted without access: 1") {
+		t.Errorf("expected omitted objects in errors, got %s", files["errors.txt"])
+	}
+	collector.access = nil
+
+	buf.Reset()
+	err := collector.Collect(context.TODO(), BundleTarget{Kind: "pvc", Namespace: "default", Name: "none"}, &buf)
+	if !k8serrors.IsNotFound(err) || buf.Len() != 0 {
+		t.Errorf("expected not found error without output, got %v, %d bytes", err, buf.Len())
+	}
+}
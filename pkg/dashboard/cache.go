@@ -0,0 +1,78 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const defaultCSIWebPort = "8080"
+
+var csiNodeHttpClient = &http.Client{Timeout: 10 * time.Second}
+
+// csiNodeWebURL returns url of the web server (metrics, cache usage, etc.) in csi node
+func csiNodeWebURL(pod *corev1.Pod, path string) string {
+	port := defaultCSIWebPort
+	for _, container := range pod.Spec.Containers {
+		for _, env := range container.Env {
+			if env.Name == "JUICEFS_CSI_WEB_PORT" && env.Value != "" {
+				port = env.Value
+			}
+		}
+	}
+	return fmt.Sprintf("http://%s:%s%s", pod.Status.PodIP, port, path)
+}
+
+// getCacheUsageOfNode proxies cache usage served by csi node of the node
+func (api *API) getCacheUsageOfNode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nodeName := c.Param("nodeName")
+		pod, err := api.getCSINode(c, nodeName)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			c.String(500, "get csi node %s error %v", nodeName, err)
+			return
+		}
+		if pod == nil || pod.Status.PodIP == "" {
+			c.String(404, "not found")
+			return
+		}
+		req, err := http.NewRequestWithContext(c, http.MethodGet, csiNodeWebURL(pod, "/cache-usage"), nil)
+		if err != nil {
+			c.String(500, "create request error %v", err)
+			return
+		}
+		resp, err := csiNodeHttpClient.Do(req)
+		if err != nil {
+			c.String(502, "get cache usage from csi node %s error %v", pod.Name, err)
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			c.String(502, "read cache usage from csi node %s error %v", pod.Name, err)
+			return
+		}
+		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+}
@@ -0,0 +1,318 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+const csiConfigKey = "config.yaml"
+
+// DriverConfig is the config of CSI driver in ConfigMap
+type DriverConfig struct {
+	Namespace       string         `json:"namespace"`
+	Name            string         `json:"name"`
+	ResourceVersion string         `json:"resourceVersion"`
+	Data            string         `json:"data"`
+	Config          *config.Config `json:"config,omitempty"`
+	Errors          []string       `json:"errors,omitempty"`
+}
+
+type configEditRequest struct {
+	Data string `json:"data"`
+	// resourceVersion of ConfigMap the edit is based on, required when saving
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// ConfigPreview is the result of validating an edited config, and how mount pods of existing PVCs are patched with it
+type ConfigPreview struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// number of PVCs whose merged patch is changed by the edit
+	Changed int               `json:"changed"`
+	PVCs    []PVCPatchPreview `json:"pvcs"`
+}
+
+// PVCPatchPreview shows mount pod patches of a PVC in current and edited config
+type PVCPatchPreview struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	PV        string `json:"pv"`
+	// indexes of matched patches in mountPodPatch of current and edited config
+	CurrentPatches []int `json:"currentPatches"`
+	Patches        []int `json:"patches"`
+	// whether merged patch of edited config differs from current one
+	Changed bool `json:"changed"`
+	// merged patch of edited config
+	Patch *config.MountPodPatch `json:"patch,omitempty"`
+	// mount pod rendered with edited config, secrets redacted
+	MountPod interface{} `json:"mountPod,omitempty"`
+	// existing mount pods of the PVC
+	MountPods []string `json:"mountPods,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// parseDriverConfig parses config, unknown fields are returned as warnings
+func parseDriverConfig(data string) (*config.Config, []string, error) {
+	cfg := &config.Config{}
+	if err := cfg.Unmarshal([]byte(data)); err != nil {
+		return nil, nil, err
+	}
+	var warnings []string
+	if err := yaml.UnmarshalStrict([]byte(data), &config.Config{}); err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	return cfg, warnings, cfg.Validate()
+}
+
+func errorStrings(err error) []string {
+	if err == nil {
+		return nil
+	}
+	if agg, ok := err.(utilerrors.Aggregate); ok {
+		var errs []string
+		for _, e := range agg.Errors() {
+			errs = append(errs, e.Error())
+		}
+		return errs
+	}
+	return []string{err.Error()}
+}
+
+func (api *API) getConfigMap(c *gin.Context) (*corev1.ConfigMap, error) {
+	return api.client.CoreV1().ConfigMaps(api.sysNamespace).Get(c, csiConfigMapName, metav1.GetOptions{})
+}
+
+func (api *API) getConfigMapMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cm, err := api.getConfigMap(c)
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				c.AbortWithStatus(404)
+				return
+			}
+			c.String(500, "get configmap error %v", err)
+			c.Abort()
+			return
+		}
+		c.Set("configmap", cm)
+	}
+}
+
+func (api *API) getConfigHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cm := c.MustGet("configmap").(*corev1.ConfigMap)
+		result := &DriverConfig{
+			Namespace:       cm.Namespace,
+			Name:            cm.Name,
+			ResourceVersion: cm.ResourceVersion,
+			Data:            cm.Data[csiConfigKey],
+		}
+		cfg, _, err := parseDriverConfig(result.Data)
+		result.Config, result.Errors = cfg, errorStrings(err)
+		c.IndentedJSON(200, result)
+	}
+}
+
+// previewConfig validates the edited config in body, and shows for PVCs (all JuiceFS PVCs, or the one in query "pvc")
+// which patches match and their mount pods with it. Only changed PVCs are returned with query changed=true.
+func (api *API) previewConfig() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cm := c.MustGet("configmap").(*corev1.ConfigMap)
+		var req configEditRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.String(400, "invalid request: %v", err)
+			return
+		}
+		edited, warnings, err := parseDriverConfig(req.Data)
+		preview := &ConfigPreview{Valid: err == nil, Errors: errorStrings(err), Warnings: warnings, PVCs: []PVCPatchPreview{}}
+		if edited == nil {
+			c.IndentedJSON(200, preview)
+			return
+		}
+		current := &config.Config{}
+		if err := current.Unmarshal([]byte(cm.Data[csiConfigKey])); err != nil {
+			preview.Warnings = append(preview.Warnings, fmt.Sprintf("current config is invalid: %v", err))
+		}
+
+		var filter *types.NamespacedName
+		if pvc := c.Query("pvc"); pvc != "" {
+			parts := strings.Split(pvc, "/")
+			if len(parts) != 2 {
+				c.String(400, "pvc should be <namespace>/<name>")
+				return
+			}
+			filter = &types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+		}
+		changedOnly := c.Query("changed") == "true"
+
+		var pvs corev1.PersistentVolumeList
+		if err := api.cachedReader.List(c, &pvs); err != nil {
+			c.String(500, "list pvs error %v", err)
+			return
+		}
+		mountPods, err := api.mountPodsByUniqueId(c)
+		if err != nil {
+			c.String(500, "list mount pods error %v", err)
+			return
+		}
+		renderer := api.newMountPodRenderer(c, edited)
+		for i := range pvs.Items {
+			pv := &pvs.Items[i]
+			if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName || pv.Spec.ClaimRef == nil {
+				continue
+			}
+			ref := types.NamespacedName{Namespace: pv.Spec.ClaimRef.Namespace, Name: pv.Spec.ClaimRef.Name}
+			if filter != nil && *filter != ref {
+				continue
+			}
+			var pvc corev1.PersistentVolumeClaim
+			if err := api.cachedReader.Get(c, ref, &pvc); err != nil {
+				continue
+			}
+			p := previewPVC(c, renderer, current, edited, pv, &pvc)
+			for _, pod := range append(mountPods[pv.Spec.CSI.VolumeHandle], mountPods[pv.Spec.StorageClassName]...) {
+				p.MountPods = append(p.MountPods, pod.Name)
+			}
+			if p.Changed {
+				preview.Changed++
+			} else if changedOnly {
+				continue
+			}
+			preview.PVCs = append(preview.PVCs, p)
+		}
+		c.IndentedJSON(200, preview)
+	}
+}
+
+func previewPVC(c *gin.Context, renderer *mountPodRenderer, current, edited *config.Config, pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) PVCPatchPreview {
+	p := PVCPatchPreview{
+		Namespace:      pvc.Namespace,
+		Name:           pvc.Name,
+		PV:             pv.Name,
+		CurrentPatches: matchedPatches(current, pvc),
+		Patches:        matchedPatches(edited, pvc),
+	}
+	setting, err := renderer.setting(c, pv, pvc)
+	if err != nil {
+		// merged patches can not be compared without setting, compare matched patches only
+		p.Error = err.Error()
+		p.Changed = !reflect.DeepEqual(patchesOf(current, p.CurrentPatches), patchesOf(edited, p.Patches))
+		return p
+	}
+	currentPatch, editedPatch := current.GenMountPodPatch(*setting), edited.GenMountPodPatch(*setting)
+	p.Changed = !reflect.DeepEqual(currentPatch, editedPatch)
+	p.Patch = &editedPatch
+
+	pod := renderer.render(setting, fmt.Sprintf("juicefs-preview-%s", setting.UniqueId))
+	data, err := json.Marshal(pod)
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	p.MountPod = redactSecrets("", v)
+	return p
+}
+
+// matchedPatches returns indexes of patches in config matching the PVC
+func matchedPatches(cfg *config.Config, pvc *corev1.PersistentVolumeClaim) []int {
+	matched := []int{}
+	for i, mp := range cfg.MountPodPatch {
+		if ok, _ := mp.Matches(pvc); ok {
+			matched = append(matched, i)
+		}
+	}
+	return matched
+}
+
+func patchesOf(cfg *config.Config, indexes []int) []config.MountPodPatch {
+	patches := make([]config.MountPodPatch, 0, len(indexes))
+	for _, i := range indexes {
+		patches = append(patches, cfg.MountPodPatch[i])
+	}
+	return patches
+}
+
+// mountPodsByUniqueId lists mount pods grouped by label volume-id
+func (api *API) mountPodsByUniqueId(c *gin.Context) (map[string][]*corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := api.cachedReader.List(c, &pods, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{config.PodTypeKey: config.PodTypeValue}),
+	}); err != nil {
+		return nil, err
+	}
+	result := make(map[string][]*corev1.Pod)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if id := pod.Labels[config.PodUniqueIdLabelKey]; id != "" {
+			result[id] = append(result[id], pod)
+		}
+	}
+	return result, nil
+}
+
+// updateConfigAction saves the edited config in body to ConfigMap if it is valid and ConfigMap is not changed since resourceVersion
+func (api *API) updateConfigAction() gin.HandlerFunc {
+	return api.doAction("update-config", "configmap", "ConfigMap", func(c *gin.Context, obj client.Object, params map[string]string) (string, error) {
+		cm := obj.(*corev1.ConfigMap)
+		var req configEditRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.ResourceVersion == "" {
+			return "", rejectf(400, "data and resourceVersion are required")
+		}
+		params["resourceVersion"] = req.ResourceVersion
+		if _, _, err := parseDriverConfig(req.Data); err != nil {
+			return "", rejectf(400, "invalid config: %v", err)
+		}
+		if req.ResourceVersion != cm.ResourceVersion {
+			return "", rejectf(409, "config is changed since resourceVersion %s, reload it and edit again", req.ResourceVersion)
+		}
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[csiConfigKey] = req.Data
+		updated, err := api.client.CoreV1().ConfigMaps(cm.Namespace).Update(c, cm, metav1.UpdateOptions{})
+		if err != nil {
+			if k8serrors.IsConflict(err) {
+				return "", rejectf(409, "config is changed since resourceVersion %s, reload it and edit again", req.ResourceVersion)
+			}
+			return "", err
+		}
+		return fmt.Sprintf("config saved, resourceVersion %s", updated.ResourceVersion), nil
+	})
+}
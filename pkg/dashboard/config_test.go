@@ -0,0 +1,163 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+func TestConfigPreview(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: csiConfigMapName, Namespace: "kube-system", ResourceVersion: "1"},
+		Data: map[string]string{csiConfigKey: `
+mountPodPatch:
+  - labels:
+      team: all
+`},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-secret", Namespace: "default"},
+		Data: map[string][]byte{
+			"name":    []byte("vol"),
+			"metaurl": []byte("redis://:password@127.0.0.1/1"),
+		},
+	}
+	newPVC := func(name string, labels map[string]string) (*corev1.PersistentVolume, *corev1.PersistentVolumeClaim) {
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-" + name},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: name},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:               config.DriverName,
+					VolumeHandle:         "pv-" + name,
+					NodePublishSecretRef: &corev1.SecretReference{Namespace: "default", Name: "juicefs-secret"},
+				}},
+			},
+		}
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: pv.Name},
+		}
+		return pv, pvc
+	}
+	pv1, pvc1 := newPVC("data-1", map[string]string{"app": "ai"})
+	pv2, pvc2 := newPVC("data-2", nil)
+	csiNode := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-csi-node-abc", Namespace: "kube-system"},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1.Container{{
+				Name: csiPluginContainerName,
+				Env:  []corev1.EnvVar{{Name: "JUICEFS_CE_MOUNT_IMAGE", Value: "juicedata/mount:ce-v1.1.0"}},
+			}},
+		},
+	}
+	objs := []runtime.Object{cm, secret, pv1, pvc1, pv2, pvc2, csiNode}
+	client := fake.NewSimpleClientset(objs...)
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithRuntimeObjects(objs...).Build(), client)
+	api.csiNodeIndex["node-1"] = types.NamespacedName{Namespace: csiNode.Namespace, Name: csiNode.Name}
+	api.SetAuth(authenticatorFunc(func(req *http.Request) (*User, error) {
+		return &User{Name: "alice"}, nil
+	}), newOperatorAuthorizer(client, "alice"))
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	do := func(method, url string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(data))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	edited := `
+mountPodPatch:
+  - labels:
+      team: all
+  - pvcSelector:
+      matchLabels:
+        app: ai
+    ceMountImage: juicedata/mount:ce-v1.2.0
+`
+	w := do(http.MethodPost, "/api/v1/config/preview", configEditRequest{Data: edited})
+	if w.Code != 200 {
+		t.Fatalf("preview: expected code 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var preview ConfigPreview
+	if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil {
+		t.Fatal(err)
+	}
+	if !preview.Valid || preview.Changed != 1 || len(preview.PVCs) != 2 {
+		t.Fatalf("unexpected preview: %s", w.Body.String())
+	}
+	for _, p := range preview.PVCs {
+		if p.Error != "" {
+			t.Errorf("preview of %s error: %s", p.Name, p.Error)
+			continue
+		}
+		wantPatches, wantChanged, wantImage := []int{0}, false, "juicedata/mount:ce-v1.1.0"
+		if p.Name == pvc1.Name {
+			wantPatches, wantChanged, wantImage = []int{0, 1}, true, "juicedata/mount:ce-v1.2.0"
+		}
+		if !reflect.DeepEqual(p.CurrentPatches, []int{0}) || !reflect.DeepEqual(p.Patches, wantPatches) || p.Changed != wantChanged {
+			t.Errorf("unexpected preview of %s: %+v", p.Name, p)
+		}
+		data, _ := json.Marshal(p.MountPod)
+		var pod corev1.Pod
+		json.Unmarshal(data, &pod)
+		if len(pod.Spec.Containers) == 0 || pod.Spec.Containers[0].Image != wantImage || pod.Labels["team"] != "all" {
+			t.Errorf("unexpected mount pod of %s: %s", p.Name, data)
+		}
+		if strings.Contains(string(data), "password") {
+			t.Errorf("secret in mount pod of %s: %s", p.Name, data)
+		}
+	}
+
+	w = do(http.MethodPost, "/api/v1/config/preview", configEditRequest{Data: "mountPodPatch:\n  - pvcSelector:\n      matchExpressions:\n        - {key: app, operator: bad}\n"})
+	if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil || preview.Valid || len(preview.Errors) != 1 {
+		t.Errorf("expected invalid config, got %s", w.Body.String())
+	}
+
+	if w := do(http.MethodPut, "/api/v1/config", configEditRequest{Data: edited, ResourceVersion: "0"}); w.Code != 409 {
+		t.Errorf("save with stale resourceVersion: expected code 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/api/v1/config", configEditRequest{Data: edited, ResourceVersion: "1"}); w.Code != 200 {
+		t.Errorf("save: expected code 200, got %d: %s", w.Code, w.Body.String())
+	}
+	saved, _ := client.CoreV1().ConfigMaps("kube-system").Get(context.TODO(), csiConfigMapName, metav1.GetOptions{})
+	if saved.Data[csiConfigKey] != edited {
+		t.Errorf("config not saved: %s", saved.Data[csiConfigKey])
+	}
+}
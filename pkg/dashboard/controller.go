@@ -74,6 +74,7 @@ func (c *PodController) Reconcile(ctx context.Context, req reconcile.Request) (r
 	}
 	if pod.DeletionTimestamp != nil {
 		c.appIndexes.removeIndex(req.NamespacedName)
+		c.appFields.remove(req.NamespacedName)
 		if isCsiNode(pod) {
 			c.csiNodeLock.Lock()
 			delete(c.csiNodeIndex, pod.Spec.NodeName)
@@ -82,9 +83,9 @@ func (c *PodController) Reconcile(ctx context.Context, req reconcile.Request) (r
 		klog.V(6).Infof("pod %s deleted", req.NamespacedName)
 		return reconcile.Result{}, nil
 	}
-	indexes := c.appIndexes
+	indexes, fields := c.appIndexes, c.appFields
 	if isSysPod(pod) {
-		indexes = c.sysIndexes
+		indexes, fields = c.sysIndexes, c.sysFields
 		if isCsiNode(pod) && pod.Spec.NodeName != "" {
 			c.csiNodeLock.Lock()
 			c.csiNodeIndex[pod.Spec.NodeName] = types.NamespacedName{
@@ -94,6 +95,7 @@ func (c *PodController) Reconcile(ctx context.Context, req reconcile.Request) (r
 			c.csiNodeLock.Unlock()
 		}
 	}
+	fields.update(req.NamespacedName, podIndexFields(pod))
 	indexes.addIndex(
 		pod,
 		func(p *corev1.Pod) metav1.ObjectMeta { return p.ObjectMeta },
@@ -123,16 +125,19 @@ func (c *PodController) SetupWithManager(mgr manager.Manager) error {
 		DeleteFunc: func(deleteEvent event.DeleteEvent) bool {
 			pod := deleteEvent.Object.(*corev1.Pod)
 			var indexes *timeOrderedIndexes[corev1.Pod]
+			var fields *fieldIndexes
 			if isAppPod(pod) {
-				indexes = c.appIndexes
+				indexes, fields = c.appIndexes, c.appFields
 			} else if isSysPod(pod) {
-				indexes = c.sysIndexes
+				indexes, fields = c.sysIndexes, c.sysFields
 			}
 			if indexes != nil {
-				indexes.removeIndex(types.NamespacedName{
+				name := types.NamespacedName{
 					Namespace: pod.GetNamespace(),
 					Name:      pod.GetName(),
-				})
+				}
+				indexes.removeIndex(name)
+				fields.remove(name)
 				if isCsiNode(pod) {
 					c.csiNodeLock.Lock()
 					delete(c.csiNodeIndex, pod.Spec.NodeName)
@@ -154,6 +159,7 @@ func (c *PVController) Reconcile(ctx context.Context, req reconcile.Request) (re
 	if err := c.cachedReader.Get(ctx, req.NamespacedName, pv); err != nil {
 		if k8serrors.IsNotFound(err) {
 			c.pvIndexes.removeIndex(req.NamespacedName)
+			c.pvFields.remove(req.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		klog.Errorf("get pv %s failed: %v", req.NamespacedName, err)
@@ -162,6 +168,7 @@ func (c *PVController) Reconcile(ctx context.Context, req reconcile.Request) (re
 	if pv.DeletionTimestamp != nil {
 		klog.V(6).Infof("watch pv %s deleted", req.NamespacedName)
 		c.pvIndexes.removeIndex(req.NamespacedName)
+		c.pvFields.remove(req.NamespacedName)
 		if pv.Spec.ClaimRef != nil {
 			pvcName := types.NamespacedName{
 				Namespace: pv.Spec.ClaimRef.Namespace,
@@ -171,9 +178,11 @@ func (c *PVController) Reconcile(ctx context.Context, req reconcile.Request) (re
 			delete(c.pairs, pvcName)
 			c.pairLock.Unlock()
 			c.pvcIndexes.removeIndex(pvcName)
+			c.pvcFields.remove(pvcName)
 		}
 		return reconcile.Result{}, nil
 	}
+	c.pvFields.update(req.NamespacedName, pvIndexFields(pv))
 	c.pvIndexes.addIndex(
 		pv,
 		func(p *corev1.PersistentVolume) metav1.ObjectMeta { return p.ObjectMeta },
@@ -196,6 +205,7 @@ func (c *PVController) Reconcile(ctx context.Context, req reconcile.Request) (re
 			klog.Errorf("get pvc %s failed: %v", pvcName, err)
 			return reconcile.Result{}, nil
 		}
+		c.pvcFields.update(pvcName, pvcIndexFields(&pvc, pv.Name))
 		c.pvcIndexes.addIndex(
 			&pvc,
 			func(p *corev1.PersistentVolumeClaim) metav1.ObjectMeta { return p.ObjectMeta },
@@ -244,6 +254,7 @@ func (c *PVCController) Reconcile(ctx context.Context, req reconcile.Request) (r
 	}
 	if pvc.Status.Phase == corev1.ClaimPending {
 		// created
+		c.pvcFields.update(req.NamespacedName, pvcIndexFields(pvc, ""))
 		c.pvcIndexes.addIndex(
 			pvc,
 			func(p *corev1.PersistentVolumeClaim) metav1.ObjectMeta { return p.ObjectMeta },
@@ -275,6 +286,7 @@ func (c *PVCController) Reconcile(ctx context.Context, req reconcile.Request) (r
 		defer c.pairLock.Unlock()
 		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == config.DriverName {
 			c.pairs[req.NamespacedName] = pvName
+			c.pvcFields.update(req.NamespacedName, pvcIndexFields(pvc, pvName.Name))
 		} else {
 			delete(c.pairs, req.NamespacedName)
 		}
@@ -299,9 +311,11 @@ func (c *PVCController) SetupWithManager(mgr manager.Manager) error {
 			newPvc := updateEvent.ObjectNew.(*corev1.PersistentVolumeClaim)
 			if oldPvc.Status.Phase == corev1.ClaimBound && newPvc.Status.Phase != corev1.ClaimBound {
 				// pvc unbound
+				name := types.NamespacedName{Namespace: oldPvc.GetNamespace(), Name: oldPvc.GetName()}
 				c.pairLock.Lock()
-				delete(c.pairs, types.NamespacedName{Namespace: oldPvc.GetNamespace(), Name: oldPvc.GetName()})
+				delete(c.pairs, name)
 				c.pairLock.Unlock()
+				c.pvcFields.update(name, pvcIndexFields(newPvc, ""))
 				return false
 			}
 			if oldPvc.Status.Phase == corev1.ClaimPending && newPvc.Status.Phase == corev1.ClaimBound {
@@ -318,6 +332,7 @@ func (c *PVCController) SetupWithManager(mgr manager.Manager) error {
 			}
 			klog.V(6).Infof("watch pvc %s deleted", name)
 			c.pvcIndexes.removeIndex(name)
+			c.pvcFields.remove(name)
 			c.pairLock.Lock()
 			delete(c.pairs, name)
 			c.pairLock.Unlock()
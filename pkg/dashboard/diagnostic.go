@@ -0,0 +1,327 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util/security"
+)
+
+const (
+	defaultAccessLogSeconds = 5
+	maxAccessLogSeconds     = 60
+	// output of diagnostics is truncated to this size
+	maxDiagnosticOutput = 1 << 20
+	redactedValue       = "******"
+)
+
+// executor executes command in container and returns its stdout and stderr
+type executor func(namespace, pod, container string, cmd []string, timeout time.Duration) (string, string, error)
+
+// SetRestConfig sets rest config used to execute diagnostics in mount pods,
+// diagnostics are only registered when authentication is enabled as well.
+func (api *API) SetRestConfig(config *rest.Config) {
+	api.exec = newExecutor(api.client, config)
+}
+
+func newExecutor(client kubernetes.Interface, config *rest.Config) executor {
+	k8sClient := &k8sclient.K8sClient{Interface: client}
+	return func(namespace, pod, container string, cmd []string, timeout time.Duration) (string, string, error) {
+		c := rest.CopyConfig(config)
+		c.Timeout = timeout
+		return k8sClient.ExecuteInContainerWithConfig(c, pod, namespace, container, cmd)
+	}
+}
+
+// DiagnosticResult is result of a diagnostic command in mount pod
+type DiagnosticResult struct {
+	Name      string   `json:"name"`
+	Pod       string   `json:"pod"`
+	Container string   `json:"container"`
+	Command   []string `json:"command"`
+	// raw output of info and summary
+	Output string `json:"output,omitempty"`
+	// metrics in .stats
+	Stats map[string]float64 `json:"stats,omitempty"`
+	// lines of .accesslog
+	Lines []string `json:"lines,omitempty"`
+	// .config with secrets redacted
+	Config interface{} `json:"config,omitempty"`
+	Stderr string      `json:"stderr,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type diagnostic struct {
+	// command run in mount container, mount point is the path in container
+	command func(c *gin.Context, mountPoint, subPath string) ([]string, error)
+	// whether command runs on a path in file system, which is limited to subPath of the PV
+	onPath bool
+	// parse fills result with stdout
+	parse func(result *DiagnosticResult, stdout string) error
+}
+
+// diagnostics are the only commands allowed to run in mount pods
+var diagnostics = map[string]diagnostic{
+	"stats": {
+		command: func(c *gin.Context, mountPoint, subPath string) ([]string, error) {
+			return []string{"cat", path.Join(mountPoint, ".stats")}, nil
+		},
+		parse: parseStats,
+	},
+	"info": {
+		onPath: true,
+		command: func(c *gin.Context, mountPoint, subPath string) ([]string, error) {
+			return []string{"juicefs", "info", path.Join(mountPoint, subPath)}, nil
+		},
+		parse: parseOutput,
+	},
+	"summary": {
+		onPath: true,
+		command: func(c *gin.Context, mountPoint, subPath string) ([]string, error) {
+			return []string{"juicefs", "summary", path.Join(mountPoint, subPath)}, nil
+		},
+		parse: parseOutput,
+	},
+	"accesslog": {
+		command: func(c *gin.Context, mountPoint, subPath string) ([]string, error) {
+			seconds := defaultAccessLogSeconds
+			if s := c.Query("seconds"); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n <= 0 || n > maxAccessLogSeconds {
+					return nil, fmt.Errorf("seconds should be 1 to %d", maxAccessLogSeconds)
+				}
+				seconds = n
+			}
+			// accesslog never ends, read it for seconds and ignore exit code of timeout
+			return []string{"sh", "-c", fmt.Sprintf("timeout %d cat %s | head -c %d; true",
+				seconds, security.EscapeBashStr(path.Join(mountPoint, ".accesslog")), maxDiagnosticOutput)}, nil
+		},
+		parse: func(result *DiagnosticResult, stdout string) error {
+			result.Lines = make([]string, 0)
+			for _, line := range strings.Split(stdout, "\n") {
+				if line != "" {
+					result.Lines = append(result.Lines, line)
+				}
+			}
+			return nil
+		},
+	},
+	"config": {
+		command: func(c *gin.Context, mountPoint, subPath string) ([]string, error) {
+			return []string{"cat", path.Join(mountPoint, ".config")}, nil
+		},
+		parse: func(result *DiagnosticResult, stdout string) error {
+			var config interface{}
+			if err := json.Unmarshal([]byte(stdout), &config); err != nil {
+				return fmt.Errorf("parse .config error: %v", err)
+			}
+			result.Config = redactSecrets("", config)
+			return nil
+		},
+	},
+}
+
+func parseOutput(result *DiagnosticResult, stdout string) error {
+	result.Output = stdout
+	return nil
+}
+
+// parseStats parses metrics in .stats, each line is "<name> <value>"
+func parseStats(result *DiagnosticResult, stdout string) error {
+	result.Stats = make(map[string]float64)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[1], 64); err == nil {
+			result.Stats[fields[0]] = v
+		}
+	}
+	return nil
+}
+
+var (
+	sensitiveKey = regexp.MustCompile(`(secret|password|passwd|passphrase|token|credential|accesskey|privatekey|encryptkey)`)
+	urlPassword  = regexp.MustCompile(`://([^:/@]*):([^@/]+)@`)
+)
+
+// isSensitiveKey checks key (or name of env) case-insensitively ignoring "_" and "-",
+// keys of names (e.g. secretName) are not sensitive
+func isSensitiveKey(key string) bool {
+	key = strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	return !strings.HasSuffix(key, "name") && sensitiveKey.MatchString(key)
+}
+
+// redactSecrets replaces values of sensitive keys, values of sensitive envs ({"name": ..., "value": ...})
+// and passwords in urls (e.g. meta url) in decoded json
+func redactSecrets(key string, v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = redactSecrets(k, item)
+		}
+		if name, ok := value["name"].(string); ok && isSensitiveKey(name) {
+			if s, ok := value["value"].(string); ok && s != "" {
+				value["value"] = redactedValue
+			}
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactSecrets(key, item)
+		}
+		return value
+	case string:
+		if value != "" && isSensitiveKey(key) {
+			return redactedValue
+		}
+		return redactURLPassword(value)
+	default:
+		return v
+	}
+}
+
+func redactURLPassword(s string) string {
+	return urlPassword.ReplaceAllString(s, "://$1:"+redactedValue+"@")
+}
+
+// runDiagnostic runs a diagnostic command of the allowlist in mount container of the pod
+func (api *API) runDiagnostic() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		obj, ok := c.Get("pod")
+		if !ok {
+			c.String(404, "not found")
+			return
+		}
+		pod := obj.(*corev1.Pod)
+		name := c.Param("command")
+		d, ok := diagnostics[name]
+		if !ok {
+			c.String(404, "diagnostic %s not found", name)
+			return
+		}
+		if !isMountPod(pod) || pod.Namespace != api.sysNamespace || len(pod.Spec.Containers) == 0 {
+			c.String(400, "pod %s is not a mount pod", pod.Name)
+			return
+		}
+		if pod.Status.Phase != corev1.PodRunning {
+			c.String(409, "mount pod %s is not running", pod.Name)
+			return
+		}
+		mountPoint, _, err := util.GetMountPathOfPod(*pod)
+		if err != nil {
+			c.String(400, "get mount point of mount pod %s error: %v", pod.Name, err)
+			return
+		}
+		subPath := ""
+		if d.onPath {
+			subPath, err = api.subPathOfMountPod(c, pod)
+			if err != nil {
+				c.String(400, err.Error())
+				return
+			}
+		}
+		cmd, err := d.command(c, mountPoint, subPath)
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+
+		container := pod.Spec.Containers[0].Name
+		result := &DiagnosticResult{Name: name, Pod: pod.Name, Container: container, Command: cmd}
+		timeout := 30 * time.Second
+		if name == "accesslog" {
+			timeout += maxAccessLogSeconds * time.Second
+		}
+		stdout, stderr, err := api.exec(pod.Namespace, pod.Name, container, cmd, timeout)
+		if len(stdout) > maxDiagnosticOutput {
+			stdout = stdout[:maxDiagnosticOutput]
+		}
+		result.Stderr = stderr
+		if err != nil {
+			klog.V(5).Infof("run diagnostic %s in pod %s error: %v, stderr: %s", name, pod.Name, err, stderr)
+			result.Error = err.Error()
+			c.IndentedJSON(200, result)
+			return
+		}
+		if err := d.parse(result, stdout); err != nil {
+			result.Error = err.Error()
+		}
+		c.IndentedJSON(200, result)
+	}
+}
+
+// subPathOfMountPod returns path relative to mount point from query "path", which should be under subPath
+// of PVs using the mount pod, or subPath of the PVs if they share the same subPath
+func (api *API) subPathOfMountPod(c *gin.Context, pod *corev1.Pod) (string, error) {
+	var pvs corev1.PersistentVolumeList
+	if err := api.cachedReader.List(c, &pvs); err != nil {
+		return "", fmt.Errorf("list pvs error: %v", err)
+	}
+	uniqueId := pod.Labels[config.PodUniqueIdLabelKey]
+	subPaths := make(map[string]bool)
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName {
+			continue
+		}
+		if pv.Spec.CSI.VolumeHandle == uniqueId || pv.Spec.StorageClassName == uniqueId {
+			subPaths[path.Clean("/"+pv.Spec.CSI.VolumeAttributes["subPath"])] = true
+		}
+	}
+	if len(subPaths) == 0 {
+		return "", fmt.Errorf("no pv of mount pod %s found", pod.Name)
+	}
+
+	p := c.Query("path")
+	if p == "" {
+		if len(subPaths) == 1 {
+			for subPath := range subPaths {
+				return subPath, nil
+			}
+		}
+		return "", fmt.Errorf("mount pod %s is shared by pvs with different subPaths, path is required", pod.Name)
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("invalid path %s", p)
+		}
+	}
+	p = path.Clean("/" + p)
+	for subPath := range subPaths {
+		if subPath == "/" || p == subPath || strings.HasPrefix(p, subPath+"/") {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("path %s is out of subPath of pvs", p)
+}
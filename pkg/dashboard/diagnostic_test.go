@@ -0,0 +1,133 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+func TestRunDiagnostic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "juicefs-node-1-pvc-1-abcdef",
+			Namespace: "kube-system",
+			Labels:    map[string]string{config.PodTypeKey: config.PodTypeValue, config.PodUniqueIdLabelKey: "pvc-1"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:    "jfs-mount",
+				Command: []string{"sh", "-c", "/bin/mount.juicefs redis://127.0.0.1/1 /jfs/pvc-1-abcdef -o metrics=0.0.0.0:9567"},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:           config.DriverName,
+				VolumeHandle:     "pvc-1",
+				VolumeAttributes: map[string]string{"subPath": "data"},
+			}},
+		},
+	}
+	outputs := map[string]string{
+		"cat /jfs/pvc-1-abcdef/.stats":  "juicefs_uptime 12.5\njuicefs_used_space 1024\ninvalid\n",
+		"cat /jfs/pvc-1-abcdef/.config": `{"Meta":{"MetaURL":"redis://:pass@127.0.0.1/1"},"Format":{"SecretKey":"abc","AccessKey":"ak","Bucket":"http://b"}}`,
+	}
+	var executed []string
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithObjects(pod, pv).Build(), fake.NewSimpleClientset(pod, pv))
+	api.exec = func(namespace, name, container string, cmd []string, timeout time.Duration) (string, string, error) {
+		executed = append(executed, strings.Join(cmd, " "))
+		return outputs[strings.Join(cmd, " ")], "", nil
+	}
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pod/kube-system/"+pod.Name+"/diagnostics/stats", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 404 {
+		t.Errorf("expected diagnostics disabled without authentication, got %d", w.Code)
+	}
+
+	api.SetAuth(authenticatorFunc(func(req *http.Request) (*User, error) {
+		return &User{Name: "alice"}, nil
+	}), nil)
+	router = gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	get := func(command string) (int, *DiagnosticResult) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/pod/kube-system/"+pod.Name+"/diagnostics/"+command, nil)
+		router.ServeHTTP(w, req)
+		var result DiagnosticResult
+		if w.Code == 200 {
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatalf("unmarshal result of %s error: %v", command, err)
+			}
+		}
+		return w.Code, &result
+	}
+
+	if code, result := get("stats"); code != 200 || !reflect.DeepEqual(result.Stats, map[string]float64{"juicefs_uptime": 12.5, "juicefs_used_space": 1024}) {
+		t.Errorf("stats: code %d, result %+v", code, result)
+	}
+	code, result := get("config")
+	want := map[string]interface{}{
+		"Meta":   map[string]interface{}{"MetaURL": "redis://:******@127.0.0.1/1"},
+		"Format": map[string]interface{}{"SecretKey": "******", "AccessKey": "******", "Bucket": "http://b"},
+	}
+	if code != 200 || !reflect.DeepEqual(result.Config, want) {
+		t.Errorf("config: code %d, result %+v", code, result.Config)
+	}
+	if code, _ := get("summary?path=/a/../../etc"); code != 400 {
+		t.Errorf("summary with invalid path: expected code 400, got %d", code)
+	}
+	if code, _ := get("summary"); code != 200 || executed[len(executed)-1] != "juicefs summary /jfs/pvc-1-abcdef/data" {
+		t.Errorf("summary: code %d, executed %v", code, executed)
+	}
+	if code, _ := get("info?path=/data/a"); code != 200 || executed[len(executed)-1] != "juicefs info /jfs/pvc-1-abcdef/data/a" {
+		t.Errorf("info with path: code %d, executed %v", code, executed)
+	}
+	for _, p := range []string{"/", "/database", "/other/data"} {
+		if code, _ := get("info?path=" + p); code != 400 {
+			t.Errorf("info with path %s out of subPath: expected code 400, got %d", p, code)
+		}
+	}
+	if code, _ := get("accesslog?seconds=120"); code != 400 {
+		t.Errorf("accesslog with invalid seconds: expected code 400, got %d", code)
+	}
+	if code, _ := get("rm"); code != 404 {
+		t.Errorf("command not in allowlist: expected code 404, got %d", code)
+	}
+}
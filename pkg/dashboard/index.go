@@ -26,6 +26,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
 )
 
 type k8sResource interface {
@@ -115,3 +117,156 @@ func (i *timeOrderedIndexes[T]) debug() []types.NamespacedName {
 	}
 	return names
 }
+
+// fields of resources indexed by fieldIndexes
+const (
+	indexNamespace    = "namespace"
+	indexNode         = "node"
+	indexPV           = "pv"
+	indexPVC          = "pvc"
+	indexStorageClass = "sc"
+	indexUniqueId     = "uniqueid"
+)
+
+// fieldIndexes indexes names of resources by values of their fields, e.g. app pods by node,
+// so that list APIs can filter resources without getting each of them
+type fieldIndexes struct {
+	sync.RWMutex
+	// indexed values of each resource
+	fields map[types.NamespacedName]map[string][]string
+	// names of resources by field and value
+	names map[string]map[string]map[types.NamespacedName]bool
+}
+
+func newFieldIndexes() *fieldIndexes {
+	return &fieldIndexes{
+		fields: make(map[types.NamespacedName]map[string][]string),
+		names:  make(map[string]map[string]map[types.NamespacedName]bool),
+	}
+}
+
+// update replaces indexed values of the resource
+func (i *fieldIndexes) update(name types.NamespacedName, fields map[string][]string) {
+	i.Lock()
+	defer i.Unlock()
+	i.unindex(name)
+	i.fields[name] = fields
+	for field, values := range fields {
+		if i.names[field] == nil {
+			i.names[field] = make(map[string]map[types.NamespacedName]bool)
+		}
+		for _, value := range values {
+			if i.names[field][value] == nil {
+				i.names[field][value] = make(map[types.NamespacedName]bool)
+			}
+			i.names[field][value][name] = true
+		}
+	}
+}
+
+func (i *fieldIndexes) remove(name types.NamespacedName) {
+	i.Lock()
+	defer i.Unlock()
+	i.unindex(name)
+}
+
+func (i *fieldIndexes) unindex(name types.NamespacedName) {
+	for field, values := range i.fields[name] {
+		for _, value := range values {
+			delete(i.names[field][value], name)
+			if len(i.names[field][value]) == 0 {
+				delete(i.names[field], value)
+			}
+		}
+	}
+	delete(i.fields, name)
+}
+
+// lookup returns names of resources which have a value of field matching match
+func (i *fieldIndexes) lookup(field string, match func(value string) bool) map[types.NamespacedName]bool {
+	i.RLock()
+	defer i.RUnlock()
+	result := make(map[types.NamespacedName]bool)
+	for value, names := range i.names[field] {
+		if !match(value) {
+			continue
+		}
+		for name := range names {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+// values returns values of field of the given resources
+func (i *fieldIndexes) values(names map[types.NamespacedName]bool, field string) map[string]bool {
+	i.RLock()
+	defer i.RUnlock()
+	result := make(map[string]bool)
+	for name := range names {
+		for _, value := range i.fields[name][field] {
+			result[value] = true
+		}
+	}
+	return result
+}
+
+// match returns names of indexed resources matching match
+func (i *fieldIndexes) match(match func(name types.NamespacedName) bool) map[types.NamespacedName]bool {
+	i.RLock()
+	defer i.RUnlock()
+	result := make(map[types.NamespacedName]bool)
+	for name := range i.fields {
+		if match(name) {
+			result[name] = true
+		}
+	}
+	return result
+}
+
+func podIndexFields(pod *corev1.Pod) map[string][]string {
+	fields := map[string][]string{
+		indexNamespace: {pod.Namespace},
+	}
+	if pod.Spec.NodeName != "" {
+		fields[indexNode] = []string{pod.Spec.NodeName}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			pvc := types.NamespacedName{Namespace: pod.Namespace, Name: volume.PersistentVolumeClaim.ClaimName}
+			fields[indexPVC] = append(fields[indexPVC], pvc.String())
+		}
+	}
+	if uniqueId := pod.Labels[config.PodUniqueIdLabelKey]; uniqueId != "" {
+		fields[indexUniqueId] = []string{uniqueId}
+	}
+	return fields
+}
+
+func pvIndexFields(pv *corev1.PersistentVolume) map[string][]string {
+	fields := make(map[string][]string)
+	if pv.Spec.ClaimRef != nil {
+		pvc := types.NamespacedName{Namespace: pv.Spec.ClaimRef.Namespace, Name: pv.Spec.ClaimRef.Name}
+		fields[indexPVC] = []string{pvc.String()}
+	}
+	if pv.Spec.StorageClassName != "" {
+		fields[indexStorageClass] = []string{pv.Spec.StorageClassName}
+	}
+	if pv.Spec.CSI != nil {
+		fields[indexUniqueId] = []string{pv.Spec.CSI.VolumeHandle}
+	}
+	return fields
+}
+
+func pvcIndexFields(pvc *corev1.PersistentVolumeClaim, pvName string) map[string][]string {
+	fields := map[string][]string{
+		indexNamespace: {pvc.Namespace},
+	}
+	if pvName != "" {
+		fields[indexPV] = []string{pvName}
+	}
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		fields[indexStorageClass] = []string{*pvc.Spec.StorageClassName}
+	}
+	return fields
+}
@@ -0,0 +1,321 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// sort keys of list APIs
+const (
+	sortByAge    = "age"
+	sortByName   = "name"
+	sortByStatus = "status"
+)
+
+// listOptions are common options of list APIs:
+// labelSelector and fieldSelector filter resources as kubectl does,
+// sort (age, name or status) and order (ascend or descend) sort them,
+// and they are paged by limit and continue, or by pageSize and current for compatibility
+type listOptions struct {
+	sortBy        string
+	descend       bool
+	labelSelector labels.Selector
+	fieldSelector fields.Selector
+
+	limit    int
+	token    *continueToken
+	pageSize int
+	current  int
+}
+
+// continueToken is where the next page starts, the offset is used if the last item of previous page is gone
+type continueToken struct {
+	Offset int    `json:"offset"`
+	Last   string `json:"last"`
+}
+
+func (t *continueToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeContinueToken(s string) (*continueToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var t continueToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	if t.Offset < 0 {
+		return nil, fmt.Errorf("negative offset %d", t.Offset)
+	}
+	return &t, nil
+}
+
+// parseListOptions parses list options from query, supportedFields are fields allowed in fieldSelector
+func parseListOptions(c *gin.Context, defaultDescend bool, supportedFields ...string) (*listOptions, error) {
+	opts := &listOptions{
+		sortBy:  sortByAge,
+		descend: defaultDescend,
+	}
+	switch order := c.Query("order"); order {
+	case "ascend":
+		opts.descend = false
+	case "descend":
+		opts.descend = true
+	}
+	if s := c.Query("sort"); s != "" {
+		if s != sortByAge && s != sortByName && s != sortByStatus {
+			return nil, fmt.Errorf("invalid sort %s, must be one of age, name and status", s)
+		}
+		opts.sortBy = s
+	}
+	if s := c.Query("labelSelector"); s != "" {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector: %v", err)
+		}
+		opts.labelSelector = selector
+	}
+	if s := c.Query("fieldSelector"); s != "" {
+		selector, err := fields.ParseSelector(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field selector: %v", err)
+		}
+		for _, r := range selector.Requirements() {
+			supported := false
+			for _, f := range supportedFields {
+				supported = supported || r.Field == f
+			}
+			if !supported {
+				return nil, fmt.Errorf("field %s is not supported in field selector", r.Field)
+			}
+		}
+		opts.fieldSelector = selector
+	}
+
+	if s := c.Query("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid limit")
+		}
+		opts.limit = limit
+		if s := c.Query("continue"); s != "" {
+			t, err := decodeContinueToken(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid continue token: %v", err)
+			}
+			opts.token = t
+		}
+		return opts, nil
+	}
+	pageSize, err := strconv.Atoi(c.Query("pageSize"))
+	if err != nil || pageSize <= 0 {
+		return nil, fmt.Errorf("invalid page size")
+	}
+	current, err := strconv.Atoi(c.Query("current"))
+	if err != nil || current <= 0 {
+		return nil, fmt.Errorf("invalid current page")
+	}
+	opts.pageSize, opts.current = pageSize, current
+	return opts, nil
+}
+
+// listQuery lists resources in time ordered indexes, filtered by names first and then by objects,
+// so that objects are only got if the options or match require them
+type listQuery[T k8sResource] struct {
+	indexes *timeOrderedIndexes[T]
+	// names allowed by field indexes, all if nil
+	names map[types.NamespacedName]bool
+	// filters by name, e.g. namespace
+	matchName func(name types.NamespacedName) bool
+	// filters by object, e.g. authorization which needs labels
+	match  func(obj *T) bool
+	get    func(name types.NamespacedName) (*T, error)
+	meta   func(obj *T) metav1.ObjectMeta
+	fields func(obj *T) fields.Set
+	status func(obj *T) string
+}
+
+type listItem[T k8sResource] struct {
+	name types.NamespacedName
+	obj  *T
+}
+
+// listPage is a page of list result, with total number of matched resources and token of next page
+type listPage[T k8sResource] struct {
+	total int
+	items []*T
+	// continue token of next page, empty if it's the last page
+	next string
+}
+
+func (q *listQuery[T]) list(ctx context.Context, opts *listOptions) *listPage[T] {
+	var items []*listItem[T]
+	for name := range q.indexes.iterate(ctx, opts.descend && opts.sortBy == sortByAge) {
+		if (q.names != nil && !q.names[name]) || (q.matchName != nil && !q.matchName(name)) {
+			continue
+		}
+		items = append(items, &listItem[T]{name: name})
+	}
+
+	if q.match != nil || opts.labelSelector != nil || opts.fieldSelector != nil || opts.sortBy == sortByStatus {
+		matched := make([]*listItem[T], 0, len(items))
+		for _, item := range items {
+			obj, err := q.get(item.name)
+			if err != nil {
+				klog.V(6).Infof("get %s error %v", item.name, err)
+				continue
+			}
+			if (q.match != nil && !q.match(obj)) ||
+				(opts.labelSelector != nil && !opts.labelSelector.Matches(labels.Set(q.meta(obj).Labels))) ||
+				(opts.fieldSelector != nil && !opts.fieldSelector.Matches(q.fields(obj))) {
+				continue
+			}
+			item.obj = obj
+			matched = append(matched, item)
+		}
+		items = matched
+	}
+
+	switch opts.sortBy {
+	case sortByName:
+		sort.SliceStable(items, func(i, j int) bool {
+			if opts.descend {
+				return items[i].name.String() > items[j].name.String()
+			}
+			return items[i].name.String() < items[j].name.String()
+		})
+	case sortByStatus:
+		// resources in the same status are still ordered by age
+		sort.SliceStable(items, func(i, j int) bool {
+			if opts.descend {
+				return q.status(items[i].obj) > q.status(items[j].obj)
+			}
+			return q.status(items[i].obj) < q.status(items[j].obj)
+		})
+	}
+
+	page := &listPage[T]{total: len(items), items: make([]*T, 0)}
+	start, size := (opts.current-1)*opts.pageSize, opts.pageSize
+	if opts.limit > 0 {
+		start, size = 0, opts.limit
+		if t := opts.token; t != nil {
+			start = t.Offset
+			if start == 0 || start > len(items) || items[start-1].name.String() != t.Last {
+				// resources changed since previous page, find the last item of it
+				for i, item := range items {
+					if item.name.String() == t.Last {
+						start = i + 1
+						break
+					}
+				}
+			}
+		}
+	}
+	if start >= len(items) {
+		return page
+	}
+	end := start + size
+	if end > len(items) {
+		end = len(items)
+	}
+	for _, item := range items[start:end] {
+		obj := item.obj
+		if obj == nil {
+			var err error
+			if obj, err = q.get(item.name); err != nil {
+				klog.V(6).Infof("get %s error %v", item.name, err)
+				continue
+			}
+		}
+		page.items = append(page.items, obj)
+	}
+	if opts.limit > 0 && end < len(items) {
+		page.next = (&continueToken{Offset: end, Last: items[end-1].name.String()}).encode()
+	}
+	return page
+}
+
+// selectorValues returns values of field required to equal by the field selector
+func selectorValues(selector fields.Selector, field string) []string {
+	if selector == nil {
+		return nil
+	}
+	var values []string
+	for _, r := range selector.Requirements() {
+		if r.Field == field && (r.Operator == selection.Equals || r.Operator == selection.DoubleEquals) {
+			values = append(values, r.Value)
+		}
+	}
+	return values
+}
+
+func containsFilter(filter string) func(string) bool {
+	return func(value string) bool {
+		return strings.Contains(value, filter)
+	}
+}
+
+func inSet(set map[string]bool) func(string) bool {
+	return func(value string) bool {
+		return set[value]
+	}
+}
+
+// keysOf returns keys of names by key, e.g. namespace/name of PVCs or name of PVs
+func keysOf(names map[types.NamespacedName]bool, key func(types.NamespacedName) string) map[string]bool {
+	result := make(map[string]bool, len(names))
+	for name := range names {
+		result[key(name)] = true
+	}
+	return result
+}
+
+// intersect returns names in both sets, nil means all names
+func intersect(a, b map[types.NamespacedName]bool) map[types.NamespacedName]bool {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	result := make(map[types.NamespacedName]bool)
+	for name := range a {
+		if b[name] {
+			result[name] = true
+		}
+	}
+	return result
+}
@@ -0,0 +1,242 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+func TestFieldIndexes(t *testing.T) {
+	i := newFieldIndexes()
+	pod1 := types.NamespacedName{Namespace: "default", Name: "pod1"}
+	pod2 := types.NamespacedName{Namespace: "default", Name: "pod2"}
+	i.update(pod1, map[string][]string{indexNode: {"node-1"}, indexPVC: {"default/a", "default/b"}})
+	i.update(pod2, map[string][]string{indexNode: {"node-2"}, indexPVC: {"default/b"}})
+
+	if got := i.lookup(indexPVC, containsFilter("b")); !reflect.DeepEqual(got, map[types.NamespacedName]bool{pod1: true, pod2: true}) {
+		t.Errorf("expected both pods using pvc b, got %v", got)
+	}
+	// pod1 rescheduled
+	i.update(pod1, map[string][]string{indexNode: {"node-2"}})
+	if got := i.lookup(indexNode, inSet(map[string]bool{"node-1": true})); len(got) != 0 {
+		t.Errorf("expected no pods on node-1, got %v", got)
+	}
+	if got := i.values(map[types.NamespacedName]bool{pod1: true, pod2: true}, indexNode); !reflect.DeepEqual(got, map[string]bool{"node-2": true}) {
+		t.Errorf("expected nodes [node-2], got %v", got)
+	}
+	i.remove(pod2)
+	if got := i.lookup(indexPVC, containsFilter("")); len(got) != 0 || len(i.names[indexPVC]) != 0 {
+		t.Errorf("expected no pods using pvcs, got %v", got)
+	}
+}
+
+func TestListWithIndexes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	start := time.Now().Add(-time.Hour)
+	sc := "juicefs-sc"
+	var objs []runtime.Object
+	newVolume := func(name string) {
+		objs = append(objs, &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID("pv-" + name), CreationTimestamp: metav1.NewTime(start)},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef:         &corev1.ObjectReference{Namespace: "default", Name: name},
+				StorageClassName: sc,
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       config.DriverName,
+					VolumeHandle: name,
+				}},
+			},
+		}, &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("pvc-" + name), CreationTimestamp: metav1.NewTime(start)},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: name, StorageClassName: &sc},
+			Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+		})
+	}
+	newVolume("pvc-1")
+	newVolume("pvc-2")
+	newPod := func(minutes int, name, node, app, claim string, phase corev1.PodPhase) {
+		objs = append(objs, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				UID:               types.UID(name),
+				Labels:            map[string]string{config.UniqueId: "", "app": app},
+				CreationTimestamp: metav1.NewTime(start.Add(time.Duration(minutes) * time.Minute)),
+			},
+			Spec: corev1.PodSpec{
+				NodeName: node,
+				Volumes: []corev1.Volume{{
+					Name:         "data",
+					VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+				}},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		})
+	}
+	newPod(1, "web-1", "node-1", "web", "pvc-1", corev1.PodRunning)
+	newPod(2, "web-2", "node-2", "web", "pvc-1", corev1.PodPending)
+	newPod(3, "db-1", "node-2", "db", "pvc-2", corev1.PodRunning)
+	newPod(4, "cache-1", "node-1", "cache", "pvc-2", corev1.PodRunning)
+	objs = append(objs, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "juicefs-node-2-pvc-2-abcdef",
+			Namespace: "kube-system",
+			UID:       "mount-pod",
+			Labels: map[string]string{
+				config.PodTypeKey:          config.PodTypeValue,
+				config.PodUniqueIdLabelKey: "pvc-2",
+			},
+			CreationTimestamp: metav1.NewTime(start),
+		},
+		Spec: corev1.PodSpec{NodeName: "node-2"},
+	}, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "juicefs-csi-node-xyz",
+			Namespace:         "kube-system",
+			UID:               "csi-node",
+			Labels:            map[string]string{"app.kubernetes.io/name": "juicefs-csi-driver", "app": "juicefs-csi-node"},
+			CreationTimestamp: metav1.NewTime(start),
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+	})
+
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithRuntimeObjects(objs...).Build(), fake.NewSimpleClientset())
+	podCtr, pvCtr, pvcCtr := PodController{api}, PVController{api}, PVCController{api}
+	for _, obj := range objs {
+		var err error
+		switch o := obj.(type) {
+		case *corev1.Pod:
+			_, err = podCtr.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: o.Namespace, Name: o.Name}})
+		case *corev1.PersistentVolume:
+			_, err = pvCtr.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Name: o.Name}})
+		case *corev1.PersistentVolumeClaim:
+			_, err = pvcCtr.Reconcile(context.TODO(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: o.Namespace, Name: o.Name}})
+		}
+		if err != nil {
+			t.Fatalf("reconcile error: %v", err)
+		}
+	}
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	listPods := func(query url.Values) (*ListAppPodResult, int) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/pods?"+query.Encode(), nil)
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			return nil, w.Code
+		}
+		var result ListAppPodResult
+		if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unmarshal result error: %v, %s", err, w.Body.String())
+		}
+		return &result, w.Code
+	}
+	names := func(result *ListAppPodResult) []string {
+		var names []string
+		for _, pod := range result.Pods {
+			names = append(names, pod.Name)
+		}
+		return names
+	}
+
+	for _, c := range []struct {
+		query string
+		want  []string
+	}{
+		{"pageSize=10&current=1", []string{"cache-1", "db-1", "web-2", "web-1"}},
+		{"pageSize=10&current=1&order=ascend&pv=pvc-1", []string{"web-1", "web-2"}},
+		{"pageSize=10&current=1&mountpod=node-2-pvc-2", []string{"db-1"}},
+		{"pageSize=10&current=1&mountpod=juicefs-node&node=node-1", nil},
+		{"pageSize=10&current=1&csinode=csi-node-xyz", []string{"cache-1", "web-1"}},
+		{"pageSize=10&current=1&sc=juicefs&node=node-2", []string{"db-1", "web-2"}},
+		{"pageSize=10&current=1&labelSelector=app+in+(web,db)&fieldSelector=status.phase=Running", []string{"db-1", "web-1"}},
+		{"pageSize=10&current=1&fieldSelector=spec.nodeName=node-1&sort=name&order=ascend", []string{"cache-1", "web-1"}},
+		{"pageSize=1&current=2&sort=status&order=ascend", []string{"web-1"}},
+	} {
+		query, _ := url.ParseQuery(c.query)
+		result, code := listPods(query)
+		if code != 200 {
+			t.Errorf("%s: expected code 200, got %d", c.query, code)
+			continue
+		}
+		if got := names(result); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected pods %v, got %v", c.query, c.want, got)
+		}
+	}
+
+	// continue pagination sorted by name, with a pod deleted between pages
+	query := url.Values{"limit": {"3"}, "sort": {"name"}, "order": {"ascend"}}
+	result, _ := listPods(query)
+	if got := names(result); !reflect.DeepEqual(got, []string{"cache-1", "db-1", "web-1"}) || result.Total != 4 || result.Continue == "" {
+		t.Fatalf("unexpected first page: %v, total %d, continue %q", got, result.Total, result.Continue)
+	}
+	api.appIndexes.removeIndex(types.NamespacedName{Namespace: "default", Name: "cache-1"})
+	query.Set("continue", result.Continue)
+	result, _ = listPods(query)
+	if got := names(result); !reflect.DeepEqual(got, []string{"web-2"}) || result.Continue != "" {
+		t.Errorf("unexpected next page: %v, continue %q", got, result.Continue)
+	}
+
+	for _, q := range []string{"pageSize=0&current=1", "limit=1&continue=invalid", "limit=1&sort=size", "limit=1&fieldSelector=spec.schedulerName=foo"} {
+		query, _ := url.ParseQuery(q)
+		if _, code := listPods(query); code != 400 {
+			t.Errorf("%s: expected code 400, got %d", q, code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pvcs?pageSize=10&current=1&pv=pvc-2&fieldSelector=status.phase=Bound", nil)
+	router.ServeHTTP(w, req)
+	var pvcs ListPVCPodResult
+	if err := json.Unmarshal(w.Body.Bytes(), &pvcs); err != nil {
+		t.Fatalf("unmarshal result error: %v, %s", err, w.Body.String())
+	}
+	if pvcs.Total != 1 || pvcs.PVCs[0].Name != "pvc-2" {
+		t.Errorf("expected pvc pvc-2, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/v1/syspods?pageSize=10&current=1&node=node-2", nil)
+	router.ServeHTTP(w, req)
+	var sysPods ListSysPodResult
+	if err := json.Unmarshal(w.Body.Bytes(), &sysPods); err != nil {
+		t.Fatalf("unmarshal result error: %v, %s", err, w.Body.String())
+	}
+	// node of pod is not found
+	if sysPods.Total != 1 {
+		t.Errorf("expected mount pod on node-2, got %s", w.Body.String())
+	}
+}
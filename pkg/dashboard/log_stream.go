@@ -0,0 +1,292 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	maxLogSources     = 50
+	logStreamInterval = 30 * time.Second
+)
+
+// LogSource is a container whose logs are streamed
+type LogSource struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Node      string `json:"node,omitempty"`
+}
+
+func (s LogSource) String() string {
+	return fmt.Sprintf("%s/%s/%s", s.Namespace, s.Pod, s.Container)
+}
+
+// LogLine is sent as data of event "log" in log stream, event "error" and "end" carry only source and error
+type LogLine struct {
+	Source string `json:"source"`
+	Node   string `json:"node,omitempty"`
+	Line   string `json:"line,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type logEvent struct {
+	name string
+	line LogLine
+}
+
+// parseLogOptions parses tail (number of lines), since (duration, e.g. 10m) and previous (logs of previous terminated container)
+func parseLogOptions(c *gin.Context) (*corev1.PodLogOptions, error) {
+	opts := &corev1.PodLogOptions{Follow: true}
+	if tail := c.Query("tail"); tail != "" {
+		lines, err := strconv.ParseInt(tail, 10, 64)
+		if err != nil || lines < 0 {
+			return nil, fmt.Errorf("invalid tail %s", tail)
+		}
+		opts.TailLines = &lines
+	}
+	if since := c.Query("since"); since != "" {
+		d, err := time.ParseDuration(since)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid since %s", since)
+		}
+		seconds := int64(d.Seconds())
+		if seconds == 0 {
+			seconds = 1
+		}
+		opts.SinceSeconds = &seconds
+	}
+	if previous := c.Query("previous"); previous != "" {
+		p, err := strconv.ParseBool(previous)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous %s", previous)
+		}
+		// logs of terminated container do not grow
+		opts.Previous, opts.Follow = p, !p
+	}
+	return opts, nil
+}
+
+func hasContainer(pod *corev1.Pod, container string) bool {
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if c.Name == container {
+			return true
+		}
+	}
+	return false
+}
+
+// streamLogs follows logs of sources and sends them to client as server-sent events until all of them end or client leaves
+func (api *API) streamLogs(c *gin.Context, sources []LogSource, opts *corev1.PodLogOptions) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	events := make(chan logEvent)
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source LogSource) {
+			defer wg.Done()
+			api.followLogs(ctx, source, opts, events)
+		}(source)
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	ticker := time.NewTicker(logStreamInterval)
+	defer ticker.Stop()
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			c.SSEvent(e.name, e.line)
+		case <-ticker.C:
+			// keep connection alive through proxies
+			c.SSEvent("ping", "")
+		case <-ctx.Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// followLogs sends logs of one source line by line, and "end" or "error" event when it ends
+func (api *API) followLogs(ctx context.Context, source LogSource, opts *corev1.PodLogOptions, events chan<- logEvent) {
+	send := func(e logEvent) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	o := opts.DeepCopy()
+	o.Container = source.Container
+	stream, err := api.client.CoreV1().Pods(source.Namespace).GetLogs(source.Pod, o).Stream(ctx)
+	if err != nil {
+		send(logEvent{"error", LogLine{Source: source.String(), Node: source.Node, Error: err.Error()}})
+		return
+	}
+	defer stream.Close()
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if !send(logEvent{"log", LogLine{Source: source.String(), Node: source.Node, Line: strings.TrimRight(line, "\n")}}) {
+				return
+			}
+		}
+		if err == io.EOF {
+			send(logEvent{"end", LogLine{Source: source.String(), Node: source.Node}})
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				send(logEvent{"error", LogLine{Source: source.String(), Node: source.Node, Error: err.Error()}})
+			}
+			return
+		}
+	}
+}
+
+// streamPodLogs follows logs of a container of the pod
+func (api *API) streamPodLogs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		obj, ok := c.Get("pod")
+		if !ok {
+			c.String(404, "not found")
+			return
+		}
+		pod := obj.(*corev1.Pod)
+		container := c.Param("container")
+		if !hasContainer(pod, container) {
+			c.String(404, "container %s not found", container)
+			return
+		}
+		opts, err := parseLogOptions(c)
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+		api.streamLogs(c, []LogSource{{Namespace: pod.Namespace, Pod: pod.Name, Container: container, Node: pod.Spec.NodeName}}, opts)
+	}
+}
+
+// streamMountPodLogsOfPV follows logs of all mount pods of the PV on every node
+func (api *API) streamMountPodLogsOfPV() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		obj, ok := c.Get("pv")
+		if !ok {
+			c.String(404, "not found")
+			return
+		}
+		pv := obj.(*corev1.PersistentVolume)
+		opts, err := parseLogOptions(c)
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+		pods, err := api.listMountPodsOfPV(c, pv)
+		if err != nil {
+			c.String(500, "list pods error %v", err)
+			return
+		}
+		sources := make([]LogSource, 0, len(pods))
+		for _, pod := range pods {
+			if len(pod.Spec.Containers) == 0 {
+				continue
+			}
+			sources = append(sources, LogSource{Namespace: pod.Namespace, Pod: pod.Name, Container: pod.Spec.Containers[0].Name, Node: pod.Spec.NodeName})
+		}
+		if len(sources) == 0 {
+			c.String(404, "no mount pod of pv %s", pv.Name)
+			return
+		}
+		if len(sources) > maxLogSources {
+			sources = sources[:maxLogSources]
+		}
+		api.streamLogs(c, sources, opts)
+	}
+}
+
+// streamLogsOfSources follows logs of containers in query "source" (<namespace>/<pod>[/<container>], repeatable),
+// container defaults to the first container of pod
+func (api *API) streamLogsOfSources() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts, err := parseLogOptions(c)
+		if err != nil {
+			c.String(400, err.Error())
+			return
+		}
+		values := c.QueryArray("source")
+		if len(values) == 0 || len(values) > maxLogSources {
+			c.String(400, "1 to %d sources are required", maxLogSources)
+			return
+		}
+		sources := make([]LogSource, 0, len(values))
+		for _, value := range values {
+			parts := strings.Split(value, "/")
+			if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+				c.String(400, "invalid source %s", value)
+				return
+			}
+			var pod corev1.Pod
+			if err := api.cachedReader.Get(c, types.NamespacedName{Namespace: parts[0], Name: parts[1]}, &pod); err != nil {
+				if k8serrors.IsNotFound(err) {
+					c.String(404, "pod %s/%s not found", parts[0], parts[1])
+				} else {
+					c.String(500, "get pod error %v", err)
+				}
+				return
+			}
+			if !api.canAccessPod(c, &pod) {
+				c.String(403, "forbidden")
+				return
+			}
+			source := LogSource{Namespace: pod.Namespace, Pod: pod.Name, Node: pod.Spec.NodeName}
+			if len(parts) == 3 {
+				source.Container = parts[2]
+			} else if len(pod.Spec.Containers) != 0 {
+				source.Container = pod.Spec.Containers[0].Name
+			}
+			if !hasContainer(&pod, source.Container) {
+				c.String(404, "container %s of pod %s/%s not found", source.Container, pod.Namespace, pod.Name)
+				return
+			}
+			sources = append(sources, source)
+		}
+		api.streamLogs(c, sources, opts)
+	}
+}
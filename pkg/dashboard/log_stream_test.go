@@ -0,0 +1,129 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+func TestStreamLogsOfSources(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newPod := func(name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+			Spec: corev1.PodSpec{
+				NodeName:   node,
+				Containers: []corev1.Container{{Name: "jfs-mount"}},
+			},
+		}
+	}
+	pod1, pod2 := newPod("mount-1", "node-1"), newPod("mount-2", "node-2")
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithObjects(pod1, pod2).Build(), fake.NewSimpleClientset(pod1, pod2))
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		want     []string
+	}{
+		{
+			name:     "multiplexed",
+			query:    "source=kube-system/mount-1&source=kube-system/mount-2/jfs-mount&tail=10",
+			wantCode: 200,
+			want: []string{
+				`event:log`,
+				`"source":"kube-system/mount-1/jfs-mount","node":"node-1","line":"fake logs"`,
+				`"source":"kube-system/mount-2/jfs-mount","node":"node-2","line":"fake logs"`,
+				`event:end`,
+			},
+		},
+		{name: "no source", query: "", wantCode: 400},
+		{name: "invalid since", query: "source=kube-system/mount-1&since=abc", wantCode: 400},
+		{name: "unknown container", query: "source=kube-system/mount-1/foo", wantCode: 404},
+		{name: "unknown pod", query: "source=kube-system/mount-3", wantCode: 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/logs/stream?"+tt.query, nil)
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected code %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("expected %s in stream, got %s", want, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestStreamMountPodLogsOfPV(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// mount pods are shared by storage class
+	csiNode := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-csi-node-abc", Namespace: "kube-system"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: csiPluginContainerName,
+			Env:  []corev1.EnvVar{{Name: "STORAGE_CLASS_SHARE_MOUNT", Value: "true"}},
+		}}},
+	}
+	pv := &corev1.PersistentVolume{
+		// fake client does not ignore namespace of cluster scoped objects as cache does
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: "kube-system"},
+		Spec: corev1.PersistentVolumeSpec{
+			StorageClassName:       "juicefs-sc",
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: config.DriverName, VolumeHandle: "pvc-1"}},
+		},
+	}
+	mountPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-node-1-juicefs-sc-abcdef", Namespace: "kube-system", Labels: map[string]string{
+			config.PodTypeKey: config.PodTypeValue, config.PodUniqueIdLabelKey: "juicefs-sc",
+		}},
+		Spec: corev1.PodSpec{NodeName: "node-1", Containers: []corev1.Container{{Name: "jfs-mount"}}},
+	}
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithObjects(csiNode, pv, mountPod).Build(), fake.NewSimpleClientset(mountPod))
+	api.csiNodeIndex["node-1"] = types.NamespacedName{Namespace: csiNode.Namespace, Name: csiNode.Name}
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/pv/pvc-1/mountpods/logs/stream?tail=10", nil)
+	router.ServeHTTP(w, req)
+	if w.Code != 200 {
+		t.Fatalf("expected code 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if want := `"source":"kube-system/juicefs-node-1-juicefs-sc-abcdef/jfs-mount"`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("expected %s in stream, got %s", want, w.Body.String())
+	}
+}
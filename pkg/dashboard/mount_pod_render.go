@@ -0,0 +1,246 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount/builder"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+// mountPodRenderer renders mount pods of PVs as CSI node does with a config,
+// defaults (images, mount path, scheduling of mount pods) are taken from the CSI node pod
+type mountPodRenderer struct {
+	api     *API
+	cfg     *config.Config
+	csiNode *corev1.Pod
+	// secrets of volumes read in this rendering
+	secrets map[types.NamespacedName]map[string]string
+}
+
+func (api *API) newMountPodRenderer(ctx context.Context, cfg *config.Config) *mountPodRenderer {
+	r := &mountPodRenderer{api: api, cfg: cfg, secrets: make(map[types.NamespacedName]map[string]string)}
+	api.csiNodeLock.RLock()
+	var name types.NamespacedName
+	for _, n := range api.csiNodeIndex {
+		name = n
+		break
+	}
+	api.csiNodeLock.RUnlock()
+	if name.Name != "" {
+		var pod corev1.Pod
+		if err := api.cachedReader.Get(ctx, name, &pod); err == nil {
+			r.csiNode = &pod
+		}
+	}
+	return r
+}
+
+// csiEnv returns value of env in plugin container of CSI node
+func (r *mountPodRenderer) csiEnv(name string) string {
+	if r.csiNode == nil {
+		return ""
+	}
+	for _, c := range r.csiNode.Spec.Containers {
+		if c.Name != csiPluginContainerName {
+			continue
+		}
+		for _, env := range c.Env {
+			if env.Name == name {
+				return env.Value
+			}
+		}
+	}
+	return ""
+}
+
+// defaultAttr is the attr of mount pod before volume context and mount pod patches are applied
+func (r *mountPodRenderer) defaultAttr(isCe bool) *config.PodAttr {
+	attr := &config.PodAttr{
+		Namespace:            r.api.sysNamespace,
+		MountPointPath:       config.MountPointPath,
+		JFSConfigPath:        config.JFSConfigPath,
+		JFSMountPriorityName: config.JFSMountPriorityName,
+		Resources:            r.defaultResources(),
+		Labels:               make(map[string]string),
+		Annotations:          make(map[string]string),
+	}
+	if r.csiNode != nil {
+		spec := r.csiNode.Spec
+		attr.HostNetwork = spec.HostNetwork
+		attr.HostAliases = spec.HostAliases
+		attr.HostPID = spec.HostPID
+		attr.HostIPC = spec.HostIPC
+		attr.DNSConfig = spec.DNSConfig
+		attr.DNSPolicy = spec.DNSPolicy
+		attr.ImagePullSecrets = spec.ImagePullSecrets
+		attr.Tolerations = spec.Tolerations
+		attr.PreemptionPolicy = spec.PreemptionPolicy
+		attr.ServiceAccountName = spec.ServiceAccountName
+	}
+	if v := r.csiEnv("JUICEFS_MOUNT_PATH"); v != "" {
+		attr.MountPointPath = v
+	}
+	if v := r.csiEnv("JUICEFS_CONFIG_PATH"); v != "" {
+		attr.JFSConfigPath = v
+	}
+	if v := r.csiEnv("JUICEFS_MOUNT_PRIORITY_NAME"); v != "" {
+		attr.JFSMountPriorityName = v
+	}
+	if v := r.csiEnv("JUICEFS_MOUNT_PREEMPTION_POLICY"); v != "" {
+		policy := corev1.PreemptionPolicy(v)
+		attr.PreemptionPolicy = &policy
+	}
+	ceImage, eeImage := config.DefaultCEMountImage, config.DefaultEEMountImage
+	if v := r.csiEnv("JUICEFS_CE_MOUNT_IMAGE"); v != "" {
+		ceImage = v
+	}
+	if v := r.csiEnv("JUICEFS_EE_MOUNT_IMAGE"); v != "" {
+		eeImage = v
+	}
+	if v := r.csiEnv("JUICEFS_MOUNT_IMAGE"); v != "" {
+		hasCE, hasEE := util.ImageResol(v)
+		if hasCE {
+			ceImage = v
+		}
+		if hasEE {
+			eeImage = v
+		}
+	}
+	if isCe {
+		attr.Image = ceImage
+	} else {
+		attr.Image = eeImage
+	}
+	return attr
+}
+
+func (r *mountPodRenderer) defaultResources() corev1.ResourceRequirements {
+	resources, _ := config.ParsePodResources("", "", "", "")
+	return resources
+}
+
+// secretOf returns secret of the PV, which is got once in a rendering.
+// It's not read from cache, which requires permissions of listing secrets in all namespaces.
+func (r *mountPodRenderer) secretOf(ctx context.Context, pv *corev1.PersistentVolume) (map[string]string, error) {
+	ref := pv.Spec.CSI.NodePublishSecretRef
+	if ref == nil {
+		return nil, fmt.Errorf("pv %s has no nodePublishSecretRef", pv.Name)
+	}
+	key := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+	if secrets, ok := r.secrets[key]; ok {
+		return secrets, nil
+	}
+	secret, err := r.api.client.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s error: %v", key, err)
+	}
+	secrets := make(map[string]string)
+	for k, v := range secret.StringData {
+		secrets[k] = v
+	}
+	for k, v := range secret.Data {
+		secrets[k] = string(v)
+	}
+	r.secrets[key] = secrets
+	return secrets, nil
+}
+
+// setting parses setting of the PV as CSI node does when mounting it, with mount pod patches of the config
+func (r *mountPodRenderer) setting(ctx context.Context, pv *corev1.PersistentVolume, pvc *corev1.PersistentVolumeClaim) (*config.JfsSetting, error) {
+	secrets, err := r.secretOf(ctx, pv)
+	if err != nil {
+		return nil, err
+	}
+	volCtx := make(map[string]string)
+	for k, v := range pv.Spec.CSI.VolumeAttributes {
+		volCtx[k] = v
+	}
+	if pvc != nil {
+		for k, v := range pvc.Annotations {
+			if strings.HasPrefix(k, "juicefs") {
+				volCtx[k] = v
+			}
+		}
+	}
+	setting, err := config.ParseSetting(secrets, volCtx, mountOptions(pv, volCtx), true, pv, pvc)
+	if err != nil {
+		return nil, err
+	}
+	setting.VolumeId = pv.Spec.CSI.VolumeHandle
+	setting.UniqueId = r.uniqueId(pv)
+	setting.MountPath = filepath.Join(config.PodMountBase, setting.UniqueId)
+	setting.Attr = r.defaultAttr(setting.IsCe)
+	if err := r.cfg.GenPodAttr(setting, volCtx); err != nil {
+		return nil, err
+	}
+	// auth may rewrite keys in secrets
+	formatSecrets := make(map[string]string, len(secrets))
+	for k, v := range secrets {
+		formatSecrets[k] = v
+	}
+	if err := juicefs.GenFormatCmd(ctx, formatSecrets, setting); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+// uniqueId returns uniqueId of mount pods of the PV, which is the StorageClass name if CSI node shares mount pods by StorageClass
+func (r *mountPodRenderer) uniqueId(pv *corev1.PersistentVolume) string {
+	if r.csiEnv("STORAGE_CLASS_SHARE_MOUNT") == "true" && pv.Spec.StorageClassName != "" {
+		return pv.Spec.StorageClassName
+	}
+	return pv.Spec.CSI.VolumeHandle
+}
+
+// mountOptions returns mount options of the PV as kubelet passes them to CSI node
+func mountOptions(pv *corev1.PersistentVolume, volCtx map[string]string) []string {
+	var options []string
+	if opts, ok := volCtx["mountOptions"]; ok {
+		options = strings.Split(opts, ",")
+	}
+	options = append(options, pv.Spec.MountOptions...)
+	result := make([]string, 0, len(options))
+	for _, option := range options {
+		option = strings.TrimSpace(option)
+		if kv := strings.Split(option, "="); len(kv) == 2 {
+			option = fmt.Sprintf("%s=%s", strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+		result = append(result, option)
+	}
+	return result
+}
+
+// render renders mount pod of the setting with pod name
+func (r *mountPodRenderer) render(setting *config.JfsSetting, podName string) *corev1.Pod {
+	s := *setting
+	s.SecretName = podName + "-secret"
+	if len(podName) > 7 {
+		s.MountPath = s.MountPath + podName[len(podName)-7:]
+	}
+	return builder.NewPodBuilder(&s, 0).NewMountPod(podName)
+}
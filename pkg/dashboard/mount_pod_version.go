@@ -0,0 +1,394 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+const mountPodVersionInterval = time.Minute
+
+const (
+	driftImage     = "image"
+	driftResources = "resources"
+	driftHash      = "hash"
+)
+
+// MountPodVersion is the client version of a mount pod, and whether it differs from the mount pod CSI node would create now
+type MountPodVersion struct {
+	Namespace         string      `json:"namespace"`
+	Name              string      `json:"name"`
+	Node              string      `json:"node"`
+	UniqueId          string      `json:"uniqueId"`
+	PV                string      `json:"pv,omitempty"`
+	Image             string      `json:"image"`
+	Version           string      `json:"version"`
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+	References        int         `json:"references"`
+	Outdated          bool        `json:"outdated"`
+	// what differs from the expected mount pod: image, resources or hash of setting
+	Drifts        []string `json:"drifts,omitempty"`
+	ExpectedImage string   `json:"expectedImage,omitempty"`
+	// expected mount pod can not be rendered, so outdated is unknown
+	Error string `json:"error,omitempty"`
+}
+
+// ImageSummary counts mount pods running an image
+type ImageSummary struct {
+	Image      string `json:"image"`
+	Version    string `json:"version"`
+	Total      int    `json:"total"`
+	Outdated   int    `json:"outdated"`
+	References int    `json:"references"`
+}
+
+type ListMountPodVersionResult struct {
+	Total    int               `json:"total"`
+	Outdated int               `json:"outdated"`
+	Images   []ImageSummary    `json:"images"`
+	Pods     []MountPodVersion `json:"pods"`
+	// when versions are checked, they are updated every minute
+	UpdateTime metav1.Time `json:"updateTime"`
+}
+
+type mountPodVersionSnapshot struct {
+	versions   []MountPodVersion
+	updateTime time.Time
+}
+
+// clientVersion returns version of juicefs client in the mount image, which is the tag without edition prefix
+func clientVersion(image string) string {
+	name := image[strings.LastIndex(image, "/")+1:]
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return "latest"
+	}
+	tag := name[i+1:]
+	return strings.TrimPrefix(strings.TrimPrefix(tag, "ce-"), "ee-")
+}
+
+// currentConfig returns config of CSI driver in ConfigMap, or empty config if ConfigMap does not exist
+func (api *API) currentConfig(ctx context.Context) (*config.Config, error) {
+	cfg := &config.Config{}
+	cm, err := api.client.CoreV1().ConfigMaps(api.sysNamespace).Get(ctx, csiConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err := cfg.Unmarshal([]byte(cm.Data[csiConfigKey])); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// mountPodVersions lists versions of all mount pods, expected mount pods are rendered with current config of CSI driver
+func (api *API) mountPodVersions(ctx context.Context) ([]MountPodVersion, error) {
+	var pods corev1.PodList
+	if err := api.cachedReader.List(ctx, &pods, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{config.PodTypeKey: config.PodTypeValue}),
+	}); err != nil {
+		return nil, err
+	}
+	var pvs corev1.PersistentVolumeList
+	if err := api.cachedReader.List(ctx, &pvs); err != nil {
+		return nil, err
+	}
+	cfg, err := api.currentConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	renderer := api.newMountPodRenderer(ctx, cfg)
+	versions := make([]MountPodVersion, 0, len(pods.Items))
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		v := MountPodVersion{
+			Namespace:         pod.Namespace,
+			Name:              pod.Name,
+			Node:              pod.Spec.NodeName,
+			UniqueId:          pod.Labels[config.PodUniqueIdLabelKey],
+			CreationTimestamp: pod.CreationTimestamp,
+			References:        referenceCount(pod),
+		}
+		if c := mountContainer(pod); c != nil {
+			v.Image = c.Image
+			v.Version = clientVersion(c.Image)
+		}
+		api.checkDrift(ctx, renderer, pod, pvs.Items, &v)
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Namespace != versions[j].Namespace {
+			return versions[i].Namespace < versions[j].Namespace
+		}
+		return versions[i].Name < versions[j].Name
+	})
+	return versions, nil
+}
+
+func mountContainer(pod *corev1.Pod) *corev1.Container {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == config.MountContainerName {
+			return &pod.Spec.Containers[i]
+		}
+	}
+	if len(pod.Spec.Containers) > 0 {
+		return &pod.Spec.Containers[0]
+	}
+	return nil
+}
+
+// pvOfMountPod returns a PV of the mount pod, the ones referencing it are preferred if mount pods are shared by StorageClass
+func pvOfMountPod(pod *corev1.Pod, pvs []corev1.PersistentVolume) *corev1.PersistentVolume {
+	uniqueId := pod.Labels[config.PodUniqueIdLabelKey]
+	var found *corev1.PersistentVolume
+	for i := range pvs {
+		pv := &pvs[i]
+		if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName {
+			continue
+		}
+		if pv.Spec.CSI.VolumeHandle != uniqueId && pv.Spec.StorageClassName != uniqueId {
+			continue
+		}
+		for k, target := range pod.Annotations {
+			if k == util.GetReferenceKey(target) && strings.Contains(target, "/"+pv.Name+"/") {
+				return pv
+			}
+		}
+		if found == nil {
+			found = pv
+		}
+	}
+	return found
+}
+
+// checkDrift renders the mount pod CSI node would create for the PV of pod now, and compares image, resources and hash of setting with pod
+func (api *API) checkDrift(ctx context.Context, renderer *mountPodRenderer, pod *corev1.Pod, pvs []corev1.PersistentVolume, v *MountPodVersion) {
+	pv := pvOfMountPod(pod, pvs)
+	if pv == nil {
+		v.Error = "pv of mount pod not found"
+		return
+	}
+	v.PV = pv.Name
+	var pvc *corev1.PersistentVolumeClaim
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		var claim corev1.PersistentVolumeClaim
+		if err := api.cachedReader.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &claim); err == nil {
+			pvc = &claim
+		}
+	}
+	setting, err := renderer.setting(ctx, pv, pvc)
+	if err != nil {
+		v.Error = err.Error()
+		return
+	}
+	if uuid := pod.Annotations[config.JuiceFSUUID]; setting.CleanCache && setting.UUID == "" && uuid != "" {
+		// uuid of community edition is got from metadata engine for cleaning cache,
+		// it's only in the setting hashed by CSI node if clean cache is enabled
+		setting.UUID = uuid
+	}
+	expected := mountContainer(renderer.render(setting, pod.Name))
+	current := mountContainer(pod)
+	if expected == nil || current == nil {
+		v.Error = "mount container not found"
+		return
+	}
+	v.ExpectedImage = expected.Image
+	if current.Image != expected.Image {
+		v.Drifts = append(v.Drifts, driftImage)
+	}
+	if !equality.Semantic.DeepEqual(current.Resources, expected.Resources) {
+		v.Drifts = append(v.Drifts, driftResources)
+	}
+	if hash := pod.Labels[config.PodJuiceHashLabelKey]; hash != "" && !(setting.IsCe && setting.CleanCache && setting.UUID == "") {
+		expectedHash, err := mount.GenHashOfSetting(*setting)
+		if err != nil {
+			v.Error = err.Error()
+		} else if hash != expectedHash {
+			v.Drifts = append(v.Drifts, driftHash)
+		}
+	}
+	v.Outdated = len(v.Drifts) > 0
+}
+
+func summarizeImages(versions []MountPodVersion) []ImageSummary {
+	summaries := make(map[string]*ImageSummary)
+	for _, v := range versions {
+		s, ok := summaries[v.Image]
+		if !ok {
+			s = &ImageSummary{Image: v.Image, Version: v.Version}
+			summaries[v.Image] = s
+		}
+		s.Total++
+		s.References += v.References
+		if v.Outdated {
+			s.Outdated++
+		}
+	}
+	result := make([]ImageSummary, 0, len(summaries))
+	for _, s := range summaries {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Image < result[j].Image
+	})
+	return result
+}
+
+// updateMountPodVersions checks versions of all mount pods and saves them for listMountPodVersions
+func (api *API) updateMountPodVersions(ctx context.Context) (*mountPodVersionSnapshot, error) {
+	versions, err := api.mountPodVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &mountPodVersionSnapshot{versions: versions, updateTime: time.Now()}
+	api.versionLock.Lock()
+	api.versions = snapshot
+	api.versionLock.Unlock()
+	return snapshot, nil
+}
+
+// cachedMountPodVersions returns versions updated by RunMountPodVersionMetrics,
+// or checks them if they are not updated in time (e.g. metrics are not running)
+func (api *API) cachedMountPodVersions(ctx context.Context) (*mountPodVersionSnapshot, error) {
+	api.versionLock.RLock()
+	snapshot := api.versions
+	api.versionLock.RUnlock()
+	if snapshot != nil && time.Since(snapshot.updateTime) < 2*mountPodVersionInterval {
+		return snapshot, nil
+	}
+	return api.updateMountPodVersions(ctx)
+}
+
+// listMountPodVersions lists versions of mount pods the user can access, filtered by query node, image and outdated=true
+func (api *API) listMountPodVersions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snapshot, err := api.cachedMountPodVersions(c)
+		if err != nil {
+			c.String(500, "list mount pod versions error %v", err)
+			return
+		}
+		node, image, outdatedOnly := c.Query("node"), c.Query("image"), c.Query("outdated") == "true"
+		result := &ListMountPodVersionResult{Pods: []MountPodVersion{}, UpdateTime: metav1.NewTime(snapshot.updateTime)}
+		for _, v := range snapshot.versions {
+			if (node != "" && v.Node != node) || (image != "" && v.Image != image) || (outdatedOnly && !v.Outdated) {
+				continue
+			}
+			if api.authorizer != nil {
+				var pod corev1.Pod
+				if err := api.cachedReader.Get(c, types.NamespacedName{Namespace: v.Namespace, Name: v.Name}, &pod); err != nil || !api.canAccessPod(c, &pod) {
+					continue
+				}
+			}
+			result.Pods = append(result.Pods, v)
+			if v.Outdated {
+				result.Outdated++
+			}
+		}
+		result.Total = len(result.Pods)
+		result.Images = summarizeImages(result.Pods)
+		c.IndentedJSON(200, result)
+	}
+}
+
+// mountPodVersionMetrics exports versions of mount pods as prometheus metrics
+type mountPodVersionMetrics struct {
+	info       *prometheus.GaugeVec
+	references *prometheus.GaugeVec
+	age        *prometheus.GaugeVec
+	outdated   *prometheus.GaugeVec
+}
+
+func newMountPodVersionMetrics(reg prometheus.Registerer) *mountPodVersionMetrics {
+	m := &mountPodVersionMetrics{
+		info: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mount_pod_info",
+			Help: "mount pod with its image and client version, whose value is always 1",
+		}, []string{"namespace", "pod", "node", "pv", "image", "version", "outdated"}),
+		references: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mount_pod_references",
+			Help: "number of targets referencing mount pod",
+		}, []string{"namespace", "pod", "node"}),
+		age: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mount_pod_age_seconds",
+			Help: "seconds since mount pod is created",
+		}, []string{"namespace", "pod", "node"}),
+		outdated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mount_pod_outdated",
+			Help: "whether mount pod differs from the one CSI node would create now in image, resources or hash of setting",
+		}, []string{"namespace", "pod", "node", "drifts"}),
+	}
+	reg.MustRegister(m.info, m.references, m.age, m.outdated)
+	return m
+}
+
+func (m *mountPodVersionMetrics) update(versions []MountPodVersion, now time.Time) {
+	m.info.Reset()
+	m.references.Reset()
+	m.age.Reset()
+	m.outdated.Reset()
+	for _, v := range versions {
+		m.info.WithLabelValues(v.Namespace, v.Name, v.Node, v.PV, v.Image, v.Version, strconv.FormatBool(v.Outdated)).Set(1)
+		m.references.WithLabelValues(v.Namespace, v.Name, v.Node).Set(float64(v.References))
+		m.age.WithLabelValues(v.Namespace, v.Name, v.Node).Set(now.Sub(v.CreationTimestamp.Time).Seconds())
+		outdated := 0.0
+		if v.Outdated {
+			outdated = 1
+		}
+		m.outdated.WithLabelValues(v.Namespace, v.Name, v.Node, strings.Join(v.Drifts, ",")).Set(outdated)
+	}
+}
+
+// RunMountPodVersionMetrics registers metrics of mount pod versions, and updates them periodically until ctx is done
+func (api *API) RunMountPodVersionMetrics(ctx context.Context, reg prometheus.Registerer) {
+	m := newMountPodVersionMetrics(reg)
+	for {
+		if snapshot, err := api.updateMountPodVersions(ctx); err != nil {
+			klog.Errorf("list mount pod versions error: %v", err)
+		} else {
+			m.update(snapshot.versions, snapshot.updateTime)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(mountPodVersionInterval):
+		}
+	}
+}
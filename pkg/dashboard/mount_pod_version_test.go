@@ -0,0 +1,212 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount/builder"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+func TestClientVersion(t *testing.T) {
+	for image, want := range map[string]string{
+		"juicedata/mount:ce-v1.1.0":                     "v1.1.0",
+		"juicedata/mount:ee-5.0.2-69f82b3":              "5.0.2-69f82b3",
+		"registry:5000/juicedata/mount:v1.0.4-4.9.16":   "v1.0.4-4.9.16",
+		"registry:5000/juicedata/mount":                 "latest",
+		"juicedata/mount:ce-v1.1.0@sha256:0123456789ab": "v1.1.0",
+	} {
+		if got := clientVersion(image); got != want {
+			t.Errorf("clientVersion(%s) = %s, want %s", image, got, want)
+		}
+	}
+}
+
+func TestMountPodVersions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-secret", Namespace: "default"},
+		Data: map[string][]byte{
+			"name":    []byte("vol"),
+			"metaurl": []byte("redis://127.0.0.1/1"),
+		},
+	}
+	var objs []runtime.Object
+	newPV := func(name string) *corev1.PersistentVolume {
+		pv := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: corev1.PersistentVolumeSpec{
+				ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: name},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:               config.DriverName,
+					VolumeHandle:         name,
+					NodePublishSecretRef: &corev1.SecretReference{Namespace: "default", Name: "juicefs-secret"},
+				}},
+			},
+		}
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: name},
+		}
+		objs = append(objs, pv, pvc)
+		return pv
+	}
+	pvs := []*corev1.PersistentVolume{newPV("pv-current"), newPV("pv-image"), newPV("pv-hash"), newPV("pv-resources")}
+	csiNode := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-csi-node-abc", Namespace: "kube-system"},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Containers: []corev1.Container{{
+				Name: csiPluginContainerName,
+				Env:  []corev1.EnvVar{{Name: "JUICEFS_CE_MOUNT_IMAGE", Value: "juicedata/mount:ce-v1.2.0"}},
+			}},
+		},
+	}
+	objs = append(objs, secret, csiNode)
+
+	// create mount pods as CSI node does, then make them drift
+	client := fake.NewSimpleClientset(objs...)
+	defer func(namespace, image string, csiPod corev1.Pod) {
+		config.Namespace, config.DefaultCEMountImage, config.CSIPod = namespace, image, csiPod
+	}(config.Namespace, config.DefaultCEMountImage, config.CSIPod)
+	config.Namespace, config.DefaultCEMountImage, config.CSIPod = "kube-system", "juicedata/mount:ce-v1.2.0", *csiNode
+	provider := juicefs.NewJfsProvider(nil, &k8sclient.K8sClient{Interface: client})
+	secrets := make(map[string]string)
+	for k, v := range secret.Data {
+		secrets[k] = string(v)
+	}
+	for _, pv := range pvs {
+		setting, err := provider.Settings(context.TODO(), pv.Spec.CSI.VolumeHandle, secrets, pv.Spec.CSI.VolumeAttributes, pv.Spec.MountOptions)
+		if err != nil {
+			t.Fatalf("settings of %s error: %v", pv.Name, err)
+		}
+		// uniqueId and mount path are set by CSI node before hashing the setting
+		setting.UniqueId = pv.Spec.CSI.VolumeHandle
+		setting.MountPath = filepath.Join(config.PodMountBase, setting.UniqueId)
+		hash, err := mount.GenHashOfSetting(*setting)
+		if err != nil {
+			t.Fatalf("hash setting of %s error: %v", pv.Name, err)
+		}
+		name := "juicefs-node-1-" + pv.Name + "-abcdef"
+		setting.MountPath += name[len(name)-7:]
+		setting.SecretName = name + "-secret"
+		pod := builder.NewPodBuilder(setting, 0).NewMountPod(name)
+		pod.Spec.NodeName = "node-1"
+		pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+		pod.Labels[config.PodJuiceHashLabelKey] = hash
+		target := "/var/lib/kubelet/pods/uid-app/volumes/kubernetes.io~csi/" + pv.Name + "/mount"
+		pod.Annotations[util.GetReferenceKey(target)] = target
+		switch pv.Name {
+		case "pv-image":
+			pod.Spec.Containers[0].Image = "juicedata/mount:ce-v1.1.0"
+		case "pv-hash":
+			pod.Labels[config.PodJuiceHashLabelKey] = "stale"
+		case "pv-resources":
+			pod.Spec.Containers[0].Resources.Limits[corev1.ResourceMemory] = resource.MustParse("1Gi")
+		}
+		objs = append(objs, pod)
+	}
+	reader := crfake.NewClientBuilder().WithRuntimeObjects(objs...).Build()
+	api := NewAPI(context.TODO(), "kube-system", reader, client)
+	api.csiNodeIndex["node-1"] = types.NamespacedName{Namespace: csiNode.Namespace, Name: csiNode.Name}
+
+	versions, err := api.mountPodVersions(context.TODO())
+	if err != nil {
+		t.Fatalf("list mount pod versions error: %v", err)
+	}
+	want := map[string][]string{
+		"juicefs-node-1-pv-current-abcdef":   nil,
+		"juicefs-node-1-pv-image-abcdef":     {driftImage},
+		"juicefs-node-1-pv-hash-abcdef":      {driftHash},
+		"juicefs-node-1-pv-resources-abcdef": {driftResources},
+	}
+	if len(versions) != len(want) {
+		t.Fatalf("expected %d mount pods, got %+v", len(want), versions)
+	}
+	for _, v := range versions {
+		if v.Error != "" {
+			t.Errorf("version of %s error: %s", v.Name, v.Error)
+		}
+		if !reflect.DeepEqual(v.Drifts, want[v.Name]) || v.Outdated != (want[v.Name] != nil) {
+			t.Errorf("unexpected drifts of %s: %v", v.Name, v.Drifts)
+		}
+		if v.References != 1 || v.Node != "node-1" || v.ExpectedImage != "juicedata/mount:ce-v1.2.0" {
+			t.Errorf("unexpected version of %s: %+v", v.Name, v)
+		}
+	}
+
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/mountpods/versions?outdated=true", nil)
+	router.ServeHTTP(w, req)
+	var result ListMountPodVersionResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unmarshal result error: %v, %s", err, w.Body.String())
+	}
+	if result.Total != 3 || result.Outdated != 3 || len(result.Images) != 2 || result.UpdateTime.IsZero() {
+		t.Errorf("unexpected result: %s", w.Body.String())
+	}
+
+	// versions are served from the last check until it's stale
+	outdated := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "juicefs-node-1-pv-image-abcdef"}}
+	if err := reader.Delete(context.TODO(), outdated); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var cached ListMountPodVersionResult
+	if err := json.Unmarshal(w.Body.Bytes(), &cached); err != nil {
+		t.Fatalf("unmarshal result error: %v, %s", err, w.Body.String())
+	}
+	if cached.Total != 3 || !cached.UpdateTime.Equal(&result.UpdateTime) {
+		t.Errorf("expected versions of the last check, got %s", w.Body.String())
+	}
+
+	reg := prometheus.NewRegistry()
+	m := newMountPodVersionMetrics(reg)
+	m.update(versions, time.Now())
+	if v := testutil.ToFloat64(m.outdated.WithLabelValues("kube-system", "juicefs-node-1-pv-image-abcdef", "node-1", driftImage)); v != 1 {
+		t.Errorf("expected outdated metric 1, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.age.WithLabelValues("kube-system", "juicefs-node-1-pv-current-abcdef", "node-1")); v < 3600 {
+		t.Errorf("expected age at least 3600, got %v", v)
+	}
+}
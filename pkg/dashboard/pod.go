@@ -18,7 +18,6 @@ package dashboard
 
 import (
 	"context"
-	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -45,81 +44,139 @@ type PodExtra struct {
 }
 
 type ListAppPodResult struct {
-	Total int         `json:"total"`
-	Pods  []*PodExtra `json:"pods"`
+	Total    int         `json:"total"`
+	Pods     []*PodExtra `json:"pods"`
+	Continue string      `json:"continue,omitempty"`
 }
 
 type ListSysPodResult struct {
-	Total int         `json:"total"`
-	Pods  []*PodExtra `json:"pods"`
+	Total    int         `json:"total"`
+	Pods     []*PodExtra `json:"pods"`
+	Continue string      `json:"continue,omitempty"`
 }
 
-func (api *API) listAppPod() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		pageSize, err := strconv.ParseUint(c.Query("pageSize"), 10, 64)
-		if err != nil || pageSize == 0 {
-			c.String(400, "invalid page size")
-			return
-		}
-		current, err := strconv.ParseUint(c.Query("current"), 10, 64)
-		if err != nil || current == 0 {
-			c.String(400, "invalid current page")
-			return
-		}
-		descend := c.Query("order") != "ascend"
-		nameFilter := c.Query("name")
-		namespaceFilter := c.Query("namespace")
-		pvFilter := c.Query("pv")
-		mountpodFilter := c.Query("mountpod")
-		csiNodeFilter := c.Query("csinode")
+// fields of pods supported in field selector
+var podSelectableFields = []string{"metadata.name", "metadata.namespace", "spec.nodeName", "status.phase"}
+
+func podFields(pod *corev1.Pod) fields.Set {
+	return fields.Set{
+		"metadata.name":      pod.Name,
+		"metadata.namespace": pod.Namespace,
+		"spec.nodeName":      pod.Spec.NodeName,
+		"status.phase":       string(pod.Status.Phase),
+	}
+}
 
-		pods := make([]*PodExtra, 0, api.appIndexes.length())
-		for name := range api.appIndexes.iterate(c, descend) {
+func (api *API) podQuery(ctx context.Context, indexes *timeOrderedIndexes[corev1.Pod]) *listQuery[corev1.Pod] {
+	return &listQuery[corev1.Pod]{
+		indexes: indexes,
+		get: func(name types.NamespacedName) (*corev1.Pod, error) {
 			var pod corev1.Pod
-			if err := api.cachedReader.Get(c, name, &pod); err == nil &&
-				(nameFilter == "" || strings.Contains(pod.Name, nameFilter)) &&
-				(namespaceFilter == "" || strings.Contains(pod.Namespace, namespaceFilter)) {
-				pods = append(pods, &PodExtra{Pod: &pod})
+			err := api.cachedReader.Get(ctx, name, &pod)
+			return &pod, err
+		},
+		meta:   func(p *corev1.Pod) metav1.ObjectMeta { return p.ObjectMeta },
+		fields: podFields,
+		status: func(p *corev1.Pod) string { return string(p.Status.Phase) },
+	}
+}
+
+// appPodNames returns names of app pods matching filters of node, csinode, pv, sc and mountpod in query by field indexes,
+// nil if none of them is set
+func (api *API) appPodNames(c *gin.Context, opts *listOptions) map[types.NamespacedName]bool {
+	var names map[types.NamespacedName]bool
+	if filter := c.Query("node"); filter != "" {
+		names = intersect(names, api.appFields.lookup(indexNode, containsFilter(filter)))
+	}
+	for _, node := range selectorValues(opts.fieldSelector, "spec.nodeName") {
+		names = intersect(names, api.appFields.lookup(indexNode, inSet(map[string]bool{node: true})))
+	}
+	if filter := c.Query("csinode"); filter != "" {
+		nodes := make(map[string]bool)
+		api.csiNodeLock.RLock()
+		for node, csiNode := range api.csiNodeIndex {
+			if strings.Contains(csiNode.Name, filter) {
+				nodes[node] = true
 			}
 		}
-		if pvFilter != "" || mountpodFilter != "" || csiNodeFilter != "" {
-			filterdPods := make([]*PodExtra, 0, len(pods))
-			for _, pod := range pods {
-				if api.filterPVsOfPod(c, pod, pvFilter) && api.filterMountPodsOfPod(c, pod, mountpodFilter) && api.filterCSINodeOfPod(c, pod, csiNodeFilter) {
-					filterdPods = append(filterdPods, pod)
-				}
+		api.csiNodeLock.RUnlock()
+		names = intersect(names, api.appFields.lookup(indexNode, inSet(nodes)))
+	}
+
+	// pv and sc filters are resolved to PVCs used by pods
+	var pvcs map[types.NamespacedName]bool
+	if filter := c.Query("pv"); filter != "" {
+		pvcs = intersect(pvcs, api.pvcFields.lookup(indexPV, containsFilter(filter)))
+	}
+	if filter := c.Query("sc"); filter != "" {
+		pvcs = intersect(pvcs, api.pvcFields.lookup(indexStorageClass, containsFilter(filter)))
+	}
+	if pvcs != nil {
+		names = intersect(names, api.appPodsOfPVCs(pvcs))
+	}
+	// mountpod filter is resolved to pods on the node of each mount pod, using PVCs of its PVs
+	if filter := c.Query("mountpod"); filter != "" {
+		mountPods := api.sysFields.match(func(name types.NamespacedName) bool {
+			return strings.Contains(name.Name, filter)
+		})
+		pods := make(map[types.NamespacedName]bool)
+		for mountPod := range mountPods {
+			mountPod := map[types.NamespacedName]bool{mountPod: true}
+			pvs := api.pvFields.lookup(indexUniqueId, inSet(api.sysFields.values(mountPod, indexUniqueId)))
+			pvNames := keysOf(pvs, func(name types.NamespacedName) string { return name.Name })
+			podsOfPVs := api.appPodsOfPVCs(api.pvcFields.lookup(indexPV, inSet(pvNames)))
+			for name := range intersect(podsOfPVs, api.appFields.lookup(indexNode, inSet(api.sysFields.values(mountPod, indexNode)))) {
+				pods[name] = true
 			}
-			pods = filterdPods
 		}
-		result := &ListAppPodResult{len(pods), make([]*PodExtra, 0)}
-		startIndex := (current - 1) * pageSize
-		if startIndex >= uint64(len(pods)) {
-			c.IndentedJSON(200, result)
+		names = intersect(names, pods)
+	}
+	return names
+}
+
+// appPodsOfPVCs returns names of app pods using any of the PVCs by field indexes
+func (api *API) appPodsOfPVCs(pvcs map[types.NamespacedName]bool) map[types.NamespacedName]bool {
+	pvcNames := keysOf(pvcs, types.NamespacedName.String)
+	return api.appFields.lookup(indexPVC, inSet(pvcNames))
+}
+
+func (api *API) listAppPod() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		opts, err := parseListOptions(c, true, podSelectableFields...)
+		if err != nil {
+			c.String(400, "%v", err)
 			return
 		}
-		endIndex := startIndex + pageSize
-		if endIndex > uint64(len(pods)) {
-			endIndex = uint64(len(pods))
-		}
-		result.Pods = pods[startIndex:endIndex]
-		for _, pod := range result.Pods {
-			if pod.Pvs == nil {
-				pod.Pvs, err = api.listPVsOfPod(c, pod.Pod)
-				if err != nil {
-					klog.Errorf("get pvs of %s error %v", pod.Spec.NodeName, err)
-				}
+		nameFilter := c.Query("name")
+		namespaceFilter := c.Query("namespace")
+		query := api.podQuery(c, api.appIndexes)
+		query.names = api.appPodNames(c, opts)
+		query.matchName = func(name types.NamespacedName) bool {
+			return (nameFilter == "" || strings.Contains(name.Name, nameFilter)) &&
+				(namespaceFilter == "" || strings.Contains(name.Namespace, namespaceFilter)) &&
+				api.canAccessNamespace(c, name.Namespace)
+		}
+		page := query.list(c, opts)
+
+		result := &ListAppPodResult{Total: page.total, Pods: make([]*PodExtra, 0, len(page.items)), Continue: page.next}
+		for _, p := range page.items {
+			pod := &PodExtra{Pod: p}
+			pod.Pvs, err = api.listPVsOfPod(c, pod.Pod)
+			if err != nil {
+				klog.Errorf("get pvs of %s error %v", pod.Spec.NodeName, err)
 			}
-			if pod.MountPods == nil {
-				pod.MountPods, err = api.listMountPodOf(c, pod.Pod)
-				if err != nil {
-					klog.Errorf("get mount pods of %s error %v", pod.Spec.NodeName, err)
-				}
+			pod.MountPods, err = api.listMountPodOf(c, pod.Pod)
+			if err != nil {
+				klog.Errorf("get mount pods of %s error %v", pod.Spec.NodeName, err)
 			}
-			if pod.CsiNode == nil {
-				pod.CsiNode, err = api.getCSINode(c, pod.Spec.NodeName)
-				if err != nil {
-					klog.Errorf("get csi node %s error %v", pod.Spec.NodeName, err)
-				}
+			pod.MountPods = api.filterPodPointers(c, pod.MountPods)
+			pod.CsiNode, err = api.getCSINode(c, pod.Spec.NodeName)
+			if err != nil {
+				klog.Errorf("get csi node %s error %v", pod.Spec.NodeName, err)
+			}
+			if pod.CsiNode != nil && !api.canAccessPod(c, pod.CsiNode) {
+				// csi node is in system namespace, which may not be accessible to the user
+				pod.CsiNode = nil
 			}
 			if pod.Spec.NodeName != "" {
 				var node corev1.Node
@@ -133,109 +190,49 @@ func (api *API) listAppPod() gin.HandlerFunc {
 			pod.Pvcs, err = api.listPVCsOfPod(c, pod.Pod)
 			if err != nil {
 				c.String(500, "list pvcs of pod %s error %v", pod.Name, err)
+				return
 			}
+			result.Pods = append(result.Pods, pod)
 		}
 		c.IndentedJSON(200, result)
 	}
 }
 
-func (api *API) filterPVsOfPod(ctx context.Context, pod *PodExtra, filter string) bool {
-	if filter == "" {
-		return true
-	}
-	var err error
-	pod.Pvs, err = api.listPVsOfPod(ctx, pod.Pod)
-	if err != nil {
-		klog.Errorf("get pvs of %s error %v", pod.Spec.NodeName, err)
-	}
-	for _, pv := range pod.Pvs {
-		if strings.Contains(pv.Name, filter) {
-			return true
-		}
-	}
-	return false
-}
-
-func (api *API) filterMountPodsOfPod(ctx context.Context, pod *PodExtra, filter string) bool {
-	if filter == "" {
-		return true
-	}
-	var err error
-	pod.MountPods, err = api.listMountPodOf(ctx, pod.Pod)
-	if err != nil {
-		klog.Errorf("get mount pods of %s error %v", pod.Spec.NodeName, err)
-	}
-	for _, mountPod := range pod.MountPods {
-		if strings.Contains(mountPod.Name, filter) {
-			return true
-		}
-	}
-	return false
-}
-
-func (api *API) filterCSINodeOfPod(ctx context.Context, pod *PodExtra, filter string) bool {
-	if filter == "" {
-		return true
-	}
-	if pod.Spec.NodeName == "" {
-		return false
-	}
-	var err error
-	pod.CsiNode, err = api.getCSINode(ctx, pod.Spec.NodeName)
-	if err != nil || pod.CsiNode == nil {
-		return false
-	}
-	return strings.Contains(pod.CsiNode.Name, filter)
-}
-
 func (api *API) listSysPod() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		pageSize, err := strconv.ParseUint(c.Query("pageSize"), 10, 64)
-		if err != nil || pageSize == 0 {
-			c.String(400, "invalid page size")
-			return
-		}
-		current, err := strconv.ParseUint(c.Query("current"), 10, 64)
-		if err != nil || current == 0 {
-			c.String(400, "invalid current page")
+		opts, err := parseListOptions(c, false, podSelectableFields...)
+		if err != nil {
+			c.String(400, "%v", err)
 			return
 		}
-		descend := c.Query("order") == "descend"
 		nameFilter := c.Query("name")
 		namespaceFilter := c.Query("namespace")
-		nodeFilter := c.Query("node")
-		required := func(pod *corev1.Pod) bool {
-			return (nameFilter == "" || strings.Contains(pod.Name, nameFilter)) &&
-				(namespaceFilter == "" || strings.Contains(pod.Namespace, namespaceFilter)) &&
-				(nodeFilter == "" || strings.Contains(pod.Spec.NodeName, nodeFilter))
-
+		query := api.podQuery(c, api.sysIndexes)
+		if filter := c.Query("node"); filter != "" {
+			query.names = intersect(query.names, api.sysFields.lookup(indexNode, containsFilter(filter)))
 		}
-		pods := make([]*corev1.Pod, 0, api.sysIndexes.length())
-		for name := range api.sysIndexes.iterate(c, descend) {
-			var pod corev1.Pod
-			if err := api.cachedReader.Get(c, name, &pod); err == nil && required(&pod) {
-				pods = append(pods, &pod)
-			}
+		for _, node := range selectorValues(opts.fieldSelector, "spec.nodeName") {
+			query.names = intersect(query.names, api.sysFields.lookup(indexNode, inSet(map[string]bool{node: true})))
 		}
-		result := &ListSysPodResult{len(pods), make([]*PodExtra, 0)}
-		startIndex := (current - 1) * pageSize
-		if startIndex >= uint64(len(pods)) {
-			c.IndentedJSON(200, result)
-			return
+		query.matchName = func(name types.NamespacedName) bool {
+			return (nameFilter == "" || strings.Contains(name.Name, nameFilter)) &&
+				(namespaceFilter == "" || strings.Contains(name.Namespace, namespaceFilter))
 		}
-		endIndex := startIndex + pageSize
-		if endIndex > uint64(len(pods)) {
-			endIndex = uint64(len(pods))
+		if api.authorizer != nil {
+			query.match = func(pod *corev1.Pod) bool { return api.canAccessPod(c, pod) }
 		}
-		for i := startIndex; i < endIndex; i++ {
+		page := query.list(c, opts)
+
+		result := &ListSysPodResult{Total: page.total, Pods: make([]*PodExtra, 0, len(page.items)), Continue: page.next}
+		for _, pod := range page.items {
 			var node corev1.Node
-			err := api.cachedReader.Get(c, types.NamespacedName{Name: pods[i].Spec.NodeName}, &node)
+			err := api.cachedReader.Get(c, types.NamespacedName{Name: pod.Spec.NodeName}, &node)
 			if err != nil {
-				klog.Errorf("get node %s error %v", pods[i].Spec.NodeName, err)
+				klog.Errorf("get node %s error %v", pod.Spec.NodeName, err)
 				continue
 			}
 			result.Pods = append(result.Pods, &PodExtra{
-				Pod:  pods[i],
+				Pod:  pod,
 				Node: &node,
 			})
 		}
@@ -262,7 +259,7 @@ func (api *API) listMountPod() gin.HandlerFunc {
 			c.String(500, "list pods error %v", err)
 			return
 		}
-		c.IndentedJSON(200, pods.Items)
+		c.IndentedJSON(200, api.filterPods(c, pods.Items))
 	}
 }
 
@@ -286,7 +283,7 @@ func (api *API) listCSINodePod() gin.HandlerFunc {
 			c.String(500, "list pods error %v", err)
 			return
 		}
-		c.IndentedJSON(200, pods.Items)
+		c.IndentedJSON(200, api.filterPods(c, pods.Items))
 	}
 }
 
@@ -310,7 +307,7 @@ func (api *API) listCSIControllerPod() gin.HandlerFunc {
 			c.String(500, "list pods error %v", err)
 			return
 		}
-		c.IndentedJSON(200, pods.Items)
+		c.IndentedJSON(200, api.filterPods(c, pods.Items))
 	}
 }
 
@@ -344,6 +341,10 @@ func (api *API) getPodMiddileware() gin.HandlerFunc {
 		} else if !isAppPod(&pod) && !isSysPod(&pod) && !api.isAppPodUnready(c, &pod) {
 			c.String(404, "not found")
 			return
+		} else if !api.canAccessPod(c, &pod) {
+			c.String(403, "forbidden")
+			c.Abort()
+			return
 		}
 		c.Set("pod", &pod)
 	}
@@ -561,7 +562,7 @@ func (api *API) listAppPodsOfMountPod() gin.HandlerFunc {
 					klog.V(6).Infof("annotation %s skipped", v)
 					continue
 				}
-				if p, ok := podMap[uid]; ok {
+				if p, ok := podMap[uid]; ok && api.canAccessNamespace(c, p.Namespace) {
 					appPods = append(appPods, p)
 				}
 			}
@@ -599,21 +600,26 @@ func (api *API) getMountPodsOfPV() gin.HandlerFunc {
 		}
 		pv := obj.(*corev1.PersistentVolume)
 
-		// todo: if unique id is sc name (mount pod shared by sc)
-		var pods corev1.PodList
-		err := api.cachedReader.List(c, &pods, &client.ListOptions{
-			LabelSelector: labels.SelectorFromSet(map[string]string{
-				config.PodUniqueIdLabelKey: pv.Spec.CSI.VolumeHandle,
-			}),
-		})
+		pods, err := api.listMountPodsOfPV(c, pv)
 		if err != nil {
 			c.String(500, "list pods error %v", err)
 			return
 		}
-		c.IndentedJSON(200, pods.Items)
+		c.IndentedJSON(200, api.filterPods(c, pods))
 	}
 }
 
+// listMountPodsOfPV lists mount pods by unique id of the PV, which is the StorageClass name if CSI node shares mount pods by StorageClass
+func (api *API) listMountPodsOfPV(ctx context.Context, pv *corev1.PersistentVolume) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	err := api.cachedReader.List(ctx, &pods, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			config.PodUniqueIdLabelKey: api.newMountPodRenderer(ctx, nil).uniqueId(pv),
+		}),
+	})
+	return pods.Items, err
+}
+
 func (api *API) getMountPodsOfPVC() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		obj, ok := c.Get("pvc")
@@ -640,18 +646,12 @@ func (api *API) getMountPodsOfPVC() gin.HandlerFunc {
 			return
 		}
 
-		// todo: if unique id is sc name (mount pod shared by sc)
-		var pods corev1.PodList
-		err := api.cachedReader.List(c, &pods, &client.ListOptions{
-			LabelSelector: labels.SelectorFromSet(map[string]string{
-				config.PodUniqueIdLabelKey: pv.Spec.CSI.VolumeHandle,
-			}),
-		})
+		pods, err := api.listMountPodsOfPV(c, &pv)
 		if err != nil {
 			c.String(500, "list pods error %v", err)
 			return
 		}
-		c.IndentedJSON(200, pods.Items)
+		c.IndentedJSON(200, api.filterPods(c, pods))
 	}
 }
 
@@ -672,7 +672,7 @@ func (api *API) getPVOfSC() gin.HandlerFunc {
 		var pvs []*corev1.PersistentVolume
 		for i := range pvList.Items {
 			pv := &pvList.Items[i]
-			if pv.Spec.StorageClassName == sc.Name {
+			if pv.Spec.StorageClassName == sc.Name && api.canAccessPV(c, pv) {
 				pvs = append(pvs, pv)
 			}
 		}
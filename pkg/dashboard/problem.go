@@ -0,0 +1,490 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+// problems last shorter than the grace period are not reported, as they may recover by themselves
+const problemGracePeriod = 5 * time.Minute
+
+const (
+	SeverityCritical = "critical"
+	SeverityWarning  = "warning"
+)
+
+const (
+	ProblemAppPodMountFailed        = "AppPodMountFailed"
+	ProblemMountPodFailed           = "MountPodFailed"
+	ProblemMountPodStuckTerminating = "MountPodStuckTerminating"
+	ProblemMountPodUnreferenced     = "MountPodUnreferenced"
+	ProblemPVCPending               = "PVCPending"
+	ProblemJobFailed                = "JobFailed"
+	ProblemCSINodeMissing           = "CSINodeMissing"
+)
+
+// Problem is an unhealthy object found in the cluster, with suggested action to fix it
+type Problem struct {
+	Type       string    `json:"type"`
+	Severity   string    `json:"severity"`
+	Kind       string    `json:"kind"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Name       string    `json:"name"`
+	Node       string    `json:"node,omitempty"`
+	Since      time.Time `json:"since"`
+	Message    string    `json:"message"`
+	Suggestion string    `json:"suggestion"`
+
+	// object of the problem, for authorization
+	obj client.Object
+}
+
+type ListProblemsResult struct {
+	Total    int       `json:"total"`
+	Critical int       `json:"critical"`
+	Warning  int       `json:"warning"`
+	Problems []Problem `json:"problems"`
+}
+
+var severityOrder = map[string]int{SeverityCritical: 0, SeverityWarning: 1}
+
+// problemDetector detects problems with objects in cache of dashboard at a time
+type problemDetector struct {
+	api *API
+	now time.Time
+
+	// JuiceFS PVs by name
+	pvs      map[string]*corev1.PersistentVolume
+	problems []Problem
+}
+
+func (d *problemDetector) add(p Problem) {
+	d.problems = append(d.problems, p)
+}
+
+func (d *problemDetector) stuck(since time.Time) bool {
+	return !since.IsZero() && d.now.Sub(since) > problemGracePeriod
+}
+
+// detectProblems detects all problems of JuiceFS volumes, sorted by severity
+func (api *API) detectProblems(ctx context.Context) ([]Problem, error) {
+	d := &problemDetector{api: api, now: time.Now(), pvs: make(map[string]*corev1.PersistentVolume)}
+	var pvs corev1.PersistentVolumeList
+	if err := api.cachedReader.List(ctx, &pvs); err != nil {
+		return nil, fmt.Errorf("list pvs error: %v", err)
+	}
+	for i := range pvs.Items {
+		pv := &pvs.Items[i]
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == config.DriverName {
+			d.pvs[pv.Name] = pv
+		}
+	}
+	for _, detect := range []func(context.Context) error{
+		d.detectAppPods,
+		d.detectMountPods,
+		d.detectPVCs,
+		d.detectJobs,
+	} {
+		if err := detect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(d.problems, func(i, j int) bool {
+		a, b := d.problems[i], d.problems[j]
+		if a.Severity != b.Severity {
+			return severityOrder[a.Severity] < severityOrder[b.Severity]
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return d.problems, nil
+}
+
+// usesJuiceFS checks if the pod uses any JuiceFS PV
+func (d *problemDetector) usesJuiceFS(ctx context.Context, pod *corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		var pvc corev1.PersistentVolumeClaim
+		if err := d.api.cachedReader.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: volume.PersistentVolumeClaim.ClaimName}, &pvc); err != nil {
+			continue
+		}
+		if _, ok := d.pvs[pvc.Spec.VolumeName]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// FailedMountEventSelector selects mount failures of pods, the only events cached by dashboard
+var FailedMountEventSelector = fields.SelectorFromSet(fields.Set{
+	"involvedObject.kind": "Pod",
+	"reason":              "FailedMount",
+	"type":                corev1.EventTypeWarning,
+})
+
+// detectAppPods detects app pods stuck in ContainerCreating with mount errors of JuiceFS volumes
+func (d *problemDetector) detectAppPods(ctx context.Context) error {
+	var events corev1.EventList
+	if err := d.api.cachedReader.List(ctx, &events); err != nil {
+		return fmt.Errorf("list events error: %v", err)
+	}
+	latest := make(map[types.UID]*corev1.Event)
+	for i := range events.Items {
+		e := &events.Items[i]
+		if e.InvolvedObject.Kind != "Pod" || e.Reason != "FailedMount" || e.Type != corev1.EventTypeWarning {
+			continue
+		}
+		if l, ok := latest[e.InvolvedObject.UID]; !ok || eventTime(l).Before(eventTime(e)) {
+			latest[e.InvolvedObject.UID] = e
+		}
+	}
+	for uid, e := range latest {
+		var pod corev1.Pod
+		if err := d.api.cachedReader.Get(ctx, types.NamespacedName{Namespace: e.InvolvedObject.Namespace, Name: e.InvolvedObject.Name}, &pod); err != nil || pod.UID != uid {
+			continue
+		}
+		if pod.DeletionTimestamp != nil || !containerCreating(&pod) || !d.stuck(pod.CreationTimestamp.Time) || !d.usesJuiceFS(ctx, &pod) {
+			continue
+		}
+		d.add(Problem{
+			Type:       ProblemAppPodMountFailed,
+			Severity:   SeverityCritical,
+			Kind:       "Pod",
+			Namespace:  pod.Namespace,
+			Name:       pod.Name,
+			Node:       pod.Spec.NodeName,
+			Since:      pod.CreationTimestamp.Time,
+			Message:    e.Message,
+			Suggestion: fmt.Sprintf("check mount pods of the pod and log of CSI node on node %s, or download the diagnostic bundle of the pod", pod.Spec.NodeName),
+			obj:        &pod,
+		})
+	}
+	return nil
+}
+
+func containerCreating(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodPending {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason == "ContainerCreating" {
+			return true
+		}
+	}
+	return false
+}
+
+// mountPodFailure returns why the mount pod failed, or empty if it does not
+func mountPodFailure(pod *corev1.Pod) string {
+	if pod.Status.Phase == corev1.PodFailed {
+		return fmt.Sprintf("mount pod failed: %s %s", pod.Status.Reason, pod.Status.Message)
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if w := cs.State.Waiting; w != nil {
+			switch w.Reason {
+			case "CrashLoopBackOff", "ErrImagePull", "ImagePullBackOff", "CreateContainerConfigError", "CreateContainerError", "InvalidImageName":
+				return fmt.Sprintf("container %s is waiting: %s %s", cs.Name, w.Reason, w.Message)
+			}
+		}
+		if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+			return fmt.Sprintf("container %s terminated with exit code %d: %s %s", cs.Name, t.ExitCode, t.Reason, t.Message)
+		}
+	}
+	return ""
+}
+
+// detectMountPods detects mount pods failed, stuck terminating or not referenced, and nodes of mount pods without CSI node
+func (d *problemDetector) detectMountPods(ctx context.Context) error {
+	var pods corev1.PodList
+	if err := d.api.cachedReader.List(ctx, &pods, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{config.PodTypeKey: config.PodTypeValue}),
+	}); err != nil {
+		return fmt.Errorf("list mount pods error: %v", err)
+	}
+	nodes := make(map[string]*corev1.Pod)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		refs := referenceCount(pod)
+		if pod.Spec.NodeName != "" {
+			nodes[pod.Spec.NodeName] = pod
+		}
+		if pod.DeletionTimestamp != nil {
+			deadline := pod.DeletionTimestamp.Time
+			if d.stuck(deadline) {
+				d.add(Problem{
+					Type:       ProblemMountPodStuckTerminating,
+					Severity:   SeverityCritical,
+					Kind:       "Pod",
+					Namespace:  pod.Namespace,
+					Name:       pod.Name,
+					Node:       pod.Spec.NodeName,
+					Since:      deadline,
+					Message:    fmt.Sprintf("mount pod is terminating since %s, FUSE connection may be hung", deadline.Format(time.RFC3339)),
+					Suggestion: "abort FUSE connection of the mount pod with action abort-fuse, and check log of CSI node",
+					obj:        pod,
+				})
+			}
+			continue
+		}
+		if failure := mountPodFailure(pod); failure != "" {
+			severity := SeverityWarning
+			if refs > 0 {
+				severity = SeverityCritical
+			}
+			d.add(Problem{
+				Type:       ProblemMountPodFailed,
+				Severity:   severity,
+				Kind:       "Pod",
+				Namespace:  pod.Namespace,
+				Name:       pod.Name,
+				Node:       pod.Spec.NodeName,
+				Since:      pod.CreationTimestamp.Time,
+				Message:    fmt.Sprintf("%s, referenced by %d targets", failure, refs),
+				Suggestion: "check previous log of the mount pod, fix the volume or config, then recreate the mount pod",
+				obj:        pod,
+			})
+			continue
+		}
+		if refs == 0 {
+			if since, ok := d.unreferencedSince(pod); ok && d.stuck(since) {
+				d.add(Problem{
+					Type:       ProblemMountPodUnreferenced,
+					Severity:   SeverityWarning,
+					Kind:       "Pod",
+					Namespace:  pod.Namespace,
+					Name:       pod.Name,
+					Node:       pod.Spec.NodeName,
+					Since:      since,
+					Message:    "mount pod is not referenced by any target, but is not deleted",
+					Suggestion: "check log of CSI node for why it's not cleaned up, or delete it with action delete",
+					obj:        pod,
+				})
+			}
+		}
+	}
+
+	for node, pod := range nodes {
+		if d.hasRunningCSINode(ctx, node) {
+			continue
+		}
+		var n corev1.Node
+		nodeObj := client.Object(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node}})
+		if err := d.api.cachedReader.Get(ctx, types.NamespacedName{Name: node}, &n); err == nil {
+			nodeObj = &n
+		}
+		d.add(Problem{
+			Type:       ProblemCSINodeMissing,
+			Severity:   SeverityCritical,
+			Kind:       "Node",
+			Name:       node,
+			Node:       node,
+			Since:      pod.CreationTimestamp.Time,
+			Message:    fmt.Sprintf("there are mount pods (e.g. %s) but no running CSI node on the node", pod.Name),
+			Suggestion: "check nodeSelector, tolerations and status of the CSI node DaemonSet, mount points on the node can not be recovered without CSI node",
+			obj:        nodeObj,
+		})
+	}
+	return nil
+}
+
+// unreferencedSince returns when the unreferenced mount pod should have been deleted: when its delete delay expires,
+// or when it was created if delete delay is not set. ok is false if it's still in delete delay.
+func (d *problemDetector) unreferencedSince(pod *corev1.Pod) (time.Time, bool) {
+	if _, ok := pod.Annotations[config.DeleteDelayTimeKey]; !ok {
+		return pod.CreationTimestamp.Time, true
+	}
+	delayAt, err := util.GetTime(pod.Annotations[config.DeleteDelayAtKey])
+	if err != nil {
+		// delete delay is not started by CSI node
+		return pod.CreationTimestamp.Time, true
+	}
+	return delayAt, d.now.After(delayAt)
+}
+
+func (d *problemDetector) hasRunningCSINode(ctx context.Context, node string) bool {
+	d.api.csiNodeLock.RLock()
+	name, ok := d.api.csiNodeIndex[node]
+	d.api.csiNodeLock.RUnlock()
+	if !ok {
+		return false
+	}
+	var pod corev1.Pod
+	if err := d.api.cachedReader.Get(ctx, name, &pod); err != nil {
+		return false
+	}
+	return pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning
+}
+
+// detectPVCs detects PVCs pending on JuiceFS StorageClasses
+func (d *problemDetector) detectPVCs(ctx context.Context) error {
+	var scs storagev1.StorageClassList
+	if err := d.api.cachedReader.List(ctx, &scs); err != nil {
+		return fmt.Errorf("list storageclasses error: %v", err)
+	}
+	juicefsSCs := make(map[string]bool)
+	for _, sc := range scs.Items {
+		if sc.Provisioner == config.DriverName {
+			juicefsSCs[sc.Name] = true
+		}
+	}
+	if len(juicefsSCs) == 0 {
+		return nil
+	}
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := d.api.cachedReader.List(ctx, &pvcs); err != nil {
+		return fmt.Errorf("list pvcs error: %v", err)
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if pvc.Status.Phase != corev1.ClaimPending || pvc.Spec.StorageClassName == nil || !juicefsSCs[*pvc.Spec.StorageClassName] {
+			continue
+		}
+		if pvc.DeletionTimestamp != nil || !d.stuck(pvc.CreationTimestamp.Time) {
+			continue
+		}
+		d.add(Problem{
+			Type:       ProblemPVCPending,
+			Severity:   SeverityWarning,
+			Kind:       "PersistentVolumeClaim",
+			Namespace:  pvc.Namespace,
+			Name:       pvc.Name,
+			Since:      pvc.CreationTimestamp.Time,
+			Message:    fmt.Sprintf("pvc is pending on storageclass %s", *pvc.Spec.StorageClassName),
+			Suggestion: "check events of the PVC and log of CSI controller, and whether volumeBindingMode of the StorageClass is WaitForFirstConsumer",
+			obj:        pvc,
+		})
+	}
+	return nil
+}
+
+// detectJobs detects failed jobs of JuiceFS volumes
+func (d *problemDetector) detectJobs(ctx context.Context) error {
+	var jobs batchv1.JobList
+	if err := d.api.cachedReader.List(ctx, &jobs, &client.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{config.PodTypeKey: config.JobTypeValue}),
+	}); err != nil {
+		return fmt.Errorf("list jobs error: %v", err)
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		var failed *batchv1.JobCondition
+		for j := range job.Status.Conditions {
+			cond := &job.Status.Conditions[j]
+			if cond.Type == batchv1.JobFailed && cond.Status == corev1.ConditionTrue {
+				failed = cond
+			}
+		}
+		if failed == nil && (job.Status.Failed == 0 || job.Status.Succeeded > 0 || !d.stuck(job.CreationTimestamp.Time)) {
+			continue
+		}
+		message := fmt.Sprintf("job has %d failed pods", job.Status.Failed)
+		since := job.CreationTimestamp.Time
+		if failed != nil {
+			message = fmt.Sprintf("job failed: %s %s", failed.Reason, failed.Message)
+			since = failed.LastTransitionTime.Time
+		}
+		suggestion := "check log of pods of the job"
+		switch {
+		case strings.HasSuffix(job.Name, "-createvol"):
+			suggestion += ", subdirectory of the PV is not created and provisioning fails"
+		case strings.HasSuffix(job.Name, "-delvol"):
+			suggestion += ", subdirectory of the deleted PV may be left in the file system"
+		case strings.Contains(job.Name, "-cleancache-"):
+			suggestion += ", cache of the volume may be left on the node"
+		}
+		d.add(Problem{
+			Type:       ProblemJobFailed,
+			Severity:   SeverityWarning,
+			Kind:       "Job",
+			Namespace:  job.Namespace,
+			Name:       job.Name,
+			Node:       job.Spec.Template.Spec.NodeName,
+			Since:      since,
+			Message:    message,
+			Suggestion: suggestion,
+			obj:        job,
+		})
+	}
+	return nil
+}
+
+func (api *API) canAccessProblem(c *gin.Context, p *Problem) bool {
+	switch obj := p.obj.(type) {
+	case *corev1.Pod:
+		return api.canAccessPod(c, obj)
+	case *corev1.PersistentVolumeClaim:
+		return api.canAccessNamespace(c, obj.Namespace)
+	default:
+		return api.canAccessNamespace(c, api.sysNamespace)
+	}
+}
+
+// listProblems lists problems the user can access, filtered by query severity and type
+func (api *API) listProblems() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		problems, err := api.detectProblems(c)
+		if err != nil {
+			c.String(500, "detect problems error %v", err)
+			return
+		}
+		severity, problemType := c.Query("severity"), c.Query("type")
+		result := &ListProblemsResult{Problems: []Problem{}}
+		for i := range problems {
+			p := &problems[i]
+			if (severity != "" && p.Severity != severity) || (problemType != "" && p.Type != problemType) {
+				continue
+			}
+			if api.authorizer != nil && !api.canAccessProblem(c, p) {
+				continue
+			}
+			result.Problems = append(result.Problems, *p)
+			switch p.Severity {
+			case SeverityCritical:
+				result.Critical++
+			case SeverityWarning:
+				result.Warning++
+			}
+		}
+		result.Total = len(result.Problems)
+		c.IndentedJSON(200, result)
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+func TestDetectProblems(t *testing.T) {
+	old := metav1.NewTime(time.Now().Add(-time.Hour))
+	recent := metav1.NewTime(time.Now().Add(-time.Minute))
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "juicefs-sc"}, Provisioner: config.DriverName}
+	scName := sc.Name
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: "data"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:       config.DriverName,
+				VolumeHandle: "pvc-1",
+			}},
+		},
+	}
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default", CreationTimestamp: old},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pvc-1", StorageClassName: &scName},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimBound},
+	}
+	pending := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default", CreationTimestamp: old},
+		Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: &scName},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: corev1.ClaimPending},
+	}
+	pendingRecently := pending.DeepCopy()
+	pendingRecently.Name, pendingRecently.CreationTimestamp = "pending-recently", recent
+
+	newAppPod := func(name string, created metav1.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name), CreationTimestamp: created},
+			Spec: corev1.PodSpec{
+				NodeName: "node-1",
+				Volumes: []corev1.Volume{{
+					Name:         "data",
+					VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+				}},
+			},
+			Status: corev1.PodStatus{
+				Phase:             corev1.PodPending,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}}},
+			},
+		}
+	}
+	stuckApp := newAppPod("stuck-app", old)
+	newApp := newAppPod("new-app", recent)
+
+	target := "/var/lib/kubelet/pods/uid-app/volumes/kubernetes.io~csi/pvc-1/mount"
+	newMountPod := func(name, node string, refs bool) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "kube-system",
+				Labels:            map[string]string{config.PodTypeKey: config.PodTypeValue, config.PodUniqueIdLabelKey: "pvc-1"},
+				Annotations:       map[string]string{},
+				CreationTimestamp: old,
+			},
+			Spec:   corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		if refs {
+			pod.Annotations[util.GetReferenceKey(target)] = target
+		}
+		return pod
+	}
+	crashing := newMountPod("juicefs-node-1-pvc-1-crash", "node-1", true)
+	crashing.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "jfs-mount", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}}
+	terminating := newMountPod("juicefs-node-1-pvc-1-term", "node-1", true)
+	terminating.DeletionTimestamp = &old
+	terminating.Finalizers = []string{"juicefs.com/test"}
+	idle := newMountPod("juicefs-node-1-pvc-1-idle", "node-1", false)
+	delayed := newMountPod("juicefs-node-1-pvc-1-delay", "node-1", false)
+	delayed.Annotations[config.DeleteDelayTimeKey] = "1h"
+	delayed.Annotations[config.DeleteDelayAtKey] = time.Now().UTC().Add(time.Hour).Format("2006-01-02 15:04:05")
+	healthy := newMountPod("juicefs-node-1-pvc-1-ok", "node-1", true)
+	orphan := newMountPod("juicefs-node-2-pvc-1-ok", "node-2", true)
+
+	csiNode := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-csi-node-1", Namespace: "kube-system"},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	failedJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "juicefs-0123456789-createvol",
+			Namespace:         "kube-system",
+			Labels:            map[string]string{config.PodTypeKey: config.JobTypeValue},
+			CreationTimestamp: old,
+		},
+		Status: batchv1.JobStatus{
+			Failed:     1,
+			Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"}},
+		},
+	}
+	succeededJob := failedJob.DeepCopy()
+	succeededJob.Name, succeededJob.Status = "juicefs-0123456789-delvol", batchv1.JobStatus{Succeeded: 1}
+
+	newFailedMount := func(pod *corev1.Pod) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: pod.Name + ".failedmount", Namespace: pod.Namespace},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID},
+			Reason:         "FailedMount",
+			Type:           corev1.EventTypeWarning,
+			Message:        "MountVolume.SetUp failed for volume \"pvc-1\" : rpc error: code = Internal desc = Could not mount juicefs",
+			LastTimestamp:  recent,
+		}
+	}
+
+	objs := []runtime.Object{sc, pv, pvc, pending, pendingRecently, stuckApp, newApp, crashing, terminating, idle, delayed, healthy, orphan, csiNode, failedJob, succeededJob,
+		newFailedMount(stuckApp), newFailedMount(newApp)}
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithRuntimeObjects(objs...).Build(), fake.NewSimpleClientset())
+	api.csiNodeIndex["node-1"] = types.NamespacedName{Namespace: csiNode.Namespace, Name: csiNode.Name}
+
+	problems, err := api.detectProblems(context.TODO())
+	if err != nil {
+		t.Fatalf("detect problems error: %v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		if p.Suggestion == "" || p.Message == "" {
+			t.Errorf("problem %s of %s without message or suggestion", p.Type, p.Name)
+		}
+		got = append(got, p.Severity+"/"+p.Type+"/"+p.Name)
+	}
+	want := []string{
+		"critical/AppPodMountFailed/stuck-app",
+		"critical/CSINodeMissing/node-2",
+		"critical/MountPodFailed/juicefs-node-1-pvc-1-crash",
+		"critical/MountPodStuckTerminating/juicefs-node-1-pvc-1-term",
+		"warning/JobFailed/juicefs-0123456789-createvol",
+		"warning/MountPodUnreferenced/juicefs-node-1-pvc-1-idle",
+		"warning/PVCPending/pending",
+	}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected problems:\n%v\ngot:\n%v", want, got)
+	}
+}
@@ -18,13 +18,14 @@ package dashboard
 
 import (
 	"context"
-	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog"
 
@@ -85,63 +86,62 @@ func (api *API) listSCsHandler() gin.HandlerFunc {
 }
 
 type ListPVPodResult struct {
-	Total int                        `json:"total"`
-	PVs   []*corev1.PersistentVolume `json:"pvs"`
+	Total    int                        `json:"total"`
+	PVs      []*corev1.PersistentVolume `json:"pvs"`
+	Continue string                     `json:"continue,omitempty"`
 }
 
 type ListPVCPodResult struct {
-	Total int                             `json:"total"`
-	PVCs  []*corev1.PersistentVolumeClaim `json:"pvcs"`
+	Total    int                             `json:"total"`
+	PVCs     []*corev1.PersistentVolumeClaim `json:"pvcs"`
+	Continue string                          `json:"continue,omitempty"`
 }
 
+// fields of PVs and PVCs supported in field selector
+var (
+	pvSelectableFields  = []string{"metadata.name", "spec.storageClassName", "status.phase"}
+	pvcSelectableFields = []string{"metadata.name", "metadata.namespace", "spec.storageClassName", "spec.volumeName", "status.phase"}
+)
+
 func (api *API) listPVsHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		pageSize, err := strconv.ParseUint(c.Query("pageSize"), 10, 64)
-		if err != nil || pageSize == 0 {
-			c.String(400, "invalid page size")
-			return
-		}
-		current, err := strconv.ParseUint(c.Query("current"), 10, 64)
-		if err != nil || current == 0 {
-			c.String(400, "invalid current page")
+		opts, err := parseListOptions(c, true, pvSelectableFields...)
+		if err != nil {
+			c.String(400, "%v", err)
 			return
 		}
-		descend := c.Query("order") != "ascend"
 		nameFilter := c.Query("name")
-		pvcFilter := c.Query("pvc")
-		scFilter := c.Query("sc")
-		required := func(pv *corev1.PersistentVolume) bool {
-			pvcName := types.NamespacedName{}
-			if pv.Spec.ClaimRef != nil {
-				pvcName = types.NamespacedName{
-					Namespace: pv.Spec.ClaimRef.Namespace,
-					Name:      pv.Spec.ClaimRef.Name,
+		query := &listQuery[corev1.PersistentVolume]{
+			indexes: api.pvIndexes,
+			matchName: func(name types.NamespacedName) bool {
+				return nameFilter == "" || strings.Contains(name.Name, nameFilter)
+			},
+			get: func(name types.NamespacedName) (*corev1.PersistentVolume, error) {
+				var pv corev1.PersistentVolume
+				err := api.cachedReader.Get(c, name, &pv)
+				return &pv, err
+			},
+			meta: func(pv *corev1.PersistentVolume) metav1.ObjectMeta { return pv.ObjectMeta },
+			fields: func(pv *corev1.PersistentVolume) fields.Set {
+				return fields.Set{
+					"metadata.name":         pv.Name,
+					"spec.storageClassName": pv.Spec.StorageClassName,
+					"status.phase":          string(pv.Status.Phase),
 				}
-			}
-			return (nameFilter == "" || strings.Contains(pv.Name, nameFilter)) &&
-				(pvcFilter == "" || strings.Contains(pvcName.String(), pvcFilter)) &&
-				(scFilter == "" || strings.Contains(pv.Spec.StorageClassName, scFilter))
-
+			},
+			status: func(pv *corev1.PersistentVolume) string { return string(pv.Status.Phase) },
 		}
-		pvs := make([]*corev1.PersistentVolume, 0, api.pvIndexes.length())
-		for name := range api.pvIndexes.iterate(c, descend) {
-			var pv corev1.PersistentVolume
-			if err := api.cachedReader.Get(c, name, &pv); err == nil && required(&pv) {
-				pvs = append(pvs, &pv)
-			}
+		if filter := c.Query("pvc"); filter != "" {
+			query.names = intersect(query.names, api.pvFields.lookup(indexPVC, containsFilter(filter)))
 		}
-		result := &ListPVPodResult{len(pvs), make([]*corev1.PersistentVolume, 0)}
-		startIndex := (current - 1) * pageSize
-		if startIndex >= uint64(len(pvs)) {
-			c.IndentedJSON(200, result)
-			return
+		if filter := c.Query("sc"); filter != "" {
+			query.names = intersect(query.names, api.pvFields.lookup(indexStorageClass, containsFilter(filter)))
 		}
-		endIndex := startIndex + pageSize
-		if endIndex > uint64(len(pvs)) {
-			endIndex = uint64(len(pvs))
+		if api.authorizer != nil {
+			query.match = func(pv *corev1.PersistentVolume) bool { return api.canAccessPV(c, pv) }
 		}
-		result.PVs = pvs[startIndex:endIndex]
-		c.IndentedJSON(200, result)
+		page := query.list(c, opts)
+		c.IndentedJSON(200, &ListPVPodResult{Total: page.total, PVs: page.items, Continue: page.next})
 	}
 }
 
@@ -157,6 +157,10 @@ func (api *API) getPVMiddileware() gin.HandlerFunc {
 			c.AbortWithStatus(404)
 			return
 		}
+		if !api.canAccessPV(c, pv) {
+			c.AbortWithStatus(403)
+			return
+		}
 		c.Set("pv", pv)
 	}
 }
@@ -165,6 +169,10 @@ func (api *API) getPVCMiddileware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.Param("name")
 		namespace := c.Param("namespace")
+		if !api.canAccessNamespace(c, namespace) {
+			c.AbortWithStatus(403)
+			return
+		}
 		pvc, err := api.getPVC(namespace, name)
 		if err != nil {
 			c.AbortWithStatus(500)
@@ -196,55 +204,49 @@ func (api *API) getSCMiddileware() gin.HandlerFunc {
 
 func (api *API) listPVCsHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		pageSize, err := strconv.ParseUint(c.Query("pageSize"), 10, 64)
-		if err != nil || pageSize == 0 {
-			c.String(400, "invalid page size")
-			return
-		}
-		current, err := strconv.ParseUint(c.Query("current"), 10, 64)
-		if err != nil || current == 0 {
-			c.String(400, "invalid current page")
+		opts, err := parseListOptions(c, true, pvcSelectableFields...)
+		if err != nil {
+			c.String(400, "%v", err)
 			return
 		}
-		descend := c.Query("order") != "ascend"
 		namespaceFilter := c.Query("namespace")
 		nameFilter := c.Query("name")
-		pvFilter := c.Query("pv")
-		scFilter := c.Query("sc")
-		required := func(pvc *corev1.PersistentVolumeClaim) bool {
-			pvName := ""
-			scName := ""
-			if pv, ok := api.pairs[types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name}]; ok {
-				pvName = pv.Name
-			}
-			if pvc.Spec.StorageClassName != nil {
-				scName = *pvc.Spec.StorageClassName
-			}
-			return (namespaceFilter == "" || strings.Contains(pvc.Namespace, namespaceFilter)) &&
-				(nameFilter == "" || strings.Contains(pvc.Name, nameFilter)) &&
-				(pvFilter == "" || strings.Contains(pvName, pvFilter)) &&
-				(scFilter == "" || strings.Contains(scName, scFilter))
-
-		}
-		pvcs := make([]*corev1.PersistentVolumeClaim, 0, api.pvcIndexes.length())
-		for name := range api.pvcIndexes.iterate(c, descend) {
-			var pvc corev1.PersistentVolumeClaim
-			if err := api.cachedReader.Get(c, name, &pvc); err == nil && required(&pvc) {
-				pvcs = append(pvcs, &pvc)
-			}
+		query := &listQuery[corev1.PersistentVolumeClaim]{
+			indexes: api.pvcIndexes,
+			matchName: func(name types.NamespacedName) bool {
+				return (namespaceFilter == "" || strings.Contains(name.Namespace, namespaceFilter)) &&
+					(nameFilter == "" || strings.Contains(name.Name, nameFilter)) &&
+					api.canAccessNamespace(c, name.Namespace)
+			},
+			get: func(name types.NamespacedName) (*corev1.PersistentVolumeClaim, error) {
+				var pvc corev1.PersistentVolumeClaim
+				err := api.cachedReader.Get(c, name, &pvc)
+				return &pvc, err
+			},
+			meta: func(pvc *corev1.PersistentVolumeClaim) metav1.ObjectMeta { return pvc.ObjectMeta },
+			fields: func(pvc *corev1.PersistentVolumeClaim) fields.Set {
+				scName := ""
+				if pvc.Spec.StorageClassName != nil {
+					scName = *pvc.Spec.StorageClassName
+				}
+				return fields.Set{
+					"metadata.name":         pvc.Name,
+					"metadata.namespace":    pvc.Namespace,
+					"spec.storageClassName": scName,
+					"spec.volumeName":       pvc.Spec.VolumeName,
+					"status.phase":          string(pvc.Status.Phase),
+				}
+			},
+			status: func(pvc *corev1.PersistentVolumeClaim) string { return string(pvc.Status.Phase) },
 		}
-		result := &ListPVCPodResult{len(pvcs), make([]*corev1.PersistentVolumeClaim, 0)}
-		startIndex := (current - 1) * pageSize
-		if startIndex >= uint64(len(pvcs)) {
-			c.IndentedJSON(200, result)
-			return
+		if filter := c.Query("pv"); filter != "" {
+			query.names = intersect(query.names, api.pvcFields.lookup(indexPV, containsFilter(filter)))
 		}
-		endIndex := startIndex + pageSize
-		if endIndex > uint64(len(pvcs)) {
-			endIndex = uint64(len(pvcs))
+		if filter := c.Query("sc"); filter != "" {
+			query.names = intersect(query.names, api.pvcFields.lookup(indexStorageClass, containsFilter(filter)))
 		}
-		result.PVCs = pvcs[startIndex:endIndex]
-		c.IndentedJSON(200, result)
+		page := query.list(c, opts)
+		c.IndentedJSON(200, &ListPVCPodResult{Total: page.total, PVCs: page.items, Continue: page.next})
 	}
 }
 
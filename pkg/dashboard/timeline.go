@@ -0,0 +1,343 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount/builder"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+// roles of objects in timeline of a volume
+const (
+	rolePVC   = "pvc"
+	rolePV    = "pv"
+	roleApp   = "app"
+	roleMount = "mount"
+	roleJob   = "job"
+)
+
+// TimelineEvent is an event of an object related to a volume
+type TimelineEvent struct {
+	Time time.Time `json:"time"`
+	// the object event is about, and its role in the volume: pvc, pv, app, mount or job
+	Role      string `json:"role"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Node      string `json:"node,omitempty"`
+
+	Type           string    `json:"type"`
+	Reason         string    `json:"reason"`
+	Message        string    `json:"message"`
+	Count          int32     `json:"count"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	Source         string    `json:"source,omitempty"`
+}
+
+// volumeObjects are objects related to a volume, whose events are in its timeline
+type volumeObjects struct {
+	pvc *corev1.PersistentVolumeClaim
+	pv  *corev1.PersistentVolume
+	// app pods by name in namespace of PVC
+	appPods map[string]*corev1.Pod
+	// existing mount pods by name
+	mountPods map[string]*corev1.Pod
+}
+
+// isMountPodOfVolume checks if name is a mount pod of the volume, including deleted ones,
+// whose name is juicefs-<node>-<uniqueId>[-<random>]
+func (o *volumeObjects) isMountPodOfVolume(name string) bool {
+	if _, ok := o.mountPods[name]; ok {
+		return true
+	}
+	if o.pv == nil || !strings.HasPrefix(name, "juicefs-") {
+		return false
+	}
+	uniqueId := o.pv.Spec.CSI.VolumeHandle
+	return strings.HasSuffix(name, "-"+uniqueId) || strings.Contains(name, "-"+uniqueId+"-")
+}
+
+// isJobOfVolume checks if name is a create, delete or clean cache job of the volume, or a pod of them
+func (o *volumeObjects) isJobOfVolume(name string) bool {
+	if o.pv == nil {
+		return false
+	}
+	return strings.HasPrefix(name, builder.GenJobNameByVolumeId(o.pv.Spec.CSI.VolumeHandle)+"-")
+}
+
+func (api *API) resolveVolumeObjects(ctx context.Context, pvc *corev1.PersistentVolumeClaim, pv *corev1.PersistentVolume) (*volumeObjects, error) {
+	objs := &volumeObjects{
+		pvc:       pvc,
+		pv:        pv,
+		appPods:   make(map[string]*corev1.Pod),
+		mountPods: make(map[string]*corev1.Pod),
+	}
+	if pvc != nil {
+		var pods corev1.PodList
+		if err := api.cachedReader.List(ctx, &pods, client.InNamespace(pvc.Namespace)); err != nil {
+			return nil, fmt.Errorf("list pods error: %v", err)
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			for _, volume := range pod.Spec.Volumes {
+				if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
+					objs.appPods[pod.Name] = pod
+					break
+				}
+			}
+		}
+	}
+	if pv != nil {
+		var pods corev1.PodList
+		if err := api.cachedReader.List(ctx, &pods, &client.ListOptions{
+			LabelSelector: labels.SelectorFromSet(map[string]string{config.PodTypeKey: config.PodTypeValue}),
+		}); err != nil {
+			return nil, fmt.Errorf("list mount pods error: %v", err)
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			uniqueId := pod.Labels[config.PodUniqueIdLabelKey]
+			if uniqueId == pv.Spec.CSI.VolumeHandle {
+				objs.mountPods[pod.Name] = pod
+				continue
+			}
+			// mount pods shared by StorageClass, which reference the PV
+			if uniqueId != "" && uniqueId == pv.Spec.StorageClassName {
+				for k, target := range pod.Annotations {
+					if k == util.GetReferenceKey(target) && strings.Contains(target, "/"+pv.Name+"/") {
+						objs.mountPods[pod.Name] = pod
+						break
+					}
+				}
+			}
+		}
+	}
+	return objs, nil
+}
+
+// role returns role of the object event is about in the volume, or empty if it's not related
+func (o *volumeObjects) role(e *corev1.Event, sysNamespace string) string {
+	obj := e.InvolvedObject
+	switch obj.Kind {
+	case "PersistentVolumeClaim":
+		if o.pvc != nil && obj.Namespace == o.pvc.Namespace && obj.Name == o.pvc.Name {
+			return rolePVC
+		}
+	case "PersistentVolume":
+		if o.pv != nil && obj.Name == o.pv.Name {
+			return rolePV
+		}
+	case "Pod":
+		if o.pvc != nil && obj.Namespace == o.pvc.Namespace {
+			if _, ok := o.appPods[obj.Name]; ok {
+				return roleApp
+			}
+		}
+		if obj.Namespace == sysNamespace {
+			if o.isMountPodOfVolume(obj.Name) {
+				return roleMount
+			}
+			if o.isJobOfVolume(obj.Name) {
+				return roleJob
+			}
+		}
+	case "Job":
+		if obj.Namespace == sysNamespace && o.isJobOfVolume(obj.Name) {
+			return roleJob
+		}
+	}
+	return ""
+}
+
+func eventTime(e *corev1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+	if !e.FirstTimestamp.IsZero() {
+		return e.FirstTimestamp.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+func (o *volumeObjects) node(e *corev1.Event, role string) string {
+	if role == roleMount {
+		if pod, ok := o.mountPods[e.InvolvedObject.Name]; ok {
+			return pod.Spec.NodeName
+		}
+	}
+	if role == roleApp {
+		if pod, ok := o.appPods[e.InvolvedObject.Name]; ok {
+			return pod.Spec.NodeName
+		}
+	}
+	if e.Source.Host != "" {
+		return e.Source.Host
+	}
+	return e.ReportingInstance
+}
+
+// volumeTimeline gathers events of the PVC, PV, app pods, mount pods on all nodes and jobs of a volume, sorted by time
+func (api *API) volumeTimeline(ctx context.Context, objs *volumeObjects) ([]TimelineEvent, error) {
+	var events []corev1.Event
+	namespaces := map[string]bool{api.sysNamespace: true}
+	if objs.pvc != nil {
+		namespaces[objs.pvc.Namespace] = true
+	}
+	for namespace := range namespaces {
+		list, err := api.client.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("list events in namespace %s error: %v", namespace, err)
+		}
+		events = append(events, list.Items...)
+	}
+	if objs.pv != nil {
+		// events of cluster scoped objects are in namespace default
+		list, err := api.client.CoreV1().Events("").List(ctx, metav1.ListOptions{
+			FieldSelector: fields.SelectorFromSet(fields.Set{
+				"involvedObject.kind": "PersistentVolume",
+				"involvedObject.name": objs.pv.Name,
+			}).String(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list events of pv %s error: %v", objs.pv.Name, err)
+		}
+		events = append(events, list.Items...)
+	}
+
+	seen := make(map[types.UID]bool)
+	timeline := make([]TimelineEvent, 0)
+	for i := range events {
+		e := &events[i]
+		if seen[e.UID] {
+			continue
+		}
+		seen[e.UID] = true
+		role := objs.role(e, api.sysNamespace)
+		if role == "" {
+			continue
+		}
+		source := e.Source.Component
+		if source == "" {
+			source = e.ReportingController
+		}
+		timeline = append(timeline, TimelineEvent{
+			Time:           eventTime(e),
+			Role:           role,
+			Kind:           e.InvolvedObject.Kind,
+			Namespace:      e.InvolvedObject.Namespace,
+			Name:           e.InvolvedObject.Name,
+			Node:           objs.node(e, role),
+			Type:           e.Type,
+			Reason:         e.Reason,
+			Message:        e.Message,
+			Count:          e.Count,
+			FirstTimestamp: e.FirstTimestamp.Time,
+			Source:         source,
+		})
+	}
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Time.Before(timeline[j].Time)
+	})
+	return timeline, nil
+}
+
+// getVolumeTimeline returns timeline of the PV or PVC in context, filtered by query type (Normal or Warning) and since (e.g. 1h)
+func (api *API) getVolumeTimeline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var pvc *corev1.PersistentVolumeClaim
+		var pv *corev1.PersistentVolume
+		if obj, ok := c.Get("pvc"); ok {
+			pvc = obj.(*corev1.PersistentVolumeClaim)
+			api.pairLock.RLock()
+			name, ok := api.pairs[types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name}]
+			api.pairLock.RUnlock()
+			if !ok && pvc.Spec.VolumeName != "" {
+				name, ok = types.NamespacedName{Name: pvc.Spec.VolumeName}, true
+			}
+			if ok {
+				var err error
+				if pv, err = api.getPV(c, name.Name); err != nil {
+					c.String(500, "get pv %s error %v", name.Name, err)
+					return
+				}
+			}
+		} else if obj, ok := c.Get("pv"); ok {
+			pv = obj.(*corev1.PersistentVolume)
+			if ref := pv.Spec.ClaimRef; ref != nil {
+				var err error
+				if pvc, err = api.getPVC(ref.Namespace, ref.Name); err != nil {
+					c.String(500, "get pvc %s/%s error %v", ref.Namespace, ref.Name, err)
+					return
+				}
+			}
+		} else {
+			c.String(404, "not found")
+			return
+		}
+		if pv != nil && (pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName) {
+			pv = nil
+		}
+
+		var since time.Time
+		if s := c.Query("since"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				c.String(400, "invalid since %s: %v", s, err)
+				return
+			}
+			since = time.Now().Add(-d)
+		}
+		eventType := c.Query("type")
+
+		objs, err := api.resolveVolumeObjects(c, pvc, pv)
+		if err != nil {
+			c.String(500, "%v", err)
+			return
+		}
+		timeline, err := api.volumeTimeline(c, objs)
+		if err != nil {
+			c.String(500, "%v", err)
+			return
+		}
+		result := make([]TimelineEvent, 0, len(timeline))
+		for _, e := range timeline {
+			if (eventType != "" && e.Type != eventType) || e.Time.Before(since) {
+				continue
+			}
+			result = append(result, e)
+		}
+		c.IndentedJSON(200, result)
+	}
+}
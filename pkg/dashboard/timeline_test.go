@@ -0,0 +1,138 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount/builder"
+)
+
+func TestVolumeTimeline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "default"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: "pvc-1"},
+	}
+	pv := &corev1.PersistentVolume{
+		// fake client does not ignore namespace of cluster scoped objects as cache does
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: "kube-system"},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: "default", Name: "data"},
+			PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{
+				Driver:       config.DriverName,
+				VolumeHandle: "pvc-1",
+			}},
+		},
+	}
+	app := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			Volumes: []corev1.Volume{{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}},
+			}},
+		},
+	}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}}
+	mountPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "juicefs-node-1-pvc-1-abcdef",
+			Namespace: "kube-system",
+			Labels:    map[string]string{config.PodTypeKey: config.PodTypeValue, config.PodUniqueIdLabelKey: "pvc-1"},
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+	}
+	job := builder.GenJobNameByVolumeId("pvc-1") + "-createvol"
+
+	start := time.Now().Add(-time.Hour)
+	var events []runtime.Object
+	newEvent := func(minutes int, kind, namespace, name, reason string) {
+		ts := metav1.NewTime(start.Add(time.Duration(minutes) * time.Minute))
+		eventNamespace := namespace
+		if eventNamespace == "" {
+			eventNamespace = "default"
+		}
+		events = append(events, &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name + "." + reason,
+				Namespace: eventNamespace,
+				UID:       types.UID(name + "." + reason),
+			},
+			InvolvedObject: corev1.ObjectReference{Kind: kind, Namespace: namespace, Name: name},
+			Reason:         reason,
+			Type:           corev1.EventTypeNormal,
+			FirstTimestamp: ts,
+			LastTimestamp:  ts,
+		})
+	}
+	newEvent(0, "PersistentVolumeClaim", "default", "data", "Provisioning")
+	newEvent(1, "Job", "kube-system", job, "SuccessfulCreate")
+	newEvent(2, "Pod", "kube-system", job+"-xyz12", "Started")
+	newEvent(3, "PersistentVolumeClaim", "default", "data", "ProvisioningSucceeded")
+	newEvent(4, "Pod", "default", "app", "Scheduled")
+	// deleted mount pod on another node
+	newEvent(5, "Pod", "kube-system", "juicefs-node-2-pvc-1-ghijkl", "Killing")
+	newEvent(6, "Pod", "kube-system", mountPod.Name, "Started")
+	newEvent(7, "Pod", "default", "other", "Scheduled")
+	newEvent(8, "Pod", "kube-system", "juicefs-node-1-pvc-10-abcdef", "Started")
+
+	objs := []runtime.Object{pvc, pv, app, other, mountPod}
+	api := NewAPI(context.TODO(), "kube-system", crfake.NewClientBuilder().WithRuntimeObjects(objs...).Build(), fake.NewSimpleClientset(events...))
+	router := gin.New()
+	api.Handle(router.Group("/api/v1"))
+
+	for _, url := range []string{"/api/v1/pvc/default/data/timeline", "/api/v1/pv/pvc-1/timeline"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		router.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatalf("%s: expected code 200, got %d: %s", url, w.Code, w.Body.String())
+		}
+		var timeline []TimelineEvent
+		if err := json.Unmarshal(w.Body.Bytes(), &timeline); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range timeline {
+			got = append(got, e.Role+":"+e.Reason)
+		}
+		want := []string{"pvc:Provisioning", "job:SuccessfulCreate", "job:Started", "pvc:ProvisioningSucceeded", "app:Scheduled", "mount:Killing", "mount:Started"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected timeline %v, got %v", url, want, got)
+		}
+		if len(timeline) == len(want) && timeline[6].Node != "node-1" {
+			t.Errorf("%s: expected node of mount pod event, got %+v", url, timeline[6])
+		}
+	}
+}
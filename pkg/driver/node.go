@@ -31,6 +31,7 @@ import (
 	k8sexec "k8s.io/utils/exec"
 	"k8s.io/utils/mount"
 
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
 	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs"
 	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
 	"github.com/juicedata/juicefs-csi-driver/pkg/util"
@@ -78,6 +79,12 @@ func newNodeService(nodeID string, k8sClient *k8sclient.K8sClient, reg prometheu
 	}
 	metrics := newNodeMetrics(reg)
 	jfsProvider := juicefs.NewJfsProvider(mounter, k8sClient)
+	if config.ByProcess {
+		// recover mounts before serving, or new mounts may be taken as orphaned
+		if err := jfsProvider.RecoverProcessMounts(context.TODO()); err != nil {
+			klog.Errorf("Recover process mounts error: %v", err)
+		}
+	}
 	return &nodeService{
 		SafeFormatAndMount: *mounter,
 		juicefs:            jfsProvider,
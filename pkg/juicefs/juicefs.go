@@ -71,6 +71,8 @@ type Interface interface {
 	CreateTarget(ctx context.Context, target string) error
 	AuthFs(ctx context.Context, secrets map[string]string, jfsSetting *config.JfsSetting, force bool) (string, error)
 	Status(ctx context.Context, metaUrl string) error
+	CheckStorage(ctx context.Context, secrets map[string]string, jfsSetting *config.JfsSetting) error
+	RecoverProcessMounts(ctx context.Context) error
 }
 
 type juicefs struct {
@@ -384,13 +386,21 @@ func (j *juicefs) Settings(ctx context.Context, volumeID string, secrets, volCtx
 		return nil, err
 	}
 	jfsSetting.VolumeId = volumeID
+	if err := j.genFormatCmd(ctx, secrets, jfsSetting); err != nil {
+		return nil, err
+	}
+	return jfsSetting, nil
+}
+
+// genFormatCmd sets format (CE) or auth (EE) command of the setting, which is run in mount pod
+func (j *juicefs) genFormatCmd(ctx context.Context, secrets map[string]string, jfsSetting *config.JfsSetting) error {
 	if !jfsSetting.IsCe {
 		if secrets["token"] == "" {
 			klog.V(5).Infof("token is empty, skip authfs.")
 		} else {
 			res, err := j.AuthFs(ctx, secrets, jfsSetting, false)
 			if err != nil {
-				return nil, fmt.Errorf("juicefs auth error: %v", err)
+				return fmt.Errorf("juicefs auth error: %v", err)
 			}
 			jfsSetting.FormatCmd = res
 		}
@@ -404,11 +414,20 @@ func (j *juicefs) Settings(ctx context.Context, volumeID string, secrets, volCtx
 		}
 		res, err := j.ceFormat(ctx, secrets, noUpdate, jfsSetting)
 		if err != nil {
-			return nil, fmt.Errorf("juicefs format error: %v", err)
+			return fmt.Errorf("juicefs format error: %v", err)
 		}
 		jfsSetting.FormatCmd = res
 	}
-	return jfsSetting, nil
+	return nil
+}
+
+// GenFormatCmd sets format or auth command of the setting as CSI node does when mounting by pod,
+// without running any command.
+func GenFormatCmd(ctx context.Context, secrets map[string]string, jfsSetting *config.JfsSetting) error {
+	if config.ByProcess {
+		return fmt.Errorf("format command is run directly in process mode")
+	}
+	return (&juicefs{}).genFormatCmd(ctx, secrets, jfsSetting)
 }
 
 // genJfsSettings get jfs settings and unique id
@@ -439,6 +458,7 @@ func (j *juicefs) genJfsSettings(ctx context.Context, volumeID string, target st
 			j.Lock()
 			j.UUIDMaps[uniqueId] = uuid
 			j.CacheDirMaps[uniqueId] = jfsSetting.CacheDirs
+			j.saveState()
 			j.Unlock()
 		}
 		klog.V(6).Infof("Get uuid of volume [%s]: %s", volumeID, uuid)
@@ -530,6 +550,12 @@ func (j *juicefs) validTarget(target string) error {
 	return nil
 }
 
+// ValidateMountOptions checks mount options in the same way as mounting volume
+func ValidateMountOptions(options []string, volCtx map[string]string) error {
+	_, err := (&juicefs{}).validOptions("", options, volCtx)
+	return err
+}
+
 func (j *juicefs) validOptions(volumeId string, options []string, volCtx map[string]string) ([]string, error) {
 	mountOptions := []string{}
 	for _, option := range options {
@@ -596,6 +622,7 @@ func (j *juicefs) JfsUnmount(ctx context.Context, volumeId, mountPath string) er
 				}
 				delete(j.UUIDMaps, uniqueId)
 				delete(j.CacheDirMaps, uniqueId)
+				j.saveState()
 
 				klog.V(5).Infof("Cleanup cache of volume %s in node %s", uniqueId, config.NodeName)
 				// clean cache should be done even when top context timeout
@@ -697,6 +724,11 @@ func (j *juicefs) JfsCleanupMountPoint(ctx context.Context, mountPath string) er
 
 // AuthFs authenticates JuiceFS, enterprise edition only
 func (j *juicefs) AuthFs(ctx context.Context, secrets map[string]string, setting *config.JfsSetting, force bool) (string, error) {
+	return j.authFs(ctx, secrets, setting, force, false)
+}
+
+// authFs authenticates JuiceFS, object storage is only checked when checkStorage is true
+func (j *juicefs) authFs(ctx context.Context, secrets map[string]string, setting *config.JfsSetting, force, checkStorage bool) (string, error) {
 	if secrets == nil {
 		return "", status.Errorf(codes.InvalidArgument, "Nil secrets")
 	}
@@ -805,7 +837,9 @@ func (j *juicefs) AuthFs(ctx context.Context, secrets map[string]string, setting
 	for key, val := range setting.Envs {
 		envs = append(envs, fmt.Sprintf("%s=%s", security.EscapeBashStr(key), security.EscapeBashStr(val)))
 	}
-	envs = append(envs, "JFS_NO_CHECK_OBJECT_STORAGE=1")
+	if !checkStorage {
+		envs = append(envs, "JFS_NO_CHECK_OBJECT_STORAGE=1")
+	}
 	authCmd.SetEnv(envs)
 	res, err := authCmd.CombinedOutput()
 	klog.Infof("Auth output is %s", res)
@@ -1066,6 +1100,98 @@ func (j *juicefs) ceFormat(ctx context.Context, secrets map[string]string, noUpd
 	return string(res), nil
 }
 
+// CheckStorage checks access to object storage of JuiceFS with a small read/write test.
+// Community edition runs objbench against the bucket in secrets, enterprise edition runs auth with object storage checked.
+func (j *juicefs) CheckStorage(ctx context.Context, secrets map[string]string, setting *config.JfsSetting) error {
+	if secrets == nil {
+		return status.Errorf(codes.InvalidArgument, "Nil secrets")
+	}
+	if !setting.IsCe {
+		s := make(map[string]string, len(secrets))
+		for k, v := range secrets {
+			s[k] = v
+		}
+		_, err := j.authFs(ctx, s, setting, true, true)
+		return err
+	}
+
+	if secrets["bucket"] == "" {
+		return status.Errorf(codes.InvalidArgument, "Empty bucket")
+	}
+	// objects as few and small as possible, only to check whether the bucket is accessible
+	args := []string{"objbench", "--block-size=1024", "--big-object-size=1", "--small-object-size=4", "--small-objects=1", "--threads=1"}
+	cmdArgs := []string{config.CeCliPath, "objbench", "--block-size=1024", "--big-object-size=1", "--small-object-size=4", "--small-objects=1", "--threads=1"}
+	for _, k := range []string{"storage", "access-key"} {
+		if secrets[k] != "" {
+			v := security.EscapeBashStr(secrets[k])
+			cmdArgs = append(cmdArgs, fmt.Sprintf("--%s=%s", k, v))
+			args = append(args, fmt.Sprintf("--%s=%s", k, v))
+		}
+	}
+	if secrets["secret-key"] != "" {
+		cmdArgs = append(cmdArgs, "--secret-key=${secretkey}")
+		args = append(args, fmt.Sprintf("--secret-key=%s", security.EscapeBashStr(secrets["secret-key"])))
+	}
+	cmdArgs = append(cmdArgs, security.EscapeBashStr(secrets["bucket"]))
+	args = append(args, security.EscapeBashStr(secrets["bucket"]))
+	klog.V(5).Infof("CheckStorage cmd: %v", cmdArgs)
+
+	benchCmd := j.Exec.CommandContext(ctx, config.CeCliPath, args...)
+	envs := syscall.Environ()
+	for key, val := range setting.Envs {
+		envs = append(envs, fmt.Sprintf("%s=%s", security.EscapeBashStr(key), security.EscapeBashStr(val)))
+	}
+	benchCmd.SetEnv(envs)
+	res, err := benchCmd.CombinedOutput()
+	klog.V(5).Infof("Objbench output is %s", res)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("juicefs objbench timed out")
+	}
+	if err != nil {
+		return errors.Wrap(err, string(res))
+	}
+	if failures := objbenchFailures(string(res)); len(failures) != 0 {
+		return fmt.Errorf("object storage check failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+var ansiColor = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// objbenchFailures parses result table of functional tests in output of objbench:
+//
+//	| CATEGORY |       TEST       |  RESULT  |
+//	|    basic |  create a bucket |     pass |
+//
+// tests not passed and supported are returned as "<test>: <result>"
+func objbenchFailures(output string) []string {
+	var failures []string
+	inTable := false
+	for _, line := range strings.Split(ansiColor.ReplaceAllString(output, ""), "\n") {
+		line = strings.TrimSpace(line)
+		if !inTable {
+			inTable = strings.HasPrefix(line, "|") && strings.Contains(line, "CATEGORY")
+			continue
+		}
+		if strings.HasPrefix(line, "+") {
+			continue
+		}
+		if !strings.HasPrefix(line, "|") {
+			break
+		}
+		fields := strings.Split(strings.Trim(line, "|"), "|")
+		if len(fields) < 3 {
+			continue
+		}
+		test, result := strings.TrimSpace(fields[1]), strings.TrimSpace(fields[2])
+		if result == "pass" || result == "skip" || strings.HasPrefix(result, "not support") {
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", test, result))
+	}
+	return failures
+}
+
 // Status checks the status of JuiceFS, only for community edition
 func (j *juicefs) Status(ctx context.Context, metaUrl string) error {
 	args := []string{"status", metaUrl}
@@ -1481,3 +1481,25 @@ func Test_clientVersion_LessThan(t *testing.T) {
 		})
 	}
 }
+
+func Test_objbenchFailures(t *testing.T) {
+	output := `Start Functional Testing ...
++----------+---------------------+------------------------------------+
+| CATEGORY |         TEST        |               RESULT               |
++----------+---------------------+------------------------------------+
+|    basic |     create a bucket |                               pass |
+|    basic |       put an object |  failed to put object: AccessDenied |
+|     sync |     list objects    |                        not support |
+|  failed? |  check "failed" key |                               pass |
++----------+---------------------+------------------------------------+
+Start Performance Testing ...
+| upload objects | failed | 1 ms/object |
+`
+	want := []string{"put an object: failed to put object: AccessDenied"}
+	if got := objbenchFailures(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("objbenchFailures() = %v, want %v", got, want)
+	}
+	if got := objbenchFailures("\x1b[1;32mpass\x1b[0m"); len(got) != 0 {
+		t.Errorf("objbenchFailures() = %v, want none without result table", got)
+	}
+}
@@ -52,6 +52,20 @@ func (mr *MockInterfaceMockRecorder) AuthFs(arg0, arg1, arg2, arg3 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthFs", reflect.TypeOf((*MockInterface)(nil).AuthFs), arg0, arg1, arg2, arg3)
 }
 
+// CheckStorage mocks base method.
+func (m *MockInterface) CheckStorage(arg0 context.Context, arg1 map[string]string, arg2 *config.JfsSetting) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckStorage", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CheckStorage indicates an expected call of CheckStorage.
+func (mr *MockInterfaceMockRecorder) CheckStorage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckStorage", reflect.TypeOf((*MockInterface)(nil).CheckStorage), arg0, arg1, arg2)
+}
+
 // CreateTarget mocks base method.
 func (m *MockInterface) CreateTarget(arg0 context.Context, arg1 string) error {
 	m.ctrl.T.Helper()
@@ -240,6 +254,20 @@ func (mr *MockInterfaceMockRecorder) MountSensitive(arg0, arg1, arg2, arg3, arg4
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MountSensitive", reflect.TypeOf((*MockInterface)(nil).MountSensitive), arg0, arg1, arg2, arg3, arg4)
 }
 
+// RecoverProcessMounts mocks base method.
+func (m *MockInterface) RecoverProcessMounts(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecoverProcessMounts", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RecoverProcessMounts indicates an expected call of RecoverProcessMounts.
+func (mr *MockInterfaceMockRecorder) RecoverProcessMounts(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecoverProcessMounts", reflect.TypeOf((*MockInterface)(nil).RecoverProcessMounts), arg0)
+}
+
 // SetQuota mocks base method.
 func (m *MockInterface) SetQuota(arg0 context.Context, arg1 map[string]string, arg2 *config.JfsSetting, arg3 string, arg4 int64) error {
 	m.ctrl.T.Helper()
@@ -17,15 +17,12 @@
 package builder
 
 import (
-	"fmt"
-	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	utilpointer "k8s.io/utils/pointer"
 
 	"github.com/juicedata/juicefs-csi-driver/pkg/config"
-	"github.com/juicedata/juicefs-csi-driver/pkg/util/security"
 )
 
 const (
@@ -64,26 +61,7 @@ func (r *CCIBuilder) NewMountSidecar() *corev1.Pod {
 	pod := r.genCommonJuicePod(r.genNonPrivilegedContainer)
 
 	// check mount & create subpath & set quota
-	capacity := strconv.FormatInt(r.capacity, 10)
-	subpath := r.jfsSetting.SubPath
-	community := "ce"
-	if !r.jfsSetting.IsCe {
-		community = "ee"
-	}
-	quotaPath := r.getQuotaPath()
-	name := r.jfsSetting.Name
-	pod.Spec.Containers[0].Lifecycle.PostStart = &corev1.Handler{
-		Exec: &corev1.ExecAction{Command: []string{"bash", "-c",
-			fmt.Sprintf("time subpath=%s name=%s capacity=%s community=%s quotaPath=%s %s '%s' >> /proc/1/fd/1",
-				security.EscapeBashStr(subpath),
-				security.EscapeBashStr(name),
-				capacity,
-				community,
-				security.EscapeBashStr(quotaPath),
-				checkMountScriptPath,
-				security.EscapeBashStr(r.jfsSetting.MountPath),
-			)}},
-	}
+	pod.Spec.Containers[0].Lifecycle.PostStart = r.genCheckMountPostStart()
 	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, []corev1.EnvVar{
 		{Name: "JFS_NO_UMOUNT", Value: "1"},
 		{Name: "JFS_FOREGROUND", Value: "1"},
@@ -177,6 +177,24 @@ func (r *BaseBuilder) genInitCommand() string {
 	return formatCmd
 }
 
+// genCheckMountCommand generates command of postStart hook, which waits until mountPath is ready,
+// then creates subPath of the volume in it and sets quota of the subPath
+func (r *BaseBuilder) genCheckMountCommand(mountPath string) string {
+	community := "ce"
+	if !r.jfsSetting.IsCe {
+		community = "ee"
+	}
+	return fmt.Sprintf("time subpath=%s name=%s capacity=%s community=%s quotaPath=%s %s '%s' >> /proc/1/fd/1",
+		security.EscapeBashStr(r.jfsSetting.SubPath),
+		security.EscapeBashStr(r.jfsSetting.Name),
+		strconv.FormatInt(r.capacity, 10),
+		community,
+		security.EscapeBashStr(r.getQuotaPath()),
+		checkMountScriptPath,
+		security.EscapeBashStr(mountPath),
+	)
+}
+
 func (r *BaseBuilder) getQuotaPath() string {
 	quotaPath := r.jfsSetting.SubPath
 	var subdir string
@@ -17,16 +17,13 @@
 package builder
 
 import (
-	"fmt"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	utilpointer "k8s.io/utils/pointer"
 
 	"github.com/juicedata/juicefs-csi-driver/pkg/config"
-	"github.com/juicedata/juicefs-csi-driver/pkg/util/security"
 )
 
 type ContainerBuilder struct {
@@ -57,25 +54,8 @@ func (r *ContainerBuilder) NewMountSidecar() *corev1.Pod {
 	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, volumeMounts...)
 
 	// check mount & create subpath & set quota
-	capacity := strconv.FormatInt(r.capacity, 10)
-	subpath := r.jfsSetting.SubPath
-	community := "ce"
-	if !r.jfsSetting.IsCe {
-		community = "ee"
-	}
-	quotaPath := r.getQuotaPath()
-	name := r.jfsSetting.Name
 	pod.Spec.Containers[0].Lifecycle.PostStart = &corev1.Handler{
-		Exec: &corev1.ExecAction{Command: []string{"bash", "-c",
-			fmt.Sprintf("time subpath=%s name=%s capacity=%s community=%s quotaPath=%s %s '%s' >> /proc/1/fd/1",
-				security.EscapeBashStr(subpath),
-				security.EscapeBashStr(name),
-				capacity,
-				community,
-				security.EscapeBashStr(quotaPath),
-				checkMountScriptPath,
-				security.EscapeBashStr(r.jfsSetting.MountPath),
-			)}},
+		Exec: &corev1.ExecAction{Command: []string{"bash", "-c", r.genCheckMountCommand(r.jfsSetting.MountPath)}},
 	}
 
 	// overwrite subdir
@@ -164,6 +164,22 @@ func (r *JobBuilder) getDeleteVolumeCmd() string {
 }
 
 func NewFuseAbortJob(mountpod *corev1.Pod, devMinor uint32) *batchv1.Job {
+	return newFuseAbortJob(mountpod, fmt.Sprintf(
+		"if [ $(cat /sys/fs/fuse/connections/%d/waiting) -gt 0 ]; then echo 1 > /sys/fs/fuse/connections/%d/abort; fi;",
+		devMinor, devMinor), false)
+}
+
+// NewFuseAbortJobOfMountPoint generates a job to abort fuse connection of mount point on node of mount pod,
+// dev minor of the connection is looked up in mountinfo of host, so that the mount point is never accessed.
+func NewFuseAbortJobOfMountPoint(mountpod *corev1.Pod, mountPoint string) *batchv1.Job {
+	return newFuseAbortJob(mountpod, fmt.Sprintf(
+		"minor=$(awk -v mp=%s '$5 == mp {split($3, dev, \":\"); print dev[2]; exit}' /proc/1/mountinfo); "+
+			"if [ -z \"$minor\" ]; then echo \"mount point not found\"; exit 1; fi; "+
+			"if [ $(cat /sys/fs/fuse/connections/$minor/waiting) -gt 0 ]; then echo 1 > /sys/fs/fuse/connections/$minor/abort; fi;",
+		security.EscapeBashStr(mountPoint)), true)
+}
+
+func newFuseAbortJob(mountpod *corev1.Pod, cmd string, hostPID bool) *batchv1.Job {
 	jobName := fmt.Sprintf("%s-abort-fuse", GenJobNameByVolumeId(mountpod.Name))
 	ttlSecond := DefaultJobTTLSecond
 	privileged := true
@@ -181,13 +197,7 @@ func NewFuseAbortJob(mountpod *corev1.Pod, devMinor uint32) *batchv1.Job {
 							Name:            "fuse-abort",
 							Image:           mountpod.Spec.Containers[0].Image,
 							ImagePullPolicy: corev1.PullIfNotPresent,
-							Command: []string{
-								"sh",
-								"-c",
-								fmt.Sprintf(
-									"if [ $(cat /sys/fs/fuse/connections/%d/waiting) -gt 0 ]; then echo 1 > /sys/fs/fuse/connections/%d/abort; fi;",
-									devMinor, devMinor),
-							},
+							Command:         []string{"sh", "-c", cmd},
 							SecurityContext: &corev1.SecurityContext{
 								Privileged: &privileged,
 							},
@@ -200,6 +210,7 @@ func NewFuseAbortJob(mountpod *corev1.Pod, devMinor uint32) *batchv1.Job {
 						},
 					},
 					NodeName:      mountpod.Spec.NodeName,
+					HostPID:       hostPID,
 					RestartPolicy: corev1.RestartPolicyNever,
 					Volumes: []corev1.Volume{
 						{
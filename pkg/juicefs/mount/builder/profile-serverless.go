@@ -0,0 +1,204 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	utilpointer "k8s.io/utils/pointer"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+// ProfileBuilder builds juicefs sidecar for serverless platforms described by config.ServerlessProfile
+type ProfileBuilder struct {
+	ServerlessBuilder
+	profile config.ServerlessProfile
+	pvc     corev1.PersistentVolumeClaim
+	app     corev1.Pod
+}
+
+var _ SidecarInterface = &ProfileBuilder{}
+
+func NewProfileBuilder(setting *config.JfsSetting, capacity int64, profile config.ServerlessProfile, app corev1.Pod, pvc corev1.PersistentVolumeClaim) SidecarInterface {
+	return &ProfileBuilder{
+		ServerlessBuilder: ServerlessBuilder{PodBuilder{BaseBuilder{
+			jfsSetting: setting,
+			capacity:   capacity,
+		}}},
+		profile: profile,
+		pvc:     pvc,
+		app:     app,
+	}
+}
+
+// NewMountSidecar generates a pod with a juicefs sidecar in serverless mode as the profile describes
+// 1. privileged container, or container with capabilities of the profile
+// 2. mount point propagated through hostPath, emptyDir or csi volume of the platform
+// 3. with env JFS_NO_UMOUNT=1 if mount point is not propagated through hostPath
+// 4. annotations and envs of the profile
+func (r *ProfileBuilder) NewMountSidecar() *corev1.Pod {
+	pod := r.genCommonJuicePod(r.genProfileContainer)
+
+	// no label for sidecar, annotations are only those of the profile
+	mountContainerName := r.genMountContainerName()
+	pod.Labels = map[string]string{}
+	pod.Annotations = map[string]string{}
+	replacer := strings.NewReplacer("${MOUNT_PATH}", r.jfsSetting.MountPath, "${MOUNT_CONTAINER}", mountContainerName)
+	for k, v := range r.profile.Annotations {
+		pod.Annotations[k] = replacer.Replace(v)
+	}
+
+	pod.Spec.Containers[0].Name = mountContainerName
+	// check mount & create subpath & set quota
+	pod.Spec.Containers[0].Lifecycle.PostStart = r.genCheckMountPostStart()
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "JFS_FOREGROUND", Value: "1"})
+	if r.profile.GetMountPropagation() != config.ServerlessPropagationHostPath {
+		// mount point can not be umounted by app containers
+		pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "JFS_NO_UMOUNT", Value: "1"})
+	}
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, r.profile.Env...)
+
+	// generate volumes and volumeMounts of the mount propagation
+	volumes, volumeMounts := r.genProfileVolumes()
+	pod.Spec.Volumes = append(pod.Spec.Volumes, volumes...)
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, volumeMounts...)
+
+	// add cache-dir PVC volume
+	cacheVolumes, cacheVolumeMounts := r.genCacheDirVolumes()
+	pod.Spec.Volumes = append(pod.Spec.Volumes, cacheVolumes...)
+	pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts, cacheVolumeMounts...)
+
+	// overwrite subdir
+	r.overwriteSubdirWithSubPath()
+
+	// command
+	mountCmd := r.genMountCommand()
+	initCmd := r.genInitCommand()
+	cmd := strings.Join([]string{initCmd, mountCmd}, "\n")
+	pod.Spec.Containers[0].Command = []string{"sh", "-c", cmd}
+
+	return pod
+}
+
+func (r *ProfileBuilder) OverwriteVolumes(volume *corev1.Volume, mountPath string) {
+	switch r.profile.GetMountPropagation() {
+	case config.ServerlessPropagationEmptyDir:
+		volume.VolumeSource = corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		}
+	case config.ServerlessPropagationCSI:
+		csi := r.profile.CSI
+		attributes := make(map[string]string, len(csi.VolumeAttributes)+1)
+		for k, v := range csi.VolumeAttributes {
+			attributes[k] = v
+		}
+		if csi.MountPointAttribute != "" {
+			attributes[csi.MountPointAttribute] = mountPath
+		}
+		volume.VolumeSource = corev1.VolumeSource{
+			CSI: &corev1.CSIVolumeSource{
+				Driver:           csi.Driver,
+				FSType:           csi.FSType,
+				VolumeAttributes: attributes,
+			},
+		}
+	default:
+		r.ServerlessBuilder.OverwriteVolumes(volume, mountPath)
+	}
+}
+
+func (r *ProfileBuilder) OverwriteVolumeMounts(mount *corev1.VolumeMount) {
+	if r.profile.AppMountPropagation != nil {
+		mp := *r.profile.AppMountPropagation
+		mount.MountPropagation = &mp
+	}
+}
+
+// genProfileVolumes generates volumes and volumeMounts for sidecar of the profile
+// 1. jfs dir: mount point as hostPath, only if mount point is propagated through hostPath
+// 2. volume of app pod shared with sidecar: mounted at mount point, only if mount point is propagated through emptyDir
+// 3. jfs-check-mount: secret volume, used to check if the mount point is mounted
+func (r *ProfileBuilder) genProfileVolumes() ([]corev1.Volume, []corev1.VolumeMount) {
+	if r.profile.GetMountPropagation() == config.ServerlessPropagationHostPath {
+		return r.genServerlessVolumes()
+	}
+
+	var mode int32 = 0755
+	volumes := []corev1.Volume{
+		{
+			Name: "jfs-check-mount",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  r.jfsSetting.SecretName,
+					DefaultMode: utilpointer.Int32Ptr(mode),
+				},
+			},
+		},
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "jfs-check-mount",
+			MountPath: checkMountScriptPath,
+			SubPath:   checkMountScriptName,
+		},
+	}
+	if r.profile.GetMountPropagation() == config.ServerlessPropagationEmptyDir {
+		for _, volume := range r.app.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == r.pvc.Name {
+				volumeMounts = append(volumeMounts, corev1.VolumeMount{
+					Name:      volume.Name,
+					MountPath: r.jfsSetting.MountPath,
+				})
+				break
+			}
+		}
+	}
+	return volumes, volumeMounts
+}
+
+func (r *ProfileBuilder) genProfileContainer() corev1.Container {
+	if r.profile.Privileged {
+		return r.genCommonContainer()
+	}
+	rootUser := int64(0)
+	container := corev1.Container{
+		Name:  config.MountContainerName,
+		Image: r.BaseBuilder.jfsSetting.Attr.Image,
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser: &rootUser,
+		},
+		Env: []corev1.EnvVar{
+			{
+				Name:  config.JfsInsideContainer,
+				Value: "1",
+			},
+		},
+	}
+	if len(r.profile.Capabilities) != 0 {
+		container.SecurityContext.Capabilities = &corev1.Capabilities{
+			Add: append([]corev1.Capability{}, r.profile.Capabilities...),
+		}
+	}
+	return container
+}
+
+func (r *ProfileBuilder) genMountContainerName() string {
+	return fmt.Sprintf("%s-%s", config.MountContainerName, r.pvc.Name)
+}
@@ -22,6 +22,8 @@ import (
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
 )
 
 const (
@@ -63,40 +65,9 @@ fi
 )
 
 func (r *BaseBuilder) NewSecret() corev1.Secret {
-	data := make(map[string]string)
-	if r.jfsSetting.MetaUrl != "" {
-		data["metaurl"] = r.jfsSetting.MetaUrl
-	}
-	if r.jfsSetting.SecretKey != "" {
-		data["secretkey"] = r.jfsSetting.SecretKey
-	}
-	if r.jfsSetting.SecretKey2 != "" {
-		data["secretkey2"] = r.jfsSetting.SecretKey2
-	}
-	if r.jfsSetting.Token != "" {
-		data["token"] = r.jfsSetting.Token
-	}
-	if r.jfsSetting.Passphrase != "" {
-		data["passphrase"] = r.jfsSetting.Passphrase
-	}
-	if r.jfsSetting.EncryptRsaKey != "" {
-		data["encrypt_rsa_key"] = r.jfsSetting.EncryptRsaKey
-	}
-	if r.jfsSetting.InitConfig != "" {
-		data["initconfig"] = r.jfsSetting.InitConfig
-	}
+	data := GenCredentialData(r.jfsSetting)
 	replacer := strings.NewReplacer("¬", "`")
 	data[checkMountScriptName] = replacer.Replace(checkMountScriptContent)
-	if options, err := r.jfsSetting.ParseFormatOptions(); err == nil {
-		for _, pair := range options {
-			if pair[0] == "session-token" {
-				data["session-token"] = pair[1]
-			}
-		}
-	}
-	for k, v := range r.jfsSetting.Envs {
-		data[k] = v
-	}
 	secret := corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: r.jfsSetting.Attr.Namespace,
@@ -139,3 +110,40 @@ func SetJobAsOwner(secret *corev1.Secret, owner batchv1.Job) {
 		Controller: &controller,
 	}})
 }
+
+// GenCredentialData generates credentials of volume used by juicefs client, which are passed as envs
+func GenCredentialData(setting *config.JfsSetting) map[string]string {
+	data := make(map[string]string)
+	if setting.MetaUrl != "" {
+		data["metaurl"] = setting.MetaUrl
+	}
+	if setting.SecretKey != "" {
+		data["secretkey"] = setting.SecretKey
+	}
+	if setting.SecretKey2 != "" {
+		data["secretkey2"] = setting.SecretKey2
+	}
+	if setting.Token != "" {
+		data["token"] = setting.Token
+	}
+	if setting.Passphrase != "" {
+		data["passphrase"] = setting.Passphrase
+	}
+	if setting.EncryptRsaKey != "" {
+		data["encrypt_rsa_key"] = setting.EncryptRsaKey
+	}
+	if setting.InitConfig != "" {
+		data["initconfig"] = setting.InitConfig
+	}
+	if options, err := setting.ParseFormatOptions(); err == nil {
+		for _, pair := range options {
+			if pair[0] == "session-token" {
+				data["session-token"] = pair[1]
+			}
+		}
+	}
+	for k, v := range setting.Envs {
+		data[k] = v
+	}
+	return data
+}
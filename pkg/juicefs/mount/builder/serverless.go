@@ -19,14 +19,12 @@ package builder
 import (
 	"fmt"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	utilpointer "k8s.io/utils/pointer"
 
 	"github.com/juicedata/juicefs-csi-driver/pkg/config"
-	"github.com/juicedata/juicefs-csi-driver/pkg/util/security"
 )
 
 type ServerlessBuilder struct {
@@ -56,26 +54,7 @@ func (r *ServerlessBuilder) NewMountSidecar() *corev1.Pod {
 	pod.Labels = map[string]string{}
 
 	// check mount & create subpath & set quota
-	capacity := strconv.FormatInt(r.capacity, 10)
-	subpath := r.jfsSetting.SubPath
-	community := "ce"
-	if !r.jfsSetting.IsCe {
-		community = "ee"
-	}
-	quotaPath := r.getQuotaPath()
-	name := r.jfsSetting.Name
-	pod.Spec.Containers[0].Lifecycle.PostStart = &corev1.Handler{
-		Exec: &corev1.ExecAction{Command: []string{"bash", "-c",
-			fmt.Sprintf("time subpath=%s name=%s capacity=%s community=%s quotaPath=%s %s '%s' >> /proc/1/fd/1",
-				security.EscapeBashStr(subpath),
-				security.EscapeBashStr(name),
-				capacity,
-				community,
-				security.EscapeBashStr(quotaPath),
-				checkMountScriptPath,
-				security.EscapeBashStr(r.jfsSetting.MountPath),
-			)}},
-	}
+	pod.Spec.Containers[0].Lifecycle.PostStart = r.genCheckMountPostStart()
 	pod.Spec.Containers[0].Env = []corev1.EnvVar{{
 		Name:  "JFS_FOREGROUND",
 		Value: "1",
@@ -103,6 +82,13 @@ func (r *ServerlessBuilder) NewMountSidecar() *corev1.Pod {
 	return pod
 }
 
+// genCheckMountPostStart checks mount, creates subpath and sets quota after juicefs client starts
+func (r *ServerlessBuilder) genCheckMountPostStart() *corev1.Handler {
+	return &corev1.Handler{
+		Exec: &corev1.ExecAction{Command: []string{"bash", "-c", r.genCheckMountCommand(r.jfsSetting.MountPath)}},
+	}
+}
+
 func (r *ServerlessBuilder) OverwriteVolumes(volume *corev1.Volume, mountPath string) {
 	// overwrite original volume and use juicefs volume mountpoint instead
 	hostMount := filepath.Join(config.MountPointPath, mountPath)
@@ -0,0 +1,68 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package builder
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util/security"
+)
+
+// SharedSubPath is subPath of a PVC served by juicefs sidecar shared by PVCs of the same filesystem
+type SharedSubPath struct {
+	Setting *config.JfsSetting
+	// capacity of PVC in GiB
+	Capacity int64
+}
+
+// SetSharedSubPaths makes juicefs sidecar which mounts root of filesystem create subPaths of PVCs sharing it
+// after mount point is ready, and set quota of each subPath. The commands are added to postStart hook of the sidecar,
+// after the existing ones checking mount point.
+func SetSharedSubPaths(mountPod *corev1.Pod, setting *config.JfsSetting, subPaths []SharedSubPath) {
+	var cmds []string
+	for _, s := range subPaths {
+		if s.Setting.SubPath == "" {
+			continue
+		}
+		r := BaseBuilder{jfsSetting: s.Setting, capacity: s.Capacity}
+		cmds = append(cmds,
+			fmt.Sprintf("mkdir -p %s", security.EscapeBashStr(path.Join(setting.MountPath, s.Setting.SubPath))),
+			r.genCheckMountCommand(setting.MountPath))
+	}
+	if len(cmds) == 0 {
+		return
+	}
+	container := &mountPod.Spec.Containers[0]
+	if container.Lifecycle == nil {
+		container.Lifecycle = &corev1.Lifecycle{}
+	}
+	if hook := container.Lifecycle.PostStart; hook != nil && hook.Exec != nil && len(hook.Exec.Command) == 3 {
+		hook.Exec.Command[2] = strings.Join(append([]string{hook.Exec.Command[2]}, cmds...), " && ")
+		return
+	}
+	// mount point must be ready before creating subPaths
+	root := BaseBuilder{jfsSetting: &config.JfsSetting{IsCe: setting.IsCe, Name: setting.Name}}
+	container.Lifecycle.PostStart = &corev1.Handler{
+		Exec: &corev1.ExecAction{Command: []string{"bash", "-c",
+			strings.Join(append([]string{root.genCheckMountCommand(setting.MountPath)}, cmds...), " && ")}},
+	}
+}
@@ -75,15 +75,15 @@ func (p *PodMount) JMount(ctx context.Context, appInfo *jfsConfig.AppInfo, jfsSe
 	if err != nil {
 		return err
 	}
-	if jfsSetting.CleanCache && jfsSetting.UUID == "" {
-		// need set uuid as label in mount pod for clean cache
-		uuid, err := p.GetJfsVolUUID(ctx, jfsSetting.Source)
+	gcEnabled := jfsConfig.GlobalConfig.CacheGC != nil && jfsConfig.GlobalConfig.CacheGC.Enabled
+	if jfsSetting.UUID == "" && (jfsSetting.CleanCache || gcEnabled) {
+		// need set uuid as annotation in mount pod for clean cache and cache gc
+		err = p.ensureUUIDAnnotation(ctx, podName, jfsSetting)
 		if err != nil {
-			return err
-		}
-		err = p.setUUIDAnnotation(ctx, podName, uuid)
-		if err != nil {
-			return err
+			if jfsSetting.CleanCache {
+				return err
+			}
+			klog.Warningf("JMount: set uuid annotation of mount pod %s error: %v", podName, err)
 		}
 	}
 	return nil
@@ -487,6 +487,25 @@ func (p *PodMount) setUUIDAnnotation(ctx context.Context, podName string, uuid s
 	return util.AddPodAnnotation(ctx, p.K8sClient, pod, map[string]string{jfsConfig.JuiceFSUUID: uuid})
 }
 
+// ensureUUIDAnnotation sets uuid of volume as annotation of mount pod if it's not set yet
+// uuid of enterprise edition is the volume name, community edition gets it from metadata engine
+func (p *PodMount) ensureUUIDAnnotation(ctx context.Context, podName string, jfsSetting *jfsConfig.JfsSetting) error {
+	pod, err := p.K8sClient.GetPod(ctx, podName, jfsConfig.Namespace)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations[jfsConfig.JuiceFSUUID] != "" {
+		return nil
+	}
+	uuid := jfsSetting.Name
+	if jfsSetting.IsCe {
+		if uuid, err = p.GetJfsVolUUID(ctx, jfsSetting.Source); err != nil {
+			return err
+		}
+	}
+	return p.setUUIDAnnotation(ctx, podName, uuid)
+}
+
 func (p *PodMount) setMountLabel(ctx context.Context, uniqueId, mountPodName string, podName, podNamespace string) (err error) {
 	var pod *corev1.Pod
 	pod, err = p.K8sClient.GetPod(context.Background(), podName, podNamespace)
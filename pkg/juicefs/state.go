@@ -0,0 +1,170 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package juicefs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/klog"
+	"k8s.io/utils/mount"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+// processState is the cache bookkeeping of process mounts,
+// persisted in config.ProcessStatePath to survive restarts of csi node.
+type processState struct {
+	UUIDMaps     map[string]string   `json:"uuidMaps"`
+	CacheDirMaps map[string][]string `json:"cacheDirMaps"`
+}
+
+// cacheCleanup is a cache clean task of a volume whose last mount is gone
+type cacheCleanup struct {
+	uniqueId  string
+	uuid      string
+	cacheDirs []string
+}
+
+// loadState loads bookkeeping from state file, caller should hold the lock
+func (j *juicefs) loadState() error {
+	data, err := os.ReadFile(config.ProcessStatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read state file %s error: %v", config.ProcessStatePath, err)
+	}
+	state := processState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("parse state file %s error: %v", config.ProcessStatePath, err)
+	}
+	for k, v := range state.UUIDMaps {
+		j.UUIDMaps[k] = v
+	}
+	for k, v := range state.CacheDirMaps {
+		j.CacheDirMaps[k] = v
+	}
+	return nil
+}
+
+// saveState writes bookkeeping into state file, caller should hold the lock
+func (j *juicefs) saveState() {
+	data, err := json.Marshal(processState{
+		UUIDMaps:     j.UUIDMaps,
+		CacheDirMaps: j.CacheDirMaps,
+	})
+	if err != nil {
+		klog.Errorf("Marshal process state error: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(config.ProcessStatePath), os.FileMode(0755)); err != nil {
+		klog.Errorf("Create dir of state file %s error: %v", config.ProcessStatePath, err)
+		return
+	}
+	// write to a temp file and rename it, so that state file is never half written
+	tmpPath := config.ProcessStatePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		klog.Errorf("Write state file %s error: %v", tmpPath, err)
+		return
+	}
+	if err := os.Rename(tmpPath, config.ProcessStatePath); err != nil {
+		klog.Errorf("Rename state file %s error: %v", tmpPath, err)
+	}
+}
+
+// RecoverProcessMounts reloads cache bookkeeping of process mounts after csi node restarts,
+// and reconciles it with mountinfo:
+// 1. mount points which are still referenced by targets are adopted
+// 2. mount points without any target are unmounted
+// 3. cache of volumes whose last mount is gone is cleaned up
+func (j *juicefs) RecoverProcessMounts(ctx context.Context) error {
+	mountInfos, err := mount.ParseMountInfo(procMountInfoPath)
+	if err != nil {
+		return err
+	}
+	cleanups, err := j.reconcileProcessMounts(mountInfos)
+	for _, c := range cleanups {
+		klog.V(5).Infof("Cleanup cache of volume %s in node %s", c.uniqueId, config.NodeName)
+		// clean cache should be done even when top context timeout
+		go j.processMount.CleanCache(context.TODO(), "", c.uuid, c.uniqueId, c.cacheDirs)
+	}
+	return err
+}
+
+func (j *juicefs) reconcileProcessMounts(mountInfos []mount.MountInfo) ([]cacheCleanup, error) {
+	j.Lock()
+	defer j.Unlock()
+	if err := j.loadState(); err != nil {
+		return nil, err
+	}
+
+	mounted := make(map[string]bool)
+	for _, mi := range mountInfos {
+		if !isProcessMountPoint(mi) {
+			continue
+		}
+		uniqueId := filepath.Base(mi.MountPoint)
+		refs := 0
+		for _, other := range mountInfos {
+			if other.ID != mi.ID && other.Major == mi.Major && other.Minor == mi.Minor && other.FsType == mi.FsType {
+				refs++
+			}
+		}
+		if refs > 0 {
+			klog.Infof("RecoverProcessMounts: adopt mount point %s with %d refs", mi.MountPoint, refs)
+			mounted[uniqueId] = true
+			continue
+		}
+		klog.Infof("RecoverProcessMounts: mount point %s has no refs, umount it", mi.MountPoint)
+		if err := j.Unmount(mi.MountPoint); err != nil {
+			klog.Errorf("RecoverProcessMounts: umount %s error: %v", mi.MountPoint, err)
+			mounted[uniqueId] = true
+		}
+	}
+
+	var cleanups []cacheCleanup
+	for uniqueId, cacheDirs := range j.CacheDirMaps {
+		if mounted[uniqueId] {
+			continue
+		}
+		cleanups = append(cleanups, cacheCleanup{
+			uniqueId:  uniqueId,
+			uuid:      j.UUIDMaps[uniqueId],
+			cacheDirs: cacheDirs,
+		})
+	}
+	for uniqueId := range j.UUIDMaps {
+		if !mounted[uniqueId] {
+			delete(j.UUIDMaps, uniqueId)
+			delete(j.CacheDirMaps, uniqueId)
+		}
+	}
+	if len(cleanups) > 0 {
+		j.saveState()
+	}
+	return cleanups, nil
+}
+
+// isProcessMountPoint checks if the mount point is a juicefs mounted by csi node in process mode
+func isProcessMountPoint(mi mount.MountInfo) bool {
+	return strings.HasPrefix(mi.FsType, "fuse.juicefs") && filepath.Dir(mi.MountPoint) == filepath.Clean(config.MountBase)
+}
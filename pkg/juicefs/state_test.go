@@ -0,0 +1,91 @@
+/*
+Copyright 2024 Juicedata Inc
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package juicefs
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	k8sexec "k8s.io/utils/exec"
+	"k8s.io/utils/mount"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+)
+
+func Test_juicefs_reconcileProcessMounts(t *testing.T) {
+	oldStatePath, oldMountBase := config.ProcessStatePath, config.MountBase
+	defer func() {
+		config.ProcessStatePath, config.MountBase = oldStatePath, oldMountBase
+	}()
+	config.ProcessStatePath = filepath.Join(t.TempDir(), "state.json")
+	config.MountBase = "/var/lib/jfs"
+
+	mounter := &mount.SafeFormatAndMount{
+		Interface: mount.NewFakeMounter(nil),
+		Exec:      k8sexec.New(),
+	}
+	j := NewJfsProvider(mounter, nil).(*juicefs)
+	j.UUIDMaps["in-use"] = "uuid-in-use"
+	j.CacheDirMaps["in-use"] = []string{"/var/jfsCache"}
+	j.UUIDMaps["orphan"] = "uuid-orphan"
+	j.CacheDirMaps["orphan"] = []string{"/var/jfsCache"}
+	j.UUIDMaps["gone"] = "uuid-gone"
+	j.CacheDirMaps["gone"] = []string{"/var/jfsCache2"}
+	j.saveState()
+
+	// restart: a new provider only knows the state file
+	j = NewJfsProvider(mounter, nil).(*juicefs)
+	mountInfos := []mount.MountInfo{
+		{ID: 1, Major: 0, Minor: 50, FsType: "fuse.juicefs", MountPoint: "/var/lib/jfs/in-use"},
+		{ID: 2, Major: 0, Minor: 50, FsType: "fuse.juicefs", MountPoint: "/var/lib/kubelet/pods/xxx/volumes/kubernetes.io~csi/pv/mount"},
+		{ID: 3, Major: 0, Minor: 51, FsType: "fuse.juicefs", MountPoint: "/var/lib/jfs/orphan"},
+		{ID: 4, Major: 0, Minor: 52, FsType: "ext4", MountPoint: "/var/lib/jfs/other"},
+	}
+	cleanups, err := j.reconcileProcessMounts(mountInfos)
+	if err != nil {
+		t.Fatalf("reconcileProcessMounts() error = %v", err)
+	}
+
+	got := map[string]cacheCleanup{}
+	for _, c := range cleanups {
+		got[c.uniqueId] = c
+	}
+	want := map[string]cacheCleanup{
+		"orphan": {uniqueId: "orphan", uuid: "uuid-orphan", cacheDirs: []string{"/var/jfsCache"}},
+		"gone":   {uniqueId: "gone", uuid: "uuid-gone", cacheDirs: []string{"/var/jfsCache2"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reconcileProcessMounts() cleanups = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(j.UUIDMaps, map[string]string{"in-use": "uuid-in-use"}) {
+		t.Errorf("reconcileProcessMounts() UUIDMaps = %v", j.UUIDMaps)
+	}
+	log := mounter.Interface.(*mount.FakeMounter).GetLog()
+	if len(log) != 1 || log[0].Action != mount.FakeActionUnmount || log[0].Target != "/var/lib/jfs/orphan" {
+		t.Errorf("reconcileProcessMounts() mount actions = %v", log)
+	}
+
+	// state file is updated
+	j = NewJfsProvider(mounter, nil).(*juicefs)
+	if err := j.loadState(); err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	if !reflect.DeepEqual(j.CacheDirMaps, map[string][]string{"in-use": {"/var/jfsCache"}}) {
+		t.Errorf("loadState() CacheDirMaps = %v", j.CacheDirMaps)
+	}
+}
@@ -430,6 +430,16 @@ func (k *K8sClient) GetDaemonSet(ctx context.Context, dsName, namespace string)
 }
 
 func (k *K8sClient) ExecuteInContainer(podName, namespace, containerName string, cmd []string) (stdout string, stderr string, err error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return "", "", err
+	}
+	config.Timeout = timeout
+	return k.ExecuteInContainerWithConfig(config, podName, namespace, containerName, cmd)
+}
+
+// ExecuteInContainerWithConfig executes command in container with the rest config, which is used out of cluster or with other timeout
+func (k *K8sClient) ExecuteInContainerWithConfig(config *restclient.Config, podName, namespace, containerName string, cmd []string) (stdout string, stderr string, err error) {
 	klog.V(6).Infof("Execute command %v in container %s in pod %s in namespace %s", cmd, containerName, podName, namespace)
 	const tty = false
 
@@ -449,11 +459,6 @@ func (k *K8sClient) ExecuteInContainer(podName, namespace, containerName string,
 	}, scheme.ParameterCodec)
 
 	var sout, serr bytes.Buffer
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return "", "", err
-	}
-	config.Timeout = timeout
 	err = execute("POST", req.URL(), config, nil, &sout, &serr, tty)
 
 	return strings.TrimSpace(sout.String()), strings.TrimSpace(serr.String()), err
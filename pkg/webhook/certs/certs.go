@@ -0,0 +1,347 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+)
+
+const (
+	CACertKey         = "ca.crt"
+	CAKeyKey          = "ca.key"
+	PreviousCACertKey = "ca-previous.crt"
+	CertKey           = corev1.TLSCertKey
+	KeyKey            = corev1.TLSPrivateKeyKey
+
+	caValidity      = 10 * 365 * 24 * time.Hour
+	servingValidity = 365 * 24 * time.Hour
+	checkInterval   = time.Hour
+)
+
+// CertManager generates CA and serving certificate of webhook, stores them in secret,
+// and keeps caBundle of webhook configurations and cert files of webhook server up to date.
+// Certificates are rotated when 2/3 of their lifetime passed. When CA is rotated, the previous CA
+// is kept in caBundle until it expires, so that replicas not yet reloaded are still trusted.
+type CertManager struct {
+	*k8sclient.K8sClient
+	certDir     string
+	namespace   string
+	secretName  string
+	serviceName string
+}
+
+func NewCertManager(client *k8sclient.K8sClient, certDir, namespace, secretName, serviceName string) *CertManager {
+	return &CertManager{
+		K8sClient:   client,
+		certDir:     certDir,
+		namespace:   namespace,
+		secretName:  secretName,
+		serviceName: serviceName,
+	}
+}
+
+// Run checks certificates periodically until ctx is done
+func (m *CertManager) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(checkInterval):
+		}
+		if err := m.Ensure(ctx); err != nil {
+			klog.Errorf("[CertManager] ensure webhook certs error: %v", err)
+		}
+	}
+}
+
+// Ensure generates or rotates certificates if needed, then syncs caBundle and cert files
+func (m *CertManager) Ensure(ctx context.Context) error {
+	var (
+		secret *corev1.Secret
+		err    error
+	)
+	// secret may be updated by other replicas at the same time
+	for i := 0; i < 3; i++ {
+		secret, err = m.ensureSecret(ctx, time.Now())
+		if err == nil || !(k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err)) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	caBundle := append(append([]byte{}, secret.Data[CACertKey]...), secret.Data[PreviousCACertKey]...)
+	if err := m.syncCABundle(ctx, caBundle); err != nil {
+		return err
+	}
+	return m.writeCertFiles(secret.Data[CACertKey], secret.Data[CertKey], secret.Data[KeyKey])
+}
+
+// ensureSecret returns secret with valid certificates, which is created or updated if needed
+func (m *CertManager) ensureSecret(ctx context.Context, now time.Time) (*corev1.Secret, error) {
+	secret, err := m.CoreV1().Secrets(m.namespace).Get(ctx, m.secretName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return nil, err
+	}
+	exists := err == nil
+	if !exists {
+		secret = &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: m.secretName, Namespace: m.namespace}}
+	}
+	data := make(map[string][]byte, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	if !m.rotate(data, now) {
+		return secret, nil
+	}
+	secret.Data = data
+	if exists {
+		klog.Infof("[CertManager] update webhook certs in secret %s/%s", m.namespace, m.secretName)
+		return m.CoreV1().Secrets(m.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	klog.Infof("[CertManager] create webhook certs in secret %s/%s", m.namespace, m.secretName)
+	return m.CoreV1().Secrets(m.namespace).Create(ctx, secret, metav1.CreateOptions{})
+}
+
+// rotate generates CA and serving certificate in data if needed, returns whether data is changed
+func (m *CertManager) rotate(data map[string][]byte, now time.Time) bool {
+	changed := false
+	ca, caErr := parseCert(data[CACertKey])
+	_, keyErr := parseKey(data[CAKeyKey])
+	if caErr != nil || keyErr != nil || needRotate(ca, now) {
+		// keep the previous CA trusted until it expires
+		delete(data, PreviousCACertKey)
+		if caErr == nil && now.Before(ca.NotAfter) {
+			data[PreviousCACertKey] = data[CACertKey]
+		}
+		certPEM, keyPEM, err := generateCA(m.serviceName+"."+m.namespace+".svc", now)
+		if err != nil {
+			klog.Errorf("[CertManager] generate CA error: %v", err)
+			return false
+		}
+		data[CACertKey], data[CAKeyKey] = certPEM, keyPEM
+		ca, _ = parseCert(certPEM)
+		changed = true
+	}
+	if prev, err := parseCert(data[PreviousCACertKey]); len(data[PreviousCACertKey]) != 0 && (err != nil || !now.Before(prev.NotAfter)) {
+		delete(data, PreviousCACertKey)
+		changed = true
+	}
+
+	cert, err := parseCert(data[CertKey])
+	if changed || err != nil || len(data[KeyKey]) == 0 || needRotate(cert, now) || cert.CheckSignatureFrom(ca) != nil {
+		certPEM, keyPEM, err := generateServingCert(data[CACertKey], data[CAKeyKey], m.dnsNames(), now)
+		if err != nil {
+			klog.Errorf("[CertManager] generate serving cert error: %v", err)
+			return false
+		}
+		data[CertKey], data[KeyKey] = certPEM, keyPEM
+		changed = true
+	}
+	return changed
+}
+
+func (m *CertManager) dnsNames() []string {
+	return []string{
+		m.serviceName,
+		fmt.Sprintf("%s.%s", m.serviceName, m.namespace),
+		fmt.Sprintf("%s.%s.svc", m.serviceName, m.namespace),
+	}
+}
+
+// syncCABundle sets caBundle of all webhooks served by the webhook service
+func (m *CertManager) syncCABundle(ctx context.Context, caBundle []byte) error {
+	mutatings, err := m.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range mutatings.Items {
+		conf := &mutatings.Items[i]
+		changed := false
+		for j := range conf.Webhooks {
+			svc := conf.Webhooks[j].ClientConfig.Service
+			if svc != nil && svc.Name == m.serviceName && svc.Namespace == m.namespace && !bytes.Equal(conf.Webhooks[j].ClientConfig.CABundle, caBundle) {
+				conf.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			klog.Infof("[CertManager] update caBundle of MutatingWebhookConfiguration %s", conf.Name)
+			if _, err := m.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(ctx, conf, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	validatings, err := m.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range validatings.Items {
+		conf := &validatings.Items[i]
+		changed := false
+		for j := range conf.Webhooks {
+			svc := conf.Webhooks[j].ClientConfig.Service
+			if svc != nil && svc.Name == m.serviceName && svc.Namespace == m.namespace && !bytes.Equal(conf.Webhooks[j].ClientConfig.CABundle, caBundle) {
+				conf.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			klog.Infof("[CertManager] update caBundle of ValidatingWebhookConfiguration %s", conf.Name)
+			if _, err := m.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(ctx, conf, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeCertFiles writes CA and serving certificate into cert dir, serving certificate is reloaded by webhook server on change
+func (m *CertManager) writeCertFiles(caPEM, certPEM, keyPEM []byte) error {
+	if err := os.MkdirAll(m.certDir, 0755); err != nil {
+		return err
+	}
+	// both files are watched by webhook server, a mismatched pair in between is reloaded on the next change
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{CACertKey, caPEM}, {KeyKey, keyPEM}, {CertKey, certPEM}} {
+		path := filepath.Join(m.certDir, f.name)
+		if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, f.data) {
+			continue
+		}
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, f.data, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		klog.Infof("[CertManager] write %s", path)
+	}
+	return nil
+}
+
+// needRotate returns true if 2/3 of lifetime of the certificate passed
+func needRotate(cert *x509.Certificate, now time.Time) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return now.After(cert.NotBefore.Add(lifetime * 2 / 3))
+}
+
+func parseCert(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no private key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func generateCA(commonName string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName + "-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encode(der, key)
+}
+
+func generateServingCert(caCertPEM, caKeyPEM []byte, dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	ca, err := parseCert(caCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	caKey, err := parseKey(caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[len(dnsNames)-1]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(servingValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encode(der, key)
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,133 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+)
+
+func TestCertManager_Ensure(t *testing.T) {
+	webhookConf := &admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-admission-webhook"},
+		Webhooks: []admissionv1.MutatingWebhook{
+			{
+				Name:         "sidecar.inject.juicefs.com",
+				ClientConfig: admissionv1.WebhookClientConfig{Service: &admissionv1.ServiceReference{Name: "juicefs-admission-webhook", Namespace: "kube-system"}},
+			},
+			{
+				Name:         "other.webhook.com",
+				ClientConfig: admissionv1.WebhookClientConfig{Service: &admissionv1.ServiceReference{Name: "other", Namespace: "kube-system"}},
+			},
+		},
+	}
+	client := &k8sclient.K8sClient{Interface: fake.NewSimpleClientset(webhookConf)}
+	certDir := t.TempDir()
+	m := NewCertManager(client, certDir, "kube-system", "juicefs-webhook-certs", "juicefs-admission-webhook")
+
+	if err := m.Ensure(context.TODO()); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	secret, err := client.GetSecret(context.TODO(), "juicefs-webhook-certs", "kube-system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := os.ReadFile(filepath.Join(certDir, CertKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(certPEM, secret.Data[CertKey]) {
+		t.Errorf("cert file is not the same as secret")
+	}
+	verify(t, secret.Data[CACertKey], certPEM, time.Now())
+
+	conf, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), webhookConf.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(conf.Webhooks[0].ClientConfig.CABundle, secret.Data[CACertKey]) {
+		t.Errorf("caBundle is not set")
+	}
+	if len(conf.Webhooks[1].ClientConfig.CABundle) != 0 {
+		t.Errorf("caBundle of other webhook should not be changed")
+	}
+
+	// nothing changes when certs are valid
+	data := copyData(secret.Data)
+	if m.rotate(data, time.Now()) {
+		t.Errorf("rotate() with valid certs should not change")
+	}
+
+	// serving cert is rotated with the same CA
+	later := time.Now().Add(300 * 24 * time.Hour)
+	data = copyData(secret.Data)
+	if !m.rotate(data, later) {
+		t.Fatalf("rotate() serving cert expected")
+	}
+	if !bytes.Equal(data[CACertKey], secret.Data[CACertKey]) || bytes.Equal(data[CertKey], secret.Data[CertKey]) {
+		t.Errorf("rotate() should only rotate serving cert")
+	}
+	verify(t, data[CACertKey], data[CertKey], later)
+
+	// CA is rotated, the previous CA is kept until it expires
+	later = time.Now().Add(8 * 365 * 24 * time.Hour)
+	data = copyData(secret.Data)
+	if !m.rotate(data, later) {
+		t.Fatalf("rotate() CA expected")
+	}
+	if !bytes.Equal(data[PreviousCACertKey], secret.Data[CACertKey]) {
+		t.Errorf("rotate() should keep the previous CA")
+	}
+	verify(t, data[CACertKey], data[CertKey], later)
+}
+
+func copyData(data map[string][]byte) map[string][]byte {
+	copied := make(map[string][]byte, len(data))
+	for k, v := range data {
+		copied[k] = v
+	}
+	return copied
+}
+
+func verify(t *testing.T, caPEM, certPEM []byte, now time.Time) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatalf("invalid CA")
+	}
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		DNSName:     "juicefs-admission-webhook.kube-system.svc",
+		Roots:       roots,
+		CurrentTime: now,
+	}); err != nil {
+		t.Errorf("verify serving cert error: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount/builder"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/webhook/handler/mutate"
+)
+
+const (
+	serviceAccountPrefix = "system:serviceaccount:"
+	podNameExtraKey      = "authentication.kubernetes.io/pod-name"
+	podUIDExtraKey       = "authentication.kubernetes.io/pod-uid"
+)
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CredentialHandler serves credentials of volume to juicefs client in sidecar, so that they are not copied
+// into namespace of app pod. The request is authenticated by service account token of app pod bound to the pod,
+// and only credentials of PVC used by the pod are served.
+type CredentialHandler struct {
+	Client *k8sclient.K8sClient
+}
+
+func NewCredentialHandler(client *k8sclient.K8sClient) *CredentialHandler {
+	return &CredentialHandler{Client: client}
+}
+
+func (h *CredentialHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if config.GlobalConfig.SidecarCredentials != config.SidecarCredentialsFetch {
+		http.Error(w, "sidecar credentials are not fetched from webhook", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	pvcName := r.URL.Query().Get("pvc")
+	if token == "" || pvcName == "" {
+		http.Error(w, "token and pvc are required", http.StatusBadRequest)
+		return
+	}
+	pod, err := h.authenticate(r.Context(), token)
+	if err != nil {
+		klog.Warningf("[CredentialHandler] authenticate error: %v", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !usesPVC(pod, pvcName) {
+		klog.Warningf("[CredentialHandler] pod %s/%s does not use pvc %s", pod.Namespace, pod.Name, pvcName)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	data, err := h.credentials(r.Context(), pod.Namespace, pvcName)
+	if err != nil {
+		klog.Errorf("[CredentialHandler] get credentials of pvc %s/%s for pod %s error: %v", pod.Namespace, pvcName, pod.Name, err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	klog.Infof("[CredentialHandler] serve credentials of pvc %s/%s for pod %s", pod.Namespace, pvcName, pod.Name)
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(envFile(data)))
+}
+
+// authenticate reviews service account token, returns the pod it is bound to,
+// which must be the running pod with the same uid, not a deleted one with the same name.
+func (h *CredentialHandler) authenticate(ctx context.Context, token string) (*corev1.Pod, error) {
+	review, err := h.Client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: []string{config.CredentialAudience},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !review.Status.Authenticated {
+		return nil, fmt.Errorf("token not authenticated: %s", review.Status.Error)
+	}
+	username := review.Status.User.Username
+	if !strings.HasPrefix(username, serviceAccountPrefix) {
+		return nil, fmt.Errorf("user %s is not service account", username)
+	}
+	podNames, podUIDs := review.Status.User.Extra[podNameExtraKey], review.Status.User.Extra[podUIDExtraKey]
+	if len(podNames) != 1 || len(podUIDs) != 1 {
+		return nil, fmt.Errorf("token of %s is not bound to pod", username)
+	}
+	sa := strings.SplitN(strings.TrimPrefix(username, serviceAccountPrefix), ":", 2)
+	if len(sa) != 2 {
+		return nil, fmt.Errorf("invalid service account %s", username)
+	}
+	pod, err := h.Client.GetPod(ctx, podNames[0], sa[0])
+	if err != nil {
+		return nil, err
+	}
+	if string(pod.UID) != podUIDs[0] {
+		return nil, fmt.Errorf("uid of pod %s/%s is %s, token is bound to %s", pod.Namespace, pod.Name, pod.UID, podUIDs[0])
+	}
+	if pod.Spec.ServiceAccountName != sa[1] {
+		return nil, fmt.Errorf("pod %s/%s does not run as %s", pod.Namespace, pod.Name, username)
+	}
+	return pod, nil
+}
+
+// usesPVC checks if the pod uses the pvc. Volumes of pvc are replaced when injecting sidecar,
+// the pvcs are recorded in pod annotation by the webhook instead.
+func usesPVC(pod *corev1.Pod, pvcName string) bool {
+	for _, claim := range strings.Split(pod.Annotations[config.InjectSidecarClaims], ",") {
+		if claim == pvcName {
+			return true
+		}
+	}
+	return false
+}
+
+// credentials generates credentials of juicefs pvc, the same as those in secret created in sidecar mode
+func (h *CredentialHandler) credentials(ctx context.Context, namespace, pvcName string) (map[string]string, error) {
+	pvc, err := h.Client.GetPersistentVolumeClaim(ctx, pvcName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if pvc.Spec.VolumeName == "" {
+		return nil, fmt.Errorf("pvc is not bound")
+	}
+	pv, err := h.Client.GetPersistentVolume(ctx, pvc.Spec.VolumeName)
+	if err != nil {
+		return nil, err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName || pv.Spec.CSI.NodePublishSecretRef == nil {
+		return nil, fmt.Errorf("pv %s is not juicefs volume", pv.Name)
+	}
+
+	secrets, volCtx, options, err := (&mutate.SidecarMutate{Client: h.Client}).GetSettings(*pv)
+	if err != nil {
+		return nil, err
+	}
+	if volCtx == nil {
+		volCtx = make(map[string]string)
+	}
+	for k, v := range pvc.Annotations {
+		if strings.HasPrefix(k, "juicefs") {
+			volCtx[k] = v
+		}
+	}
+	jfsSetting, err := juicefs.NewJfsProvider(nil, h.Client).Settings(ctx, pv.Spec.CSI.VolumeHandle, secrets, volCtx, options)
+	if err != nil {
+		return nil, err
+	}
+	return builder.GenCredentialData(jfsSetting), nil
+}
+
+// envFile renders credentials as shell variables, which are sourced by the sidecar
+func envFile(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		if !envNameRegexp.MatchString(k) {
+			klog.V(5).Infof("[CredentialHandler] %s is not valid env name, skipped", k)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s='%s'\n", k, strings.ReplaceAll(data[k], "'", `'\''`))
+	}
+	return b.String()
+}
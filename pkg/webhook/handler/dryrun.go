@@ -0,0 +1,233 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gomodules.xyz/jsonpatch/v2"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+const (
+	redacted       = "******"
+	maxDryRunBytes = 1 << 20
+)
+
+// DryRunResult is what sidecar injection does to a pod
+type DryRunResult struct {
+	// reason why pod is not mutated
+	Skipped string `json:"skipped,omitempty"`
+	// mutated pod
+	Pod json.RawMessage `json:"pod,omitempty"`
+	// json patch applied to pod by webhook
+	Patch []jsonpatch.JsonPatchOperation `json:"patch,omitempty"`
+	// unified diff between yaml of the original and mutated pod
+	Diff string `json:"diff,omitempty"`
+	// settings of each volume injected as sidecar, credentials are redacted
+	Settings  []*config.JfsSetting `json:"settings,omitempty"`
+	Decisions []injectionDecision  `json:"decisions,omitempty"`
+	// whether sidecar is injected for serverless environment
+	Serverless bool `json:"serverless,omitempty"`
+}
+
+// DryRunHandler shows how a pod is mutated in sidecar mode without creating anything.
+// The caller must be allowed to create pods in namespace of the pod.
+type DryRunHandler struct {
+	Client     *k8sclient.K8sClient
+	sidecar    *SidecarHandler
+	serverless *SidecarHandler
+}
+
+func NewDryRunHandler(client *k8sclient.K8sClient, sidecar, serverless *SidecarHandler) *DryRunHandler {
+	return &DryRunHandler{
+		Client:     client,
+		sidecar:    sidecar,
+		serverless: serverless,
+	}
+}
+
+func (h *DryRunHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDryRunBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// pod manifest in yaml or json
+	raw, err := yaml.YAMLToJSON(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid pod manifest: %v", err), http.StatusBadRequest)
+		return
+	}
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(raw, pod); err != nil {
+		http.Error(w, fmt.Sprintf("invalid pod manifest: %v", err), http.StatusBadRequest)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = pod.Namespace
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	pod.Namespace = namespace
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := h.authorize(r.Context(), token, namespace); err != nil {
+		klog.Warningf("[DryRunHandler] authorize error: %v", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	handler := h.sidecar
+	if r.URL.Query().Get("serverless") == config.True {
+		handler = h.serverless
+	}
+	result, code, err := handler.mutatePod(r.Context(), pod, raw, namespace, true)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	res, err := newDryRunResult(raw, result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// authorize checks whether the user of token can create pods in namespace
+func (h *DryRunHandler) authorize(ctx context.Context, token, namespace string) error {
+	if token == "" {
+		return fmt.Errorf("bearer token is required")
+	}
+	review, err := h.Client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !review.Status.Authenticated {
+		return fmt.Errorf("token not authenticated: %s", review.Status.Error)
+	}
+	user := review.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar, err := h.Client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "create",
+				Resource:  "pods",
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if !sar.Status.Allowed {
+		return fmt.Errorf("user %s can not create pods in namespace %s", user.Username, namespace)
+	}
+	return nil
+}
+
+func newDryRunResult(raw []byte, result *sidecarMutation) (*DryRunResult, error) {
+	res := &DryRunResult{Skipped: result.Skipped, Decisions: result.Decisions, Serverless: result.Serverless}
+	if result.Skipped != "" {
+		return res, nil
+	}
+	res.Pod = result.Raw
+	res.Patch = admission.PatchResponseFromRaw(raw, result.Raw).Patches
+	origin, err := yaml.JSONToYAML(raw)
+	if err != nil {
+		return nil, err
+	}
+	mutated, err := yaml.JSONToYAML(result.Raw)
+	if err != nil {
+		return nil, err
+	}
+	res.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(origin)),
+		B:        difflib.SplitLines(string(mutated)),
+		FromFile: "original",
+		ToFile:   "mutated",
+		Context:  3,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, setting := range result.Settings {
+		res.Settings = append(res.Settings, redactSetting(setting))
+	}
+	return res, nil
+}
+
+// redactSetting returns a copy of setting with credentials redacted
+func redactSetting(setting *config.JfsSetting) *config.JfsSetting {
+	r := *setting
+	r.MetaUrl = util.StripPasswd(r.MetaUrl)
+	// source is metaurl in community edition
+	r.Source = util.StripPasswd(r.Source)
+	for _, s := range []*string{&r.SecretKey, &r.SecretKey2, &r.Token, &r.Passphrase, &r.EncryptRsaKey, &r.InitConfig} {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+	if options, err := r.ParseFormatOptions(); err == nil {
+		r.FormatOptions = strings.Join(r.StripFormatOptions(options, []string{"session-token"}), " ")
+	}
+	// configs and envs are from secret, only keys are kept
+	for _, m := range []*map[string]string{&r.Envs, &r.Configs} {
+		if *m == nil {
+			continue
+		}
+		values := make(map[string]string, len(*m))
+		for k := range *m {
+			values[k] = redacted
+		}
+		*m = values
+	}
+	return &r
+}
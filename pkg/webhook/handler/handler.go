@@ -21,8 +21,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
@@ -38,8 +43,13 @@ type SidecarHandler struct {
 	Client *k8sclient.K8sClient
 	// A decoder will be automatically injected
 	decoder *admission.Decoder
-	// is in serverless environment
+	// is in serverless environment, unless decided per pod by serverless detection
 	serverless bool
+
+	// whether apiserver supports native sidecar, checked once
+	nativeSidecarMu        sync.Mutex
+	nativeSidecarChecked   bool
+	nativeSidecarSupported bool
 }
 
 func NewSidecarHandler(client *k8sclient.K8sClient, serverless bool) *SidecarHandler {
@@ -59,45 +69,154 @@ func (s *SidecarHandler) Handle(ctx context.Context, request admission.Request)
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
+	result, code, err := s.mutatePod(ctx, pod, raw, request.Namespace, false)
+	if err != nil {
+		return admission.Errored(int32(code), err)
+	}
+	if result.Skipped != "" {
+		return admission.Allowed(result.Skipped)
+	}
+	klog.V(6).Infof("[SidecarHandler] mutated pod: %s", string(result.Raw))
+	resp := admission.PatchResponseFromRaw(raw, result.Raw)
+	return resp
+}
+
+// sidecarMutation is the result of mutating pod in sidecar mode
+type sidecarMutation struct {
+	// reason why pod is not mutated
+	Skipped string
+	Pod     *corev1.Pod
+	// marshaled mutated pod
+	Raw       []byte
+	Settings  []*config.JfsSetting
+	Decisions []injectionDecision
+	// whether sidecar is injected for serverless environment
+	Serverless bool
+}
+
+// mutatePod injects juicefs client as sidecar in pod, returns http status code when error occurs.
+// Secrets are not created in dry run.
+func (s *SidecarHandler) mutatePod(ctx context.Context, pod *corev1.Pod, raw []byte, namespace string, dryRun bool) (*sidecarMutation, int, error) {
 	// check if pod has done label
 	if util.CheckExpectValue(pod.Labels, config.InjectSidecarDone, config.True) {
 		klog.Infof("[SidecarHandler] skip mutating the pod because injection is done. Pod %s namespace %s", pod.Name, pod.Namespace)
-		return admission.Allowed("skip mutating the pod because injection is done")
+		return &sidecarMutation{Skipped: "skip mutating the pod because injection is done"}, 0, nil
 	}
 
 	// check if pod has disable label
 	if util.CheckExpectValue(pod.Labels, config.InjectSidecarDisable, config.True) {
 		klog.Infof("[SidecarHandler] skip mutating the pod because injection is disabled. Pod %s namespace %s", pod.Name, pod.Namespace)
-		return admission.Allowed("skip mutating the pod because injection is disabled")
+		return &sidecarMutation{Skipped: "skip mutating the pod because injection is disabled"}, 0, nil
 	}
 
 	// check if pod use JuiceFS Volume
 	used, pair, err := util.GetVolumes(ctx, s.Client, pod)
 	if err != nil {
 		klog.Errorf("[SidecarHandler] get pv from pod %s namespace %s err: %v", pod.Name, pod.Namespace, err)
-		return admission.Errored(http.StatusBadRequest, err)
+		return nil, http.StatusBadRequest, err
 	} else if !used {
 		klog.Infof("[SidecarHandler] skip mutating the pod because it doesn't use JuiceFS Volume. Pod %s namespace %s", pod.Name, pod.Namespace)
-		return admission.Allowed("skip mutating the pod because it doesn't use JuiceFS Volume")
+		return &sidecarMutation{Skipped: "skip mutating the pod because it doesn't use JuiceFS Volume"}, 0, nil
 	}
 
-	jfs := juicefs.NewJfsProvider(nil, s.Client)
-	sidecarMutate := mutate.NewSidecarMutate(s.Client, jfs, s.serverless, pair)
-	klog.Infof("[SidecarHandler] start injecting juicefs client as sidecar in pod [%s] namespace [%s].", pod.Name, pod.Namespace)
-	out, err := sidecarMutate.Mutate(ctx, pod)
-	if err != nil {
-		return admission.Errored(http.StatusBadRequest, err)
+	// decide whether pod is in serverless environment, the webhook path decides if not detected
+	serverless := s.serverless
+	if detection := config.GlobalConfig.ServerlessDetection; detection != nil {
+		if detected, reason, ok := detection.Detect(pod); ok {
+			klog.Infof("[SidecarHandler] pod %s namespace %s is detected serverless: %v by %s", pod.Name, namespace, detected, reason)
+			serverless = detected
+		}
 	}
-	pod = out
 
-	marshaledPod, err := json.Marshal(pod)
+	// decide how each volume is mounted according to injection rules
+	var decisions []injectionDecision
+	if injection := config.GlobalConfig.SidecarInjection; injection != nil && !serverless {
+		pair, decisions, err = s.applyInjectionRules(ctx, injection, pod, namespace, pair)
+		if err != nil {
+			klog.Errorf("[SidecarHandler] apply injection rules to pod %s namespace %s err: %v", pod.Name, namespace, err)
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	result := &sidecarMutation{Decisions: decisions, Serverless: serverless}
+	out := pod.DeepCopy()
+	var nativeSidecars []string
+	if len(pair) != 0 {
+		jfs := juicefs.NewJfsProvider(nil, s.Client)
+		nativeSidecar := config.GlobalConfig.EnableNativeSidecar && s.supportNativeSidecar()
+		sidecarMutate := mutate.NewSidecarMutate(s.Client, jfs, serverless, nativeSidecar, pair)
+		sidecarMutate.DryRun = dryRun
+		klog.Infof("[SidecarHandler] start injecting juicefs client as sidecar in pod [%s] namespace [%s], dry run: %v.", pod.Name, pod.Namespace, dryRun)
+		out, err = sidecarMutate.Mutate(ctx, pod)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		result.Settings = sidecarMutate.Settings
+		if nativeSidecar {
+			nativeSidecars = injectedInitContainers(pod, out)
+		}
+	} else {
+		klog.Infof("[SidecarHandler] skip injecting sidecar in pod %s namespace %s because all its JuiceFS volumes use mount pod", pod.Name, namespace)
+	}
+	if decisions != nil {
+		if err := setInjectionDecisions(out, decisions); err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+	result.Pod = out
+
+	marshaledPod, err := json.Marshal(out)
 	if err != nil {
 		klog.Error(err, "unable to marshal pod")
-		return admission.Errored(http.StatusInternalServerError, err)
+		return nil, http.StatusInternalServerError, err
 	}
-	klog.V(6).Infof("[SidecarHandler] mutated pod: %s", string(marshaledPod))
-	resp := admission.PatchResponseFromRaw(raw, marshaledPod)
-	return resp
+	marshaledPod, err = mutate.SetNativeSidecars(raw, marshaledPod, nativeSidecars)
+	if err != nil {
+		klog.Error(err, "unable to set native sidecars of pod")
+		return nil, http.StatusInternalServerError, err
+	}
+	result.Raw = marshaledPod
+	return result, 0, nil
+}
+
+// supportNativeSidecar checks if native sidecar is enabled by default in apiserver, which is v1.29+
+func (s *SidecarHandler) supportNativeSidecar() bool {
+	s.nativeSidecarMu.Lock()
+	defer s.nativeSidecarMu.Unlock()
+	if s.nativeSidecarChecked {
+		return s.nativeSidecarSupported
+	}
+	info, err := s.Client.Discovery().ServerVersion()
+	if err != nil {
+		klog.Errorf("[SidecarHandler] get server version error: %v, inject sidecar as normal container", err)
+		return false
+	}
+	v, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		klog.Errorf("[SidecarHandler] parse server version %s error: %v, inject sidecar as normal container", info.GitVersion, err)
+		return false
+	}
+	s.nativeSidecarChecked = true
+	s.nativeSidecarSupported = v.AtLeast(version.MustParseGeneric("v1.29.0"))
+	if !s.nativeSidecarSupported {
+		klog.Warningf("[SidecarHandler] native sidecar is not supported in server version %s, inject sidecar as normal container", info.GitVersion)
+	}
+	return s.nativeSidecarSupported
+}
+
+// injectedInitContainers returns names of init containers injected in pod
+func injectedInitContainers(origin, out *corev1.Pod) []string {
+	exists := make(map[string]bool)
+	for _, c := range origin.Spec.InitContainers {
+		exists[c.Name] = true
+	}
+	var names []string
+	for _, c := range out.Spec.InitContainers {
+		if !exists[c.Name] {
+			names = append(names, c.Name)
+		}
+	}
+	return names
 }
 
 // InjectDecoder injects the decoder.
@@ -106,10 +225,15 @@ func (s *SidecarHandler) InjectDecoder(d *admission.Decoder) error {
 	return nil
 }
 
+// secretWebhookTimeoutMargin is left for the response to reach apiserver before the webhook times out
+const secretWebhookTimeoutMargin = 2 * time.Second
+
 type SecretHandler struct {
 	Client *k8sclient.K8sClient
 	// A decoder will be automatically injected
 	decoder *admission.Decoder
+	// timeout of the secret webhook in apiserver, checks must finish before it, or the secret is admitted silently
+	webhookTimeout time.Duration
 }
 
 func NewSecretHandler(client *k8sclient.K8sClient) *SecretHandler {
@@ -118,6 +242,41 @@ func NewSecretHandler(client *k8sclient.K8sClient) *SecretHandler {
 	}
 }
 
+// loadWebhookTimeout gets timeoutSeconds of the secret webhook, and warns if secret checks may not finish before it
+func (s *SecretHandler) loadWebhookTimeout(ctx context.Context) {
+	if s.Client == nil {
+		return
+	}
+	conf, err := s.Client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, config.WebhookName, metav1.GetOptions{})
+	if err != nil {
+		klog.Warningf("get ValidatingWebhookConfiguration %s error: %v, timeout of secret checks is not limited by the webhook", config.WebhookName, err)
+		return
+	}
+	for _, wh := range conf.Webhooks {
+		if wh.ClientConfig.Service == nil || wh.ClientConfig.Service.Path == nil || *wh.ClientConfig.Service.Path != SecretPath {
+			continue
+		}
+		// default timeout of admissionregistration.k8s.io/v1 is 10s
+		s.webhookTimeout = 10 * time.Second
+		if wh.TimeoutSeconds != nil {
+			s.webhookTimeout = time.Duration(*wh.TimeoutSeconds) * time.Second
+		}
+		if timeout := config.GlobalConfig.SecretValidation.GetTimeout(); timeout > s.checkTimeout() {
+			klog.Warningf("timeout of secret checks %s is not shorter than timeoutSeconds %s of webhook %s, it's limited to %s",
+				timeout, s.webhookTimeout, wh.Name, s.checkTimeout())
+		}
+		return
+	}
+}
+
+// checkTimeout is the longest time secret checks can take before the webhook times out
+func (s *SecretHandler) checkTimeout() time.Duration {
+	if s.webhookTimeout > 2*secretWebhookTimeoutMargin {
+		return s.webhookTimeout - secretWebhookTimeoutMargin
+	}
+	return s.webhookTimeout / 2
+}
+
 // InjectDecoder injects the decoder.
 func (s *SecretHandler) InjectDecoder(d *admission.Decoder) error {
 	s.decoder = d
@@ -132,13 +291,22 @@ func (s *SecretHandler) Handle(ctx context.Context, request admission.Request) a
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
+	if s.webhookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.checkTimeout())
+		defer cancel()
+	}
 	jfs := juicefs.NewJfsProvider(nil, nil)
-	secretValidateor := validator.NewSecretValidator(jfs)
-	if err := secretValidateor.Validate(ctx, *secret); err != nil {
+	secretValidateor := validator.NewSecretValidator(jfs, config.GlobalConfig.SecretValidation)
+	warnings, err := secretValidateor.ValidateWithWarnings(ctx, *secret)
+	if err != nil {
 		klog.Errorf("secret validation failed, secret: %s, err: %v", secret.Name, err)
 		return admission.Errored(http.StatusBadRequest, err)
 	}
-	return admission.Allowed("")
+	for _, w := range warnings {
+		klog.Warningf("secret validation warning, secret: %s, %s", secret.Name, w)
+	}
+	return admission.Allowed("").WithWarnings(warnings...)
 }
 
 type PVHandler struct {
@@ -170,8 +338,13 @@ func (s *PVHandler) Handle(ctx context.Context, request admission.Request) admis
 	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != config.DriverName {
 		return admission.Allowed("")
 	}
+	warnings, err := validator.NewVolumeValidator(s.Client).ValidatePV(ctx, *pv)
+	if err != nil {
+		klog.Errorf("pv %s validation failed, err: %v", pv.Name, err)
+		return admission.Denied(fmt.Sprintf("pv %s is invalid: %v", pv.Name, err))
+	}
 	if pv.Spec.StorageClassName != "" {
-		return admission.Allowed("")
+		return admission.Allowed("").WithWarnings(warnings...)
 	}
 
 	volumeHandle := pv.Spec.CSI.VolumeHandle
@@ -183,5 +356,81 @@ func (s *PVHandler) Handle(ctx context.Context, request admission.Request) admis
 	if len(existPvs) > 0 {
 		return admission.Denied(fmt.Sprintf("pv %s with volume handle %s already exists", pv.Name, volumeHandle))
 	}
-	return admission.Allowed("")
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+type PVCHandler struct {
+	Client *k8sclient.K8sClient
+	// A decoder will be automatically injected
+	decoder *admission.Decoder
+}
+
+func NewPVCHandler(client *k8sclient.K8sClient) *PVCHandler {
+	return &PVCHandler{
+		Client: client,
+	}
+}
+
+// InjectDecoder injects the decoder.
+func (s *PVCHandler) InjectDecoder(d *admission.Decoder) error {
+	s.decoder = d
+	return nil
+}
+
+func (s *PVCHandler) Handle(ctx context.Context, request admission.Request) admission.Response {
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := s.decoder.Decode(request, pvc)
+	if err != nil {
+		klog.Errorf("unable to decoder pvc from req, %v", err)
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	var oldPVC *corev1.PersistentVolumeClaim
+	if len(request.OldObject.Raw) != 0 {
+		oldPVC = &corev1.PersistentVolumeClaim{}
+		if err := s.decoder.DecodeRaw(request.OldObject, oldPVC); err != nil {
+			klog.Errorf("unable to decoder old pvc from req, %v", err)
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+	warnings, err := validator.NewVolumeValidator(s.Client).ValidatePVC(ctx, *pvc, oldPVC)
+	if err != nil {
+		klog.Errorf("pvc %s/%s validation failed, err: %v", pvc.Namespace, pvc.Name, err)
+		return admission.Denied(fmt.Sprintf("pvc %s is invalid: %v", pvc.Name, err))
+	}
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+type StorageClassHandler struct {
+	Client *k8sclient.K8sClient
+	// A decoder will be automatically injected
+	decoder *admission.Decoder
+}
+
+func NewStorageClassHandler(client *k8sclient.K8sClient) *StorageClassHandler {
+	return &StorageClassHandler{
+		Client: client,
+	}
+}
+
+// InjectDecoder injects the decoder.
+func (s *StorageClassHandler) InjectDecoder(d *admission.Decoder) error {
+	s.decoder = d
+	return nil
+}
+
+func (s *StorageClassHandler) Handle(ctx context.Context, request admission.Request) admission.Response {
+	sc := &storagev1.StorageClass{}
+	err := s.decoder.Decode(request, sc)
+	if err != nil {
+		klog.Errorf("unable to decoder storageClass from req, %v", err)
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	warnings, err := validator.NewVolumeValidator(s.Client).ValidateStorageClass(ctx, *sc)
+	if err != nil {
+		klog.Errorf("storageClass %s validation failed, err: %v", sc.Name, err)
+		return admission.Denied(fmt.Sprintf("storageClass %s is invalid: %v", sc.Name, err))
+	}
+	return admission.Allowed("").WithWarnings(warnings...)
 }
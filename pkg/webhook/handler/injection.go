@@ -0,0 +1,81 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util"
+)
+
+// injectionDecision is how a juicefs volume of pod is mounted, recorded in pod annotation
+type injectionDecision struct {
+	PVC  string `json:"pvc"`
+	Mode string `json:"mode"`
+	// name of the matched rule, empty if default mode is used
+	Rule string `json:"rule,omitempty"`
+}
+
+// applyInjectionRules decides mode of each juicefs volume of pod, and returns volumes to be injected as sidecar
+func (s *SidecarHandler) applyInjectionRules(ctx context.Context, injection *config.SidecarInjection, pod *corev1.Pod, namespace string, pairs []util.PVPair) ([]util.PVPair, []injectionDecision, error) {
+	if pod.Namespace != "" {
+		namespace = pod.Namespace
+	}
+	target := config.SidecarInjectionTarget{
+		Namespace:      namespace,
+		PodLabels:      pod.Labels,
+		PodAnnotations: pod.Annotations,
+	}
+	if injection.NeedNamespaceLabels() {
+		ns, err := s.Client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		target.NamespaceLabels = ns.Labels
+	}
+
+	var sidecarPairs []util.PVPair
+	decisions := make([]injectionDecision, 0, len(pairs))
+	for _, pair := range pairs {
+		target.StorageClass = pair.PV.Spec.StorageClassName
+		mode, rule := injection.Decide(target)
+		klog.V(5).Infof("[SidecarHandler] pvc %s of pod %s namespace %s uses %s, rule: %q", pair.PVC.Name, pod.Name, namespace, mode, rule)
+		decisions = append(decisions, injectionDecision{PVC: pair.PVC.Name, Mode: mode, Rule: rule})
+		if mode == config.InjectModeSidecar {
+			sidecarPairs = append(sidecarPairs, pair)
+		}
+	}
+	return sidecarPairs, decisions, nil
+}
+
+func setInjectionDecisions(pod *corev1.Pod, decisions []injectionDecision) error {
+	data, err := json.Marshal(decisions)
+	if err != nil {
+		return err
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[config.InjectSidecarDecision] = string(data)
+	return nil
+}
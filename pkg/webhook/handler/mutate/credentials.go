@@ -0,0 +1,111 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package mutate
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mount/builder"
+	"github.com/juicedata/juicefs-csi-driver/pkg/util/security"
+)
+
+const (
+	credentialVolumeName = "jfs-credentials"
+	credentialDir        = "/var/run/secrets/juicefs"
+	credentialCAKey      = "credential-ca.crt"
+	// service account token is only used once at start, keep it as short-lived as possible
+	credentialTokenExpiration int64 = 600
+)
+
+// stripCredentials removes credentials from secret created in namespace of app pod,
+// and adds CA of webhook which is used by sidecar to fetch credentials.
+func stripCredentials(secret *corev1.Secret, setting *config.JfsSetting) error {
+	ca, err := os.ReadFile(filepath.Join(config.WebhookCertDir, "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("read CA of webhook to fetch credentials: %v", err)
+	}
+	for k := range builder.GenCredentialData(setting) {
+		delete(secret.StringData, k)
+	}
+	secret.StringData[credentialCAKey] = string(ca)
+	return nil
+}
+
+// fetchCredentials makes juicefs client in sidecar fetch credentials from webhook at start,
+// authenticated by service account token of app pod, instead of reading them from secret.
+func fetchCredentials(mountPod *corev1.Pod, secretName, pvcName string, setting *config.JfsSetting) error {
+	container := &mountPod.Spec.Containers[0]
+	if len(container.Command) != 3 || container.Command[0] != "sh" || container.Command[1] != "-c" {
+		return fmt.Errorf("fetching credentials is not supported by command of sidecar: %v", container.Command)
+	}
+
+	// files of credentials are written by the fetch command instead of mounted from secret
+	volumes := []corev1.Volume{}
+	for _, v := range mountPod.Spec.Volumes {
+		if v.Name != "rsa-key" && v.Name != "init-config" {
+			volumes = append(volumes, v)
+		}
+	}
+	volumeMounts := []corev1.VolumeMount{}
+	for _, vm := range container.VolumeMounts {
+		if vm.Name != "rsa-key" && vm.Name != "init-config" {
+			volumeMounts = append(volumeMounts, vm)
+		}
+	}
+
+	expiration := credentialTokenExpiration
+	mountPod.Spec.Volumes = append(volumes, corev1.Volume{
+		Name: credentialVolumeName,
+		VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+					Audience:          config.CredentialAudience,
+					ExpirationSeconds: &expiration,
+					Path:              "token",
+				}},
+				{Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+					Items:                []corev1.KeyToPath{{Key: credentialCAKey, Path: "ca.crt"}},
+				}},
+			},
+		}},
+	})
+	container.VolumeMounts = append(volumeMounts, corev1.VolumeMount{
+		Name:      credentialVolumeName,
+		MountPath: credentialDir,
+		ReadOnly:  true,
+	})
+
+	endpoint := fmt.Sprintf("https://%s.%s.svc%s?pvc=%s", config.WebhookService, config.Namespace, config.CredentialPath, url.QueryEscape(pvcName))
+	container.Command[2] = fetchCredentialsCommand(endpoint, setting.Name) + "\n" + container.Command[2]
+	return nil
+}
+
+// fetchCredentialsCommand fetches credentials as envs, and writes credentials used as files
+func fetchCredentialsCommand(endpoint, name string) string {
+	return fmt.Sprintf(`curl -sSf --retry 5 --retry-connrefused --cacert %[1]s/ca.crt -H "Authorization: Bearer $(cat %[1]s/token)" -X POST '%[2]s' -o /tmp/.jfs-credentials || exit 1
+set -a; . /tmp/.jfs-credentials; set +a; rm -f /tmp/.jfs-credentials
+if [ -n "$encrypt_rsa_key" ]; then mkdir -p /root/.rsa && printf '%%s' "$encrypt_rsa_key" > /root/.rsa/rsa-key.pem; fi
+if [ -n "$initconfig" ]; then mkdir -p %[3]s && printf '%%s' "$initconfig" > %[3]s/%[4]s; fi`,
+		credentialDir, endpoint, config.ROConfPath, security.EscapeBashStr(name+".conf"))
+}
@@ -39,26 +39,45 @@ type SidecarMutate struct {
 	Client     *k8sclient.K8sClient
 	juicefs    juicefs.Interface
 	Serverless bool
+	// inject juicefs client as native sidecar, i.e. init container with restartPolicy Always
+	NativeSidecar bool
+
+	// only generate the mutated pod, without creating secrets
+	DryRun bool
 
 	Pair       []util.PVPair
 	jfsSetting *config.JfsSetting
+	// settings of each volume injected
+	Settings []*config.JfsSetting
 }
 
 var _ Mutate = &SidecarMutate{}
 
-func NewSidecarMutate(client *k8sclient.K8sClient, jfs juicefs.Interface, serverless bool, pair []util.PVPair) Mutate {
+func NewSidecarMutate(client *k8sclient.K8sClient, jfs juicefs.Interface, serverless, nativeSidecar bool, pair []util.PVPair) *SidecarMutate {
 	return &SidecarMutate{
-		Client:     client,
-		juicefs:    jfs,
-		Serverless: serverless,
-		Pair:       pair,
+		Client:        client,
+		juicefs:       jfs,
+		Serverless:    serverless,
+		NativeSidecar: nativeSidecar,
+		Pair:          pair,
 	}
 }
 
 func (s *SidecarMutate) Mutate(ctx context.Context, pod *corev1.Pod) (out *corev1.Pod, err error) {
 	out = pod.DeepCopy()
-	for i, pair := range s.Pair {
-		out, err = s.mutate(ctx, out, pair, i)
+	// claims are only recorded by webhook, which are trusted when serving credentials
+	delete(out.Annotations, config.InjectSidecarClaims)
+	volumes := make([]sidecarVolume, 0, len(s.Pair))
+	for _, pair := range s.Pair {
+		var setting *config.JfsSetting
+		setting, err = s.genSetting(ctx, pod, pair)
+		if err != nil {
+			return
+		}
+		volumes = append(volumes, sidecarVolume{pair: pair, setting: setting})
+	}
+	for i, group := range s.groupVolumes(pod, volumes) {
+		out, err = s.mutate(ctx, out, group, i)
 		if err != nil {
 			return
 		}
@@ -66,12 +85,19 @@ func (s *SidecarMutate) Mutate(ctx context.Context, pod *corev1.Pod) (out *corev
 	return
 }
 
-func (s *SidecarMutate) mutate(ctx context.Context, pod *corev1.Pod, pair util.PVPair, index int) (out *corev1.Pod, err error) {
+// sidecarVolume is a juicefs PVC of pod with its settings
+type sidecarVolume struct {
+	pair    util.PVPair
+	setting *config.JfsSetting
+}
+
+// genSetting generates juicefs settings of PVC from PV, PVC and secret
+func (s *SidecarMutate) genSetting(ctx context.Context, pod *corev1.Pod, pair util.PVPair) (*config.JfsSetting, error) {
 	// get secret, volumeContext and mountOptions from PV
 	secrets, volCtx, options, err := s.GetSettings(*pair.PV)
 	if err != nil {
 		klog.Errorf("get settings from pv %s of pod %s namespace %s err: %v", pair.PV.Name, pod.Name, pod.Namespace, err)
-		return
+		return nil, err
 	}
 
 	if volCtx == nil {
@@ -84,11 +110,110 @@ func (s *SidecarMutate) mutate(ctx context.Context, pod *corev1.Pod, pair util.P
 		}
 		volCtx[k] = v
 	}
-	out = pod.DeepCopy()
 	// gen jfs settings
-	jfsSetting, err := s.juicefs.Settings(ctx, pair.PV.Spec.CSI.VolumeHandle, secrets, volCtx, options)
-	if err != nil {
-		return
+	return s.juicefs.Settings(ctx, pair.PV.Spec.CSI.VolumeHandle, secrets, volCtx, options)
+}
+
+// groupVolumes groups PVCs served by one juicefs client. Each PVC has its own client unless shareSidecarClient
+// is enabled, then PVCs of the same filesystem with the same options, credentials and pod attributes share one.
+func (s *SidecarMutate) groupVolumes(pod *corev1.Pod, volumes []sidecarVolume) [][]sidecarVolume {
+	groups := make([][]sidecarVolume, 0, len(volumes))
+	if !config.GlobalConfig.ShareSidecarClient || !s.canShareClient(pod) {
+		for _, v := range volumes {
+			groups = append(groups, []sidecarVolume{v})
+		}
+		return groups
+	}
+	index := make(map[string]int)
+	for _, v := range volumes {
+		key := shareKey(v.setting)
+		if i, ok := index[key]; ok {
+			klog.V(5).Infof("pvc %s shares juicefs client with pvc %s", v.pair.PVC.Name, groups[i][0].pair.PVC.Name)
+			groups[i] = append(groups[i], v)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []sidecarVolume{v})
+	}
+	return groups
+}
+
+// canShareClient checks if mount point of juicefs client can be split into subPaths of PVCs,
+// which is only possible if it is propagated to app containers through hostPath
+func (s *SidecarMutate) canShareClient(pod *corev1.Pod) bool {
+	if !s.Serverless {
+		return true
+	}
+	if profile := config.GlobalConfig.FindServerlessProfile(config.NewServerlessProfileTarget(pod)); profile != nil {
+		return profile.GetMountPropagation() == config.ServerlessPropagationHostPath
+	}
+	if pod.Annotations != nil && pod.Annotations[builder.VCIANNOKey] == builder.VCIANNOValue {
+		return false
+	}
+	if pod.Labels != nil && pod.Labels[builder.CCIANNOKey] == builder.CCIANNOValue {
+		return false
+	}
+	return true
+}
+
+// newBuilder returns builder of juicefs sidecar for pod
+func (s *SidecarMutate) newBuilder(pod *corev1.Pod, pair util.PVPair, jfsSetting *config.JfsSetting, cap int64) builder.SidecarInterface {
+	if !s.Serverless {
+		return builder.NewContainerBuilder(jfsSetting, cap)
+	}
+	if profile := config.GlobalConfig.FindServerlessProfile(config.NewServerlessProfileTarget(pod)); profile != nil {
+		klog.V(5).Infof("use serverless profile %s for pod %s/%s", profile.Name, pod.Namespace, pod.Name)
+		return builder.NewProfileBuilder(jfsSetting, cap, *profile, *pod, *pair.PVC)
+	}
+	if pod.Annotations != nil && pod.Annotations[builder.VCIANNOKey] == builder.VCIANNOValue {
+		return builder.NewVCIBuilder(jfsSetting, cap, *pod, *pair.PVC)
+	}
+	if pod.Labels != nil && pod.Labels[builder.CCIANNOKey] == builder.CCIANNOValue {
+		return builder.NewCCIBuilder(jfsSetting, cap, *pod, *pair.PVC)
+	}
+	return builder.NewServerlessBuilder(jfsSetting, cap)
+}
+
+// shareKey returns what must be the same for PVCs to share one juicefs client
+func shareKey(setting *config.JfsSetting) string {
+	data, _ := json.Marshal(struct {
+		IsCe               bool
+		Name               string
+		Source             string
+		Options            []string
+		FormatCmd          string
+		Credentials        map[string]string
+		Attr               *config.PodAttr
+		CachePVCs          []config.CachePVC
+		CacheEmptyDir      *config.CacheEmptyDir
+		CacheInlineVolumes []*config.CacheInlineVolume
+	}{
+		IsCe:               setting.IsCe,
+		Name:               setting.Name,
+		Source:             setting.Source,
+		Options:            setting.Options,
+		FormatCmd:          setting.FormatCmd,
+		Credentials:        builder.GenCredentialData(setting),
+		Attr:               setting.Attr,
+		CachePVCs:          setting.CachePVCs,
+		CacheEmptyDir:      setting.CacheEmptyDir,
+		CacheInlineVolumes: setting.CacheInlineVolumes,
+	})
+	return string(data)
+}
+
+// mutate injects one juicefs client serving the group of PVCs. If the group has more than one PVC,
+// the client mounts root of the filesystem, and each PVC is mounted from its subPath.
+func (s *SidecarMutate) mutate(ctx context.Context, pod *corev1.Pod, group []sidecarVolume, index int) (out *corev1.Pod, err error) {
+	out = pod.DeepCopy()
+	pair := group[0].pair
+	jfsSetting := group[0].setting
+	shared := len(group) > 1
+	if shared {
+		// settings of the first PVC are used by the client, except that root is mounted
+		setting := *jfsSetting
+		setting.SubPath = ""
+		jfsSetting = &setting
 	}
 	mountPath := util.RandStringRunes(6)
 	jfsSetting.MountPath = filepath.Join(config.PodMountBase, mountPath)
@@ -96,32 +221,47 @@ func (s *SidecarMutate) mutate(ctx context.Context, pod *corev1.Pod, pair util.P
 	jfsSetting.Attr.Namespace = pod.Namespace
 	jfsSetting.SecretName = pair.PVC.Name + "-jfs-secret"
 	s.jfsSetting = jfsSetting
-	capacity := pair.PVC.Spec.Resources.Requests.Storage().Value()
-	cap := capacity / 1024 / 1024 / 1024
-	if cap <= 0 {
-		return nil, fmt.Errorf("capacity %d is too small, at least 1GiB for quota", capacity)
-	}
-
-	var r builder.SidecarInterface
-	if !s.Serverless {
-		r = builder.NewContainerBuilder(jfsSetting, cap)
-	} else if pod.Annotations != nil && pod.Annotations[builder.VCIANNOKey] == builder.VCIANNOValue {
-		r = builder.NewVCIBuilder(jfsSetting, cap, *pod, *pair.PVC)
-	} else if pod.Labels != nil && pod.Labels[builder.CCIANNOKey] == builder.CCIANNOValue {
-		r = builder.NewCCIBuilder(jfsSetting, cap, *pod, *pair.PVC)
-	} else {
-		r = builder.NewServerlessBuilder(jfsSetting, cap)
+	capacities := make([]int64, 0, len(group))
+	for _, v := range group {
+		s.Settings = append(s.Settings, v.setting)
+		capacity := v.pair.PVC.Spec.Resources.Requests.Storage().Value()
+		cap := capacity / 1024 / 1024 / 1024
+		if cap <= 0 {
+			return nil, fmt.Errorf("capacity %d is too small, at least 1GiB for quota", capacity)
+		}
+		capacities = append(capacities, cap)
 	}
+	r := s.newBuilder(pod, pair, jfsSetting, capacities[0])
 
-	// create secret per PVC
+	// create secret per PVC, credentials are not kept in it if sidecar fetches them from webhook
+	fetch := config.GlobalConfig.SidecarCredentials == config.SidecarCredentialsFetch
 	secret := r.NewSecret()
+	if fetch {
+		if err = stripCredentials(&secret, jfsSetting); err != nil {
+			return
+		}
+	}
 	builder.SetPVCAsOwner(&secret, pair.PVC)
-	if err = s.createOrUpdateSecret(ctx, &secret); err != nil {
-		return
+	if !s.DryRun {
+		if err = s.createOrUpdateSecret(ctx, &secret); err != nil {
+			return
+		}
 	}
 
 	// gen mount pod
 	mountPod := r.NewMountSidecar()
+	if shared {
+		subPaths := make([]builder.SharedSubPath, 0, len(group))
+		for i, v := range group {
+			subPaths = append(subPaths, builder.SharedSubPath{Setting: v.setting, Capacity: capacities[i]})
+		}
+		builder.SetSharedSubPaths(mountPod, jfsSetting, subPaths)
+	}
+	if fetch {
+		if err = fetchCredentials(mountPod, secret.Name, pair.PVC.Name, jfsSetting); err != nil {
+			return
+		}
+	}
 	podStr, _ := json.Marshal(mountPod)
 	klog.V(6).Infof("mount pod: %v\n", string(podStr))
 
@@ -129,11 +269,22 @@ func (s *SidecarMutate) mutate(ctx context.Context, pod *corev1.Pod, pair util.P
 	s.Deduplicate(pod, mountPod, index)
 
 	// inject volume
-	s.injectVolume(out, r, mountPod.Spec.Volumes, mountPath, pair)
+	if !shared {
+		s.injectVolume(out, r, mountPod.Spec.Volumes, mountPath, pair)
+	} else {
+		for i, v := range group {
+			volumes := mountPod.Spec.Volumes
+			if i > 0 {
+				volumes = nil
+			}
+			s.injectVolume(out, r, volumes, filepath.Join(mountPath, v.setting.SubPath), v.pair)
+		}
+	}
 	// inject label
 	s.injectLabel(out)
 	// inject annotation
 	s.injectAnnotation(out, mountPod.Annotations)
+	s.injectClaims(out, group)
 	// inject container
 	s.injectContainer(out, mountPod.Spec.Containers[0])
 
@@ -142,9 +293,11 @@ func (s *SidecarMutate) mutate(ctx context.Context, pod *corev1.Pod, pair util.P
 
 func (s *SidecarMutate) Deduplicate(pod, mountPod *corev1.Pod, index int) {
 	// deduplicate container name
-	for _, c := range pod.Spec.Containers {
-		if c.Name == mountPod.Spec.Containers[0].Name {
-			mountPod.Spec.Containers[0].Name = fmt.Sprintf("%s-%d", c.Name, index)
+	for _, cs := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range cs {
+			if c.Name == mountPod.Spec.Containers[0].Name {
+				mountPod.Spec.Containers[0].Name = fmt.Sprintf("%s-%d", c.Name, index)
+			}
 		}
 	}
 
@@ -207,6 +360,12 @@ func (s *SidecarMutate) GetSettings(pv corev1.PersistentVolume) (secrets, volCtx
 }
 
 func (s *SidecarMutate) injectContainer(pod *corev1.Pod, container corev1.Container) {
+	if s.NativeSidecar {
+		// native sidecar is started before init containers of app, and stopped by kubelet after app containers exit.
+		// restartPolicy is set when marshaling pod, see SetNativeSidecars.
+		pod.Spec.InitContainers = append([]corev1.Container{container}, pod.Spec.InitContainers...)
+		return
+	}
 	pod.Spec.Containers = append([]corev1.Container{container}, pod.Spec.Containers...)
 }
 
@@ -230,12 +389,19 @@ func (s *SidecarMutate) injectVolume(pod *corev1.Pod, build builder.SidecarInter
 			build.OverwriteVolumes(&volume, mountPath)
 			pod.Spec.Volumes[i] = volume
 
-			for cni, cn := range pod.Spec.Containers {
-				for j, vm := range cn.VolumeMounts {
-					// overwrite volumeMount
-					if vm.Name == volume.Name {
-						build.OverwriteVolumeMounts(&vm)
-						pod.Spec.Containers[cni].VolumeMounts[j] = vm
+			containers := [][]corev1.Container{pod.Spec.Containers}
+			if s.NativeSidecar {
+				// init containers of app are started after native sidecar, they can use the volume too
+				containers = append(containers, pod.Spec.InitContainers)
+			}
+			for _, cs := range containers {
+				for cni, cn := range cs {
+					for j, vm := range cn.VolumeMounts {
+						// overwrite volumeMount
+						if vm.Name == volume.Name {
+							build.OverwriteVolumeMounts(&vm)
+							cs[cni].VolumeMounts[j] = vm
+						}
 					}
 				}
 			}
@@ -256,6 +422,18 @@ func (s *SidecarMutate) injectLabel(pod *corev1.Pod) {
 	metaObj.DeepCopyInto(&pod.ObjectMeta)
 }
 
+// injectClaims records PVCs of the group in pod annotation, whose volumes are no longer in pod after injection
+func (s *SidecarMutate) injectClaims(pod *corev1.Pod, group []sidecarVolume) {
+	var claims []string
+	if recorded := pod.Annotations[config.InjectSidecarClaims]; recorded != "" {
+		claims = strings.Split(recorded, ",")
+	}
+	for _, v := range group {
+		claims = append(claims, v.pair.PVC.Name)
+	}
+	s.injectAnnotation(pod, map[string]string{config.InjectSidecarClaims: strings.Join(claims, ",")})
+}
+
 func (s *SidecarMutate) injectAnnotation(pod *corev1.Pod, annotations map[string]string) {
 	metaObj := pod.ObjectMeta
 
@@ -283,6 +461,8 @@ func (s *SidecarMutate) createOrUpdateSecret(ctx context.Context, secret *corev1
 			return err
 		}
 
+		// keys not generated any more (e.g. credentials when sidecar fetches them) are removed
+		oldSecret.Data = nil
 		oldSecret.StringData = secret.StringData
 		return s.Client.UpdateSecret(ctx, oldSecret)
 	})
@@ -292,3 +472,50 @@ func (s *SidecarMutate) createOrUpdateSecret(ctx context.Context, secret *corev1
 	}
 	return nil
 }
+
+// SetNativeSidecars sets restartPolicy Always of the given init containers in marshaled pod,
+// since restartPolicy of container is not supported by k8s.io/api in use.
+// restartPolicy of init containers in the original pod is kept as well, otherwise it will be removed by patch.
+func SetNativeSidecars(raw, marshaled []byte, sidecars []string) ([]byte, error) {
+	policies := make(map[string]interface{})
+	var origin map[string]interface{}
+	if err := json.Unmarshal(raw, &origin); err != nil {
+		return nil, err
+	}
+	for _, c := range initContainersOf(origin) {
+		if policy, ok := c["restartPolicy"]; ok {
+			policies[c["name"].(string)] = policy
+		}
+	}
+	for _, name := range sidecars {
+		policies[name] = string(corev1.RestartPolicyAlways)
+	}
+	if len(policies) == 0 {
+		return marshaled, nil
+	}
+
+	var out map[string]interface{}
+	if err := json.Unmarshal(marshaled, &out); err != nil {
+		return nil, err
+	}
+	for _, c := range initContainersOf(out) {
+		if policy, ok := policies[c["name"].(string)]; ok {
+			c["restartPolicy"] = policy
+		}
+	}
+	return json.Marshal(out)
+}
+
+func initContainersOf(pod map[string]interface{}) []map[string]interface{} {
+	spec, _ := pod["spec"].(map[string]interface{})
+	containers, _ := spec["initContainers"].([]interface{})
+	result := make([]map[string]interface{}, 0, len(containers))
+	for _, c := range containers {
+		if container, ok := c.(map[string]interface{}); ok {
+			if _, ok := container["name"].(string); ok {
+				result = append(result, container)
+			}
+		}
+	}
+	return result
+}
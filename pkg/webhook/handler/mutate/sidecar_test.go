@@ -17,8 +17,10 @@
 package mutate
 
 import (
+	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	corev1 "k8s.io/api/core/v1"
@@ -190,15 +192,50 @@ func TestSidecarMutate_injectVolume(t *testing.T) {
 	}
 }
 
+func TestSidecarMutate_injectVolumeNativeSidecar(t *testing.T) {
+	pair := volconf.PVPair{PVC: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"}}}
+	setting := &config.JfsSetting{VolumeId: "volume-id"}
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name:         "app-volume",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-1"}},
+			}},
+			InitContainers: []corev1.Container{{
+				Name:         "init",
+				VolumeMounts: []corev1.VolumeMount{{Name: "app-volume", MountPath: "/data"}},
+			}},
+			Containers: []corev1.Container{{
+				Name:         "app",
+				VolumeMounts: []corev1.VolumeMount{{Name: "app-volume", MountPath: "/data"}},
+			}},
+		}}
+	}
+	for _, nativeSidecar := range []bool{true, false} {
+		pod := newPod()
+		s := &SidecarMutate{jfsSetting: setting, NativeSidecar: nativeSidecar}
+		r := builder.NewVCIBuilder(setting, 0, *pod, *pair.PVC)
+		s.injectVolume(pod, r, nil, "data", pair)
+		if pod.Spec.Containers[0].VolumeMounts[0].MountPropagation == nil {
+			t.Errorf("nativeSidecar %v: volumeMount of app container is not overwritten", nativeSidecar)
+		}
+		if overwritten := pod.Spec.InitContainers[0].VolumeMounts[0].MountPropagation != nil; overwritten != nativeSidecar {
+			t.Errorf("nativeSidecar %v: volumeMount of init container overwritten = %v", nativeSidecar, overwritten)
+		}
+	}
+}
+
 func TestSidecarMutate_injectContainer(t *testing.T) {
 	type args struct {
 		pod       *corev1.Pod
 		container corev1.Container
 	}
 	tests := []struct {
-		name             string
-		args             args
-		wantContainerLen int
+		name                 string
+		args                 args
+		nativeSidecar        bool
+		wantContainerLen     int
+		wantInitContainerLen int
 	}{
 		{
 			name: "test inject init container",
@@ -211,18 +248,59 @@ func TestSidecarMutate_injectContainer(t *testing.T) {
 			},
 			wantContainerLen: 1,
 		},
+		{
+			name: "test inject native sidecar",
+			args: args{
+				pod: &corev1.Pod{Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "init"}},
+					Containers:     []corev1.Container{{Name: "app"}},
+				}},
+				container: corev1.Container{
+					Name:  "mount",
+					Image: "juicedata/mount:latest",
+				},
+			},
+			nativeSidecar:        true,
+			wantContainerLen:     1,
+			wantInitContainerLen: 2,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &SidecarMutate{}
+			s := &SidecarMutate{NativeSidecar: tt.nativeSidecar}
 			s.injectContainer(tt.args.pod, tt.args.container)
 			if len(tt.args.pod.Spec.Containers) != tt.wantContainerLen {
 				t.Errorf("injectContainer() = %v, want %v", tt.args.pod.Spec.Containers, tt.wantContainerLen)
 			}
+			if len(tt.args.pod.Spec.InitContainers) != tt.wantInitContainerLen {
+				t.Errorf("injectContainer() init containers = %v, want %v", tt.args.pod.Spec.InitContainers, tt.wantInitContainerLen)
+			}
+			if tt.nativeSidecar && tt.args.pod.Spec.InitContainers[0].Name != tt.args.container.Name {
+				t.Errorf("injectContainer() native sidecar should be the first init container, got %v", tt.args.pod.Spec.InitContainers)
+			}
 		})
 	}
 }
 
+func TestSetNativeSidecars(t *testing.T) {
+	raw := []byte(`{"spec":{"initContainers":[{"name":"istio-proxy","restartPolicy":"Always"},{"name":"init"}],"containers":[{"name":"app"}]}}`)
+	marshaled := []byte(`{"spec":{"initContainers":[{"name":"jfs-mount"},{"name":"istio-proxy"},{"name":"init"}],"containers":[{"name":"app"}]}}`)
+	got, err := SetNativeSidecars(raw, marshaled, []string{"jfs-mount"})
+	if err != nil {
+		t.Fatalf("SetNativeSidecars() error = %v", err)
+	}
+	want := `{"spec":{"containers":[{"name":"app"}],"initContainers":[{"name":"jfs-mount","restartPolicy":"Always"},{"name":"istio-proxy","restartPolicy":"Always"},{"name":"init"}]}}`
+	if string(got) != want {
+		t.Errorf("SetNativeSidecars() got = %s, want %s", got, want)
+	}
+
+	// nothing changed if there is no native sidecar
+	got, err = SetNativeSidecars([]byte(`{"spec":{}}`), marshaled, nil)
+	if err != nil || string(got) != string(marshaled) {
+		t.Errorf("SetNativeSidecars() got = %s, err = %v, want %s", got, err, marshaled)
+	}
+}
+
 func TestSidecarMutate_Deduplicate(t *testing.T) {
 	type args struct {
 		pod      *corev1.Pod
@@ -316,3 +394,189 @@ func TestSidecarMutate_Deduplicate(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchCredentials(t *testing.T) {
+	certDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(certDir, "ca.crt"), []byte("ca"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(dir string) { config.WebhookCertDir = dir }(config.WebhookCertDir)
+	config.WebhookCertDir = certDir
+
+	setting := &config.JfsSetting{
+		Name:          "test",
+		MetaUrl:       "redis://127.0.0.1:6379/0",
+		SecretKey:     "secret",
+		EncryptRsaKey: "rsa",
+		Envs:          map[string]string{"AWS_SECRET": "aws"},
+	}
+	secret := corev1.Secret{StringData: map[string]string{
+		"metaurl":         "redis://127.0.0.1:6379/0",
+		"secretkey":       "secret",
+		"encrypt_rsa_key": "rsa",
+		"AWS_SECRET":      "aws",
+		"check_mount.sh":  "script",
+	}}
+	if err := stripCredentials(&secret, setting); err != nil {
+		t.Fatalf("stripCredentials() error = %v", err)
+	}
+	wantData := map[string]string{"check_mount.sh": "script", credentialCAKey: "ca"}
+	if !reflect.DeepEqual(secret.StringData, wantData) {
+		t.Errorf("stripCredentials() data = %v, want %v", secret.StringData, wantData)
+	}
+
+	mountPod := &corev1.Pod{Spec: corev1.PodSpec{
+		Volumes: []corev1.Volume{{Name: "rsa-key"}, {Name: "jfs-check-mount"}},
+		Containers: []corev1.Container{{
+			Command:      []string{"sh", "-c", "/bin/mount.juicefs ${metaurl} /jfs/abc"},
+			VolumeMounts: []corev1.VolumeMount{{Name: "rsa-key"}, {Name: "jfs-check-mount"}},
+		}},
+	}}
+	if err := fetchCredentials(mountPod, "pvc-jfs-secret", "pvc", setting); err != nil {
+		t.Fatalf("fetchCredentials() error = %v", err)
+	}
+	if len(mountPod.Spec.Volumes) != 2 || mountPod.Spec.Volumes[1].Name != credentialVolumeName {
+		t.Errorf("fetchCredentials() volumes = %v", mountPod.Spec.Volumes)
+	}
+	token := mountPod.Spec.Volumes[1].Projected.Sources[0].ServiceAccountToken
+	if token == nil || token.Audience != config.CredentialAudience {
+		t.Errorf("fetchCredentials() token projection = %v", token)
+	}
+	container := mountPod.Spec.Containers[0]
+	if len(container.VolumeMounts) != 2 || container.VolumeMounts[1].MountPath != credentialDir {
+		t.Errorf("fetchCredentials() volumeMounts = %v", container.VolumeMounts)
+	}
+	if !strings.Contains(container.Command[2], "?pvc=pvc") || !strings.HasSuffix(container.Command[2], "/bin/mount.juicefs ${metaurl} /jfs/abc") {
+		t.Errorf("fetchCredentials() command = %s", container.Command[2])
+	}
+}
+
+func TestSidecarMutate_injectVolumeWithProfile(t *testing.T) {
+	fsType := "gpath"
+	none := corev1.MountPropagationNone
+	profile := config.ServerlessProfile{
+		Name:                "csi",
+		MountPropagation:    config.ServerlessPropagationCSI,
+		CSI:                 &config.ServerlessProfileCSI{Driver: "juicefs.csi.example.com", FSType: &fsType, MountPointAttribute: "mountpoint"},
+		AppMountPropagation: &none,
+	}
+	pvc := corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"}}
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Tolerations: []corev1.Toleration{{Key: config.VirtualKubeletProviderKey, Value: "acme"}},
+			Volumes: []corev1.Volume{{
+				Name:         "app-volume",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc-1"}},
+			}},
+			Containers: []corev1.Container{{
+				Name:         "test",
+				VolumeMounts: []corev1.VolumeMount{{Name: "app-volume", MountPath: "data"}},
+			}},
+		},
+	}
+	if target := config.NewServerlessProfileTarget(pod); !reflect.DeepEqual(target.Providers, []string{"acme"}) {
+		t.Errorf("NewServerlessProfileTarget() providers = %v", target.Providers)
+	}
+
+	s := &SidecarMutate{}
+	r := builder.NewProfileBuilder(&config.JfsSetting{VolumeId: "volume-id"}, 1, profile, *pod, pvc)
+	s.injectVolume(pod, r, nil, "abc", volconf.PVPair{PVC: &pvc})
+
+	wantVolume := corev1.Volume{
+		Name: "app-volume",
+		VolumeSource: corev1.VolumeSource{CSI: &corev1.CSIVolumeSource{
+			Driver:           "juicefs.csi.example.com",
+			FSType:           &fsType,
+			VolumeAttributes: map[string]string{"mountpoint": "abc"},
+		}},
+	}
+	if !reflect.DeepEqual(pod.Spec.Volumes, []corev1.Volume{wantVolume}) {
+		t.Errorf("injectVolume() volumes = %v, want %v", pod.Spec.Volumes, wantVolume)
+	}
+	if mp := pod.Spec.Containers[0].VolumeMounts[0].MountPropagation; mp == nil || *mp != none {
+		t.Errorf("injectVolume() mountPropagation = %v, want %v", mp, none)
+	}
+}
+
+func TestSidecarMutate_groupVolumes(t *testing.T) {
+	defer func() { config.GlobalConfig.ShareSidecarClient = false }()
+	newVolume := func(pvc, source, subPath string) sidecarVolume {
+		return sidecarVolume{
+			pair: volconf.PVPair{PVC: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: pvc}}},
+			setting: &config.JfsSetting{
+				IsCe:    true,
+				Name:    "test",
+				Source:  source,
+				MetaUrl: source,
+				SubPath: subPath,
+				Attr:    &config.PodAttr{Image: "juicedata/mount:ce"},
+			},
+		}
+	}
+	volumes := []sidecarVolume{
+		newVolume("pvc-1", "redis://127.0.0.1:6379/0", "pvc-1"),
+		newVolume("pvc-2", "redis://127.0.0.1:6379/1", "pvc-2"),
+		newVolume("pvc-3", "redis://127.0.0.1:6379/0", "pvc-3"),
+	}
+	groupNames := func(groups [][]sidecarVolume) [][]string {
+		names := [][]string{}
+		for _, g := range groups {
+			n := []string{}
+			for _, v := range g {
+				n = append(n, v.pair.PVC.Name)
+			}
+			names = append(names, n)
+		}
+		return names
+	}
+
+	s := &SidecarMutate{}
+	pod := &corev1.Pod{}
+	if got := groupNames(s.groupVolumes(pod, volumes)); !reflect.DeepEqual(got, [][]string{{"pvc-1"}, {"pvc-2"}, {"pvc-3"}}) {
+		t.Errorf("groupVolumes() = %v, want one client per pvc", got)
+	}
+
+	config.GlobalConfig.ShareSidecarClient = true
+	if got := groupNames(s.groupVolumes(pod, volumes)); !reflect.DeepEqual(got, [][]string{{"pvc-1", "pvc-3"}, {"pvc-2"}}) {
+		t.Errorf("groupVolumes() = %v, want pvc-1 and pvc-3 grouped", got)
+	}
+
+	// mount point of VCI sidecar is not propagated through hostPath
+	s = &SidecarMutate{Serverless: true}
+	pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{builder.VCIANNOKey: builder.VCIANNOValue}}}
+	if got := groupNames(s.groupVolumes(pod, volumes)); len(got) != 3 {
+		t.Errorf("groupVolumes() = %v, want one client per pvc in VCI", got)
+	}
+
+	// postStart of sidecar checking mount point is kept
+	mountPod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Lifecycle: &corev1.Lifecycle{
+		PostStart: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"bash", "-c", "check-mount"}}},
+	}}}}}
+	shared := &config.JfsSetting{IsCe: true, Name: "test", MountPath: "/jfs/abc"}
+	builder.SetSharedSubPaths(mountPod, shared, []builder.SharedSubPath{
+		{Setting: volumes[0].setting, Capacity: 1},
+		{Setting: volumes[2].setting, Capacity: 2},
+	})
+	cmd := mountPod.Spec.Containers[0].Lifecycle.PostStart.Exec.Command[2]
+	if !strings.HasPrefix(cmd, "check-mount && ") {
+		t.Errorf("SetSharedSubPaths() postStart = %s, want existing hook kept", cmd)
+	}
+	for _, want := range []string{"mkdir -p /jfs/abc/pvc-1", "subpath=pvc-3 name=test capacity=2 community=ce quotaPath=pvc-3"} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("SetSharedSubPaths() postStart = %s, want contains %s", cmd, want)
+		}
+	}
+}
+
+func TestSidecarMutate_injectClaims(t *testing.T) {
+	newVolume := func(pvc string) sidecarVolume {
+		return sidecarVolume{pair: volconf.PVPair{PVC: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: pvc}}}}
+	}
+	s := &SidecarMutate{}
+	pod := &corev1.Pod{}
+	s.injectClaims(pod, []sidecarVolume{newVolume("pvc-1"), newVolume("pvc-3")})
+	s.injectClaims(pod, []sidecarVolume{newVolume("pvc-2")})
+	if got := pod.Annotations[config.InjectSidecarClaims]; got != "pvc-1,pvc-3,pvc-2" {
+		t.Errorf("injectClaims() annotation = %s, want pvc-1,pvc-3,pvc-2", got)
+	}
+}
@@ -17,6 +17,8 @@
 package handler
 
 import (
+	"context"
+
 	"k8s.io/klog"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -30,17 +32,32 @@ const (
 	ServerlessPath = "/juicefs/serverless/inject-v1-pod"
 	SecretPath     = "/juicefs/validate-secret"
 	PVPath         = "/juicefs/validate-pv"
+	PVCPath        = "/juicefs/validate-pvc"
+	SCPath         = "/juicefs/validate-storageclass"
+	DryRunPath     = "/juicefs/dry-run-inject"
 )
 
 // Register registers the handlers to the manager
 func Register(mgr manager.Manager, client *k8sclient.K8sClient) {
 	server := mgr.GetWebhookServer()
-	server.Register(SidecarPath, &webhook.Admission{Handler: NewSidecarHandler(client, false)})
+	sidecarHandler, serverlessHandler := NewSidecarHandler(client, false), NewSidecarHandler(client, true)
+	server.Register(SidecarPath, &webhook.Admission{Handler: sidecarHandler})
 	klog.Infof("Registered webhook handler path %s for sidecar", SidecarPath)
-	server.Register(ServerlessPath, &webhook.Admission{Handler: NewSidecarHandler(client, true)})
+	server.Register(ServerlessPath, &webhook.Admission{Handler: serverlessHandler})
 	klog.Infof("Registered webhook handler path %s for serverless", ServerlessPath)
+	server.Register(DryRunPath, NewDryRunHandler(client, sidecarHandler, serverlessHandler))
+	klog.Infof("Registered handler path %s for dry run of sidecar injection", DryRunPath)
+	if config.GlobalConfig.SidecarCredentials == config.SidecarCredentialsFetch {
+		// switching to fetch mode later requires restarting webhook
+		server.Register(config.CredentialPath, NewCredentialHandler(client))
+		klog.Infof("Registered handler path %s for sidecar credentials", config.CredentialPath)
+	}
 	if config.ValidatingWebhook {
-		server.Register(SecretPath, &webhook.Admission{Handler: NewSecretHandler(client)})
+		secretHandler := NewSecretHandler(client)
+		secretHandler.loadWebhookTimeout(context.TODO())
+		server.Register(SecretPath, &webhook.Admission{Handler: secretHandler})
 		server.Register(PVPath, &webhook.Admission{Handler: NewPVHandler(client)})
+		server.Register(PVCPath, &webhook.Admission{Handler: NewPVCHandler(client)})
+		server.Register(SCPath, &webhook.Admission{Handler: NewStorageClassHandler(client)})
 	}
 }
@@ -2,40 +2,66 @@ package validator
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
 
 	"github.com/juicedata/juicefs-csi-driver/pkg/config"
 	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs"
 )
 
 type SecretValidator struct {
-	jfs juicefs.Interface
+	jfs    juicefs.Interface
+	policy *config.SecretValidation
 }
 
 var _ Validator[corev1.Secret] = &SecretValidator{}
 
-func NewSecretValidator(jfs juicefs.Interface) *SecretValidator {
+// checkResult is the result of checking metadata engine and object storage of a secret
+type checkResult struct {
+	err    error
+	expire time.Time
+}
+
+// checkResults caches results of checks by content of secret, shared by all validators
+var checkResults = struct {
+	sync.Mutex
+	results map[string]checkResult
+}{results: map[string]checkResult{}}
+
+func NewSecretValidator(jfs juicefs.Interface, policy *config.SecretValidation) *SecretValidator {
 	return &SecretValidator{
-		jfs: jfs,
+		jfs:    jfs,
+		policy: policy,
 	}
 }
 
 func (s *SecretValidator) Validate(ctx context.Context, secret corev1.Secret) error {
+	_, err := s.ValidateWithWarnings(ctx, secret)
+	return err
+}
+
+// ValidateWithWarnings validates secret, failed checks are returned as warnings in warn-only mode
+func (s *SecretValidator) ValidateWithWarnings(ctx context.Context, secret corev1.Secret) ([]string, error) {
 	secretsMap := make(map[string]string)
 	if configs, ok := secret.Data["configs"]; ok {
 		err := s.ValidateConfigs(string(configs[:]))
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	if envs, ok := secret.Data["envs"]; ok {
 		err := s.ValidateEnvs(string(envs[:]))
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -44,31 +70,100 @@ func (s *SecretValidator) Validate(ctx context.Context, secret corev1.Secret) er
 	}
 	jfsSetting, err := s.jfs.Settings(ctx, "", secretsMap, nil, nil)
 	if err != nil {
+		return nil, err
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, s.policy.GetTimeout())
+	defer cancel()
+	if err := s.cachedCheck(checkCtx, secretsMap, jfsSetting); err != nil {
+		if s.policy != nil && s.policy.WarnOnly {
+			return []string{fmt.Sprintf("secret %s check failed: %v", secret.Name, err)}, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// cachedCheck checks secret, or returns cached result of the same secret content
+func (s *SecretValidator) cachedCheck(ctx context.Context, secrets map[string]string, setting *config.JfsSetting) error {
+	ttl := s.policy.GetCacheTTL()
+	key, err := s.cacheKey(secrets)
+	if err != nil || ttl == 0 {
+		return s.check(ctx, secrets, setting)
+	}
+
+	now := time.Now()
+	checkResults.Lock()
+	result, ok := checkResults.results[key]
+	checkResults.Unlock()
+	if ok && now.Before(result.expire) {
+		klog.V(5).Infof("use cached check result of secret: %v", result.err)
+		return result.err
+	}
+
+	err = s.check(ctx, secrets, setting)
+	// timeout may be transient, not cached
+	if ctx.Err() != nil {
 		return err
 	}
+	checkResults.Lock()
+	defer checkResults.Unlock()
+	for k, r := range checkResults.results {
+		if now.After(r.expire) {
+			delete(checkResults.results, k)
+		}
+	}
+	checkResults.results[key] = checkResult{err: err, expire: now.Add(ttl)}
+	return err
+}
 
+// cacheKey is hash of secret content and checks to run
+func (s *SecretValidator) cacheKey(secrets map[string]string) (string, error) {
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write(data)
+	if s.policy != nil && s.policy.ProbeStorage {
+		h.Write([]byte("probe"))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// check checks metadata engine (community edition) or auth (enterprise edition), and object storage if probe is enabled
+func (s *SecretValidator) check(ctx context.Context, secrets map[string]string, setting *config.JfsSetting) error {
 	tempConfDir, err := os.MkdirTemp(os.TempDir(), "juicefs-")
 	if err != nil {
 		return err
 	}
 	defer os.RemoveAll(tempConfDir)
-	jfsSetting.ClientConfPath = tempConfDir
-	if jfsSetting.IsCe {
-		metaUrl := secretsMap["metaurl"]
+	setting.ClientConfPath = tempConfDir
+	probe := s.policy != nil && s.policy.ProbeStorage
+	if setting.IsCe {
+		metaUrl := secrets["metaurl"]
 		if metaUrl == "" {
 			return fmt.Errorf("metaurl is empty")
 		}
 		if err := s.jfs.Status(ctx, metaUrl); err != nil {
 			return err
 		}
-	} else {
-		_, err := s.jfs.AuthFs(ctx, secretsMap, jfsSetting, true)
-		if err != nil {
-			return err
+		if !probe {
+			return nil
+		}
+		// storage of formatted volume is kept in metadata engine, which can not be probed without bucket in secret
+		if secrets["bucket"] == "" {
+			klog.V(5).Infof("bucket is not set in secret %s, skip checking object storage", secrets["name"])
+			return nil
 		}
+		return s.jfs.CheckStorage(ctx, secrets, setting)
 	}
-
-	return nil
+	if probe {
+		// auth with object storage checked
+		return s.jfs.CheckStorage(ctx, secrets, setting)
+	}
+	_, err = s.jfs.AuthFs(ctx, secrets, setting, true)
+	return err
 }
 
 func (s *SecretValidator) ValidateConfigs(configs string) error {
@@ -1,7 +1,92 @@
 package validator
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs/mocks"
+)
 
 func TestSecretValidate_Validate(t *testing.T) {
-	// TODO
+	newSecret := func(bucket string) corev1.Secret {
+		return corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "juicefs-secret"},
+			Data: map[string][]byte{
+				"name":    []byte("test"),
+				"metaurl": []byte("redis://127.0.0.1:6379/0"),
+				"bucket":  []byte(bucket),
+			},
+		}
+	}
+	setting := func() *config.JfsSetting { return &config.JfsSetting{IsCe: true} }
+	storageErr := errors.New("access denied")
+
+	t.Run("cached by secret content", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		jfs := mocks.NewMockInterface(mockCtl)
+		jfs.EXPECT().Settings(gomock.Any(), "", gomock.Any(), nil, nil).DoAndReturn(
+			func(context.Context, string, map[string]string, map[string]string, []string) (*config.JfsSetting, error) {
+				return setting(), nil
+			}).Times(3)
+		jfs.EXPECT().Status(gomock.Any(), "redis://127.0.0.1:6379/0").Return(nil).Times(2)
+
+		v := NewSecretValidator(jfs, nil)
+		if err := v.Validate(context.TODO(), newSecret("http://cached-a")); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		if err := v.Validate(context.TODO(), newSecret("http://cached-a")); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		if err := v.Validate(context.TODO(), newSecret("http://cached-b")); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+	})
+
+	t.Run("probe storage", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		jfs := mocks.NewMockInterface(mockCtl)
+		jfs.EXPECT().Settings(gomock.Any(), "", gomock.Any(), nil, nil).Return(setting(), nil)
+		jfs.EXPECT().Status(gomock.Any(), gomock.Any()).Return(nil)
+		jfs.EXPECT().CheckStorage(gomock.Any(), gomock.Any(), gomock.Any()).Return(storageErr)
+
+		v := NewSecretValidator(jfs, &config.SecretValidation{ProbeStorage: true})
+		if err := v.Validate(context.TODO(), newSecret("http://probe")); !errors.Is(err, storageErr) {
+			t.Fatalf("Validate() error = %v, want %v", err, storageErr)
+		}
+	})
+
+	t.Run("warn only", func(t *testing.T) {
+		mockCtl := gomock.NewController(t)
+		defer mockCtl.Finish()
+		jfs := mocks.NewMockInterface(mockCtl)
+		jfs.EXPECT().Settings(gomock.Any(), "", gomock.Any(), nil, nil).Return(setting(), nil)
+		jfs.EXPECT().Status(gomock.Any(), gomock.Any()).Return(nil)
+		jfs.EXPECT().CheckStorage(gomock.Any(), gomock.Any(), gomock.Any()).Return(storageErr)
+
+		v := NewSecretValidator(jfs, &config.SecretValidation{ProbeStorage: true, WarnOnly: true})
+		warnings, err := v.ValidateWithWarnings(context.TODO(), newSecret("http://warn-only"))
+		if err != nil {
+			t.Fatalf("ValidateWithWarnings() error = %v", err)
+		}
+		if len(warnings) != 1 {
+			t.Errorf("ValidateWithWarnings() warnings = %v, want 1 warning", warnings)
+		}
+	})
+
+	t.Run("invalid envs are always rejected", func(t *testing.T) {
+		secret := newSecret("http://invalid-envs")
+		secret.Data["envs"] = []byte("{a: b")
+		v := NewSecretValidator(nil, &config.SecretValidation{WarnOnly: true})
+		if _, err := v.ValidateWithWarnings(context.TODO(), secret); err == nil {
+			t.Errorf("ValidateWithWarnings() expect error for invalid envs")
+		}
+	})
 }
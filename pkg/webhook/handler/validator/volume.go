@@ -0,0 +1,218 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package validator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/klog"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/juicefs"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+)
+
+// ceMountOptions are mount options known by juicefs community edition, unknown options only cause warnings,
+// because options vary between versions of juicefs.
+var ceMountOptions = map[string]bool{
+	// global
+	"verbose": true, "debug": true, "quiet": true, "trace": true, "no-agent": true, "pyroscope": true,
+	// mount
+	"metrics": true, "consul": true, "no-usage-report": true, "log": true, "no-syslog": true, "update-fstab": true,
+	"attr-cache": true, "entry-cache": true, "dir-entry-cache": true, "open-cache": true, "open-cache-limit": true,
+	"readdir-cache": true, "enable-xattr": true, "enable-ioctl": true, "root-squash": true, "prefix-internal": true,
+	// meta
+	"subdir": true, "backup-meta": true, "backup-skip-trash": true, "heartbeat": true, "read-only": true,
+	"no-bgjob": true, "atime-mode": true, "skip-dir-nlink": true, "skip-dir-mtime": true,
+	// data storage
+	"storage-class": true, "get-timeout": true, "put-timeout": true, "io-retries": true, "max-uploads": true,
+	"max-stage-write": true, "max-deletes": true, "upload-limit": true, "download-limit": true,
+	// data cache
+	"buffer-size": true, "prefetch": true, "writeback": true, "upload-delay": true, "upload-hours": true,
+	"cache-dir": true, "cache-mode": true, "cache-size": true, "free-space-ratio": true, "cache-partial-only": true,
+	"verify-cache-checksum": true, "cache-eviction": true, "cache-scan-interval": true, "cache-expire": true,
+	// fuse
+	"allow_other": true, "allow_root": true, "writeback_cache": true, "ro": true, "rw": true, "fsname": true,
+	"nonempty": true, "max_read": true, "default_permissions": true, "auto_unmount": true,
+}
+
+// VolumeValidator validates juicefs settings in PV, PVC and StorageClass,
+// which are otherwise only parsed when mounting volume on node.
+type VolumeValidator struct {
+	client *k8sclient.K8sClient
+}
+
+func NewVolumeValidator(client *k8sclient.K8sClient) *VolumeValidator {
+	return &VolumeValidator{client: client}
+}
+
+// ValidatePV validates volume attributes and mount options of pv, returns warnings for unknown mount options
+func (v *VolumeValidator) ValidatePV(ctx context.Context, pv corev1.PersistentVolume) ([]string, error) {
+	if pv.Spec.CSI == nil {
+		return nil, nil
+	}
+	volCtx := pv.Spec.CSI.VolumeAttributes
+	if err := config.ValidateVolumeContext(volCtx); err != nil {
+		return nil, err
+	}
+	options := append([]string{}, pv.Spec.MountOptions...)
+	if opts := volCtx["mountOptions"]; opts != "" {
+		options = append(options, strings.Split(opts, ",")...)
+	}
+	if err := juicefs.ValidateMountOptions(options, volCtx); err != nil {
+		return nil, err
+	}
+	return v.unknownOptionWarnings(ctx, pv.Spec.CSI.NodePublishSecretRef, options), nil
+}
+
+// ValidatePVC validates juicefs settings in annotations of pvc, which overwrite volume attributes of pv.
+// Only annotations changed from oldPVC are validated on update, so that pvc with invalid settings created
+// before can still be updated (e.g. removing finalizers), and pvcs of other drivers are skipped.
+// Settings depending on each other are validated with all annotations of pvc, but errors already in oldPVC
+// don't involve changed annotations and are ignored.
+func (v *VolumeValidator) ValidatePVC(ctx context.Context, pvc corev1.PersistentVolumeClaim, oldPVC *corev1.PersistentVolumeClaim) ([]string, error) {
+	if pvc.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	volCtx := juicefsAnnotations(&pvc)
+	var changed []string
+	for k, val := range volCtx {
+		if oldPVC == nil || oldPVC.Annotations[k] != val {
+			changed = append(changed, k)
+		}
+	}
+	removed := false
+	if oldPVC != nil {
+		for k := range juicefsAnnotations(oldPVC) {
+			if _, ok := volCtx[k]; !ok {
+				removed = true
+			}
+		}
+	}
+	if (len(changed) == 0 && !removed) || !v.isJuiceFSClaim(ctx, pvc) {
+		return nil, nil
+	}
+	sort.Strings(changed)
+	for _, k := range changed {
+		if err := config.ValidateVolumeContextKey(k, volCtx[k]); err != nil {
+			return nil, err
+		}
+	}
+	err := config.ValidateVolumeContextCombination(volCtx)
+	if err == nil || oldPVC == nil {
+		return nil, err
+	}
+	if oldErr := config.ValidateVolumeContextCombination(juicefsAnnotations(oldPVC)); oldErr != nil && oldErr.Error() == err.Error() {
+		klog.V(5).Infof("pvc %s/%s has invalid settings before update: %v", pvc.Namespace, pvc.Name, err)
+		return nil, nil
+	}
+	return nil, err
+}
+
+// juicefsAnnotations returns annotations of pvc with juicefs/ prefix
+func juicefsAnnotations(pvc *corev1.PersistentVolumeClaim) map[string]string {
+	volCtx := make(map[string]string)
+	for k, val := range pvc.Annotations {
+		if strings.HasPrefix(k, "juicefs/") {
+			volCtx[k] = val
+		}
+	}
+	return volCtx
+}
+
+// isJuiceFSClaim checks if pvc is bound to or provisioned by juicefs, from its pv or storageClass
+func (v *VolumeValidator) isJuiceFSClaim(ctx context.Context, pvc corev1.PersistentVolumeClaim) bool {
+	if v.client == nil {
+		return false
+	}
+	if pvc.Spec.VolumeName != "" {
+		pv, err := v.client.GetPersistentVolume(ctx, pvc.Spec.VolumeName)
+		if err == nil {
+			return pv.Spec.CSI != nil && pv.Spec.CSI.Driver == config.DriverName
+		}
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false
+	}
+	sc, err := v.client.GetStorageClass(ctx, *pvc.Spec.StorageClassName)
+	if err != nil {
+		klog.V(5).Infof("get storageClass %s error: %v, skip validating pvc %s/%s", *pvc.Spec.StorageClassName, err, pvc.Namespace, pvc.Name)
+		return false
+	}
+	return sc.Provisioner == config.DriverName
+}
+
+// ValidateStorageClass validates parameters and mount options of storageClass.
+// Values with template (e.g. ${.pvc.name}) are rendered when provisioning, which are skipped.
+func (v *VolumeValidator) ValidateStorageClass(ctx context.Context, sc storagev1.StorageClass) ([]string, error) {
+	if sc.Provisioner != config.DriverName {
+		return nil, nil
+	}
+	volCtx := make(map[string]string)
+	for k, val := range sc.Parameters {
+		if !strings.Contains(val, "$") {
+			volCtx[k] = val
+		}
+	}
+	if err := config.ValidateVolumeContext(volCtx); err != nil {
+		return nil, err
+	}
+	var options []string
+	for _, o := range sc.MountOptions {
+		if !strings.Contains(o, "$") {
+			options = append(options, o)
+		}
+	}
+	if err := juicefs.ValidateMountOptions(options, volCtx); err != nil {
+		return nil, err
+	}
+	var secretRef *corev1.SecretReference
+	name, namespace := sc.Parameters[config.ProvisionerSecretName], sc.Parameters[config.ProvisionerSecretNamespace]
+	if name != "" && namespace != "" && !strings.Contains(name+namespace, "$") {
+		secretRef = &corev1.SecretReference{Name: name, Namespace: namespace}
+	}
+	return v.unknownOptionWarnings(ctx, secretRef, options), nil
+}
+
+// unknownOptionWarnings warns mount options unknown by community edition,
+// only checked when the volume is known as community edition from its secret.
+func (v *VolumeValidator) unknownOptionWarnings(ctx context.Context, secretRef *corev1.SecretReference, options []string) []string {
+	if v.client == nil || secretRef == nil || len(options) == 0 {
+		return nil
+	}
+	secret, err := v.client.GetSecret(ctx, secretRef.Name, secretRef.Namespace)
+	if err != nil {
+		klog.V(5).Infof("get secret %s/%s error: %v, skip checking mount options", secretRef.Namespace, secretRef.Name, err)
+		return nil
+	}
+	if _, ok := secret.Data["metaurl"]; !ok {
+		return nil
+	}
+	var warnings []string
+	for _, o := range options {
+		key := strings.TrimSpace(strings.SplitN(o, "=", 2)[0])
+		if key != "" && !ceMountOptions[key] {
+			warnings = append(warnings, fmt.Sprintf("mount option %s may be unknown by juicefs community edition", key))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,181 @@
+/*
+ Copyright 2024 Juicedata Inc
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package validator
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/juicedata/juicefs-csi-driver/pkg/config"
+	"github.com/juicedata/juicefs-csi-driver/pkg/k8sclient"
+)
+
+func TestVolumeValidator_ValidateStorageClass(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "juicefs-secret", Namespace: "default"},
+		Data:       map[string][]byte{"name": []byte("test"), "metaurl": []byte("redis://127.0.0.1:6379/0")},
+	}
+	v := NewVolumeValidator(&k8sclient.K8sClient{Interface: fake.NewSimpleClientset(secret)})
+	params := func(kv ...string) map[string]string {
+		p := map[string]string{
+			config.ProvisionerSecretName:      "juicefs-secret",
+			config.ProvisionerSecretNamespace: "default",
+		}
+		for i := 0; i+1 < len(kv); i += 2 {
+			p[kv[i]] = kv[i+1]
+		}
+		return p
+	}
+	tests := []struct {
+		name         string
+		sc           storagev1.StorageClass
+		wantWarnings []string
+		wantErr      bool
+	}{
+		{
+			name: "valid",
+			sc: storagev1.StorageClass{
+				Provisioner:  config.DriverName,
+				Parameters:   params(config.MountPodMemLimitKey, "1Gi"),
+				MountOptions: []string{"cache-size=1024", "buffer-size=300"},
+			},
+		},
+		{
+			name: "template is skipped",
+			sc: storagev1.StorageClass{
+				Provisioner: config.DriverName,
+				Parameters:  params(config.MountPodCpuLimitKey, "${.pvc.annotations.cpu}"),
+			},
+		},
+		{
+			name: "other provisioner",
+			sc: storagev1.StorageClass{
+				Provisioner: "other",
+				Parameters:  map[string]string{config.MountPodCpuLimitKey: "abc"},
+			},
+		},
+		{
+			name: "invalid parameter",
+			sc: storagev1.StorageClass{
+				Provisioner: config.DriverName,
+				Parameters:  params(config.MountPodCpuLimitKey, "abc"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "buffer-size greater than memory limit",
+			sc: storagev1.StorageClass{
+				Provisioner:  config.DriverName,
+				Parameters:   params(config.MountPodMemLimitKey, "100Mi"),
+				MountOptions: []string{"buffer-size=1024"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown option",
+			sc: storagev1.StorageClass{
+				Provisioner:  config.DriverName,
+				Parameters:   params(),
+				MountOptions: []string{"cache-sise=1024"},
+			},
+			wantWarnings: []string{"mount option cache-sise may be unknown by juicefs community edition"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := v.ValidateStorageClass(context.TODO(), tt.sc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateStorageClass() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(warnings, tt.wantWarnings) {
+				t.Errorf("ValidateStorageClass() warnings = %v, want %v", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestVolumeValidator_ValidatePVC(t *testing.T) {
+	juicefsSC, otherSC := "juicefs-sc", "other-sc"
+	v := NewVolumeValidator(&k8sclient.K8sClient{Interface: fake.NewSimpleClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: juicefsSC}, Provisioner: config.DriverName},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: otherSC}, Provisioner: "other"},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "static-pv"},
+			Spec: corev1.PersistentVolumeSpec{PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: config.DriverName, VolumeHandle: "static-pv"},
+			}},
+		},
+	)})
+	newPVC := func(sc, volumeName, cpuLimit string) corev1.PersistentVolumeClaim {
+		return corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "pvc",
+				Namespace:   "default",
+				Annotations: map[string]string{config.MountPodCpuLimitKey: cpuLimit},
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &sc, VolumeName: volumeName},
+		}
+	}
+	withResources := func(cpuLimit, cpuRequest string, others map[string]string) *corev1.PersistentVolumeClaim {
+		pvc := newPVC(juicefsSC, "", cpuLimit)
+		pvc.Annotations[config.MountPodCpuRequestKey] = cpuRequest
+		for k, val := range others {
+			pvc.Annotations[k] = val
+		}
+		return &pvc
+	}
+	invalid := newPVC(juicefsSC, "", "abc")
+	deleting := newPVC(juicefsSC, "", "abc")
+	deleting.DeletionTimestamp = &metav1.Time{}
+	tests := []struct {
+		name    string
+		pvc     corev1.PersistentVolumeClaim
+		oldPVC  *corev1.PersistentVolumeClaim
+		wantErr bool
+	}{
+		{name: "valid", pvc: newPVC(juicefsSC, "", "1")},
+		{name: "invalid", pvc: invalid, wantErr: true},
+		{name: "invalid static pvc", pvc: newPVC("", "static-pv", "abc"), wantErr: true},
+		{name: "other storageClass", pvc: newPVC(otherSC, "", "abc")},
+		{name: "unchanged annotation on update", pvc: invalid, oldPVC: &invalid},
+		{name: "changed annotation on update", pvc: invalid, oldPVC: func() *corev1.PersistentVolumeClaim { p := newPVC(juicefsSC, "", "1"); return &p }(), wantErr: true},
+		{name: "deleting", pvc: deleting, oldPVC: &invalid},
+		{name: "request exceeds limit", pvc: *withResources("1", "2", nil), wantErr: true},
+		{name: "request within unchanged limit on update", pvc: *withResources("4", "3", nil), oldPVC: withResources("4", "1", nil)},
+		{name: "request exceeds unchanged limit on update", pvc: *withResources("4", "5", nil), oldPVC: withResources("4", "1", nil), wantErr: true},
+		{name: "limit below unchanged request on update", pvc: *withResources("2", "3", nil), oldPVC: withResources("4", "3", nil), wantErr: true},
+		{name: "limit removed on update", pvc: func() corev1.PersistentVolumeClaim {
+			p := withResources("4", "3", nil)
+			delete(p.Annotations, config.MountPodCpuLimitKey)
+			return *p
+		}(), oldPVC: withResources("4", "3", nil), wantErr: true},
+		{name: "invalid combination before update", pvc: *withResources("1", "2", map[string]string{"juicefs/mount-delete-delay": "1m"}), oldPVC: withResources("1", "2", nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.ValidatePVC(context.TODO(), tt.pvc, tt.oldPVC); (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePVC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -130,3 +130,11 @@ func (fs *fakeJfs) BindTarget(ctx context.Context, bindSource, target string) er
 func (j *fakeJfsProvider) Status(ctx context.Context, metaUrl string) error {
 	return nil
 }
+
+func (j *fakeJfsProvider) CheckStorage(ctx context.Context, secrets map[string]string, jfsSetting *config.JfsSetting) error {
+	return nil
+}
+
+func (j *fakeJfsProvider) RecoverProcessMounts(ctx context.Context) error {
+	return nil
+}